@@ -18,7 +18,7 @@ from app.bot.keyboards import (
 from app.core.config import get_settings
 from app.core.db import AsyncSessionLocal
 from app.db.models import AccountSettings, CryptoAccount, Order, User
-from app.services.engine_client import engine_client
+from app.services.engine_client import EngineConfigError, EngineError, engine_client
 import httpx
 from sqlalchemy.exc import SQLAlchemyError
 from app.bot.db_utils import ensure_orders_schema, wei_to_float
@@ -37,12 +37,13 @@ def build_default_payment_kb(acc_id: int, payment_id: str, amount: float, rate:
     )
 
 
-def build_confirm_kb(prefix: str, ok_payload: str, back_payload: str) -> InlineKeyboardMarkup:
+def build_confirm_kb(prefix: str, ok_payload: str, back_payload: str, ok_text: str = "Да") -> InlineKeyboardMarkup:
+    # «Назад» стоит на месте исходной кнопки: двойной тап не подтверждает
     return InlineKeyboardMarkup(
         inline_keyboard=[
             [
-                InlineKeyboardButton(text="Да", callback_data=f"{prefix}ok:{ok_payload}"),
                 InlineKeyboardButton(text="↩️ Назад", callback_data=f"{prefix}back:{back_payload}"),
+                InlineKeyboardButton(text=ok_text, callback_data=f"{prefix}ok:{ok_payload}"),
             ]
         ]
     )
@@ -70,21 +71,64 @@ async def _engine_reload(
     auto_mode: bool | None = None,
     is_active: bool | None = None,
     p2c_account_id: str | None = None,
-) -> None:
+) -> list[dict]:
+    """Returns the fields the engine rejected, empty when the config was accepted."""
     if min_amount is not None:
         min_amount = float(min_amount)
     if max_amount is not None:
         max_amount = float(max_amount)
-    await engine_client.reload_account(
-        account_id=account_id,
-        access_token=access_token,
-        chat_id=chat_id,
-        min_amount=min_amount,
-        max_amount=max_amount,
-        auto_mode=auto_mode,
-        is_active=is_active,
-        p2c_account_id=p2c_account_id,
-    )
+    try:
+        await engine_client.reload_account(
+            account_id=account_id,
+            access_token=access_token,
+            chat_id=chat_id,
+            min_amount=min_amount,
+            max_amount=max_amount,
+            auto_mode=auto_mode,
+            is_active=is_active,
+            p2c_account_id=p2c_account_id,
+        )
+    except EngineConfigError as exc:
+        return exc.fields
+    return []
+
+RECEIPT_MAX_BYTES = 900 * 1024  # тело запроса к движку — не больше 1 МБ
+
+
+def _order_from_card(message: types.Message | None) -> tuple[int, str] | None:
+    """(acc_id, payment_id) заявки по кнопкам её карточки."""
+    markup = getattr(message, "reply_markup", None)
+    if not markup:
+        return None
+    for row in markup.inline_keyboard:
+        for button in row:
+            parts = (button.callback_data or "").split(":")
+            if len(parts) >= 3 and parts[0] in ("paid", "paid_ok", "cancel"):
+                try:
+                    return int(parts[1]), parts[2]
+                except ValueError:
+                    return None
+    return None
+
+
+def _receipt_warning(receipt: dict | None) -> str:
+    """Что в чеке не сошлось с заявкой; пусто — совпал или не проверялся."""
+    if not receipt:
+        return ""
+    mismatch = receipt.get("mismatch") or []
+    issues = []
+    if "amount" in mismatch:
+        found = ", ".join(receipt.get("found") or []) or "нет"
+        issues.append(f"суммы {receipt.get('amount')} в чеке нет (в чеке: {found})")
+    if "requisites" in mismatch:
+        issues.append(f"реквизитов …{receipt.get('tail')} в чеке нет")
+    return "; ".join(issues)
+
+
+async def _receipt_mismatch(acc_id: int, payment_id: str) -> str:
+    record = await engine_client.get_payment(acc_id, payment_id)
+    return _receipt_warning((record or {}).get("receipt"))
+
 
 router = Router()
 
@@ -109,10 +153,13 @@ async def on_paid(callback: types.CallbackQuery) -> None:
         await callback.answer("Ошибка данных платежа", show_alert=True)
         return
 
-    # Первая кнопка → показываем подтверждение.
-    await callback.answer("Подтвердить оплату?", show_alert=False)
+    # Первая кнопка → показываем подтверждение с суммой.
+    if warning := await _receipt_mismatch(acc_id, payment_id):
+        await callback.answer(f"⚠️ Чек не сходится: {warning}. Точно оплатили {amount:g}?"[:200], show_alert=True)
+    else:
+        await callback.answer(f"Точно оплатили {amount:g}?", show_alert=False)
     ok_payload = f"{acc_id}:{payment_id}:{amount}:{rate}:{fee}"
-    kb = build_confirm_kb("paid_", ok_payload, ok_payload)
+    kb = build_confirm_kb("paid_", ok_payload, ok_payload, ok_text=f"✅ Да, оплатил {amount:g}")
     try:
         await callback.message.edit_reply_markup(reply_markup=kb)
     except Exception:
@@ -135,7 +182,32 @@ async def on_paid_ok(callback: types.CallbackQuery) -> None:
         await callback.answer("Ошибка данных платежа", show_alert=True)
         return
 
-    ok = await engine_client.complete_order(acc_id, payment_id)
+    markup = callback.message.reply_markup if callback.message else None
+    confirming = any(
+        (button.callback_data or "").startswith("paid_back:")
+        for row in (markup.inline_keyboard if markup else [])
+        for button in row
+    )
+    if not confirming and (warning := await _receipt_mismatch(acc_id, payment_id)):
+        # оплата в одно касание, но чек не сошёлся — всё же переспрашиваем
+        await callback.answer(f"⚠️ Чек не сходится: {warning}. Точно оплатили {amount:g}?"[:200], show_alert=True)
+        ok_payload = f"{acc_id}:{payment_id}:{amount}:{rate}:{fee}"
+        kb = build_confirm_kb("paid_", ok_payload, ok_payload, ok_text=f"✅ Да, оплатил {amount:g}")
+        try:
+            await callback.message.edit_reply_markup(reply_markup=kb)
+        except Exception:
+            pass
+        return
+
+    try:
+        ok = await engine_client.complete_order(acc_id, payment_id, callback.from_user.id)
+    except PermissionError:
+        await callback.answer("Подтверждать оплату этого аккаунта вам нельзя", show_alert=True)
+        return
+    except EngineError as e:
+        # в тексте req= и cf-ray= — их просит поддержка P2C; алерт Telegram до 200 символов
+        await callback.answer(f"Не удалось подтвердить оплату на стороне P2C: {e}"[:200], show_alert=True)
+        return
     if not ok:
         await callback.answer("Не удалось подтвердить оплату на стороне P2C", show_alert=True)
         return
@@ -210,6 +282,116 @@ async def on_paid_back(callback: types.CallbackQuery) -> None:
     await callback.answer()
 
 
+@router.message(F.reply_to_message, F.photo | F.document)
+async def on_receipt(message: types.Message) -> None:
+    """Чек ответом на карточку заявки: сверяем сумму и реквизиты до «Я оплатил»."""
+    order = _order_from_card(message.reply_to_message)
+    if order is None:
+        return
+    acc_id, payment_id = order
+    text = message.caption or ""
+    filename, content_type = "receipt.jpg", "image/jpeg"
+    if message.photo:
+        # самый крупный размер, который пролезет в запрос к движку
+        fitting = [p for p in message.photo if (p.file_size or 0) <= RECEIPT_MAX_BYTES]
+        file_id = fitting[-1].file_id if fitting else None
+    else:
+        doc = message.document
+        file_id = doc.file_id if (doc.file_size or 0) <= RECEIPT_MAX_BYTES else None
+        filename = doc.file_name or "receipt"
+        content_type = doc.mime_type or "application/octet-stream"
+    data: bytes | None = None
+    if file_id:
+        buf = await message.bot.download(file_id)
+        data = buf.read() if buf else None
+    if data and content_type.startswith("text/"):
+        text = f"{text}\n{data.decode('utf-8', errors='replace')}"
+        data = None
+
+    receipt = await engine_client.check_receipt(acc_id, payment_id, text, data, filename, content_type)
+    if receipt is None:
+        await message.reply("Не удалось сверить чек: движок недоступен или заявка не найдена")
+        return
+    if receipt.get("unread"):
+        await message.reply("🧾 Чек получен, но текста в нём не разобрать — сверьте сумму и реквизиты сами")
+        return
+    if warning := _receipt_warning(receipt):
+        await message.reply(f"⚠️ Чек не сходится с заявкой: {warning}. Проверьте перед «Я оплатил».")
+    else:
+        await message.reply("🧾 Чек сходится с заявкой по сумме и реквизитам")
+
+
+@router.callback_query(F.data.startswith("ack:"))
+async def on_ack(callback: types.CallbackQuery) -> None:
+    """Плательщик из пула берёт назначенную ему заявку."""
+    parts = (callback.data or "").split(":")
+    # expected: ack:<acc_id>:<payment_id>
+    if len(parts) < 3:
+        await callback.answer("Не распознал заявку", show_alert=True)
+        return
+    try:
+        acc_id = int(parts[1])
+        payment_id = parts[2]
+    except (ValueError, IndexError):
+        await callback.answer("Ошибка данных заявки", show_alert=True)
+        return
+
+    status = await engine_client.ack_order(acc_id, payment_id, callback.from_user.id)
+    if status == 403:
+        await callback.answer("Вы не в списке плательщиков аккаунта", show_alert=True)
+        return
+    if status == 409:
+        await callback.answer("Заявку уже взяли или она закрыта", show_alert=True)
+        return
+    if status != 200:
+        await callback.answer("Не удалось связаться с движком", show_alert=True)
+        return
+    await callback.answer("👌 Заявка за вами", show_alert=False)
+
+
+@router.callback_query(F.data.startswith("tune:"))
+async def on_tune(callback: types.CallbackQuery) -> None:
+    """Ответ оператора на предложение движка поменять фильтр суммы."""
+    parts = (callback.data or "").split(":")
+    # expected: tune:<acc_id>:<proposal_id>:<apply|reject>
+    if len(parts) < 4 or parts[3] not in ("apply", "reject"):
+        await callback.answer("Не распознал предложение", show_alert=True)
+        return
+    try:
+        acc_id = int(parts[1])
+    except ValueError:
+        await callback.answer("Ошибка данных предложения", show_alert=True)
+        return
+    apply = parts[3] == "apply"
+
+    status, data = await engine_client.answer_tune(acc_id, parts[2], apply)
+    if status == 409:
+        await callback.answer("Предложение уже неактуально", show_alert=True)
+        return
+    if status != 200:
+        await callback.answer("Не удалось связаться с движком", show_alert=True)
+        return
+
+    if apply:
+        # движок уже поменял границы, держим настройки бота в согласии с ним
+        async with AsyncSessionLocal() as session:
+            settings = await session.scalar(
+                select(AccountSettings).where(AccountSettings.account_id == acc_id)
+            )
+            if settings is None:
+                settings = AccountSettings(account_id=acc_id)
+                session.add(settings)
+            settings.min_amount_fiat = data.get("min_amount")
+            settings.max_amount_fiat = data.get("max_amount")
+            await session.commit()
+
+    try:
+        await callback.message.edit_reply_markup(reply_markup=None)
+    except Exception:
+        pass
+    await callback.answer("✅ Применено" if apply else "Оставил как есть", show_alert=False)
+
+
 @router.callback_query(F.data.startswith("cancel:"))
 async def on_cancel(callback: types.CallbackQuery) -> None:
     """Отмена заявки из уведомления."""
@@ -248,7 +430,11 @@ async def on_cancel_ok(callback: types.CallbackQuery) -> None:
         await callback.answer("Ошибка данных заявки", show_alert=True)
         return
 
-    ok = await engine_client.cancel_order(acc_id, payment_id)
+    try:
+        ok = await engine_client.cancel_order(acc_id, payment_id)
+    except EngineError as e:
+        await callback.answer(f"Не удалось отменить заявку на стороне P2C: {e}"[:200], show_alert=True)
+        return
     if not ok:
         await callback.answer("Не удалось отменить заявку на стороне P2C", show_alert=True)
         return
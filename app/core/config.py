@@ -9,6 +9,8 @@ class Settings(BaseSettings):
     BOT_TOKEN: str
     DB_URL: str = "sqlite+aiosqlite:///./p2c.db"
     ENGINE_URL: str | None = None
+    # Bearer token for the engine API (needs control scope)
+    ENGINE_API_TOKEN: str | None = None
     # Optional: engine-side bot token; ignore if present in .env
     P2C_BOT_TOKEN: str | None = None
 
@@ -5,10 +5,32 @@ import httpx
 from app.core.config import get_settings
 
 
+class EngineError(Exception):
+    """The engine failed the operation; the message carries req= and cf-ray= for P2C support."""
+
+
+class EngineConfigError(EngineError):
+    """The engine rejected an account config; fields lists {"field", "error"} for each invalid field."""
+
+    def __init__(self, fields: list[dict]) -> None:
+        super().__init__("; ".join(f"{f.get('field')}: {f.get('error')}" for f in fields))
+        self.fields = fields
+
+
+def _error_text(resp: httpx.Response) -> str:
+    try:
+        return str(resp.json().get("error") or "")
+    except ValueError:
+        return ""
+
+
 class P2CEngineClient:
     def __init__(self, base_url: str | None = None) -> None:
         settings = get_settings()
         self.base_url = (base_url or settings.ENGINE_URL or "").rstrip("/")
+        self.headers: dict[str, str] = {}
+        if settings.ENGINE_API_TOKEN:
+            self.headers["Authorization"] = f"Bearer {settings.ENGINE_API_TOKEN}"
 
     def _build_url(self, path: str) -> str:
         if not self.base_url:
@@ -25,6 +47,33 @@ class P2CEngineClient:
         auto_mode: bool | None = None,
         is_active: bool | None = None,
         p2c_account_id: str | None = None,
+        min_out_amount: float | None = None,
+        max_out_amount: float | None = None,
+        label: str | None = None,
+        owner: str | None = None,
+        note: str | None = None,
+        transport: dict | None = None,
+        ws_standby: bool | None = None,
+        slack_webhook: str | None = None,
+        discord_webhook: str | None = None,
+        webhook_url: str | None = None,
+        webhook_secret: str | None = None,
+        cancel_at_expiry: bool | None = None,
+        take_delay_ms: int | None = None,
+        payers: list[int] | None = None,
+        payer_ack_sec: int | None = None,
+        paid_one_tap: bool | None = None,
+        paid_users: list[int] | None = None,
+        order_card: bool | None = None,
+        message_thread_id: int | None = None,
+        auto_topic: bool | None = None,
+        environment: str | None = None,
+        skip_summary: bool | None = None,
+        deaf_after_sec: int | None = None,
+        market_edge_pct: float | None = None,
+        auto_tune: bool | None = None,
+        priority: int | None = None,
+        weight: int | None = None,
     ) -> bool:
         url = self._build_url("/accounts/reload")
         if not url:
@@ -46,40 +95,104 @@ class P2CEngineClient:
         payload["is_active"] = is_active
         if p2c_account_id:
             payload["p2c_account_id"] = p2c_account_id
-        async with httpx.AsyncClient(timeout=2.0) as client:
+        if min_out_amount is not None:
+            payload["min_out_amount"] = min_out_amount
+        if max_out_amount is not None:
+            payload["max_out_amount"] = max_out_amount
+        if label:
+            payload["label"] = label
+        if owner:
+            payload["owner"] = owner
+        if note:
+            payload["note"] = note
+        if transport:
+            payload["transport"] = transport
+        if ws_standby is not None:
+            payload["ws_standby"] = ws_standby
+        if slack_webhook:
+            payload["slack_webhook"] = slack_webhook
+        if discord_webhook:
+            payload["discord_webhook"] = discord_webhook
+        if webhook_url:
+            payload["webhook_url"] = webhook_url
+        if webhook_secret:
+            payload["webhook_secret"] = webhook_secret
+        if cancel_at_expiry is not None:
+            payload["cancel_at_expiry"] = cancel_at_expiry
+        if take_delay_ms:
+            payload["take_delay_ms"] = take_delay_ms
+        if payers is not None:
+            payload["payers"] = payers
+        if payer_ack_sec:
+            payload["payer_ack_sec"] = payer_ack_sec
+        if paid_one_tap is not None:
+            payload["paid_one_tap"] = paid_one_tap
+        if paid_users is not None:
+            payload["paid_users"] = paid_users
+        if order_card is not None:
+            payload["order_card"] = order_card
+        if message_thread_id:
+            payload["message_thread_id"] = message_thread_id
+        if auto_topic is not None:
+            payload["auto_topic"] = auto_topic
+        if environment:
+            payload["environment"] = environment
+        if skip_summary is not None:
+            payload["skip_summary"] = skip_summary
+        if deaf_after_sec is not None:
+            payload["deaf_after_sec"] = deaf_after_sec
+        if market_edge_pct is not None:
+            payload["market_edge_pct"] = market_edge_pct
+        if auto_tune is not None:
+            payload["auto_tune"] = auto_tune
+        if priority is not None:
+            payload["priority"] = priority
+        if weight is not None:
+            payload["weight"] = weight
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
             try:
                 resp = await client.post(url, json=payload)
+                if resp.status_code == 422:
+                    raise EngineConfigError(resp.json().get("fields") or [])
                 resp.raise_for_status()
                 data = resp.json()
                 return bool(data.get("ok", True))
             except httpx.HTTPError:
                 return False
 
-    async def take_order(self, account_id: int, order_external_id: str) -> bool:
+    async def take_order(self, account_id: int, order_external_id: str) -> dict | None:
+        """Returns take details (payment, expires_at, requisites, take_ms, cf_ray) or None."""
         url = self._build_url("/orders/take")
         if not url:
-            return False
+            return None
         payload = {
             "account_id": account_id,
             "order_external_id": order_external_id,
         }
-        async with httpx.AsyncClient(timeout=2.0) as client:
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
             try:
                 resp = await client.post(url, json=payload)
                 resp.raise_for_status()
-                data = resp.json()
-                return bool(data.get("ok", True))
+                return resp.json().get("take") or {}
             except httpx.HTTPError:
-                return False
+                return None
 
-    async def complete_order(self, account_id: int, payment_id: str) -> bool:
+    async def complete_order(self, account_id: int, payment_id: str, user_id: int | None = None) -> bool:
+        """Raises PermissionError when the engine does not let user_id confirm payments of the account
+        and EngineError with the engine's message when P2C rejected the confirmation."""
         url = self._build_url("/orders/complete")
         if not url:
             return False
         payload = {"account_id": account_id, "payment_id": payment_id}
-        async with httpx.AsyncClient(timeout=2.0) as client:
+        if user_id:
+            payload["user_id"] = user_id
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
             try:
                 resp = await client.post(url, json=payload)
+                if resp.status_code == 403:
+                    raise PermissionError("user may not confirm payments of this account")
+                if resp.status_code >= 500 and (error := _error_text(resp)):
+                    raise EngineError(error)
                 resp.raise_for_status()
                 data = resp.json()
                 return bool(data.get("ok", True))
@@ -87,14 +200,358 @@ class P2CEngineClient:
                 return False
 
     async def cancel_order(self, account_id: int, payment_id: str) -> bool:
+        """Raises EngineError with the engine's message when P2C rejected the cancel."""
         url = self._build_url("/orders/cancel")
         if not url:
             return False
         payload = {"account_id": account_id, "payment_id": payment_id}
-        async with httpx.AsyncClient(timeout=2.0) as client:
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=payload)
+                if resp.status_code >= 500 and (error := _error_text(resp)):
+                    raise EngineError(error)
+                resp.raise_for_status()
+                data = resp.json()
+                return bool(data.get("ok", True))
+            except httpx.HTTPError:
+                return False
+
+    async def complete_batch(self, items: list[tuple[int, str]]) -> dict | None:
+        """Confirms many (account_id, payment_id) pairs; returns the per-item report."""
+        return await self._post_batch("complete", items)
+
+    async def cancel_batch(self, items: list[tuple[int, str]]) -> dict | None:
+        """Cancels many (account_id, payment_id) pairs; returns the per-item report."""
+        return await self._post_batch("cancel", items)
+
+    async def _post_batch(self, action: str, items: list[tuple[int, str]]) -> dict | None:
+        url = self._build_url(f"/payments/{action}-batch")
+        if not url:
+            return None
+        payload = {"items": [{"account_id": a, "payment_id": p} for a, p in items]}
+        # движок выдерживает паузу между вызовами одного аккаунта — пачка идёт долго
+        async with httpx.AsyncClient(timeout=300.0, headers=self.headers) as client:
             try:
                 resp = await client.post(url, json=payload)
                 resp.raise_for_status()
+                return resp.json()
+            except httpx.HTTPError:
+                return None
+
+    async def ack_order(self, account_id: int, payment_id: str, user_id: int) -> int:
+        """Acknowledges an order assigned to the payer pool; returns the HTTP status (0 on network error)."""
+        url = self._build_url(f"/accounts/{account_id}/payments/{payment_id}/ack")
+        if not url:
+            return 0
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json={"user_id": user_id})
+                return resp.status_code
+            except httpx.HTTPError:
+                return 0
+
+    async def handoff_order(self, account_id: int, payment_id: str, to_account_id: int | None = None) -> tuple[int, dict]:
+        """Cancels an order the account cannot pay so another account re-takes it; returns the HTTP status (0 on network error) and the body."""
+        url = self._build_url(f"/accounts/{account_id}/payments/{payment_id}/handoff")
+        if not url:
+            return 0, {}
+        payload: dict[str, object] = {}
+        if to_account_id is not None:
+            payload["to_account_id"] = to_account_id
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=payload)
+                return resp.status_code, resp.json()
+            except (httpx.HTTPError, ValueError):
+                return 0, {}
+
+    async def get_payment(self, account_id: int, payment_id: str) -> dict | None:
+        """Returns the engine's journal record of the payment, None when it is unknown or the engine is unreachable."""
+        url = self._build_url(f"/accounts/{account_id}/payments/{payment_id}")
+        if not url:
+            return None
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url)
+                resp.raise_for_status()
+                return resp.json()
+            except (httpx.HTTPError, ValueError):
+                return None
+
+    async def check_receipt(
+        self,
+        account_id: int,
+        payment_id: str,
+        text: str = "",
+        file: bytes | None = None,
+        filename: str = "receipt",
+        content_type: str = "application/octet-stream",
+    ) -> dict | None:
+        """Checks a payer's receipt (caption text and optional image, up to 1 MB) against the order amount
+        and requisites; returns the check with "mismatch" listing what was not found."""
+        url = self._build_url(f"/accounts/{account_id}/payments/{payment_id}/receipt")
+        if not url:
+            return None
+        files = {"file": (filename, file, content_type)} if file else None
+        # OCR на стороне движка небыстрый
+        async with httpx.AsyncClient(timeout=30.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, data={"text": text}, files=files)
+                resp.raise_for_status()
+                return resp.json().get("receipt")
+            except (httpx.HTTPError, ValueError):
+                return None
+
+    async def preview_filters(self, account_id: int, filters: dict, hours: int | None = None) -> dict | None:
+        """Replays recently seen payments through candidate filters (reload field names; omitted ones keep current values)."""
+        url = self._build_url(f"/accounts/{account_id}/filters/preview")
+        if not url:
+            return None
+        params = {"hours": hours} if hours is not None else None
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=filters, params=params)
+                if resp.status_code == 400 and (error := _error_text(resp)):
+                    raise EngineError(error)
+                resp.raise_for_status()
+                return resp.json()
+            except (httpx.HTTPError, ValueError):
+                return None
+
+    async def answer_tune(self, account_id: int, proposal_id: str, apply: bool) -> tuple[int, dict]:
+        """Applies or rejects an auto-tune proposal; returns the HTTP status (0 on network error) and the body."""
+        action = "apply" if apply else "reject"
+        url = self._build_url(f"/accounts/{account_id}/tune/{proposal_id}/{action}")
+        if not url:
+            return 0, {}
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url)
+                return resp.status_code, resp.json()
+            except (httpx.HTTPError, ValueError):
+                return 0, {}
+
+    async def start_maintenance(self, reason: str = "", duration_sec: int | None = None) -> bool:
+        """Pauses auto-takes on all accounts; resumes by itself after duration_sec if given."""
+        url = self._build_url("/maintenance")
+        if not url:
+            return False
+        payload: dict[str, object] = {"reason": reason}
+        if duration_sec is not None:
+            payload["duration_sec"] = duration_sec
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=payload)
+                resp.raise_for_status()
+                return bool(resp.json().get("ok"))
+            except httpx.HTTPError:
+                return False
+
+    async def end_maintenance(self) -> bool:
+        url = self._build_url("/maintenance")
+        if not url:
+            return False
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.delete(url)
+                resp.raise_for_status()
+                return bool(resp.json().get("ok"))
+            except httpx.HTTPError:
+                return False
+
+    async def list_blackouts(self, account_id: int | None = None) -> list[dict]:
+        """Current and upcoming windows without auto-take; with account_id only those covering it."""
+        url = self._build_url("/blackouts")
+        if not url:
+            return []
+        params = {"account_id": account_id} if account_id is not None else None
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params)
+                resp.raise_for_status()
+                return resp.json().get("blackouts") or []
+            except (httpx.HTTPError, ValueError):
+                return []
+
+    async def add_blackout(
+        self, start: str, end: str | None = None, account_id: int | None = None, reason: str = ""
+    ) -> dict | None:
+        """Adds a window without auto-take; start/end as YYYY-MM-DD or YYYY-MM-DDTHH:MM, all accounts without account_id."""
+        url = self._build_url("/blackouts")
+        if not url:
+            return None
+        payload: dict[str, object] = {"from": start, "reason": reason}
+        if end is not None:
+            payload["to"] = end
+        if account_id is not None:
+            payload["account_id"] = account_id
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=payload)
+                if resp.status_code == 400 and (error := _error_text(resp)):
+                    raise EngineError(error)
+                resp.raise_for_status()
+                return resp.json().get("blackout")
+            except (httpx.HTTPError, ValueError):
+                return None
+
+    async def remove_blackout(self, blackout_id: str) -> bool:
+        url = self._build_url(f"/blackouts/{blackout_id}")
+        if not url:
+            return False
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.delete(url)
+                resp.raise_for_status()
+                return True
+            except httpx.HTTPError:
+                return False
+
+    async def brand_report(self, days: int | None = None, account_id: int | None = None) -> list[dict]:
+        """Completed, canceled and disputed orders per brand, most disputed first; with account_id marks the brands it denies."""
+        url = self._build_url("/brands")
+        if not url:
+            return []
+        params = {}
+        if days is not None:
+            params["days"] = days
+        if account_id is not None:
+            params["account_id"] = account_id
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params or None)
+                resp.raise_for_status()
+                return resp.json().get("brands") or []
+            except (httpx.HTTPError, ValueError):
+                return []
+
+    async def pause_account(self, account_id: int) -> bool:
+        return await self._post_account_action(account_id, "pause")
+
+    async def resume_account(self, account_id: int) -> bool:
+        return await self._post_account_action(account_id, "resume")
+
+    async def restart_account(self, account_id: int) -> bool:
+        return await self._post_account_action(account_id, "restart")
+
+    async def reset_cursor(self, account_id: int) -> bool:
+        return await self._post_account_action(account_id, "cursor/reset")
+
+    async def delete_account(self, account_id: int, cancel_open: bool = False) -> bool:
+        url = self._build_url(f"/accounts/{account_id}")
+        if not url:
+            return False
+        params = {"cancel_open": "true"} if cancel_open else None
+        async with httpx.AsyncClient(timeout=15.0, headers=self.headers) as client:
+            try:
+                resp = await client.delete(url, params=params)
+                resp.raise_for_status()
+                return resp.json().get("status") == "deleted"
+            except httpx.HTTPError:
+                return False
+
+    async def live_orders(self, account_id: int, limit: int | None = None) -> list[dict]:
+        url = self._build_url(f"/accounts/{account_id}/live-orders")
+        if not url:
+            return []
+        params = {"limit": limit} if limit else None
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params)
+                resp.raise_for_status()
+                return list(resp.json().get("orders") or [])
+            except httpx.HTTPError:
+                return []
+
+    async def recent_payments(self, account_id: int, limit: int | None = None) -> list[dict]:
+        url = self._build_url(f"/accounts/{account_id}/payments")
+        if not url:
+            return []
+        params = {"limit": limit} if limit else None
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params)
+                resp.raise_for_status()
+                return list(resp.json().get("payments") or [])
+            except httpx.HTTPError:
+                return []
+
+    async def search_payments(self, **filters: str | float | int | None) -> list[dict]:
+        """Filters: amount, fiat, status, brand, from_, to, limit; dates as 2026-10-16 or 2026-10-16T18:00."""
+        url = self._build_url("/payments/search")
+        if not url:
+            return []
+        params = {k.rstrip("_"): v for k, v in filters.items() if v is not None}
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params)
+                resp.raise_for_status()
+                return list(resp.json().get("payments") or [])
+            except httpx.HTTPError:
+                return []
+
+    async def skips(self, account_id: int, since: str | None = None, code: str | None = None) -> dict:
+        """Returns {"counts": {code: n}, "skips": [...]} for the account, newest first."""
+        url = self._build_url(f"/accounts/{account_id}/skips")
+        if not url:
+            return {}
+        params = {k: v for k, v in (("since", since), ("code", code)) if v}
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url, params=params or None)
+                resp.raise_for_status()
+                return resp.json()
+            except httpx.HTTPError:
+                return {}
+
+    async def list_requisites(self, account_id: int) -> list[dict]:
+        url = self._build_url(f"/accounts/{account_id}/requisites")
+        if not url:
+            return []
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
+            try:
+                resp = await client.get(url)
+                resp.raise_for_status()
+                return list(resp.json().get("requisites") or [])
+            except httpx.HTTPError:
+                return []
+
+    async def add_requisite(
+        self,
+        account_id: int,
+        type_: str,
+        value: str,
+        bank: str | None = None,
+        holder: str | None = None,
+    ) -> dict | None:
+        url = self._build_url(f"/accounts/{account_id}/requisites")
+        if not url:
+            return None
+        payload: dict[str, object] = {"type": type_, "value": value}
+        if bank:
+            payload["bank"] = bank
+        if holder:
+            payload["holder"] = holder
+        async with httpx.AsyncClient(timeout=5.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url, json=payload)
+                resp.raise_for_status()
+                return resp.json().get("requisite")
+            except httpx.HTTPError:
+                return None
+
+    async def set_requisite_enabled(self, account_id: int, requisite_id: str, enabled: bool) -> bool:
+        action = "enable" if enabled else "disable"
+        return await self._post_account_action(account_id, f"requisites/{requisite_id}/{action}")
+
+    async def _post_account_action(self, account_id: int, action: str) -> bool:
+        url = self._build_url(f"/accounts/{account_id}/{action}")
+        if not url:
+            return False
+        async with httpx.AsyncClient(timeout=2.0, headers=self.headers) as client:
+            try:
+                resp = await client.post(url)
+                resp.raise_for_status()
                 data = resp.json()
                 return bool(data.get("ok", True))
             except httpx.HTTPError:
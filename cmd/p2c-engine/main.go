@@ -4,29 +4,235 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"p2c-engine/internal/engine"
+	"p2c-engine/internal/fx"
 	"p2c-engine/internal/httpserver"
+	"p2c-engine/internal/logx"
 	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/store"
+	"p2c-engine/internal/tracing"
 )
 
 func main() {
 	addr := getenv("ENGINE_ADDR", ":8080")
-	baseURL := getenv("P2C_BASE_URL", "https://app.cr.bot/internal/v1")
+	// Несколько зеркал через запятую: первое — основное, остальные — запасные домены.
+	baseURLs := splitList(getenv("P2C_BASE_URL", "https://app.cr.bot/internal/v1"))
+	if len(baseURLs) == 0 {
+		log.Fatalf("P2C_BASE_URL is empty")
+	}
 	// Предпочитаем отдельный токен для engine-уведомлений, но fallback на основной бот.
 	botToken := getenv("P2C_BOT_TOKEN", os.Getenv("BOT_TOKEN"))
 
-	p2cClient := p2c.NewClient(baseURL, "")
-	mgr := engine.NewManager(p2cClient, botToken)
-	srv := httpserver.New(addr, mgr)
+	// Список edge-адресов P2C (hostname/IP Cloudflare POP) для выбора самого быстрого.
+	edgeTargets := splitList(os.Getenv("P2C_EDGE_TARGETS"))
+	probeInterval := getenvDuration("P2C_PROBE_INTERVAL", time.Minute)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Уровень логов: debug | info | warn; меняется без рестарта через SIGHUP и POST /log-level.
+	if v := os.Getenv("ENGINE_LOG_LEVEL"); v != "" {
+		level, err := logx.ParseLevel(v)
+		if err != nil {
+			log.Fatalf("ENGINE_LOG_LEVEL: %v", err)
+		}
+		logx.SetLevel(level, 0)
+	}
+
+	// Трассы take в Tempo/Jaeger по OTLP: OTEL_EXPORTER_OTLP_ENDPOINT, сборка с -tags otel.
+	var shutdownTracing func(context.Context) error
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		fn, err := tracing.Setup(ctx)
+		if err != nil {
+			log.Printf("tracing disabled: %v", err)
+		} else {
+			shutdownTracing = fn
+		}
+	}
+
+	p2cClient := p2c.NewClientWith(baseURLs[0], "", transportConfig())
+	// Общий кэш TLS-сессий: переподключение после простоя без полного handshake.
+	sessionCache := 256
+	if v := os.Getenv("P2C_TLS_SESSION_CACHE"); v != "" {
+		sessionCache, _ = strconv.Atoi(v)
+	}
+	if sessionCache > 0 {
+		p2cClient.UseTLSSessions(p2c.NewTLSSessions(sessionCache))
+	}
+	// Кэш DNS и статические IP хостов P2C: на пути take нет DNS-запросов.
+	if ttl := getenvDuration("P2C_DNS_CACHE_TTL", 5*time.Minute); ttl > 0 {
+		resolver := p2c.NewResolver(ttl, splitPairs(os.Getenv("P2C_STATIC_HOSTS")))
+		p2cClient.UseResolver(resolver)
+		hosts := make([]string, 0, len(baseURLs))
+		for _, u := range baseURLs {
+			if parsed, err := url.Parse(u); err == nil {
+				hosts = append(hosts, parsed.Hostname())
+			}
+		}
+		go resolver.Run(ctx, hosts)
+	}
+	// Take по HTTP/3 с откатом на HTTP/2; нужна сборка с -tags http3.
+	if os.Getenv("P2C_HTTP3") == "1" {
+		if err := p2cClient.UseHTTP3(); err != nil {
+			log.Printf("P2C_HTTP3 ignored: %v", err)
+		}
+	}
+	if len(baseURLs) > 1 {
+		maxFailures, _ := strconv.Atoi(os.Getenv("P2C_FAILOVER_MAX_FAILURES"))
+		p2cClient.UseMirrors(p2c.NewMirrors(baseURLs, maxFailures))
+	}
+	if len(edgeTargets) > 0 {
+		prober := p2c.NewProber(edgeTargets, probeInterval)
+		p2cClient.UseProber(prober)
+		go prober.Run(ctx)
+	}
+	// Каталог для состояния движка (штрафы и т.п.); пусто — только в памяти.
+	// Ключ шифрования токенов в хранилище: ENGINE_SECRET_KEY или файл ENGINE_SECRET_KEY_FILE.
+	if key := secretKey(); key != "" {
+		if err := store.SetSecretKey(key); err != nil {
+			log.Fatalf("secret key: %v", err)
+		}
+	}
+	// ENGINE_STORAGE=sqlite:///var/lib/p2c/engine.db или postgres://… — вместо файлов в ENGINE_DATA_DIR.
+	storage := os.Getenv("ENGINE_STORAGE")
+	if storage == "" {
+		storage = os.Getenv("ENGINE_DATA_DIR")
+	}
+	st, err := store.OpenURL(storage)
+	if err != nil {
+		log.Fatalf("open storage: %v", err)
+	}
+	mgr := engine.NewManager(p2cClient, botToken, st)
+	if os.Getenv("ENGINE_ARBITRATION") == "1" {
+		mgr.EnableArbitration()
+	}
+	if os.Getenv("ENGINE_SHARED_DEDUP") == "1" {
+		mgr.EnableSharedDedup()
+	}
+	// Реплики для одного маркетплейса: общий seen-набор и замок активной заявки в Redis.
+	if redisURL := os.Getenv("ENGINE_REDIS_URL"); redisURL != "" {
+		if err := mgr.EnableRedis(redisURL); err != nil {
+			log.Fatalf("redis: %v", err)
+		}
+	}
+	// Несколько инстансов на общем хранилище (ENGINE_DATA_DIR или ENGINE_STORAGE): аккаунты распределяются арендой,
+	// API проксирует запросы к инстансу-владельцу по ENGINE_INSTANCE_URL.
+	if instanceURL := os.Getenv("ENGINE_INSTANCE_URL"); instanceURL != "" {
+		instance := os.Getenv("ENGINE_INSTANCE_ID")
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		if secretKey() == "" {
+			log.Fatalf("leases: ENGINE_SECRET_KEY is required to share access tokens between instances")
+		}
+		if err := mgr.EnableLeases(instance, instanceURL, getenvDuration("ENGINE_LEASE_TTL", 15*time.Second)); err != nil {
+			log.Fatalf("leases: %v", err)
+		}
+		go mgr.RunLeases(ctx)
+	}
+	// Команды из Telegram: отдельный токен, чтобы не конфликтовать с getUpdates фронтового бота.
+	if cmdToken := os.Getenv("ENGINE_COMMAND_BOT_TOKEN"); cmdToken != "" {
+		operators := parseIDs(os.Getenv("ENGINE_COMMAND_OPERATORS"))
+		if len(operators) == 0 {
+			log.Fatalf("command bot: ENGINE_COMMAND_OPERATORS is required, commands are accepted only from these Telegram user ids")
+		}
+		go engine.NewCommandBot(cmdToken, mgr, parseIDs(os.Getenv("ENGINE_COMMAND_ADMIN_CHATS")), operators).Run(ctx)
+	}
+	// Ежедневная сводка в чат аккаунта, например ENGINE_DAILY_REPORT_AT=23:55.
+	if at := os.Getenv("ENGINE_DAILY_REPORT_AT"); at != "" {
+		if err := mgr.RunDailyReports(ctx, at); err != nil {
+			log.Fatalf("daily reports: %v", err)
+		}
+	}
+	// Ops-чат: алерты и объявления о технических работах (POST /maintenance).
+	opsChat, _ := strconv.ParseInt(os.Getenv("ENGINE_OPS_CHAT_ID"), 10, 64)
+	mgr.SetOpsChat(opsChat)
+	// Алерты в ops-чат/вебхук, например ENGINE_ALERT_RULES=ws_reconnects>3/10m,no_events/15m@09-21.
+	if spec := os.Getenv("ENGINE_ALERT_RULES"); spec != "" {
+		rules, err := engine.ParseAlertRules(spec)
+		if err != nil {
+			log.Fatalf("alert rules: %v", err)
+		}
+		mgr.EnableAlerts(rules, opsChat, os.Getenv("ENGINE_OPS_WEBHOOK_URL"))
+		go mgr.RunAlerts(ctx)
+	}
+	// Свои тексты уведомлений: ENGINE_TEMPLATES_DIR/<name>.tmpl и <dir>/<account_id>/<name>.tmpl.
+	if dir := os.Getenv("ENGINE_TEMPLATES_DIR"); dir != "" {
+		tmpl, err := engine.NewTemplates(dir)
+		if err != nil {
+			log.Fatalf("templates: %v", err)
+		}
+		mgr.SetTemplates(tmpl)
+	}
+	// Sandbox-аккаунты (environment=sandbox) ходят на тестовую площадку P2C, уведомления — в тестовый чат.
+	sandboxChat, _ := strconv.ParseInt(os.Getenv("ENGINE_SANDBOX_CHAT_ID"), 10, 64)
+	mgr.SetSandbox(os.Getenv("P2C_SANDBOX_URL"), sandboxChat)
+	// Рыночные курсы для маржи и фильтра market_edge_pct: живой источник ENGINE_FX_SOURCE=cbr|binance,
+	// при его недоступности — фиксированные ENGINE_REFERENCE_RATES=USDT/RUB:95.3.
+	var rates []engine.RateSource
+	if name := os.Getenv("ENGINE_FX_SOURCE"); name != "" {
+		src, err := fx.NewSource(name)
+		if err != nil {
+			log.Fatalf("fx: %v", err)
+		}
+		pairs, err := fx.ParsePairs(splitList(getenv("ENGINE_FX_PAIRS", "USDT/RUB")))
+		if err != nil {
+			log.Fatalf("fx: %v", err)
+		}
+		interval := getenvDuration("ENGINE_FX_INTERVAL", time.Minute)
+		oracle := fx.NewOracle(src, pairs, interval, getenvDuration("ENGINE_FX_MAX_AGE", 10*interval))
+		go oracle.Run(ctx)
+		rates = append(rates, oracle)
+	}
+	if pairs := splitPairs(os.Getenv("ENGINE_REFERENCE_RATES")); len(pairs) > 0 {
+		static, err := engine.ParseStaticRates(pairs)
+		if err != nil {
+			log.Fatalf("reference rates: %v", err)
+		}
+		rates = append(rates, static)
+	}
+	if len(rates) > 0 {
+		mgr.SetReferenceRates(rates...)
+	}
+	// Запись сырых кадров websocket для прогона через p2c-replay.
+	if dir := os.Getenv("ENGINE_WS_CAPTURE_DIR"); dir != "" {
+		mgr.SetCaptureDir(dir)
+	}
+	// Сервис распознавания чеков: POST изображения, в ответ текст. Без него сверяется только подпись к чеку.
+	if url := os.Getenv("ENGINE_OCR_URL"); url != "" {
+		mgr.SetOCR(engine.NewHTTPOCR(url))
+	}
+	// Веб-страница заявки: ссылка из Telegram-карточки, подписанная HMAC.
+	mgr.SetWebLinks(engine.NewWebLinks(os.Getenv("ENGINE_PUBLIC_URL"), os.Getenv("ENGINE_WEB_SECRET")))
+	// Аккаунты из хранилища (сохранённые движком или p2c-migrate) стартуют, не дожидаясь бота.
+	if n := mgr.RestoreAccounts(); n > 0 {
+		log.Printf("restored %d accounts from storage", n)
+	}
+	srv := httpserver.New(addr, mgr)
+	// ENGINE_TENANT_TOKENS=tenantA:tokenA,tenantB:tokenB — мульти-тенантный режим.
+	if tokens := splitPairs(os.Getenv("ENGINE_TENANT_TOKENS")); len(tokens) > 0 {
+		srv.SetTenantTokens(tokens)
+	}
+	// ENGINE_TENANT_READ_TOKENS=tenantA:tokenR — токены тенанта только на чтение.
+	if tokens := splitPairs(os.Getenv("ENGINE_TENANT_READ_TOKENS")); len(tokens) > 0 {
+		srv.SetTenantReadTokens(tokens)
+	}
+	// ENGINE_API_TOKENS=tokenR:read,tokenC:control — доступ к API без тенантов.
+	if tokens := splitPairs(os.Getenv("ENGINE_API_TOKENS")); len(tokens) > 0 {
+		srv.SetAPITokens(tokens)
+	}
+	srv.SetSlowRequest(getenvDuration("ENGINE_SLOW_REQUEST", 2*time.Second))
+	// kill -HUP: debug-логи на ENGINE_LOG_DEBUG_FOR (повторный HUP — раньше вернуть уровень) и перечитать шаблоны.
+	go reloadOnHUP(ctx, mgr, getenvDuration("ENGINE_LOG_DEBUG_FOR", 10*time.Minute))
+
 	go func() {
 		log.Printf("p2c-engine HTTP listening on %s", addr)
 		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
@@ -39,19 +245,140 @@ func main() {
 	<-ctx.Done()
 	log.Println("shutdown signal received, stopping...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Сначала воркеры перестают брать новые заявки, потом HTTP дожидается начатых
+	// complete/cancel (SSE-потоки закрываются сразу), потом воркеры — взятий и
+	// уведомлений. У каждого шага свой грейс-период: медленный HTTP не съедает время дренажа.
+	grace := getenvDuration("ENGINE_SHUTDOWN_GRACE", 15*time.Second)
+	mgr.StopIntake()
 
-	if err := srv.Shutdown(shutdownCtx); err != nil {
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), grace)
+	if err := srv.Shutdown(httpCtx); err != nil {
 		log.Printf("server shutdown error: %v", err)
 	}
-	mgr.StopAll()
+	cancelHTTP()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), grace)
+	mgr.Shutdown(drainCtx)
+	cancelDrain()
+
+	if err := st.Close(); err != nil {
+		log.Printf("close storage: %v", err)
+	}
+	if shutdownTracing != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("flush traces: %v", err)
+		}
+		cancelFlush()
+	}
 	log.Println("p2c-engine stopped")
 }
 
+// reloadOnHUP toggles temporary debug logging and reloads the notification
+// templates on every SIGHUP, leaving workers and their orders untouched.
+func reloadOnHUP(ctx context.Context, mgr *engine.Manager, debugFor time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if st := logx.Current(); st.Level == logx.Debug && st.Base != logx.Debug {
+			logx.SetLevel(st.Base, 0)
+		} else {
+			logx.SetLevel(logx.Debug, debugFor)
+		}
+		if err := mgr.ReloadTemplates(); err != nil {
+			log.Printf("SIGHUP: templates not reloaded: %v", err)
+		}
+	}
+}
+
 func getenv(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return def
 }
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+func parseIDs(v string) []int64 {
+	var out []int64
+	for _, item := range splitList(v) {
+		if id, err := strconv.ParseInt(item, 10, 64); err == nil {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// secretKey reads ENGINE_SECRET_KEY, falling back to ENGINE_SECRET_KEY_FILE.
+func secretKey() string {
+	if key := os.Getenv("ENGINE_SECRET_KEY"); key != "" {
+		return key
+	}
+	path := os.Getenv("ENGINE_SECRET_KEY_FILE")
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("read secret key file: %v", err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// splitPairs parses "k1:v1,k2:v2" into a map.
+func splitPairs(v string) map[string]string {
+	out := make(map[string]string)
+	for _, item := range splitList(v) {
+		if k, val, ok := strings.Cut(item, ":"); ok {
+			out[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+	}
+	return out
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// transportConfig reads engine-wide P2C client timeouts and pools; accounts
+// may override them in reload.transport.
+func transportConfig() p2c.TransportConfig {
+	ms := func(key string) int { return int(getenvDuration(key, 0).Milliseconds()) }
+	maxConns, _ := strconv.Atoi(os.Getenv("P2C_MAX_CONNS_PER_HOST"))
+	maxIdle, _ := strconv.Atoi(os.Getenv("P2C_MAX_IDLE_CONNS"))
+	return p2c.TransportConfig{
+		DialMs:          ms("P2C_DIAL_TIMEOUT"),
+		TLSMs:           ms("P2C_TLS_TIMEOUT"),
+		ResponseMs:      ms("P2C_RESPONSE_TIMEOUT"),
+		RequestMs:       ms("P2C_REQUEST_TIMEOUT"),
+		HandshakeMs:     ms("P2C_WS_HANDSHAKE_TIMEOUT"),
+		MaxConnsPerHost: maxConns,
+		MaxIdleConns:    maxIdle,
+		IdleTimeoutMs:   ms("P2C_IDLE_CONN_TIMEOUT"),
+		DisableHTTP2:    os.Getenv("P2C_DISABLE_HTTP2") == "1",
+	}
+}
@@ -0,0 +1,75 @@
+// Command p2c-fake runs a fake P2C for load tests of the engine: synthetic
+// payments over the websocket, takes with competition and latencies, and a
+// summary of what the engine took and how fast:
+//
+//	go run ./cmd/p2c-fake -rate 20 -contention 0.5 -take-latency 40ms
+//
+// It prints the P2C_BASE_URL and the certificate file to start the engine
+// with (SSL_CERT_FILE); every account token is accepted. The summary is
+// printed every -report and on exit.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"p2c-engine/internal/p2c/p2cfake"
+)
+
+func main() {
+	var cfg p2cfake.Config
+	addr := flag.String("addr", "127.0.0.1:0", "listen address")
+	certFile := flag.String("cert", filepath.Join(os.TempDir(), "p2c-fake.pem"), "where to write the server certificate")
+	flag.Float64Var(&cfg.Rate, "rate", 1, "new payments per second")
+	flag.DurationVar(&cfg.Lifetime, "lifetime", 30*time.Second, "how long an untaken payment stays listed")
+	flag.Float64Var(&cfg.MinAmount, "min", 500, "min amount")
+	flag.Float64Var(&cfg.MaxAmount, "max", 20000, "max amount")
+	providers := flag.String("providers", "sbp,card", "providers, comma separated")
+	flag.Float64Var(&cfg.Contention, "contention", 0, "share of payments taken by other traders")
+	flag.DurationVar(&cfg.RivalDelay, "rival-delay", 150*time.Millisecond, "how fast other traders take them")
+	flag.DurationVar(&cfg.TakeLatency, "take-latency", 0, "take response time")
+	flag.DurationVar(&cfg.APILatency, "api-latency", 0, "response time of the other requests")
+	flag.Float64Var(&cfg.Jitter, "jitter", 0.2, "latency spread, share")
+	flag.Float64Var(&cfg.TakeErrors, "take-errors", 0, "share of successful takes answered with 502")
+	report := flag.Duration("report", 10*time.Second, "summary interval, 0 — only on exit")
+	flag.Parse()
+	cfg.Providers = strings.Split(*providers, ",")
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen %s: %v", *addr, err)
+	}
+	srv := p2cfake.NewOn(cfg, l)
+	defer srv.Close()
+	if err := os.WriteFile(*certFile, srv.CertPEM(), 0o644); err != nil {
+		log.Fatalf("write cert: %v", err)
+	}
+	fmt.Printf("fake P2C at %s\nstart the engine with:\n  P2C_BASE_URL=%s SSL_CERT_FILE=%s\n", srv.URL(), srv.URL(), *certFile)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var tick <-chan time.Time
+	if *report > 0 {
+		t := time.NewTicker(*report)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println(srv.Stats())
+			return
+		case <-tick:
+			fmt.Println(srv.Stats())
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Command p2c-migrate imports account configs exported from the bot's
+// database (CSV with a header row, or a JSON array) into the engine storage,
+// checking every access token against the P2C API first:
+//
+//	go run ./cmd/p2c-migrate -in accounts.csv -storage ./engine-data
+//
+// Columns: account_id (or id), access_token, chat_id (or
+// notification_chat_id), is_active, auto_mode, min_amount, max_amount,
+// p2c_account_id, label (or name), owner, note, tenant_id. Accounts whose
+// token P2C rejects are reported as dead and not imported. The engine
+// encrypts tokens at rest, so ENGINE_SECRET_KEY must be the engine's key.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/store"
+)
+
+// result is the outcome of one account.
+type result struct {
+	cfg    engine.WorkerConfig
+	status string // imported, dead, error, invalid, ok (dry run)
+	note   string
+}
+
+func main() {
+	in := flag.String("in", "", "export file: .csv or .json")
+	storage := flag.String("storage", getenv("ENGINE_STORAGE", os.Getenv("ENGINE_DATA_DIR")), "engine storage: directory, sqlite:// or postgres:// URL")
+	baseURL := flag.String("p2c-url", strings.Split(getenv("P2C_BASE_URL", "https://app.cr.bot/internal/v1"), ",")[0], "P2C API base URL")
+	dryRun := flag.Bool("dry-run", false, "validate only, write nothing")
+	noValidate := flag.Bool("no-validate", false, "import without checking tokens against P2C")
+	workers := flag.Int("concurrency", 4, "parallel token checks")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of one token check")
+	flag.Parse()
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfgs, invalid, err := readExport(*in)
+	if err != nil {
+		log.Fatalf("read %s: %v", *in, err)
+	}
+	var st *store.Store
+	if !*dryRun {
+		key := os.Getenv("ENGINE_SECRET_KEY")
+		if key == "" {
+			log.Fatalf("ENGINE_SECRET_KEY is required: tokens are stored encrypted with the engine key")
+		}
+		if err := store.SetSecretKey(key); err != nil {
+			log.Fatalf("secret key: %v", err)
+		}
+		if st, err = store.OpenURL(*storage); err != nil || st == nil {
+			log.Fatalf("open storage %q: %v", *storage, err)
+		}
+		defer st.Close()
+	}
+
+	results := make([]result, len(cfgs))
+	sem := make(chan struct{}, max(*workers, 1))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, cfg engine.WorkerConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = migrate(cfg, *baseURL, *timeout, st, *noValidate)
+		}(i, cfg)
+	}
+	wg.Wait()
+	results = append(invalid, results...)
+	os.Exit(report(os.Stdout, results))
+}
+
+// migrate checks the token and writes the config.
+func migrate(cfg engine.WorkerConfig, baseURL string, timeout time.Duration, st *store.Store, noValidate bool) result {
+	res := result{cfg: cfg}
+	if !noValidate {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := p2c.NewClient(baseURL, cfg.AccessToken.Reveal()).ListPayments(ctx, p2c.ListPaymentsParams{Size: 1})
+		switch {
+		case errors.Is(err, p2c.ErrUnauthorized):
+			res.status, res.note = "dead", "token rejected by P2C"
+			return res
+		case err != nil:
+			res.status, res.note = "error", err.Error()
+			return res
+		}
+	}
+	if st == nil {
+		res.status = "ok"
+		return res
+	}
+	if err := engine.SaveAccountConfig(st, cfg); err != nil {
+		res.status, res.note = "error", err.Error()
+		return res
+	}
+	res.status = "imported"
+	return res
+}
+
+// report prints the table and a summary; the exit code is 1 if anything
+// was not imported.
+func report(out io.Writer, results []result) int {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tLABEL\tSTATUS\tNOTE")
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.status]++
+		id := "-"
+		if r.cfg.AccountID != 0 {
+			id = strconv.FormatInt(r.cfg.AccountID, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", id, r.cfg.Label, r.status, r.note)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "\nimported=%d ok=%d dead=%d error=%d invalid=%d\n", counts["imported"], counts["ok"], counts["dead"], counts["error"], counts["invalid"])
+	if counts["dead"]+counts["error"]+counts["invalid"] > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readExport parses the export into configs; rows that cannot be used are
+// returned as invalid results.
+func readExport(path string) ([]engine.WorkerConfig, []result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var rows []map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		rows, err = readJSON(f)
+	} else {
+		rows, err = readCSV(f)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var cfgs []engine.WorkerConfig
+	var invalid []result
+	seen := make(map[int64]bool)
+	for i, row := range rows {
+		cfg, err := parseRow(row)
+		if err == nil && seen[cfg.AccountID] {
+			err = fmt.Errorf("duplicate account %d", cfg.AccountID)
+		}
+		if err != nil {
+			invalid = append(invalid, result{cfg: cfg, status: "invalid", note: fmt.Sprintf("row %d: %v", i+1, err)})
+			continue
+		}
+		seen[cfg.AccountID] = true
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, invalid, nil
+}
+
+func readCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(rec) {
+				row[strings.ToLower(strings.TrimSpace(col))] = strings.TrimSpace(rec[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJSON(r io.Reader) ([]map[string]string, error) {
+	var items []map[string]any
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		row := make(map[string]string, len(item))
+		for k, v := range item {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				row[strings.ToLower(k)] = v
+			default:
+				b, _ := json.Marshal(v)
+				row[strings.ToLower(k)] = string(b)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseRow maps an export row to a worker config. Booleans default to true
+// like in the bot; empty amounts mean no limit.
+func parseRow(row map[string]string) (engine.WorkerConfig, error) {
+	col := func(names ...string) string {
+		for _, n := range names {
+			if v := row[n]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	var cfg engine.WorkerConfig
+	id, err := strconv.ParseInt(col("account_id", "id"), 10, 64)
+	if err != nil || id <= 0 {
+		return cfg, fmt.Errorf("bad account_id %q", col("account_id", "id"))
+	}
+	cfg.AccountID = id
+	cfg.Label = col("label", "name")
+	token := col("access_token", "token")
+	if token == "" {
+		return cfg, errors.New("access_token is empty (export decrypted tokens)")
+	}
+	cfg.AccessToken = store.Secret(token)
+	if v := col("chat_id", "notification_chat_id"); v != "" {
+		if cfg.ChatID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return cfg, fmt.Errorf("bad chat_id %q", v)
+		}
+	}
+	if cfg.Active, err = parseBool(col("is_active", "active")); err != nil {
+		return cfg, err
+	}
+	if cfg.AutoMode, err = parseBool(col("auto_mode")); err != nil {
+		return cfg, err
+	}
+	for _, f := range []struct {
+		name string
+		dst  **float64
+	}{{"min_amount", &cfg.MinAmount}, {"max_amount", &cfg.MaxAmount}} {
+		v := col(f.name)
+		if v == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", "."), 64)
+		if err != nil {
+			return cfg, fmt.Errorf("bad %s %q", f.name, v)
+		}
+		*f.dst = &x
+	}
+	cfg.P2CAccountID = col("p2c_account_id")
+	cfg.Owner = col("owner")
+	cfg.Note = col("note")
+	cfg.TenantID = col("tenant_id", "tenant")
+	return cfg, nil
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "", "1", "true", "t", "yes", "y":
+		return true, nil
+	case "0", "false", "f", "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad boolean %q", v)
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,134 @@
+// Command p2c-replay runs a websocket stream recorded by the engine
+// (ENGINE_WS_CAPTURE_DIR) through an account's filters and strategy and
+// prints what the account would have taken, without calling P2C:
+//
+//	go run ./cmd/p2c-replay -in capture/12-20260101-120000.jsonl -config engine-data/accounts/12.json
+//
+// The config is the account document of the engine storage; its access
+// token is not needed and is ignored. -strategy, -params, -min and -max
+// override it, so a filter change can be compared against real traffic.
+// The stream plays at its original speed; -speed 0 replays it at once.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+
+	"p2c-engine/internal/engine"
+)
+
+func main() {
+	in := flag.String("in", "", "recorded stream (.jsonl), - for stdin")
+	config := flag.String("config", "", "account config JSON; empty — amount band without limits")
+	strategy := flag.String("strategy", "", "strategy name, overrides the config")
+	params := flag.String("params", "", "strategy params JSON, overrides the config")
+	minAmount := flag.String("min", "", "min amount, overrides the config")
+	maxAmount := flag.String("max", "", "max amount, overrides the config")
+	speed := flag.Float64("speed", 1, "playback speed: 1 — original timing, 0 — no pauses")
+	takesOnly := flag.Bool("takes", false, "print only the payments that would be taken")
+	flag.Parse()
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if *strategy != "" {
+		cfg.Strategy.Name = *strategy
+	}
+	if *params != "" {
+		cfg.Strategy.Params = nil
+		if err := json.Unmarshal([]byte(*params), &cfg.Strategy.Params); err != nil {
+			log.Fatalf("params: %v", err)
+		}
+	}
+	for _, f := range []struct {
+		name, v string
+		dst     **float64
+	}{{"min", *minAmount, &cfg.MinAmount}, {"max", *maxAmount, &cfg.MaxAmount}} {
+		if f.v == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(f.v, 64)
+		if err != nil {
+			log.Fatalf("bad -%s %q", f.name, f.v)
+		}
+		*f.dst = &x
+	}
+
+	r := os.Stdin
+	if *in != "-" {
+		if r, err = os.Open(*in); err != nil {
+			log.Fatalf("open %s: %v", *in, err)
+		}
+		defer r.Close()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// строки печатаются по мере воспроизведения, поэтому ширина колонок фиксирована
+	const row = "%-23s  %-24s  %10s  %-8s  %s\n"
+	fmt.Printf(row, "TIME", "PAYMENT", "AMOUNT", "PROVIDER", "DECISION")
+	var seen, taken int
+	reasons := make(map[engine.SkipCode]int)
+	err = engine.Replay(ctx, cfg, r, *speed, func(d engine.ReplayDecision) {
+		seen++
+		decision := "take"
+		if d.Take {
+			taken++
+		} else {
+			reasons[d.Code]++
+			decision = "skip: " + d.Reason
+			if *takesOnly {
+				return
+			}
+		}
+		fmt.Printf(row, d.At.Local().Format("2006-01-02 15:04:05.000"), d.ID, d.Amount, d.Provider, decision)
+	})
+	if err != nil {
+		log.Printf("replay: %v", err)
+	}
+	fmt.Printf("\nseen=%d taken=%d skipped=%d\n", seen, taken, seen-taken)
+	keys := make([]engine.SkipCode, 0, len(reasons))
+	for k := range reasons {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return reasons[keys[i]] > reasons[keys[j]] })
+	for _, k := range keys {
+		fmt.Printf("  %6d  %s\n", reasons[k], k)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// loadConfig reads an account document, dropping the access token: it is
+// encrypted with the engine key and replay does not need it.
+func loadConfig(path string) (engine.WorkerConfig, error) {
+	var cfg engine.WorkerConfig
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return cfg, err
+	}
+	delete(doc, "AccessToken")
+	data, _ = json.Marshal(doc)
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
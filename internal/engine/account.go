@@ -0,0 +1,35 @@
+package engine
+
+import "strconv"
+
+// AccountInfo identifies an account for people: chat members see the label
+// and owner of the card behind the account instead of a raw id.
+type AccountInfo struct {
+	ID    int64  `json:"account_id"`
+	Label string `json:"label,omitempty"` // например, «Тинькофф *1234»
+	Owner string `json:"owner,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+// Named reports whether the account has a label or an owner.
+func (a AccountInfo) Named() bool { return a.Label != "" || a.Owner != "" }
+
+// String formats the account as `123 «label» (owner)`.
+func (a AccountInfo) String() string {
+	s := strconv.FormatInt(a.ID, 10)
+	if a.Label != "" {
+		s += " «" + a.Label + "»"
+	}
+	if a.Owner != "" {
+		s += " (" + a.Owner + ")"
+	}
+	return s
+}
+
+func (cfg WorkerConfig) account() AccountInfo {
+	return AccountInfo{ID: cfg.AccountID, Label: cfg.Label, Owner: cfg.Owner, Note: cfg.Note}
+}
+
+func (st WorkerStatus) account() AccountInfo {
+	return AccountInfo{ID: st.AccountID, Label: st.Label, Owner: st.Owner, Note: st.Note}
+}
@@ -0,0 +1,380 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/i18n"
+)
+
+// Alert rule kinds.
+const (
+	AlertWSReconnects  = "ws_reconnects"   // больше N переподключений websocket за окно
+	AlertNoEvents      = "no_events"       // ни одного события ленты за окно
+	AlertTakeErrorRate = "take_error_rate" // доля неудачных take за окно больше порога
+	AlertWSDeaf        = "ws_deaf"         // больше N переподключений из-за молчащего websocket за окно
+	AlertPanics        = "panics"          // больше N перехваченных паник воркера за окно
+)
+
+const (
+	alertTick      = 30 * time.Second
+	alertMinTakes  = 5 // меньше попыток в окне — доля ошибок ничего не говорит
+	healthKeep     = 24 * time.Hour
+	healthMaxItems = 4096
+)
+
+// AlertRule is one error budget: the account alerts while the measured
+// value exceeds Threshold over Window. With business hours set (From !=
+// To) the rule is checked only between From and To o'clock local time.
+type AlertRule struct {
+	Spec      string        `json:"spec"`
+	Kind      string        `json:"kind"`
+	Threshold float64       `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	From      int           `json:"from,omitempty"`
+	To        int           `json:"to,omitempty"`
+}
+
+// ParseAlertRules parses comma-separated rules "kind[>threshold]/window[@HH-HH]",
+// e.g. "ws_reconnects>3/10m,no_events/15m@09-21,take_error_rate>0.2/10m,ws_deaf>0/1h,panics>0/1h".
+func ParseAlertRules(s string) ([]AlertRule, error) {
+	var rules []AlertRule
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r := AlertRule{Spec: spec}
+		rest := spec
+		if i := strings.LastIndex(rest, "@"); i >= 0 {
+			from, to, ok := strings.Cut(rest[i+1:], "-")
+			var err1, err2 error
+			r.From, err1 = strconv.Atoi(from)
+			r.To, err2 = strconv.Atoi(to)
+			if !ok || err1 != nil || err2 != nil || r.From < 0 || r.From > 23 || r.To < 0 || r.To > 24 {
+				return nil, fmt.Errorf("alert %q: bad hours %q", spec, rest[i+1:])
+			}
+			rest = rest[:i]
+		}
+		head, window, ok := strings.Cut(rest, "/")
+		if !ok {
+			return nil, fmt.Errorf("alert %q: window is required", spec)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("alert %q: bad window %q", spec, window)
+		}
+		r.Window = d
+		r.Kind = head
+		if kind, threshold, ok := strings.Cut(head, ">"); ok {
+			r.Kind = kind
+			if r.Threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+				return nil, fmt.Errorf("alert %q: bad threshold %q", spec, threshold)
+			}
+		}
+		switch r.Kind {
+		case AlertWSReconnects, AlertNoEvents, AlertTakeErrorRate, AlertWSDeaf, AlertPanics:
+		default:
+			return nil, fmt.Errorf("alert %q: unknown kind %q", spec, r.Kind)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// active reports whether the rule is checked at now.
+func (r AlertRule) active(now time.Time) bool {
+	if r.From == r.To {
+		return true
+	}
+	h := now.Hour()
+	if r.From < r.To {
+		return h >= r.From && h < r.To
+	}
+	return h >= r.From || h < r.To // окно через полночь, например 22-06
+}
+
+// Alert is a rule firing for an account.
+type Alert struct {
+	Rule      string    `json:"rule"`
+	AccountID int64     `json:"account_id"`
+	Account   string    `json:"account"`
+	Value     float64   `json:"value"`
+	Detail    string    `json:"detail"`
+	Since     time.Time `json:"since"`
+}
+
+// health keeps the recent signals of a worker the alert rules look at.
+type health struct {
+	mu         sync.Mutex
+	reconnects []time.Time
+	deafs      []time.Time // переподключения из-за молчащего websocket
+	panics     []time.Time
+	takes      []takeOutcome
+	lastEvent  time.Time
+}
+
+func (h *health) reconnect(now time.Time) {
+	h.mu.Lock()
+	h.reconnects = trimTimes(append(h.reconnects, now), now)
+	h.mu.Unlock()
+}
+
+func (h *health) deaf(now time.Time) {
+	h.mu.Lock()
+	h.deafs = trimTimes(append(h.deafs, now), now)
+	h.mu.Unlock()
+}
+
+func (h *health) panic(now time.Time) {
+	h.mu.Lock()
+	h.panics = trimTimes(append(h.panics, now), now)
+	h.mu.Unlock()
+}
+
+// silence is how long there were no list events.
+func (h *health) silence(now time.Time) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastEvent.IsZero() {
+		return 0
+	}
+	return now.Sub(h.lastEvent)
+}
+
+func (h *health) event(now time.Time) {
+	h.mu.Lock()
+	h.lastEvent = now
+	h.mu.Unlock()
+}
+
+func (h *health) take(ok bool, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.takes = append(h.takes, takeOutcome{at: now, ok: ok})
+	i := 0
+	for i < len(h.takes) && (now.Sub(h.takes[i].at) > healthKeep || len(h.takes)-i > healthMaxItems) {
+		i++
+	}
+	h.takes = h.takes[i:]
+}
+
+func trimTimes(ts []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(ts) && (now.Sub(ts[i]) > healthKeep || len(ts)-i > healthMaxItems) {
+		i++
+	}
+	return ts[i:]
+}
+
+// check evaluates the rule; detail describes the value for the ops chat.
+func (h *health) check(r AlertRule, now time.Time) (value float64, detail string, firing bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := now.Add(-r.Window)
+	switch r.Kind {
+	case AlertWSReconnects:
+		for _, t := range h.reconnects {
+			if t.After(since) {
+				value++
+			}
+		}
+		return value, fmt.Sprintf("%.0f reconnects in %s", value, r.Window), value > r.Threshold
+	case AlertWSDeaf:
+		for _, t := range h.deafs {
+			if t.After(since) {
+				value++
+			}
+		}
+		return value, fmt.Sprintf("%.0f silent websocket reconnects in %s", value, r.Window), value > r.Threshold
+	case AlertPanics:
+		for _, t := range h.panics {
+			if t.After(since) {
+				value++
+			}
+		}
+		return value, fmt.Sprintf("%.0f panics in %s", value, r.Window), value > r.Threshold
+	case AlertNoEvents:
+		if h.lastEvent.IsZero() {
+			return 0, "", false
+		}
+		silent := now.Sub(h.lastEvent)
+		return silent.Seconds(), fmt.Sprintf("no events for %s", silent.Round(time.Second)), silent > r.Window
+	case AlertTakeErrorRate:
+		var total, failed int
+		for _, t := range h.takes {
+			if t.at.After(since) {
+				total++
+				if !t.ok {
+					failed++
+				}
+			}
+		}
+		if total < alertMinTakes {
+			return 0, "", false
+		}
+		value = float64(failed) / float64(total)
+		return value, fmt.Sprintf("%d of %d takes failed in %s", failed, total, r.Window), value > r.Threshold
+	}
+	return 0, "", false
+}
+
+// alerting evaluates the rules for every running worker and notifies the
+// ops chat or webhook, separately from the per-order messages of accounts.
+type alerting struct {
+	rules    []AlertRule
+	botToken string
+	chatID   int64
+	webhook  string
+	client   *http.Client
+
+	mu     sync.Mutex
+	firing map[string]Alert // rule spec + "/" + account
+}
+
+// EnableAlerts turns on the alert rules. Notifications go to the Telegram
+// chatID through the engine bot and/or are posted as JSON to webhook.
+func (m *Manager) EnableAlerts(rules []AlertRule, chatID int64, webhook string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alerts = &alerting{
+		rules:    rules,
+		botToken: m.botToken,
+		chatID:   chatID,
+		webhook:  webhook,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		firing:   make(map[string]Alert),
+	}
+}
+
+// RunAlerts checks the rules every 30s until ctx is done.
+func (m *Manager) RunAlerts(ctx context.Context) {
+	m.mu.Lock()
+	a := m.alerts
+	m.mu.Unlock()
+	if a == nil {
+		return
+	}
+	ticker := time.NewTicker(alertTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.evaluate(m.snapshotWorkers(), now)
+		}
+	}
+}
+
+func (a *alerting) evaluate(workers []*Worker, now time.Time) {
+	seen := make(map[string]bool)
+	for _, w := range workers {
+		cfg := w.config()
+		// остановленный воркер не слушает ленту — молчание и ошибки не его
+		if !cfg.Active || !cfg.AutoMode {
+			continue
+		}
+		for _, r := range a.rules {
+			if !r.active(now) {
+				continue
+			}
+			value, detail, firing := w.health.check(r, now)
+			if !firing {
+				continue
+			}
+			key := r.Spec + "/" + strconv.FormatInt(cfg.AccountID, 10)
+			seen[key] = true
+			a.mu.Lock()
+			_, already := a.firing[key]
+			alert := Alert{Rule: r.Spec, AccountID: cfg.AccountID, Account: cfg.account().String(), Value: value, Detail: detail, Since: now}
+			if already {
+				alert.Since = a.firing[key].Since
+			}
+			a.firing[key] = alert
+			a.mu.Unlock()
+			if !already {
+				log.Printf("[alert] %s account=%d: %s", r.Spec, cfg.AccountID, detail)
+				a.notify(alert, true)
+			}
+		}
+	}
+	a.mu.Lock()
+	var resolved []Alert
+	for key, alert := range a.firing {
+		if !seen[key] {
+			delete(a.firing, key)
+			resolved = append(resolved, alert)
+		}
+	}
+	a.mu.Unlock()
+	for _, alert := range resolved {
+		log.Printf("[alert] resolved %s account=%d", alert.Rule, alert.AccountID)
+		a.notify(alert, false)
+	}
+}
+
+// notify sends the alert to the ops chat and webhook without blocking the
+// evaluation.
+func (a *alerting) notify(alert Alert, firing bool) {
+	if a.chatID != 0 && a.botToken != "" {
+		text := i18n.T("", "alert.resolved", alert.Account, alert.Rule)
+		if firing {
+			text = i18n.T("", "alert.firing", alert.Account, alert.Rule, alert.Detail)
+		}
+		telegramSender(a.botToken).enqueue(textMessage(a.chatID, text, nil))
+	}
+	if a.webhook == "" {
+		return
+	}
+	status := "resolved"
+	if firing {
+		status = "firing"
+	}
+	body, _ := json.Marshal(struct {
+		Alert
+		Status string `json:"status"`
+	}{alert, status})
+	go func() {
+		resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[alert] webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("[alert] webhook status %d", resp.StatusCode)
+		}
+	}()
+}
+
+// active returns the firing alerts of the accounts in ids, oldest first.
+func (a *alerting) active(ids map[int64]bool) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Alert, 0, len(a.firing))
+	for _, alert := range a.firing {
+		if ids[alert.AccountID] {
+			out = append(out, alert)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Since.Equal(out[j].Since) {
+			return out[i].Since.Before(out[j].Since)
+		}
+		if out[i].AccountID != out[j].AccountID {
+			return out[i].AccountID < out[j].AccountID
+		}
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
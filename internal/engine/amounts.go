@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// checkAmount applies the account's amount pattern allow/deny lists to a
+// live payment. A pattern is either a glob over the amount written without
+// trailing zeros ("*.99", "*000", "1?00") or "%N" for multiples of N
+// ("%100" — round hundreds). An empty allow list allows all.
+func checkAmount(cfg WorkerConfig, inAmount string) Decision {
+	if len(cfg.AmountsAllow) == 0 && len(cfg.AmountsDeny) == 0 {
+		return take()
+	}
+	amount := normalizeAmount(inAmount)
+	for _, deny := range cfg.AmountsDeny {
+		if matchAmount(deny, amount) {
+			return skip(SkipAmountPattern, "amount %s matches %q", amount, deny)
+		}
+	}
+	if len(cfg.AmountsAllow) == 0 {
+		return take()
+	}
+	for _, allow := range cfg.AmountsAllow {
+		if matchAmount(allow, amount) {
+			return take()
+		}
+	}
+	return skip(SkipAmountPattern, "amount %s matches no allowed pattern", amount)
+}
+
+// normalizeAmount writes the amount without trailing zeros: "1500.00" is
+// "1500", "1499.90" is "1499.9".
+func normalizeAmount(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", ".")
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return s
+}
+
+func matchAmount(pattern, amount string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if n, ok := strings.CutPrefix(pattern, "%"); ok {
+		step, err := strconv.ParseFloat(n, 64)
+		v, err2 := strconv.ParseFloat(amount, 64)
+		if err != nil || err2 != nil || step <= 0 {
+			return false
+		}
+		// копейки считаем целыми, чтобы 0.1 + 0.2 не ломали кратность
+		cents, stepCents := int64(v*100+0.5), int64(step*100+0.5)
+		return stepCents > 0 && cents%stepCents == 0
+	}
+	ok, _ := path.Match(pattern, amount)
+	return ok
+}
+
+// ValidAmountPattern reports whether pattern can match anything: "%N" needs
+// a positive N, a glob must be well-formed.
+func ValidAmountPattern(pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if n, ok := strings.CutPrefix(pattern, "%"); ok {
+		step, err := strconv.ParseFloat(n, 64)
+		return err == nil && step >= 0.01
+	}
+	_, err := path.Match(pattern, "")
+	return pattern != "" && err == nil
+}
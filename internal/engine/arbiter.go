@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// arbiter picks a single account of the fleet to take a live payment that
+// every worker receives from the same marketplace, so our own accounts do
+// not race each other into ActiveOrderExists/conflict errors.
+type arbiter struct {
+	workers func() []*Worker
+
+	mu        sync.Mutex
+	decisions map[string]arbDecision
+	turns     map[string]map[int64]int // текущие веса взвешенной очереди по флоту
+}
+
+type arbDecision struct {
+	accountID int64
+	at        time.Time
+}
+
+func newArbiter(workers func() []*Worker) *arbiter {
+	return &arbiter{workers: workers, decisions: make(map[string]arbDecision), turns: make(map[string]map[int64]int)}
+}
+
+// decide returns the account that should take the payment. The first worker
+// to ask makes the decision among currently eligible workers of its tenant;
+// others reuse it. Tenants are independent fleets and never yield to each
+// other, so decisions are keyed by tenant too; so are environments, since
+// sandbox and production are different marketplaces.
+//
+// Only the eligible accounts of the highest priority compete; among them the
+// payment goes by smooth weighted round-robin, so an account of weight 3
+// gets three payments for every one of an account of weight 1, in a fixed
+// interleaved order rather than by whichever goroutine asks first.
+func (a *arbiter) decide(p p2c.LivePayment, caller *Worker) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	fleet := caller.fleet()
+	key := fleet + "/" + p.ID
+	if d, ok := a.decisions[key]; ok {
+		return d.accountID
+	}
+	for id, d := range a.decisions {
+		if now.Sub(d.at) > time.Minute {
+			delete(a.decisions, id)
+		}
+	}
+
+	top := []*Worker{caller}
+	for _, w := range a.workers() {
+		if w == caller || w.fleet() != fleet || !w.eligible(p, now) {
+			continue
+		}
+		switch wp, tp := w.config().Priority, top[0].config().Priority; {
+		case wp > tp:
+			top = []*Worker{w}
+		case wp == tp:
+			top = append(top, w)
+		}
+	}
+	best := a.next(fleet, top)
+	a.decisions[key] = arbDecision{accountID: best.accountID, at: now}
+	return best.accountID
+}
+
+// next is one step of smooth weighted round-robin over the candidates: each
+// gains its weight, the one with the most current weight wins and pays back
+// the total. Accounts that are not candidates keep their current weight.
+func (a *arbiter) next(fleet string, candidates []*Worker) *Worker {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	turns := a.turns[fleet]
+	if turns == nil {
+		turns = make(map[int64]int)
+		a.turns[fleet] = turns
+	}
+	var best *Worker
+	total := 0
+	for _, w := range candidates {
+		weight := w.config().weight()
+		total += weight
+		turns[w.accountID] += weight
+		if best == nil || turns[w.accountID] > turns[best.accountID] ||
+			turns[w.accountID] == turns[best.accountID] && w.accountID < best.accountID {
+			best = w
+		}
+	}
+	turns[best.accountID] -= total
+	return best
+}
+
+// forget drops the round-robin state of a removed account.
+func (a *arbiter) forget(accountID int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, turns := range a.turns {
+		delete(turns, accountID)
+	}
+}
+
+// eligible reports whether the worker could take the payment right now: no
+// active order, penalty or pause, the same filter its own live path runs
+// (providers, brands, amounts, market, strategy, daily cap) and room in the
+// owner group cap. Sharing filter keeps the arbiter from handing a payment to
+// an account that would then skip it.
+func (w *Worker) eligible(p p2c.LivePayment, now time.Time) bool {
+	if !w.available(now) {
+		return false
+	}
+	if d := w.filter(p, now); !d.Take {
+		return false
+	}
+	amount, _ := strconv.ParseFloat(p.InAmount, 64)
+	return w.groups.room(w, amount, now)
+}
+
+// available reports whether the worker takes payments at all right now:
+// auto mode on and not paused, in maintenance or a blackout, no active order,
+// penalty or open breaker, and daily cap left.
+func (w *Worker) available(now time.Time) bool {
+	cfg := w.config()
+	if !cfg.Active || !cfg.AutoMode || w.paused.Load() || w.maint.active() {
+		return false
+	}
+	if _, on := w.blackouts.active(cfg.TenantID, cfg.AccountID, now); on || w.isActiveLocked(now) {
+		return false
+	}
+	if _, penalized := w.penalties.Blocked(cfg.AccountID, now); penalized {
+		return false
+	}
+	if _, open := w.breaker.blocked(now); open {
+		return false
+	}
+	return w.remainingCap(now) > 0
+}
+
+// weight is the account's share in the arbiter's round-robin, at least 1.
+func (c WorkerConfig) weight() int {
+	return max(c.Weight, 1)
+}
+
+// remainingCap returns fiat volume left for today; +Inf when no cap is set.
+func (w *Worker) remainingCap(now time.Time) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cfg.DailyCap <= 0 {
+		return math.Inf(1)
+	}
+	if w.dayKey != now.Format("2006-01-02") {
+		return w.cfg.DailyCap
+	}
+	return w.cfg.DailyCap - w.dayVolume
+}
+
+// addDayVolume accounts a taken payment against the daily cap.
+func (w *Worker) addDayVolume(amount string, now time.Time) {
+	v, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return
+	}
+	day := now.Format("2006-01-02")
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dayKey != day {
+		w.dayKey = day
+		w.dayVolume = 0
+		w.dayCount = 0
+	}
+	w.dayVolume += v
+	w.dayCount++
+}
@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/store"
+)
+
+const (
+	tuneEvery      = time.Hour
+	tuneWindow     = 7 * 24 * time.Hour // по каким заявкам судим о полосе
+	tuneBands      = 4                  // на сколько равных полос делим [min, max]
+	tuneMinSamples = 5                  // меньше исходов в полосе — о ней ничего не говорим
+	tuneBadRate    = 0.4                // доля отмен/истечений/споров, с которой полосу отрезаем
+	tuneGoodRate   = 0.1                // крайняя полоса с такой долей и меньше — границу можно расширить
+	tuneMinSkips   = 20                 // сколько заявок за неделю должно упереться в границу, чтобы её расширить
+	tuneWidenPct   = 10
+	tuneQuiet      = 24 * time.Hour // после отказа оператора не предлагаем снова
+)
+
+// ErrNoProposal is returned when applying or rejecting a proposal that is
+// not the account's pending one: it was answered already or replaced.
+var ErrNoProposal = errors.New("no such pending proposal")
+
+func tuneDoc(accountID int64) string {
+	return fmt.Sprintf("tune/%d", accountID)
+}
+
+// TuneBand is the outcome tally of one amount band.
+type TuneBand struct {
+	From      float64 `json:"from"`
+	To        float64 `json:"to"`
+	Completed int     `json:"completed"`
+	Canceled  int     `json:"canceled"`
+	Expired   int     `json:"expired"`
+	Disputed  int     `json:"disputed"`
+}
+
+func (b TuneBand) total() int { return b.Completed + b.Canceled + b.Expired + b.Disputed }
+
+// BadRate is the share of taken payments of the band that did not complete.
+func (b TuneBand) BadRate() float64 {
+	if b.total() == 0 {
+		return 0
+	}
+	return float64(b.Canceled+b.Expired+b.Disputed) / float64(b.total())
+}
+
+// TuneProposal is a change of the amount filter suggested by the adaptive
+// mode. It waits for the operator: nothing changes until it is applied.
+type TuneProposal struct {
+	ID        string     `json:"id"`
+	AccountID int64      `json:"account_id"`
+	OldMin    *float64   `json:"old_min,omitempty"`
+	OldMax    *float64   `json:"old_max,omitempty"`
+	NewMin    *float64   `json:"new_min,omitempty"`
+	NewMax    *float64   `json:"new_max,omitempty"`
+	Bands     []TuneBand `json:"bands"`
+	SkipsLow  int        `json:"skips_below_min"`
+	SkipsHigh int        `json:"skips_above_max"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (p *TuneProposal) same(o *TuneProposal) bool {
+	return p != nil && o != nil && tuneBound(p.NewMin) == tuneBound(o.NewMin) && tuneBound(p.NewMax) == tuneBound(o.NewMax)
+}
+
+// autoTuner keeps the pending proposal of an account. It is persisted, so
+// a proposal sent before a restart can still be applied after it.
+type autoTuner struct {
+	mu    sync.Mutex
+	state tuneState
+	store *store.Store
+	doc   string
+}
+
+type tuneState struct {
+	Pending    *TuneProposal `json:"pending,omitempty"`
+	AppliedAt  time.Time     `json:"applied_at,omitempty"`  // исходы до этого момента уже учтены
+	QuietUntil time.Time     `json:"quiet_until,omitempty"` // оператор отказался: молчим до этого времени
+}
+
+func (t *autoTuner) attach(st *store.Store, accountID int64) {
+	if st == nil {
+		return
+	}
+	var saved tuneState
+	if err := st.Load(tuneDoc(accountID), &saved); err != nil {
+		log.Printf("[tune %d] load error: %v", accountID, err)
+	}
+	t.mu.Lock()
+	t.store, t.doc, t.state = st, tuneDoc(accountID), saved
+	t.mu.Unlock()
+}
+
+// saveLocked writes the state; t.mu must be held.
+func (t *autoTuner) saveLocked() {
+	if err := t.store.Save(t.doc, t.state); err != nil {
+		log.Printf("[store] save %s error: %v", t.doc, err)
+	}
+}
+
+func (t *autoTuner) pending() *TuneProposal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state.Pending
+}
+
+// offer makes p the pending proposal unless the operator asked for quiet or
+// the same change is already waiting; it reports whether p is new.
+func (t *autoTuner) offer(p *TuneProposal, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Before(t.state.QuietUntil) || p.same(t.state.Pending) {
+		return false
+	}
+	t.state.Pending = p
+	t.saveLocked()
+	return true
+}
+
+// take removes the pending proposal with the given id; applied proposals
+// reset the window, rejected ones silence the tuner for tuneQuiet.
+func (t *autoTuner) take(id string, applied bool, now time.Time) (*TuneProposal, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.state.Pending
+	if p == nil || p.ID != id {
+		return nil, ErrNoProposal
+	}
+	t.state.Pending = nil
+	if applied {
+		t.state.AppliedAt = now
+	} else {
+		t.state.QuietUntil = now.Add(tuneQuiet)
+	}
+	t.saveLocked()
+	return p, nil
+}
+
+func (t *autoTuner) since(now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if from := now.Add(-tuneWindow); from.After(t.state.AppliedAt) {
+		return from
+	}
+	return t.state.AppliedAt
+}
+
+// proposeTune looks at how the payments taken since `since` ended, by amount
+// band, and suggests cutting an edge band that mostly fails or widening a
+// bound that is reliable at its edge and often turns payments away. It
+// returns nil when there is nothing to change.
+func proposeTune(cfg WorkerConfig, records []PaymentRecord, skipsLow, skipsHigh int, since time.Time) *TuneProposal {
+	type outcome struct {
+		amount float64
+		state  PaymentState
+	}
+	var outcomes []outcome
+	for _, rec := range records {
+		if rec.TakenAt.Before(since) {
+			continue
+		}
+		switch rec.Status {
+		case StateCompleted, StateCanceled, StateExpired, StateDisputed:
+		default:
+			continue // открытые и не взятые ещё ничего не говорят
+		}
+		v, err := strconv.ParseFloat(strings.ReplaceAll(rec.InAmount, ",", "."), 64)
+		if err != nil {
+			continue
+		}
+		outcomes = append(outcomes, outcome{v, rec.Status})
+	}
+	if len(outcomes) < tuneMinSamples {
+		return nil
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].amount < outcomes[j].amount })
+	lo, hi := outcomes[0].amount, outcomes[len(outcomes)-1].amount
+	if cfg.MinAmount != nil {
+		lo = *cfg.MinAmount
+	}
+	if cfg.MaxAmount != nil && *cfg.MaxAmount > 0 {
+		hi = *cfg.MaxAmount
+	}
+	if hi <= lo {
+		return nil
+	}
+	width := (hi - lo) / tuneBands
+	bands := make([]TuneBand, tuneBands)
+	for i := range bands {
+		bands[i].From, bands[i].To = lo+width*float64(i), lo+width*float64(i+1)
+	}
+	for _, o := range outcomes {
+		i := int((o.amount - lo) / width)
+		i = max(0, min(i, tuneBands-1))
+		switch o.state {
+		case StateCompleted:
+			bands[i].Completed++
+		case StateCanceled:
+			bands[i].Canceled++
+		case StateExpired:
+			bands[i].Expired++
+		case StateDisputed:
+			bands[i].Disputed++
+		}
+	}
+
+	p := &TuneProposal{AccountID: cfg.AccountID, OldMin: cfg.MinAmount, OldMax: cfg.MaxAmount, NewMin: cfg.MinAmount, NewMax: cfg.MaxAmount,
+		Bands: bands, SkipsLow: skipsLow, SkipsHigh: skipsHigh}
+	first, last := bands[0], bands[tuneBands-1]
+	switch {
+	case first.total() >= tuneMinSamples && first.BadRate() >= tuneBadRate:
+		p.NewMin = tuneValue(first.To)
+	case cfg.MinAmount != nil && *cfg.MinAmount > 0 && skipsLow >= tuneMinSkips &&
+		first.total() >= tuneMinSamples && first.BadRate() <= tuneGoodRate:
+		p.NewMin = tuneValue(*cfg.MinAmount * (1 - tuneWidenPct/100.0))
+	}
+	switch {
+	case last.total() >= tuneMinSamples && last.BadRate() >= tuneBadRate:
+		p.NewMax = tuneValue(last.From)
+	case cfg.MaxAmount != nil && *cfg.MaxAmount > 0 && skipsHigh >= tuneMinSkips &&
+		last.total() >= tuneMinSamples && last.BadRate() <= tuneGoodRate:
+		p.NewMax = tuneValue(*cfg.MaxAmount * (1 + tuneWidenPct/100.0))
+	}
+	if deref(p.NewMin) == deref(cfg.MinAmount) && deref(p.NewMax) == deref(cfg.MaxAmount) {
+		return nil
+	}
+	return p
+}
+
+// tuneValue rounds a suggested bound to whole units of fiat.
+func tuneValue(v float64) *float64 {
+	v = math.Round(v)
+	return &v
+}
+
+func newProposalID() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// runAutoTune re-evaluates the amount bands every tuneEvery while AutoTune
+// is on and asks the operator to approve each new proposal.
+func (w *Worker) runAutoTune(ctx context.Context) {
+	ticker := time.NewTicker(tuneEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.evaluateTune(time.Now())
+	}
+}
+
+func (w *Worker) evaluateTune(now time.Time) {
+	cfg := w.config()
+	if !cfg.AutoTune {
+		return
+	}
+	since := w.tuner.since(now)
+	skipsLow := len(w.skips.find(SkipQuery{Code: SkipBelowMin, Since: since}))
+	skipsHigh := len(w.skips.find(SkipQuery{Code: SkipAboveMax, Since: since}))
+	p := proposeTune(cfg, w.journal.list(), skipsLow, skipsHigh, since)
+	if p == nil {
+		return
+	}
+	p.ID, p.CreatedAt = newProposalID(), now
+	if !w.tuner.offer(p, now) {
+		return
+	}
+	w.logf("auto-tune proposal %s: min %s -> %s, max %s -> %s", p.ID,
+		tuneBound(p.OldMin), tuneBound(p.NewMin), tuneBound(p.OldMax), tuneBound(p.NewMax))
+	w.send(Notification{Text: tuneText(cfg.Locale, cfg.account(), p), Markup: tuneKeyboard(cfg.Locale, p)})
+}
+
+func tuneBound(v *float64) string {
+	if v == nil || *v == 0 {
+		return "—"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// tuneText renders the proposal with the band tally it is based on.
+func tuneText(locale string, account AccountInfo, p *TuneProposal) string {
+	lines := []string{i18n.T(locale, "tune.proposal", account, tuneBound(p.OldMin), tuneBound(p.OldMax), tuneBound(p.NewMin), tuneBound(p.NewMax))}
+	for _, b := range p.Bands {
+		lines = append(lines, i18n.T(locale, "tune.band", b.From, b.To, b.Completed, b.Canceled, b.Expired, b.Disputed, b.BadRate()*100))
+	}
+	if p.SkipsLow > 0 || p.SkipsHigh > 0 {
+		lines = append(lines, i18n.T(locale, "tune.skips", p.SkipsLow, p.SkipsHigh))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func tuneKeyboard(locale string, p *TuneProposal) map[string]any {
+	data := fmt.Sprintf("tune:%d:%s:", p.AccountID, p.ID)
+	return map[string]any{"inline_keyboard": [][]map[string]string{{
+		{"text": i18n.T(locale, "button.tune_apply"), "callback_data": data + "apply"},
+		{"text": i18n.T(locale, "button.tune_reject"), "callback_data": data + "reject"},
+	}}}
+}
+
+// TuneProposal returns the account's pending amount filter proposal, nil
+// if there is none.
+func (m *Manager) TuneProposal(accountID int64) (*TuneProposal, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, ErrNoWorker
+	}
+	return w.tuner.pending(), nil
+}
+
+// ApplyTune sets the amount filter the proposal suggests. The bounds are
+// changed in place, like /setmin and /setmax.
+func (m *Manager) ApplyTune(accountID int64, id string) (*TuneProposal, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, ErrNoWorker
+	}
+	p := w.tuner.pending()
+	if p == nil || p.ID != id {
+		return nil, ErrNoProposal
+	}
+	err := m.UpdateConfig(accountID, func(cfg *WorkerConfig) {
+		cfg.MinAmount, cfg.MaxAmount = p.NewMin, p.NewMax
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.tuner.take(id, true, time.Now()); err != nil {
+		return nil, err // пока применяли, ответили на неё же
+	}
+	cfg := w.config()
+	w.logf("auto-tune proposal %s applied", p.ID)
+	w.notify(i18n.T(cfg.Locale, "tune.applied", cfg.account(), tuneBound(p.NewMin), tuneBound(p.NewMax)))
+	return p, nil
+}
+
+// RejectTune drops the pending proposal; the tuner stays quiet for a day.
+func (m *Manager) RejectTune(accountID int64, id string) error {
+	w := m.worker(accountID)
+	if w == nil {
+		return ErrNoWorker
+	}
+	p, err := w.tuner.take(id, false, time.Now())
+	if err != nil {
+		return err
+	}
+	cfg := w.config()
+	w.logf("auto-tune proposal %s rejected", p.ID)
+	w.notify(i18n.T(cfg.Locale, "tune.rejected", cfg.account()))
+	return nil
+}
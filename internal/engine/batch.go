@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// batchInterval spaces the P2C calls of one account in a bulk complete or
+// cancel; accounts run in parallel.
+const batchInterval = 300 * time.Millisecond
+
+// BatchItem is one payment of a bulk complete or cancel; PaymentID may be
+// hex or numeric.
+type BatchItem struct {
+	AccountID int64  `json:"account_id"`
+	PaymentID string `json:"payment_id"`
+}
+
+// BatchResult is the outcome of one BatchItem.
+type BatchResult struct {
+	AccountID int64      `json:"account_id"`
+	PaymentID string     `json:"payment_id"`
+	Payment   PaymentRef `json:"payment"`
+	OK        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// CompleteBatch confirms many payments, e.g. the end-of-day cleanup of stale
+// accepted orders. Results follow the order of items.
+func (m *Manager) CompleteBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return m.batch(ctx, items, (*Worker).CompletePayment)
+}
+
+// CancelBatch cancels many payments. Results follow the order of items.
+func (m *Manager) CancelBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return m.batch(ctx, items, (*Worker).CancelPayment)
+}
+
+func (m *Manager) batch(ctx context.Context, items []BatchItem, action func(*Worker, context.Context, string) (PaymentRef, error)) []BatchResult {
+	results := make([]BatchResult, len(items))
+	byAccount := make(map[int64][]int)
+	for i, it := range items {
+		results[i] = BatchResult{AccountID: it.AccountID, PaymentID: it.PaymentID}
+		byAccount[it.AccountID] = append(byAccount[it.AccountID], i)
+	}
+	var wg sync.WaitGroup
+	for accountID, idx := range byAccount {
+		w := m.worker(accountID)
+		if w == nil {
+			for _, i := range idx {
+				results[i].Error = ErrNoWorker.Error()
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n, i := range idx {
+				if n > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(batchInterval):
+					}
+				}
+				if err := ctx.Err(); err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				ref, err := action(w, ctx, items[i].PaymentID)
+				results[i].Payment = ref
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].OK = true
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+const blackoutsDoc = "blackouts"
+
+// ErrNoBlackout is returned when a blackout id is unknown to the caller.
+var ErrNoBlackout = errors.New("blackout not found")
+
+// Blackout is a window of the calendar, such as a bank maintenance window
+// or a national holiday, when auto-take is off. A global blackout covers
+// every account of its tenant.
+type Blackout struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	AccountID int64     `json:"account_id,omitempty"` // 0 — все аккаунты тенанта
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+func (b Blackout) covers(tenant string, accountID int64, now time.Time) bool {
+	return b.TenantID == tenant && (b.AccountID == 0 || b.AccountID == accountID) &&
+		!now.Before(b.From) && now.Before(b.To)
+}
+
+// blackouts is the persisted calendar shared by all workers. Writers swap an
+// immutable copy, so the check on every payment takes no lock.
+type blackouts struct {
+	items atomic.Pointer[[]Blackout]
+
+	mu    sync.Mutex // сериализует изменения
+	store *store.Store
+}
+
+func newBlackouts(st *store.Store) *blackouts {
+	b := &blackouts{store: st}
+	var saved []Blackout
+	if err := st.Load(blackoutsDoc, &saved); err != nil {
+		log.Printf("[blackout] load error: %v", err)
+	}
+	b.items.Store(&saved)
+	return b
+}
+
+// active returns the blackout covering the account now, if any.
+func (b *blackouts) active(tenant string, accountID int64, now time.Time) (Blackout, bool) {
+	if b == nil {
+		return Blackout{}, false
+	}
+	for _, bo := range *b.items.Load() {
+		if bo.covers(tenant, accountID, now) {
+			return bo, true
+		}
+	}
+	return Blackout{}, false
+}
+
+// list returns the current and upcoming blackouts of the tenant; with
+// accountID != 0 only those covering that account.
+func (b *blackouts) list(tenant string, accountID int64) []Blackout {
+	now := time.Now()
+	var out []Blackout
+	for _, bo := range *b.items.Load() {
+		if bo.TenantID != tenant || !bo.To.After(now) {
+			continue
+		}
+		if accountID != 0 && bo.AccountID != 0 && bo.AccountID != accountID {
+			continue
+		}
+		out = append(out, bo)
+	}
+	return out
+}
+
+// update applies fn to a copy of the calendar, drops past windows and
+// saves it.
+func (b *blackouts) update(fn func(items []Blackout) ([]Blackout, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items, err := fn(slices.Clone(*b.items.Load()))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	items = slices.DeleteFunc(items, func(bo Blackout) bool { return !bo.To.After(now) })
+	slices.SortFunc(items, func(x, y Blackout) int { return x.From.Compare(y.From) })
+	b.items.Store(&items)
+	if err := b.store.Save(blackoutsDoc, items); err != nil {
+		log.Printf("[store] save %s error: %v", blackoutsDoc, err)
+	}
+	return nil
+}
+
+// forget drops the blackouts of a removed account.
+func (b *blackouts) forget(accountID int64) {
+	_ = b.update(func(items []Blackout) ([]Blackout, error) {
+		return slices.DeleteFunc(items, func(bo Blackout) bool { return bo.AccountID == accountID }), nil
+	})
+}
+
+func newBlackoutID() string {
+	var buf [4]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// ParseBlackoutTime parses a calendar bound in local time: a date
+// (2026-12-31), a date with time (2026-12-31T09:00 or "2026-12-31 09:00")
+// or RFC 3339. A bare date as the end of a window means the end of that day.
+func ParseBlackoutTime(s string, end bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339", s)
+}
+
+// remove drops the first blackout that matches.
+func (b *blackouts) remove(match func(Blackout) bool) error {
+	return b.update(func(items []Blackout) ([]Blackout, error) {
+		i := slices.IndexFunc(items, match)
+		if i < 0 {
+			return nil, ErrNoBlackout
+		}
+		return slices.Delete(items, i, i+1), nil
+	})
+}
+
+// AddBlackout adds a blackout window to the calendar of the tenant; with
+// accountID 0 it covers all of the tenant's accounts. An account's blackout
+// belongs to the account's tenant; the caller authorizes the account.
+func (m *Manager) AddBlackout(tenant string, accountID int64, from, to time.Time, reason string) (Blackout, error) {
+	if !to.After(from) {
+		return Blackout{}, fmt.Errorf("blackout ends before it starts")
+	}
+	if !to.After(time.Now()) {
+		return Blackout{}, fmt.Errorf("blackout is in the past")
+	}
+	if accountID != 0 {
+		tenant = m.AccountTenant(accountID)
+	}
+	bo := Blackout{ID: newBlackoutID(), TenantID: tenant, AccountID: accountID, From: from, To: to, Reason: reason}
+	_ = m.blackouts.update(func(items []Blackout) ([]Blackout, error) {
+		return append(items, bo), nil
+	})
+	log.Printf("[blackout] tenant=%q account=%d %s..%s added: %s", tenant, accountID, from.Format(time.RFC3339), to.Format(time.RFC3339), reason)
+	return bo, nil
+}
+
+// RemoveBlackout removes a blackout of the tenant from the calendar.
+func (m *Manager) RemoveBlackout(tenant, id string) error {
+	return m.blackouts.remove(func(bo Blackout) bool { return bo.ID == id && bo.TenantID == tenant })
+}
+
+// Blackouts returns the current and upcoming blackouts of the tenant; with
+// accountID != 0 only those covering that account.
+func (m *Manager) Blackouts(tenant string, accountID int64) []Blackout {
+	return m.blackouts.list(tenant, accountID)
+}
@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/store"
+)
+
+const (
+	brandsDoc          = "brands"
+	brandGuardDays     = 7 // окно правила по умолчанию
+	BrandReportDays    = 30
+	BrandReportMaxDays = statsKeepDays
+)
+
+// BrandGuardConfig denies a brand to the account while its recent outcomes
+// across the tenant's accounts are bad, e.g. 3 disputes in 7 days. The
+// brand comes back on its own once the window moves past them.
+type BrandGuardConfig struct {
+	MaxDisputes int `json:"max_disputes"` // споров за окно, с которых бренд запрещён; 0 — не проверять
+	MaxCancels  int `json:"max_cancels"`  // отмен за окно; 0 — не проверять
+	Days        int `json:"days"`         // окно в днях, по умолчанию 7
+}
+
+func (c BrandGuardConfig) enabled() bool { return c.MaxDisputes > 0 || c.MaxCancels > 0 }
+
+func (c BrandGuardConfig) days() int {
+	if c.Days > 0 {
+		return c.Days
+	}
+	return brandGuardDays
+}
+
+// BrandDay counts the outcomes of one brand's payments over a local day.
+type BrandDay struct {
+	Day       string `json:"day"`
+	Completed int    `json:"completed"`
+	Canceled  int    `json:"canceled"`
+	Disputed  int    `json:"disputed"`
+}
+
+// BrandStats sums the outcomes of a brand over the report window.
+type BrandStats struct {
+	Brand       string  `json:"brand"`
+	Completed   int     `json:"completed"`
+	Canceled    int     `json:"canceled"`
+	Disputed    int     `json:"disputed"`
+	DisputeRate float64 `json:"dispute_rate"` // споры от всех исходов
+	CancelRate  float64 `json:"cancel_rate"`
+	Denied      bool    `json:"denied,omitempty"` // правило brand_guard аккаунта сейчас запрещает бренд
+}
+
+type brandKey struct {
+	tenant, brand string
+}
+
+// brandRecord is a persisted day of a brand.
+type brandRecord struct {
+	TenantID string `json:"tenant_id,omitempty"`
+	Brand    string `json:"brand"`
+	BrandDay
+}
+
+// brandBook keeps the daily outcomes of every brand per tenant, shared by
+// all workers so one account's disputes protect the others.
+type brandBook struct {
+	mu    sync.Mutex
+	days  map[brandKey]map[string]*BrandDay
+	store *store.Store
+}
+
+func newBrandBook(st *store.Store) *brandBook {
+	b := &brandBook{days: make(map[brandKey]map[string]*BrandDay), store: st}
+	var saved []brandRecord
+	if err := st.Load(brandsDoc, &saved); err != nil {
+		log.Printf("[brands] load error: %v", err)
+	}
+	for _, r := range saved {
+		day := r.BrandDay
+		b.dayLocked(brandKey{r.TenantID, r.Brand}, day.Day).add(day)
+	}
+	return b
+}
+
+func (d *BrandDay) add(o BrandDay) {
+	d.Completed += o.Completed
+	d.Canceled += o.Canceled
+	d.Disputed += o.Disputed
+}
+
+func (b *brandBook) dayLocked(k brandKey, day string) *BrandDay {
+	days := b.days[k]
+	if days == nil {
+		days = make(map[string]*BrandDay)
+		b.days[k] = days
+	}
+	d := days[day]
+	if d == nil {
+		d = &BrandDay{Day: day}
+		days[day] = d
+	}
+	return d
+}
+
+// record counts a final outcome of a payment of the brand.
+func (b *brandBook) record(tenant, brand string, outcome PaymentState, at time.Time) {
+	brand = strings.TrimSpace(brand)
+	if b == nil || brand == "" {
+		return
+	}
+	var o BrandDay
+	switch outcome {
+	case StateCompleted:
+		o.Completed = 1
+	case StateCanceled:
+		o.Canceled = 1
+	case StateDisputed:
+		o.Disputed = 1
+	default:
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dayLocked(brandKey{tenant, brand}, at.Format(statsDayFormat)).add(o)
+	b.saveLocked(at)
+}
+
+// saveLocked drops days older than statsKeepDays and saves the book.
+func (b *brandBook) saveLocked(now time.Time) {
+	oldest := now.AddDate(0, 0, -statsKeepDays).Format(statsDayFormat)
+	var out []brandRecord
+	for k, days := range b.days {
+		for day, d := range days {
+			if day < oldest {
+				delete(days, day)
+				continue
+			}
+			out = append(out, brandRecord{TenantID: k.tenant, Brand: k.brand, BrandDay: *d})
+		}
+		if len(days) == 0 {
+			delete(b.days, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day < out[j].Day
+		}
+		return out[i].Brand < out[j].Brand
+	})
+	if err := b.store.Save(brandsDoc, out); err != nil {
+		log.Printf("[store] save %s error: %v", brandsDoc, err)
+	}
+}
+
+// window sums the outcomes of the brand over the last days, today included.
+func (b *brandBook) window(tenant, brand string, days int, now time.Time) BrandDay {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.windowLocked(brandKey{tenant, brand}, days, now)
+}
+
+func (b *brandBook) windowLocked(k brandKey, days int, now time.Time) BrandDay {
+	var sum BrandDay
+	from := now.AddDate(0, 0, 1-days).Format(statsDayFormat)
+	for day, d := range b.days[k] {
+		if day >= from {
+			sum.add(*d)
+		}
+	}
+	return sum
+}
+
+// check applies the brand guard of the account.
+func (b *brandBook) check(cfg WorkerConfig, brand string, now time.Time) Decision {
+	c := cfg.BrandGuard
+	brand = strings.TrimSpace(brand)
+	if b == nil || !c.enabled() || brand == "" {
+		return take()
+	}
+	sum := b.window(cfg.TenantID, brand, c.days(), now)
+	return c.decide(brand, sum)
+}
+
+func (c BrandGuardConfig) decide(brand string, sum BrandDay) Decision {
+	if c.MaxDisputes > 0 && sum.Disputed >= c.MaxDisputes {
+		return skip(SkipBrand, "brand %q: %d disputes in %d days", brand, sum.Disputed, c.days())
+	}
+	if c.MaxCancels > 0 && sum.Canceled >= c.MaxCancels {
+		return skip(SkipBrand, "brand %q: %d cancels in %d days", brand, sum.Canceled, c.days())
+	}
+	return take()
+}
+
+// report sums every brand of the tenant over the last days, the most
+// disputed first; with guard set it marks the brands the guard denies now.
+func (b *brandBook) report(tenant string, days int, guard BrandGuardConfig, now time.Time) []BrandStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := []BrandStats{}
+	for k := range b.days {
+		if k.tenant != tenant {
+			continue
+		}
+		sum := b.windowLocked(k, days, now)
+		total := sum.Completed + sum.Canceled + sum.Disputed
+		if total == 0 {
+			continue
+		}
+		st := BrandStats{Brand: k.brand, Completed: sum.Completed, Canceled: sum.Canceled, Disputed: sum.Disputed,
+			DisputeRate: float64(sum.Disputed) / float64(total), CancelRate: float64(sum.Canceled) / float64(total)}
+		if guard.enabled() {
+			st.Denied = !guard.decide(k.brand, b.windowLocked(k, guard.days(), now)).Take
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Disputed != out[j].Disputed {
+			return out[i].Disputed > out[j].Disputed
+		}
+		if out[i].Canceled != out[j].Canceled {
+			return out[i].Canceled > out[j].Canceled
+		}
+		return out[i].Brand < out[j].Brand
+	})
+	return out
+}
+
+// recordOutcome books a final state of the payment for its brand and tells
+// the account when this outcome makes its guard deny the brand.
+func (w *Worker) recordOutcome(rec PaymentRecord) {
+	if w.brands == nil || (rec.Status != StateCompleted && rec.Status != StateCanceled && rec.Status != StateDisputed) {
+		return
+	}
+	cfg := w.config()
+	now := time.Now()
+	before := w.brands.check(cfg, rec.BrandName, now)
+	w.brands.record(cfg.TenantID, rec.BrandName, rec.Status, now)
+	if after := w.brands.check(cfg, rec.BrandName, now); before.Take && !after.Take {
+		w.logf("brand guard: %s", after.Reason)
+		sum := w.brands.window(cfg.TenantID, strings.TrimSpace(rec.BrandName), cfg.BrandGuard.days(), now)
+		w.notify(i18n.T(cfg.Locale, "brand.denied", cfg.account(), rec.BrandName, sum.Disputed, sum.Canceled, cfg.BrandGuard.days()))
+	}
+}
+
+// BrandReport returns the outcomes of the tenant's brands over the last days
+// (at most BrandReportMaxDays). With accountID != 0 it marks the brands that
+// account's brand_guard denies now.
+func (m *Manager) BrandReport(tenant string, accountID int64, days int) ([]BrandStats, error) {
+	var guard BrandGuardConfig
+	if accountID != 0 {
+		w := m.worker(accountID)
+		if w == nil {
+			return nil, ErrNoWorker
+		}
+		guard = w.config().BrandGuard
+	}
+	if days <= 0 {
+		days = BrandReportDays
+	}
+	return m.brands.report(tenant, min(days, BrandReportMaxDays), guard, time.Now()), nil
+}
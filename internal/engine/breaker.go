@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/i18n"
+)
+
+// BreakerConfig trips the take circuit breaker: after MaxFailures failed
+// takes in a row, or when the success rate over the window drops below
+// MinSuccessRate, the worker stops taking for the cooldown.
+type BreakerConfig struct {
+	MaxFailures    int     `json:"max_failures"`     // подряд неудачных take, 0 — не проверять
+	MinSuccessRate float64 `json:"min_success_rate"` // 0..1, 0 — не проверять
+	WindowSec      int     `json:"window_sec"`       // окно для доли успеха, по умолчанию 300
+	MinAttempts    int     `json:"min_attempts"`     // минимум попыток в окне, по умолчанию 10
+	CooldownSec    int     `json:"cooldown_sec"`     // пауза после срабатывания, по умолчанию 300
+}
+
+func (c BreakerConfig) enabled() bool { return c.MaxFailures > 0 || c.MinSuccessRate > 0 }
+
+func (c BreakerConfig) window() time.Duration {
+	if c.WindowSec > 0 {
+		return time.Duration(c.WindowSec) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+func (c BreakerConfig) minAttempts() int {
+	if c.MinAttempts > 0 {
+		return c.MinAttempts
+	}
+	return 10
+}
+
+func (c BreakerConfig) cooldown() time.Duration {
+	if c.CooldownSec > 0 {
+		return time.Duration(c.CooldownSec) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// breaker keeps recent take outcomes of a worker.
+type breaker struct {
+	mu          sync.Mutex
+	attempts    []takeOutcome
+	consecutive int
+	openUntil   time.Time
+}
+
+type takeOutcome struct {
+	at time.Time
+	ok bool
+}
+
+// record adds a take outcome and reports whether the breaker has just opened.
+func (b *breaker) record(cfg BreakerConfig, ok bool, now time.Time) (tripped bool, reason string) {
+	if !cfg.enabled() {
+		return false, ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kept := b.attempts[:0]
+	for _, a := range b.attempts {
+		if now.Sub(a.at) <= cfg.window() {
+			kept = append(kept, a)
+		}
+	}
+	b.attempts = append(kept, takeOutcome{at: now, ok: ok})
+	if ok {
+		b.consecutive = 0
+		return false, ""
+	}
+	b.consecutive++
+	if now.Before(b.openUntil) {
+		return false, ""
+	}
+
+	if cfg.MaxFailures > 0 && b.consecutive >= cfg.MaxFailures {
+		reason = fmt.Sprintf("%d failed takes in a row", b.consecutive)
+	} else if cfg.MinSuccessRate > 0 && len(b.attempts) >= cfg.minAttempts() {
+		succeeded := 0
+		for _, a := range b.attempts {
+			if a.ok {
+				succeeded++
+			}
+		}
+		if rate := float64(succeeded) / float64(len(b.attempts)); rate < cfg.MinSuccessRate {
+			reason = fmt.Sprintf("success rate %.0f%% < %.0f%% over %d takes", rate*100, cfg.MinSuccessRate*100, len(b.attempts))
+		}
+	}
+	if reason == "" {
+		return false, ""
+	}
+	// после паузы считаем заново, иначе старые ошибки сразу откроют его снова
+	b.openUntil = now.Add(cfg.cooldown())
+	b.attempts = b.attempts[:0]
+	b.consecutive = 0
+	return true, reason
+}
+
+// blocked returns the end of the cooldown while the breaker is open.
+func (b *breaker) blocked(now time.Time) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil, now.Before(b.openUntil)
+}
+
+// reset closes the breaker (operator resume).
+func (b *breaker) reset() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := time.Now().Before(b.openUntil)
+	b.openUntil = time.Time{}
+	b.attempts = b.attempts[:0]
+	b.consecutive = 0
+	return wasOpen
+}
+
+// recordTake feeds the breaker and alerts the operator when it opens.
+func (w *Worker) recordTake(ok bool) {
+	cfg := w.config()
+	now := time.Now()
+	w.health.take(ok, now)
+	tripped, reason := w.breaker.record(cfg.Breaker, ok, now)
+	if !tripped {
+		return
+	}
+	until := now.Add(cfg.Breaker.cooldown())
+	w.logf("circuit breaker open until %s: %s", until.Format(time.RFC3339), reason)
+	w.events.publish(Event{Type: EventBreakerOpen, AccountID: w.accountID, At: now, Reason: reason, Until: &until})
+	w.notify(i18n.T(cfg.Locale, "breaker.open", cfg.account(), reason, until.Local().Format("15:04:05")))
+}
@@ -0,0 +1,63 @@
+// Package cache holds small concurrency-safe caches used by workers.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// TTL is a set of keys that expire ttl after they were added. It is safe
+// for concurrent use and may be shared by workers of one marketplace.
+type TTL struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	items     map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewTTL returns an empty set with the given lifetime of keys.
+func NewTTL(ttl time.Duration) *TTL {
+	return &TTL{ttl: ttl, items: make(map[string]time.Time)}
+}
+
+// Add inserts key unless it is already present and not expired. It reports
+// whether the key was added, so exactly one of concurrent callers wins.
+func (c *TTL) Add(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweepLocked(now)
+	if at, ok := c.items[key]; ok && now.Sub(at) <= c.ttl {
+		return false
+	}
+	c.items[key] = now
+	return true
+}
+
+// Has reports whether key is present and not expired.
+func (c *TTL) Has(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at, ok := c.items[key]
+	return ok && now.Sub(at) <= c.ttl
+}
+
+// Len returns the number of stored keys, including not yet swept ones.
+func (c *TTL) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// sweepLocked drops expired keys, at most a few times per ttl.
+func (c *TTL) sweepLocked(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl/4 {
+		return
+	}
+	c.lastSweep = now
+	for key, at := range c.items {
+		if now.Sub(at) > c.ttl {
+			delete(c.items, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/p2c"
+)
+
+// cardRefresh is how often open order cards update their countdown.
+const cardRefresh = time.Minute
+
+// OrderCard is the Telegram message of a taken order. With
+// WorkerConfig.OrderCard the engine edits it through the payment lifecycle —
+// status, countdown to expiry, final result — instead of sending new
+// messages.
+type OrderCard struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int64 `json:"message_id"`
+	Photo     bool  `json:"photo"` // подпись к QR; false — текст (фото не дошло)
+	Payer     int64 `json:"payer,omitempty"`
+}
+
+// livePayment rebuilds the order fields of the card from the record.
+func (rec PaymentRecord) livePayment() p2c.LivePayment {
+	return p2c.LivePayment{
+		ID:           rec.Ref.Hex,
+		BrandName:    rec.BrandName,
+		InAmount:     rec.InAmount,
+		InAsset:      rec.InAsset,
+		OutAsset:     rec.OutAsset,
+		ExchangeRate: rec.ExchangeRate,
+		FeeAmount:    rec.FeeAmount,
+		URL:          rec.URL,
+		Provider:     rec.Provider,
+		Payload:      rec.Payload,
+		ExpiresAt:    rec.ExpiresAt,
+	}
+}
+
+// cardOpen reports whether the card still waits for the payer.
+func cardOpen(s PaymentState) bool {
+	return s == StateTaken || s == StateAwaitingPayment
+}
+
+// cardStatus is the status line of the card for the payment state; now is
+// on the P2C clock, like expires_at.
+func cardStatus(locale string, rec PaymentRecord, now time.Time) string {
+	switch rec.Status {
+	case StateTaken, StateAwaitingPayment:
+		status := i18n.T(locale, "live.taken_auto")
+		if at, err := time.Parse(time.RFC3339, rec.ExpiresAt); err == nil && at.After(now) {
+			left := at.Sub(now).Round(time.Minute)
+			status += "\n" + i18n.T(locale, "card.expires_in", fmt.Sprintf("%d:%02d", int(left.Hours()), int(left.Minutes())%60))
+		}
+		return status
+	case StateCompleting:
+		return i18n.T(locale, "card.completing")
+	case StateCompleted:
+		return i18n.T(locale, "card.completed")
+	case StateCancelling:
+		return i18n.T(locale, "card.cancelling")
+	case StateCanceled:
+		return i18n.T(locale, "card.canceled")
+	case StateExpired:
+		return i18n.T(locale, "card.expired")
+	case StateDisputed:
+		return i18n.T(locale, "card.disputed")
+	}
+	return string(rec.Status)
+}
+
+// cardSent remembers the message of a new card so it can be edited later.
+func (w *Worker) cardSent(ref PaymentRef, payer int64) func(chatID, messageID int64, photo bool) {
+	if !w.config().OrderCard {
+		return nil
+	}
+	return func(chatID, messageID int64, photo bool) {
+		w.journal.setCard(ref, OrderCard{ChatID: chatID, MessageID: messageID, Photo: photo, Payer: payer})
+	}
+}
+
+// paymentChanged follows a state change of a payment: the card shows the new
+// state, and a notification still waiting in the outbox is dropped once the
+// order is paid or gone.
+func (w *Worker) paymentChanged(ref PaymentRef) {
+	w.refreshCard(ref)
+	if rec, ok := w.journal.get(ref); ok && (rec.Status.Final() || rec.Status == StateCompleted) {
+		w.outbox.drop(orderKey(w.accountID, rec.Ref))
+	}
+}
+
+// refreshCard re-renders the card of the payment in place.
+func (w *Worker) refreshCard(ref PaymentRef) {
+	cfg := w.config()
+	rec, ok := w.journal.get(ref)
+	if !ok || rec.Card == nil || !cfg.OrderCard || w.botToken == "" {
+		return
+	}
+	text := buildLiveCaption(w.render, cfg.account(), rec.livePayment(), rec.Ref, cardStatus(cfg.Locale, rec, w.clock.Now()))
+	// клавиатура нужна, пока заявку можно оплатить или отменить
+	markup := map[string]any{"inline_keyboard": [][]map[string]string{}}
+	if cardOpen(rec.Status) {
+		if rec.Card.Payer != 0 {
+			text += "\n" + i18n.T(cfg.Locale, "payer.assigned", payerMention(rec.Card.Payer))
+		}
+		markup = buildPaidKeyboard(cfg.Locale, w.accountID, rec.livePayment(), w.web.URL(w.accountID, rec.Ref.Hex), cfg.PaidOneTap)
+		if w.jobs != nil && w.jobs.Pending(payerJobID(w.accountID, rec.Ref.Hex)) {
+			markup = withAck(markup, cfg.Locale, w.accountID, rec.Ref.Hex)
+		}
+	}
+	telegramSender(w.botToken).enqueue(editMessage(*rec.Card, text, markup))
+}
+
+// setCardPayer records a new payer of the card and shows it; it reports
+// whether the payment has a card.
+func (w *Worker) setCardPayer(ref PaymentRef, payer int64) bool {
+	if !w.journal.setCardPayer(ref, payer) {
+		return false
+	}
+	w.refreshCard(ref)
+	return true
+}
+
+// runCards keeps the countdown of open cards current.
+func (w *Worker) runCards(ctx context.Context) {
+	ticker := time.NewTicker(cardRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !w.config().OrderCard {
+			continue
+		}
+		for _, rec := range w.journal.list() {
+			if rec.Card != nil && cardOpen(rec.Status) {
+				w.refreshCard(rec.Ref)
+			}
+		}
+	}
+}
+
+// setCard attaches the sent card to the payment.
+func (j *journal) setCard(ref PaymentRef, card OrderCard) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec := j.find(ref); rec != nil {
+		rec.Card = &card
+		j.saver.changed()
+	}
+}
+
+func (j *journal) setCardPayer(ref PaymentRef, payer int64) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := j.find(ref)
+	if rec == nil || rec.Card == nil {
+		return false
+	}
+	card := *rec.Card
+	card.Payer = payer
+	rec.Card = &card
+	j.saver.changed()
+	return true
+}
@@ -0,0 +1,403 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"p2c-engine/internal/i18n"
+)
+
+// CommandBot listens for Telegram updates and maps chat commands onto the
+// Manager. Only operators, listed by Telegram user id, may run commands; a
+// chat may control accounts whose ChatID it is, admin chats may control any
+// account by passing its id.
+type CommandBot struct {
+	token     string
+	mgr       *Manager
+	admins    map[int64]bool
+	operators map[int64]bool // user id отправителя: членство в чате аккаунта само по себе прав не даёт
+	client    *http.Client
+}
+
+func NewCommandBot(token string, mgr *Manager, adminChats, operators []int64) *CommandBot {
+	return &CommandBot{
+		token:     token,
+		mgr:       mgr,
+		admins:    idSet(adminChats),
+		operators: idSet(operators),
+		client:    &http.Client{Timeout: 40 * time.Second},
+	}
+}
+
+func idSet(ids []int64) map[int64]bool {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+type tgUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text            string `json:"text"`
+		MessageThreadID int64  `json:"message_thread_id"`
+		Chat            struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		// нет у сообщений от имени канала или анонимного админа группы
+		From *struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+// Run long-polls getUpdates until ctx is done.
+func (b *CommandBot) Run(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[tgcmd] getUpdates error: %v", err)
+				time.Sleep(3 * time.Second)
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || !strings.HasPrefix(u.Message.Text, "/") {
+				continue
+			}
+			if u.Message.From == nil || !b.operators[u.Message.From.ID] {
+				from := int64(0)
+				if u.Message.From != nil {
+					from = u.Message.From.ID
+				}
+				log.Printf("[tgcmd] ignored command from user %d in chat %d: not an operator", from, u.Message.Chat.ID)
+				continue
+			}
+			reply := b.handle(u.Message.Chat.ID, u.Message.MessageThreadID, u.Message.Text)
+			if reply == "" {
+				continue
+			}
+			// в форум-группе отвечаем в ту же тему
+			telegramSender(b.token).enqueue(textMessage(u.Message.Chat.ID, reply, nil).inThread(u.Message.MessageThreadID))
+		}
+	}
+}
+
+func (b *CommandBot) getUpdates(ctx context.Context, offset int64) ([]tgUpdate, error) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?timeout=30&offset=%d&allowed_updates=%%5B%%22message%%22%%5D", b.token, offset)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		OK     bool       `json:"ok"`
+		Result []tgUpdate `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if !out.OK {
+		return nil, fmt.Errorf("telegram status %d", resp.StatusCode)
+	}
+	return out.Result, nil
+}
+
+// handle executes a command and returns the reply text; threadID is the
+// forum topic the command came from.
+func (b *CommandBot) handle(chatID, threadID int64, text string) string {
+	fields := strings.Fields(text)
+	cmd := strings.ToLower(fields[0])
+	// /cmd@botname -> /cmd
+	if i := strings.Index(cmd, "@"); i > 0 {
+		cmd = cmd[:i]
+	}
+	args := fields[1:]
+
+	switch cmd {
+	case "/status":
+		return b.forAccounts(chatID, args, func(w *Worker) string { return formatStatusLine(w.config().Locale, w.Status()) })
+	case "/pause", "/resume":
+		paused := cmd == "/pause"
+		return b.forAccounts(chatID, args, func(w *Worker) string {
+			b.mgr.SetPaused(w.accountID, paused)
+			if paused {
+				return i18n.T(w.config().Locale, "cmd.paused", w.config().account())
+			}
+			return i18n.T(w.config().Locale, "cmd.resumed", w.config().account())
+		})
+	case "/setmin", "/setmax":
+		if len(args) == 0 {
+			return i18n.T(b.chatLocale(chatID), "cmd.usage_amount", cmd)
+		}
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(args[0], ",", "."), 64)
+		if err != nil || amount < 0 {
+			return i18n.T(b.chatLocale(chatID), "cmd.bad_amount", args[0])
+		}
+		return b.forAccounts(chatID, args[1:], func(w *Worker) string {
+			err := b.mgr.UpdateConfig(w.accountID, func(cfg *WorkerConfig) {
+				if cmd == "/setmin" {
+					cfg.MinAmount = &amount
+				} else {
+					cfg.MaxAmount = &amount
+				}
+			})
+			if err != nil {
+				return i18n.T(w.config().Locale, "cmd.update_error", w.config().account(), err)
+			}
+			return i18n.T(w.config().Locale, "cmd.updated", w.config().account(), strings.TrimPrefix(cmd, "/set"), amount)
+		})
+	case "/takes":
+		if len(args) > 0 && args[0] == "today" {
+			args = args[1:]
+		}
+		return b.forAccounts(chatID, args, func(w *Worker) string {
+			st := w.Status()
+			return i18n.T(w.config().Locale, "cmd.takes_today", st.account(), st.DayCount, st.DayVolume)
+		})
+	case "/export":
+		return b.export(chatID, threadID, args)
+	case "/blackout":
+		return b.blackout(chatID, args)
+	default:
+		return ""
+	}
+}
+
+// export sends the payment history of the chat's accounts as files:
+// /export [account_id] [period] [csv|xlsx], period as for stats (today,
+// yesterday, 7d, 2026-10-01..2026-10-16).
+func (b *CommandBot) export(chatID, threadID int64, args []string) string {
+	format, period := ExportCSV, "today"
+	var account []string
+	for _, arg := range args {
+		switch arg = strings.ToLower(arg); {
+		case arg == ExportCSV || arg == ExportXLSX:
+			format = arg
+		case isDigits(arg):
+			account = []string{arg}
+		default:
+			period = arg
+		}
+	}
+	fromDay, toDay, err := statsPeriod(period, time.Now())
+	if err != nil {
+		return i18n.T(b.chatLocale(chatID), "cmd.usage_export")
+	}
+	from, _ := time.ParseInLocation(statsDayFormat, fromDay, time.Local)
+	to, _ := time.ParseInLocation(statsDayFormat, toDay, time.Local)
+	to = to.AddDate(0, 0, 1)
+	return b.forAccounts(chatID, account, func(w *Worker) string {
+		var buf bytes.Buffer
+		n, err := b.mgr.ExportPayments(&buf, w.accountID, from, to, format)
+		if err != nil {
+			return i18n.T(w.config().Locale, "cmd.update_error", w.config().account(), err)
+		}
+		name := ExportName(w.accountID, from, to, format)
+		telegramSender(b.token).enqueue(documentMessage(chatID, name, buf.Bytes(), "").inThread(threadID))
+		return i18n.T(w.config().Locale, "cmd.exported", w.config().account(), n, fromDay, toDay)
+	})
+}
+
+// blackout lists and edits the blackout calendar of the chat's accounts:
+// /blackout [account_id] lists the windows, /blackout add <from> [to]
+// [account_id|all] [reason] adds one, /blackout del <id> removes one. Bounds
+// are YYYY-MM-DD or YYYY-MM-DDTHH:MM; without to the window is the whole day
+// of from. Only admin chats may add windows for all accounts.
+func (b *CommandBot) blackout(chatID int64, args []string) string {
+	locale := b.chatLocale(chatID)
+	if len(args) == 0 || isDigits(args[0]) {
+		seen := make(map[string]bool)
+		var lines []string
+		list := func(bos []Blackout) {
+			for _, bo := range bos {
+				if !seen[bo.ID] {
+					seen[bo.ID] = true
+					lines = append(lines, formatBlackout(locale, bo))
+				}
+			}
+		}
+		if b.admins[chatID] && len(args) == 0 {
+			list(b.mgr.Blackouts("", 0))
+		}
+		reply := b.forAccounts(chatID, args, func(w *Worker) string {
+			list(b.mgr.Blackouts(w.tenant, w.accountID))
+			return ""
+		})
+		switch {
+		case len(lines) > 0:
+			return strings.Join(lines, "\n")
+		case strings.TrimSpace(reply) != "":
+			return reply // аккаунт не найден или чужой
+		}
+		return i18n.T(locale, "cmd.blackout_none")
+	}
+
+	switch args[0] {
+	case "add":
+		var bounds, reason, account []string
+		all := false
+		for _, arg := range args[1:] {
+			switch {
+			case len(reason) == 0 && len(bounds) < 2 && strings.Count(arg, "-") == 2:
+				bounds = append(bounds, arg)
+			case len(reason) == 0 && account == nil && !all && isDigits(arg):
+				account = []string{arg}
+			case len(reason) == 0 && account == nil && !all && arg == "all":
+				all = true
+			default:
+				reason = append(reason, arg)
+			}
+		}
+		if len(bounds) == 0 {
+			return i18n.T(locale, "cmd.usage_blackout")
+		}
+		from, err := ParseBlackoutTime(bounds[0], false)
+		if err != nil {
+			return i18n.T(locale, "cmd.blackout_bad", err)
+		}
+		// без конца окна — весь день начала
+		end := bounds[0]
+		if len(bounds) > 1 {
+			end = bounds[1]
+		} else if _, err := time.Parse(time.DateOnly, end); err != nil {
+			return i18n.T(locale, "cmd.usage_blackout")
+		}
+		to, err := ParseBlackoutTime(end, true)
+		if err != nil {
+			return i18n.T(locale, "cmd.blackout_bad", err)
+		}
+		if all {
+			if !b.admins[chatID] {
+				return i18n.T(locale, "cmd.no_accounts")
+			}
+			bo, err := b.mgr.AddBlackout("", 0, from, to, strings.Join(reason, " "))
+			if err != nil {
+				return i18n.T(locale, "cmd.blackout_bad", err)
+			}
+			return i18n.T(locale, "cmd.blackout_added", formatBlackout(locale, bo))
+		}
+		return b.forAccounts(chatID, account, func(w *Worker) string {
+			bo, err := b.mgr.AddBlackout(w.tenant, w.accountID, from, to, strings.Join(reason, " "))
+			if err != nil {
+				return i18n.T(w.config().Locale, "cmd.update_error", w.config().account(), err)
+			}
+			return i18n.T(w.config().Locale, "cmd.blackout_added", formatBlackout(w.config().Locale, bo))
+		})
+	case "del":
+		if len(args) < 2 {
+			return i18n.T(locale, "cmd.usage_blackout")
+		}
+		id := args[1]
+		err := b.mgr.blackouts.remove(func(bo Blackout) bool {
+			if bo.ID != id {
+				return false
+			}
+			if b.admins[chatID] {
+				return true
+			}
+			w := b.mgr.worker(bo.AccountID)
+			return w != nil && w.config().ChatID == chatID
+		})
+		if err != nil {
+			return i18n.T(locale, "cmd.blackout_not_found", id)
+		}
+		return i18n.T(locale, "cmd.blackout_removed", id)
+	default:
+		return i18n.T(locale, "cmd.usage_blackout")
+	}
+}
+
+// formatBlackout renders a calendar window as one line of a chat reply.
+func formatBlackout(locale string, bo Blackout) string {
+	scope := i18n.T(locale, "cmd.blackout_all")
+	if bo.AccountID != 0 {
+		scope = strconv.FormatInt(bo.AccountID, 10)
+	}
+	const layout = "02.01.2006 15:04"
+	line := i18n.T(locale, "cmd.blackout_line", bo.ID, bo.From.Local().Format(layout), bo.To.Local().Format(layout), scope)
+	if bo.Reason != "" {
+		line += " — " + bo.Reason
+	}
+	return line
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// forAccounts runs fn for the accounts the chat may control: the one given
+// in args, or all accounts bound to the chat.
+func (b *CommandBot) forAccounts(chatID int64, args []string, fn func(w *Worker) string) string {
+	var targets []*Worker
+	var wantID int64
+	if len(args) > 0 {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return i18n.T(b.chatLocale(chatID), "cmd.bad_account", args[0])
+		}
+		wantID = id
+	}
+	for _, w := range b.mgr.snapshotWorkers() {
+		if wantID != 0 && w.accountID != wantID {
+			continue
+		}
+		if !b.admins[chatID] && w.config().ChatID != chatID {
+			continue
+		}
+		targets = append(targets, w)
+	}
+	if len(targets) == 0 {
+		return i18n.T(b.chatLocale(chatID), "cmd.no_accounts")
+	}
+	lines := make([]string, 0, len(targets))
+	for _, w := range targets {
+		lines = append(lines, fn(w))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chatLocale is the locale of the first account bound to the chat.
+func (b *CommandBot) chatLocale(chatID int64) string {
+	for _, w := range b.mgr.snapshotWorkers() {
+		if cfg := w.config(); cfg.ChatID == chatID {
+			return cfg.Locale
+		}
+	}
+	return i18n.Default
+}
+
+func formatStatusLine(locale string, st WorkerStatus) string {
+	mode := i18n.T(locale, "status.mode_auto")
+	if st.Paused {
+		mode = i18n.T(locale, "status.mode_paused")
+	}
+	line := i18n.T(locale, "status.line", st.account(), mode, deref(st.MinAmount), deref(st.MaxAmount), st.DayCount, st.DayVolume)
+	if st.ActivePayment != nil {
+		line += i18n.T(locale, "status.active", st.ActivePayment)
+	}
+	if st.PenaltyUntil.After(time.Now()) {
+		line += i18n.T(locale, "status.blocked_till", st.PenaltyUntil.Local().Format("15:04:05"))
+	}
+	return line
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"p2c-engine/internal/store"
+)
+
+// accountsDir holds the last config of every account, so the engine starts
+// its workers after a restart without waiting for the bot to push them.
+const accountsDir = "accounts"
+
+func accountDoc(accountID int64) string {
+	return fmt.Sprintf("%s/%d", accountsDir, accountID)
+}
+
+// SaveAccountConfig persists cfg in st. The access token is encrypted, so
+// it fails with store.ErrNoSecretKey when no secret key is set.
+func SaveAccountConfig(st *store.Store, cfg WorkerConfig) error {
+	return st.Save(accountDoc(cfg.AccountID), cfg)
+}
+
+// LoadAccountConfigs returns the configs persisted in st.
+func LoadAccountConfigs(st *store.Store) ([]WorkerConfig, error) {
+	names, err := st.List(accountsDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WorkerConfig, 0, len(names))
+	for _, name := range names {
+		var cfg WorkerConfig
+		if err := st.Load(name, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if cfg.AccountID != 0 {
+			out = append(out, cfg)
+		}
+	}
+	return out, nil
+}
+
+// saveConfig persists the account config; without a secret key the token
+// is not written to disk and the bot has to push configs after a restart.
+func (m *Manager) saveConfig(cfg WorkerConfig) {
+	if cfg.AccessToken == "" {
+		return // служебная перезагрузка без настроек не должна затирать сохранённые
+	}
+	err := SaveAccountConfig(m.store, cfg)
+	if errors.Is(err, store.ErrNoSecretKey) {
+		return
+	}
+	if err != nil {
+		log.Printf("[mgr] save config account=%d: %v", cfg.AccountID, err)
+	}
+}
+
+// RestoreAccounts starts workers from the persisted configs and returns
+// how many were restored.
+func (m *Manager) RestoreAccounts() int {
+	cfgs, err := LoadAccountConfigs(m.store)
+	if err != nil {
+		log.Printf("[mgr] restore accounts: %v", err)
+	}
+	for _, cfg := range cfgs {
+		m.ReloadAccount(cfg)
+	}
+	return len(cfgs)
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"p2c-engine/internal/store"
+)
+
+func cursorDoc(accountID int64) string {
+	return fmt.Sprintf("cursor/%d", accountID)
+}
+
+// pollCursor is the ListPayments cursor of the polling fallback. It is
+// persisted per account, so after a restart polling resumes where it stopped
+// instead of re-scanning old payments.
+type pollCursor struct {
+	mu    sync.Mutex
+	value string
+	store *store.Store
+	doc   string
+}
+
+type cursorState struct {
+	Cursor string `json:"cursor"`
+}
+
+func (c *pollCursor) attach(st *store.Store, accountID int64) {
+	if st == nil {
+		return
+	}
+	var saved cursorState
+	if err := st.Load(cursorDoc(accountID), &saved); err != nil {
+		log.Printf("[cursor %d] load error: %v", accountID, err)
+	}
+	c.mu.Lock()
+	c.store, c.doc, c.value = st, cursorDoc(accountID), saved.Cursor
+	c.mu.Unlock()
+}
+
+func (c *pollCursor) get() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// set moves the cursor; the document is written only when it changes, at
+// most once per poll.
+func (c *pollCursor) set(v string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v == "" || v == c.value {
+		return
+	}
+	c.value = v
+	if err := c.store.Save(c.doc, cursorState{Cursor: v}); err != nil {
+		log.Printf("[store] save %s error: %v", c.doc, err)
+	}
+}
+
+// reset drops the cursor so the next poll starts from the newest payments;
+// it returns the cursor it dropped.
+func (c *pollCursor) reset() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.value
+	c.value = ""
+	if err := c.store.Delete(c.doc); err != nil {
+		log.Printf("[store] delete %s error: %v", c.doc, err)
+	}
+	return old
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+const deafTick = 30 * time.Second
+
+// runDeafCheck catches a websocket that stays connected and answers pings
+// but has not delivered a list event for DeafAfterSec while ListPayments
+// shows payments on the market: the session is dropped so the socket
+// reconnects, and the ws_deaf alert rule counts such drops.
+func (w *Worker) runDeafCheck(ctx context.Context, sock p2c.Feed) {
+	ticker := time.NewTicker(deafTick)
+	defer ticker.Stop()
+	var probed time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cfg := w.config()
+		if cfg.DeafAfterSec <= 0 || w.wsDownSince.Load() != 0 {
+			continue
+		}
+		now := time.Now()
+		after := time.Duration(cfg.DeafAfterSec) * time.Second
+		silent := w.health.silence(now)
+		// молчание ленты без торгов — норма, проверяем рынок не чаще раза в окно
+		if silent < after || now.Sub(probed) < after || !w.allowRequest(now) {
+			continue
+		}
+		probed = now
+		payments, err := w.client.ListPayments(ctx, p2c.ListPaymentsParams{Size: 10, Status: p2c.StatusProcessing})
+		if err != nil {
+			w.logf("deaf check: %v", err)
+			continue
+		}
+		if len(payments.Data) == 0 {
+			continue
+		}
+		w.logf("websocket silent for %s while the market has %d+ payments, reconnecting", silent.Round(time.Second), len(payments.Data))
+		w.trace.add(TraceFrame{At: now, Note: "deaf: reconnect"})
+		w.health.deaf(now)
+		sock.Drop()
+	}
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"p2c-engine/internal/i18n"
+)
+
+// DeleteResult reports what DeleteAccount did.
+type DeleteResult struct {
+	Stopped  bool
+	Canceled []PaymentRef
+	Failed   map[string]string // payment hex -> cancel error
+}
+
+// DeleteAccount removes the account from the engine: optionally cancels its
+// open payments, stops the worker, sends a final Telegram notice and wipes
+// everything persisted for it (payments journal, stats, polling cursor,
+// auto-tune state, penalties, tenant binding). Unlike a reload with is_active=false nothing
+// is left behind.
+func (m *Manager) DeleteAccount(ctx context.Context, accountID int64, cancelOpen bool) DeleteResult {
+	m.mu.Lock()
+	w := m.workers[accountID]
+	if w != nil {
+		delete(m.workers, accountID)
+		m.publishWorkers()
+	}
+	if _, ok := m.tenants[accountID]; ok {
+		delete(m.tenants, accountID)
+		m.saveTenantsLocked()
+	}
+	m.mu.Unlock()
+
+	var res DeleteResult
+	if w != nil {
+		if cancelOpen {
+			res.Canceled, res.Failed = w.cancelOpen(ctx)
+		}
+		w.Drain(ctx)
+		res.Stopped = true
+		w.notify(i18n.T(w.config().Locale, "account.deleted", w.config().account(), len(res.Canceled)))
+	}
+
+	if m.leases != nil {
+		m.leases.release(accountID)
+	}
+	m.penalties.Forget(accountID)
+	m.topics.forget(accountID)
+	m.blackouts.forget(accountID)
+	m.outbox.forget(accountID)
+	if m.arbiter != nil {
+		m.arbiter.forget(accountID)
+	}
+	for _, doc := range []string{fmt.Sprintf("payments/%d", accountID), fmt.Sprintf("stats/%d", accountID), cursorDoc(accountID), tuneDoc(accountID), accountDoc(accountID)} {
+		if err := m.store.Delete(doc); err != nil {
+			log.Printf("[mgr] delete account=%d: remove %s: %v", accountID, doc, err)
+		}
+	}
+	log.Printf("[mgr] deleted account=%d stopped=%v canceled=%d failed=%d", accountID, res.Stopped, len(res.Canceled), len(res.Failed))
+	return res
+}
+
+// cancelOpen cancels every payment of the journal that is not final yet.
+func (w *Worker) cancelOpen(ctx context.Context) ([]PaymentRef, map[string]string) {
+	var canceled []PaymentRef
+	var failed map[string]string
+	for _, rec := range w.journal.list() {
+		if !rec.Status.Open() {
+			continue
+		}
+		ref, err := w.CancelPayment(ctx, rec.Ref.Hex)
+		if err != nil {
+			if failed == nil {
+				failed = make(map[string]string)
+			}
+			failed[ref.Hex] = err.Error()
+			continue
+		}
+		canceled = append(canceled, ref)
+	}
+	return canceled, failed
+}
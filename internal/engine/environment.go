@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"p2c-engine/internal/p2c"
+)
+
+// Account environments. A sandbox account trades on the P2C staging
+// marketplace: its client uses the sandbox base URL, its Telegram
+// notifications go to the test chat (or nowhere) and its logs and status
+// are tagged, so strategies can be tried without touching real money.
+const (
+	EnvProd    = "prod"
+	EnvSandbox = "sandbox"
+)
+
+// ValidEnvironment reports whether env is a known environment; empty is prod.
+func ValidEnvironment(env string) bool {
+	return env == "" || env == EnvProd || env == EnvSandbox
+}
+
+func (cfg WorkerConfig) sandbox() bool {
+	return cfg.Environment == EnvSandbox
+}
+
+// env returns the account environment, prod by default.
+func (cfg WorkerConfig) env() string {
+	if cfg.Environment == "" {
+		return EnvProd
+	}
+	return cfg.Environment
+}
+
+// sandboxConfig is where sandbox accounts go instead of production.
+type sandboxConfig struct {
+	baseURL string
+	chatID  int64 // тестовый чат; 0 — Telegram для sandbox выключен
+}
+
+// SetSandbox sets the P2C staging base URL and the Telegram chat that gets
+// the notifications of sandbox accounts; without a URL sandbox accounts do
+// not start.
+func (m *Manager) SetSandbox(baseURL string, chatID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sandbox = sandboxConfig{baseURL: baseURL, chatID: chatID}
+}
+
+// sandboxClient builds the client of a sandbox account. The edge prober,
+// mirrors and DNS pinning of the engine point at production and are not
+// shared.
+func (m *Manager) sandboxClient(cfg WorkerConfig) (*p2c.Client, error) {
+	if m.sandbox.baseURL == "" {
+		return nil, fmt.Errorf("sandbox account %d: P2C_SANDBOX_URL is not set", cfg.AccountID)
+	}
+	return p2c.NewClientWith(m.sandbox.baseURL, cfg.AccessToken.Reveal(), m.client.Transport().Merge(cfg.Transport)), nil
+}
+
+// fleet is the marketplace the worker shares with the other accounts of its
+// tenant and environment.
+func (w *Worker) fleet() string {
+	return w.tenant + "/" + w.env
+}
+
+// tag identifies the worker in logs: the account id, plus the environment
+// for sandbox accounts.
+func (w *Worker) tag() string {
+	if w.env == EnvSandbox {
+		return strconv.FormatInt(w.accountID, 10) + " " + EnvSandbox
+	}
+	return strconv.FormatInt(w.accountID, 10)
+}
+
+// logf logs with the worker tag.
+func (w *Worker) logf(format string, args ...any) {
+	log.Printf("[worker "+w.tag()+"] "+format, args...)
+}
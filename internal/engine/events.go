@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// Event types streamed to subscribers.
+const (
+	EventSeen        = "seen"
+	EventSkipped     = "skipped"
+	EventTaken       = "taken"
+	EventTakeFailed  = "take_failed"
+	EventPenalty     = "penalty"
+	EventPenaltyEnd  = "penalty_end"
+	EventCompleted   = "completed"
+	EventCanceled    = "canceled"
+	EventBreakerOpen = "breaker_open"
+	EventFailover    = "p2c_failover"
+)
+
+// Event is a structured worker activity record.
+type Event struct {
+	Type      string            `json:"type"`
+	AccountID int64             `json:"account_id"`
+	At        time.Time         `json:"at"`
+	Payment   *PaymentRef       `json:"payment,omitempty"`
+	Amount    string            `json:"amount,omitempty"`
+	Code      SkipCode          `json:"code,omitempty"` // тип причины пропуска
+	Reason    string            `json:"reason,omitempty"`
+	TakeMs    int64             `json:"take_ms,omitempty"`
+	Until     *time.Time        `json:"until,omitempty"`
+	Latency   *LatencyBreakdown `json:"latency,omitempty"`    // разбивка времени take
+	RequestID string            `json:"request_id,omitempty"` // id операции в логах и запросах к P2C
+	CFRay     string            `json:"cf_ray,omitempty"`
+}
+
+// eventBus fans worker events out to subscribers without ever blocking the
+// publisher: slow subscribers lose events instead of delaying takes. When
+// the worker stops the bus is closed and so are the subscriber channels, so
+// streams end and their clients resubscribe to the next worker.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+	hook   func(Event) // вызывается на каждое событие, не должен блокировать
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]struct{})}
+}
+
+func (b *eventBus) publish(ev Event) {
+	if b.hook != nil {
+		b.hook(ev)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// close ends every subscription; later events are dropped.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+	}
+}
+
+// emit publishes an event about a payment.
+func (w *Worker) emit(typ string, ref *PaymentRef, amount, reason string) {
+	w.emitCall(nil, typ, ref, amount, reason)
+}
+
+// emitCall is emit with the identifiers of the operation behind the event.
+func (w *Worker) emitCall(call *p2c.Call, typ string, ref *PaymentRef, amount, reason string) {
+	ev := Event{
+		Type:      typ,
+		AccountID: w.accountID,
+		At:        time.Now(),
+		Payment:   ref,
+		Amount:    amount,
+		Reason:    reason,
+	}
+	if call != nil {
+		ev.RequestID = call.ID
+		ev.CFRay = call.CFRay()
+	}
+	w.events.publish(ev)
+}
+
+// Subscribe streams worker events until cancel is called; the channel is
+// closed when the worker stops, e.g. on a restart.
+func (w *Worker) Subscribe() (<-chan Event, func()) {
+	return w.events.subscribe()
+}
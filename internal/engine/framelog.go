@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"p2c-engine/internal/logx"
+)
+
+// FrameLogMode is how much of the websocket traffic a worker writes to the log.
+type FrameLogMode string
+
+const (
+	FrameLogOff    FrameLogMode = "off"
+	FrameLogErrors FrameLogMode = "errors" // только кадры, которые не удалось разобрать (по умолчанию)
+	FrameLogSample FrameLogMode = "sample" // каждый Every-й кадр и ошибки
+	FrameLogFull   FrameLogMode = "full"
+)
+
+const (
+	frameLogEvery  = 100
+	frameLogMaxLen = 2048 // снапшот бывает на сотни КБ — в лог идёт начало
+)
+
+// FrameLogConfig sets the frame logging of a worker. At the debug log level
+// every frame is logged whatever the mode.
+type FrameLogConfig struct {
+	Mode  FrameLogMode `json:"mode"`            // пусто — errors
+	Every int          `json:"every,omitempty"` // sample: каждый N-й кадр, 0 = 100
+}
+
+// ValidFrameLogMode reports whether m is a known mode; empty is errors.
+func ValidFrameLogMode(m FrameLogMode) bool {
+	switch m {
+	case "", FrameLogOff, FrameLogErrors, FrameLogSample, FrameLogFull:
+		return true
+	}
+	return false
+}
+
+func (c FrameLogConfig) normalize() FrameLogConfig {
+	if c.Mode == "" {
+		c.Mode = FrameLogErrors
+	}
+	if c.Mode == FrameLogSample && c.Every <= 0 {
+		c.Every = frameLogEvery
+	}
+	if c.Mode != FrameLogSample {
+		c.Every = 0
+	}
+	return c
+}
+
+// FrameLogStatus is the frame logging in effect and its counters since the
+// worker started.
+type FrameLogStatus struct {
+	FrameLogConfig
+	Override bool   `json:"override,omitempty"` // задано через API поверх конфига аккаунта
+	Frames   uint64 `json:"frames"`
+	Logged   uint64 `json:"logged"`
+	Errors   uint64 `json:"errors"`
+}
+
+// frameLog decides per frame whether to log it; it sits on the websocket
+// read path, so everything is atomic.
+type frameLog struct {
+	cfg      atomic.Pointer[FrameLogConfig]
+	override atomic.Bool
+	frames   atomic.Uint64
+	logged   atomic.Uint64
+	errors   atomic.Uint64
+}
+
+func (f *frameLog) set(c FrameLogConfig, override bool) {
+	c = c.normalize()
+	f.cfg.Store(&c)
+	f.override.Store(override)
+}
+
+func (f *frameLog) config() FrameLogConfig {
+	if c := f.cfg.Load(); c != nil {
+		return *c
+	}
+	return FrameLogConfig{}.normalize()
+}
+
+func (f *frameLog) status() FrameLogStatus {
+	return FrameLogStatus{FrameLogConfig: f.config(), Override: f.override.Load(), Frames: f.frames.Load(), Logged: f.logged.Load(), Errors: f.errors.Load()}
+}
+
+// logFrame logs a raw frame according to the mode.
+func (w *Worker) logFrame(frame []byte) {
+	n := w.frames.frames.Add(1)
+	c := w.frames.config()
+	switch {
+	case c.Mode == FrameLogFull, logx.Enabled(logx.Debug):
+	case c.Mode == FrameLogSample && n%uint64(c.Every) == 0:
+	default:
+		return
+	}
+	w.frames.logged.Add(1)
+	w.logf("ws raw #%d: %s", n, clipFrame(frame))
+}
+
+// frameError logs a frame the socket could not parse; with the off mode
+// only the error, without the frame.
+func (w *Worker) frameError(frame []byte, err error) {
+	w.frames.errors.Add(1)
+	w.trace.add(TraceFrame{At: time.Now(), Note: "frame error: " + err.Error()})
+	if w.frames.config().Mode == FrameLogOff || frame == nil {
+		w.logf("ws %v", err)
+		return
+	}
+	w.frames.logged.Add(1)
+	w.logf("ws %v: %s", err, clipFrame(frame))
+}
+
+func clipFrame(frame []byte) string {
+	if len(frame) > frameLogMaxLen {
+		return fmt.Sprintf("%q… (%d bytes)", frame[:frameLogMaxLen], len(frame))
+	}
+	return fmt.Sprintf("%q", frame)
+}
+
+// FrameLog returns the frame logging of the account's worker.
+func (m *Manager) FrameLog(accountID int64) (FrameLogStatus, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return FrameLogStatus{}, ErrNoWorker
+	}
+	return w.frames.status(), nil
+}
+
+// SetFrameLog changes the frame logging of a running worker without a
+// restart. It lasts until the worker restarts or a reload changes the
+// account's frame_log.
+func (m *Manager) SetFrameLog(accountID int64, c FrameLogConfig) (FrameLogStatus, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return FrameLogStatus{}, ErrNoWorker
+	}
+	w.frames.set(c, true)
+	st := w.frames.status()
+	w.logf("frame log: %s every=%d", st.Mode, st.Every)
+	return st, nil
+}
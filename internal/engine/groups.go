@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// ownerGroups enforces the combined cap of simultaneously open fiat volume
+// of accounts run by one operator (WorkerConfig.OwnerGroup), so one person
+// is never expected to pay several large orders at once. Open volume is the
+// sum of payments being taken, taken or awaiting payment.
+type ownerGroups struct {
+	workers func() []*Worker
+
+	mu       sync.Mutex
+	reserved map[string]float64 // take в процессе, ещё не записан в журнал
+}
+
+func newOwnerGroups(workers func() []*Worker) *ownerGroups {
+	return &ownerGroups{workers: workers, reserved: make(map[string]float64)}
+}
+
+func groupKey(cfg WorkerConfig) string {
+	return cfg.TenantID + "/" + cfg.OwnerGroup
+}
+
+// usageLocked returns the open volume of the group and its cap: the
+// smallest non-zero OwnerGroupCap among its accounts, +Inf when none is set.
+func (g *ownerGroups) usageLocked(key string, now time.Time) (used, limit float64) {
+	limit = math.Inf(1)
+	used = g.reserved[key]
+	for _, w := range g.workers() {
+		cfg := w.config()
+		if cfg.OwnerGroup == "" || groupKey(cfg) != key {
+			continue
+		}
+		if cfg.OwnerGroupCap > 0 {
+			limit = min(limit, cfg.OwnerGroupCap)
+		}
+		used += w.journal.openVolume(now)
+	}
+	return used, limit
+}
+
+// room reports whether the group of w can take amount more right now.
+func (g *ownerGroups) room(w *Worker, amount float64, now time.Time) bool {
+	cfg := w.config()
+	if g == nil || cfg.OwnerGroup == "" {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	used, limit := g.usageLocked(groupKey(cfg), now)
+	return used+amount <= limit
+}
+
+// reserve books the payment amount against the group until release is
+// called, which the worker does once the take is in its journal. It skips
+// the payment when the group cap would be exceeded.
+func (g *ownerGroups) reserve(w *Worker, p p2c.LivePayment, now time.Time) (release func(), d Decision) {
+	cfg := w.config()
+	amount, err := strconv.ParseFloat(p.InAmount, 64)
+	if g == nil || cfg.OwnerGroup == "" || err != nil {
+		return func() {}, take()
+	}
+	key := groupKey(cfg)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	used, limit := g.usageLocked(key, now)
+	if used+amount > limit {
+		return func() {}, skip(SkipOwnerGroup, "owner group %q open %.2f + %.2f > cap %.2f", cfg.OwnerGroup, used, amount, limit)
+	}
+	g.reserved[key] += amount
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.reserved[key] -= amount; g.reserved[key] <= 0 {
+				delete(g.reserved, key)
+			}
+		})
+	}, take()
+}
+
+// openVolume sums the fiat amounts of payments the account still has to pay.
+func (j *journal) openVolume(now time.Time) float64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var sum float64
+	for _, rec := range j.records {
+		if !rec.Status.holdsAccount() && rec.Status != StateAwaitingPayment || now.After(rec.Deadline) {
+			continue
+		}
+		if v, err := strconv.ParseFloat(rec.InAmount, 64); err == nil {
+			sum += v
+		}
+	}
+	return sum
+}
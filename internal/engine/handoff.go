@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/p2c"
+)
+
+const handoffTTL = 10 * time.Minute // столько ждём, что P2C вернёт заявку в ленту
+
+// ErrNoHandoff is returned when a payment cannot be handed off: it is not
+// an open order of the account or the target account does not fit.
+var ErrNoHandoff = errors.New("payment cannot be handed off")
+
+// Handoff is a payment released by one account for another account of the
+// same fleet to re-take once the marketplace lists it again.
+type Handoff struct {
+	PaymentID string    `json:"payment_id"` // hex id из ленты
+	From      int64     `json:"from_account_id"`
+	To        int64     `json:"to_account_id,omitempty"` // 0 — любой подходящий аккаунт
+	InAmount  string    `json:"in_amount"`
+	At        time.Time `json:"at"`
+	Until     time.Time `json:"until"`
+}
+
+// handoffs holds the released payments until they are listed again. The
+// first worker to see the re-listed payment picks the account to take it,
+// like the arbiter does; every other worker of the fleet skips it.
+type handoffs struct {
+	workers func() []*Worker
+	n       atomic.Int32 // читается на горячем пути каждой заявки
+
+	mu    sync.Mutex
+	items map[string]*handoff
+}
+
+type handoff struct {
+	Handoff
+	fleet  string
+	winner int64 // выбран при повторном появлении, 0 — ещё нет
+	timer  *time.Timer
+}
+
+func newHandoffs(workers func() []*Worker) *handoffs {
+	return &handoffs{workers: workers, items: make(map[string]*handoff)}
+}
+
+// add registers a released payment; expired runs if nobody took it within
+// handoffTTL.
+func (h *handoffs) add(ho Handoff, fleet string, expired func(Handoff)) *handoff {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.items[ho.PaymentID]; ok {
+		old.timer.Stop()
+	}
+	item := &handoff{Handoff: ho, fleet: fleet}
+	item.timer = time.AfterFunc(time.Until(ho.Until), func() {
+		if h.drop(ho.PaymentID, item) {
+			expired(ho)
+		}
+	})
+	h.items[ho.PaymentID] = item
+	h.n.Store(int32(len(h.items)))
+	return item
+}
+
+// drop removes the handoff if it is still the given one.
+func (h *handoffs) drop(paymentID string, item *handoff) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.items[paymentID] != item {
+		return false
+	}
+	item.timer.Stop()
+	delete(h.items, paymentID)
+	h.n.Store(int32(len(h.items)))
+	return true
+}
+
+// route decides who re-takes a released payment. ok is false when the
+// payment is not handed off and goes through the usual checks; otherwise
+// take tells the caller to take it, bypassing dedup, filters and arbitration.
+// The releasing account never takes it back.
+func (h *handoffs) route(p p2c.LivePayment, caller *Worker) (winner int64, take, ok bool) {
+	if h == nil || h.n.Load() == 0 {
+		return 0, false, false
+	}
+	h.mu.Lock()
+	item, ok := h.items[p.ID]
+	if !ok || item.fleet != caller.fleet() {
+		h.mu.Unlock()
+		return 0, false, false
+	}
+	decided := item.winner != 0
+	if !decided {
+		item.winner = h.pick(item, p)
+	}
+	winner, ho := item.winner, item.Handoff
+	take = winner != 0 && winner == caller.accountID
+	if take || winner == 0 {
+		// заявка вернулась: либо её берут, либо брать некому
+		item.timer.Stop()
+		delete(h.items, p.ID)
+		h.n.Store(int32(len(h.items)))
+	}
+	h.mu.Unlock()
+
+	if !decided {
+		log.Printf("[handoff] %s from account %d routed to account %d", p.ID, ho.From, winner)
+		if from := h.worker(ho.From); from != nil {
+			cfg := from.config()
+			if winner == 0 {
+				from.notify(i18n.T(cfg.Locale, "handoff.unclaimed", cfg.account(), p.ID))
+			} else {
+				to := fmt.Sprint(winner)
+				if w := h.worker(winner); w != nil {
+					to = w.config().account().String()
+				}
+				from.notify(i18n.T(cfg.Locale, "handoff.routed", cfg.account(), p.ID, to))
+			}
+		}
+	}
+	return winner, take, true
+}
+
+// pick returns the account to re-take the payment: the requested one if it
+// is eligible, otherwise (for handoffs to any account) the eligible account
+// of the highest priority, the lowest id on a tie; 0 if none fits. h.mu must
+// be held.
+func (h *handoffs) pick(item *handoff, p p2c.LivePayment) int64 {
+	now := time.Now()
+	var best *Worker
+	for _, w := range h.workers() {
+		if w.accountID == item.From || w.fleet() != item.fleet {
+			continue
+		}
+		if item.To != 0 && w.accountID != item.To {
+			continue
+		}
+		if !w.eligible(p, now) {
+			continue
+		}
+		if best == nil || w.config().Priority > best.config().Priority ||
+			w.config().Priority == best.config().Priority && w.accountID < best.accountID {
+			best = w
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return best.accountID
+}
+
+func (h *handoffs) worker(accountID int64) *Worker {
+	for _, w := range h.workers() {
+		if w.accountID == accountID {
+			return w
+		}
+	}
+	return nil
+}
+
+// Handoff releases an accepted order the account cannot pay: it is canceled
+// on P2C, and when the marketplace lists it again another account of the
+// same tenant re-takes it — toAccountID, or any eligible account if 0.
+func (m *Manager) Handoff(ctx context.Context, accountID int64, paymentID string, toAccountID int64) (Handoff, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return Handoff{}, ErrNoWorker
+	}
+	cfg := w.config()
+	if toAccountID == accountID {
+		return Handoff{}, fmt.Errorf("%w: target is the same account", ErrNoHandoff)
+	}
+	if toAccountID != 0 {
+		to := m.worker(toAccountID)
+		if to == nil {
+			return Handoff{}, ErrNoWorker
+		}
+		if m.AccountTenant(toAccountID) != m.AccountTenant(accountID) {
+			return Handoff{}, ErrForeignAccount
+		}
+		if to.config().env() != cfg.env() {
+			return Handoff{}, fmt.Errorf("%w: target account works in %s", ErrNoHandoff, to.config().env())
+		}
+	}
+	rec, ok := w.Payment(paymentID)
+	if !ok || !rec.Status.Open() {
+		return Handoff{}, fmt.Errorf("%w: no open order %s", ErrNoHandoff, paymentID)
+	}
+	if rec.Ref.Hex == "" {
+		// без hex повторное появление в ленте не узнать
+		return Handoff{}, fmt.Errorf("%w: feed id of %s is unknown", ErrNoHandoff, rec.Ref)
+	}
+
+	now := time.Now()
+	ho := Handoff{PaymentID: rec.Ref.Hex, From: accountID, To: toAccountID, InAmount: rec.InAmount, At: now, Until: now.Add(handoffTTL)}
+	// регистрируем до отмены: заявка может вернуться в ленту раньше ответа P2C
+	item := m.handoffs.add(ho, cfg.TenantID+"/"+cfg.env(), func(ho Handoff) {
+		log.Printf("[handoff] %s from account %d not listed again within %s", ho.PaymentID, ho.From, handoffTTL)
+		if w := m.worker(ho.From); w != nil {
+			cfg := w.config()
+			w.notify(i18n.T(cfg.Locale, "handoff.expired", cfg.account(), ho.PaymentID))
+		}
+	})
+	if _, err := w.CancelPayment(ctx, rec.Ref.Hex); err != nil {
+		m.handoffs.drop(ho.PaymentID, item)
+		return Handoff{}, err
+	}
+	w.logf("handoff %s to account %d", rec.Ref, toAccountID)
+	to := i18n.T(cfg.Locale, "handoff.any")
+	if tw := m.worker(toAccountID); tw != nil {
+		to = tw.config().account().String()
+	}
+	w.notify(i18n.T(cfg.Locale, "handoff.released", cfg.account(), rec.Ref, rec.InAmount, rec.InAsset, to))
+	return ho, nil
+}
@@ -0,0 +1,13 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newIdempotencyKey returns a random key for one take attempt.
+func newIdempotencyKey() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
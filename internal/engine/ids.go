@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// PaymentRef carries both forms of a payment id: hex from the websocket list
+// and numeric from the REST API. Numeric is zero until the mapping is known.
+type PaymentRef struct {
+	Hex     string `json:"id,omitempty"`
+	Numeric int64  `json:"numeric_id,omitempty"`
+}
+
+// String formats the id for logs and messages, showing both forms once known.
+func (r PaymentRef) String() string {
+	switch {
+	case r.Hex != "" && r.Numeric != 0:
+		return fmt.Sprintf("%s (#%d)", r.Hex, r.Numeric)
+	case r.Numeric != 0:
+		return fmt.Sprintf("#%d", r.Numeric)
+	default:
+		return r.Hex
+	}
+}
+
+// APIID returns the id to send to P2C REST endpoints: numeric when known.
+func (r PaymentRef) APIID() string {
+	if r.Numeric != 0 {
+		return strconv.FormatInt(r.Numeric, 10)
+	}
+	return r.Hex
+}
+
+// idStore maps hex ids to numeric ids and back.
+type idStore struct {
+	mu       sync.Mutex
+	byHex    map[string]int64
+	byNumber map[int64]string
+}
+
+func newIDStore() *idStore {
+	return &idStore{
+		byHex:    make(map[string]int64),
+		byNumber: make(map[int64]string),
+	}
+}
+
+func (s *idStore) store(hexID string, numericID int64) {
+	if hexID == "" || numericID == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.byHex[hexID] = numericID
+	s.byNumber[numericID] = hexID
+	s.mu.Unlock()
+}
+
+// seed restores the mappings known to the journal after a restart.
+func (s *idStore) seed(refs []PaymentRef) {
+	for _, ref := range refs {
+		s.store(ref.Hex, ref.Numeric)
+	}
+}
+
+// resolve accepts either form and fills in the other one if it is known.
+// A known hex id wins over numeric parsing: hex ids can be all digits.
+func (s *idStore) resolve(id string) PaymentRef {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if num, ok := s.byHex[id]; ok {
+		return PaymentRef{Hex: id, Numeric: num}
+	}
+	if num, err := strconv.ParseInt(id, 10, 64); err == nil && num > 0 {
+		return PaymentRef{Hex: s.byNumber[num], Numeric: num}
+	}
+	return PaymentRef{Hex: id}
+}
@@ -0,0 +1,350 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+// Deferred action kinds.
+const (
+	JobComplete      = "complete"       // подтвердить оплату в момент At
+	JobCancelExpiry  = "cancel_expiry"  // отменить заявку, если к сроку она всё ещё не оплачена
+	JobNotify        = "notify"         // повторить недоставленное событие на вебхук аккаунта
+	JobResumePenalty = "resume_penalty" // снять штраф аккаунта по окончании
+	JobEscalate      = "escalate"       // передать неподтверждённую заявку следующему плательщику
+)
+
+const (
+	jobsDoc         = "jobs"
+	jobMaxAttempts  = 8
+	jobRetryBase    = 30 * time.Second
+	jobRetryMax     = 30 * time.Minute
+	jobRetryNoOwner = 15 * time.Second // воркер аккаунта ещё не загружен
+)
+
+// errJobNoWorker postpones a job until the account's worker is loaded; it
+// does not count as a failed attempt.
+var errJobNoWorker = errors.New("account worker is not running")
+
+// Job is a deferred action persisted in the data dir, so it runs even if
+// the engine was restarted in between. ID is unique: scheduling a job with
+// an existing ID replaces it.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	AccountID int64           `json:"account_id"`
+	PaymentID string          `json:"payment_id,omitempty"`
+	At        time.Time       `json:"at"`
+	Attempts  int             `json:"attempts,omitempty"`
+	LastError string          `json:"last_error,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// JobQueue runs deferred actions at their time. Jobs are saved on every
+// change; a failed job is retried with exponential backoff and dropped
+// after jobMaxAttempts.
+type JobQueue struct {
+	store *store.Store
+
+	mu       sync.Mutex
+	jobs     map[string]Job
+	handlers map[string]func(Job) error
+	timer    *time.Timer
+	running  bool
+	closed   bool
+}
+
+// NewJobQueue loads the persisted jobs; they start running once Start is
+// called, after the handlers are registered.
+func NewJobQueue(st *store.Store) *JobQueue {
+	q := &JobQueue{store: st, jobs: make(map[string]Job), handlers: make(map[string]func(Job) error)}
+	var jobs []Job
+	if err := st.Load(jobsDoc, &jobs); err != nil {
+		log.Printf("[jobs] load error: %v", err)
+	}
+	for _, j := range jobs {
+		q.jobs[j.ID] = j
+	}
+	return q
+}
+
+// Handle registers the action of a job kind.
+func (q *JobQueue) Handle(kind string, fn func(Job) error) {
+	q.mu.Lock()
+	q.handlers[kind] = fn
+	q.mu.Unlock()
+}
+
+// Start arms the timer for the loaded jobs.
+func (q *JobQueue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) > 0 {
+		log.Printf("[jobs] %d deferred actions restored", len(q.jobs))
+	}
+	q.armLocked()
+}
+
+// Schedule adds or replaces a job.
+func (q *JobQueue) Schedule(j Job) {
+	if j.ID == "" {
+		j.ID = fmt.Sprintf("%s:%d:%s:%d", j.Kind, j.AccountID, j.PaymentID, j.At.UnixNano())
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[j.ID] = j
+	q.saveLocked()
+	q.armLocked()
+}
+
+// Cancel removes a job; it reports whether the job was pending.
+func (q *JobQueue) Cancel(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.jobs[id]; !ok {
+		return false
+	}
+	delete(q.jobs, id)
+	q.saveLocked()
+	q.armLocked()
+	return true
+}
+
+// Pending reports whether a job is scheduled.
+func (q *JobQueue) Pending(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.jobs[id]
+	return ok
+}
+
+// List returns the pending jobs of an account (all with accountID 0), by time.
+func (q *JobQueue) List(accountID int64) []Job {
+	q.mu.Lock()
+	out := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		if accountID == 0 || j.AccountID == accountID {
+			out = append(out, j)
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(out, func(i, k int) bool { return out[i].At.Before(out[k].At) })
+	return out
+}
+
+// Close stops running jobs; pending ones stay saved for the next start.
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+}
+
+// armLocked sets the timer to the earliest job.
+func (q *JobQueue) armLocked() {
+	if q.closed {
+		return
+	}
+	var next time.Time
+	for _, j := range q.jobs {
+		if next.IsZero() || j.At.Before(next) {
+			next = j.At
+		}
+	}
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	if next.IsZero() {
+		return
+	}
+	q.timer = time.AfterFunc(max(time.Until(next), 0), q.runDue)
+}
+
+// runDue executes the jobs whose time has come, one after another.
+func (q *JobQueue) runDue() {
+	now := time.Now()
+	q.mu.Lock()
+	if q.running {
+		q.mu.Unlock()
+		return // текущий проход перевзведёт таймер сам
+	}
+	q.running = true
+	var due []Job
+	for _, j := range q.jobs {
+		if !j.At.After(now) {
+			due = append(due, j)
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(due, func(i, k int) bool { return due[i].At.Before(due[k].At) })
+	for _, j := range due {
+		q.run(j)
+	}
+	q.mu.Lock()
+	q.running = false
+	q.armLocked()
+	q.mu.Unlock()
+}
+
+func (q *JobQueue) run(j Job) {
+	q.mu.Lock()
+	fn := q.handlers[j.Kind]
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return
+	}
+	err := fmt.Errorf("no handler for %q", j.Kind)
+	if fn != nil {
+		err = fn(j)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cur, ok := q.jobs[j.ID]; !ok || !cur.At.Equal(j.At) {
+		return // за время выполнения задачу отменили или перепланировали
+	}
+	switch {
+	case err == nil:
+		delete(q.jobs, j.ID)
+	case errors.Is(err, errJobNoWorker):
+		j.At = time.Now().Add(jobRetryNoOwner)
+		q.jobs[j.ID] = j
+	default:
+		j.Attempts++
+		j.LastError = err.Error()
+		if j.Attempts >= jobMaxAttempts {
+			log.Printf("[jobs] %s dropped after %d attempts: %v", j.ID, j.Attempts, err)
+			delete(q.jobs, j.ID)
+			break
+		}
+		delay := min(jobRetryBase<<(j.Attempts-1), jobRetryMax)
+		log.Printf("[jobs] %s failed (attempt %d), retry in %s: %v", j.ID, j.Attempts, delay, err)
+		j.At = time.Now().Add(delay)
+		q.jobs[j.ID] = j
+	}
+	q.saveLocked()
+}
+
+func (q *JobQueue) saveLocked() {
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j)
+	}
+	if err := q.store.Save(jobsDoc, jobs); err != nil {
+		log.Printf("[jobs] save error: %v", err)
+	}
+}
+
+// penaltyJobID is the single resume job of an account.
+func penaltyJobID(accountID int64) string {
+	return JobResumePenalty + ":" + strconv.FormatInt(accountID, 10)
+}
+
+// notifyJob is the data of a JobNotify: an outbound post to retry.
+type notifyJob struct {
+	URL         string      `json:"url"`
+	ContentType string      `json:"content_type"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body"`
+}
+
+// registerJobs sets the actions of the deferred jobs run on accounts.
+func (m *Manager) registerJobs() {
+	m.jobs.Handle(JobComplete, func(j Job) error {
+		w := m.worker(j.AccountID)
+		if w == nil {
+			return errJobNoWorker
+		}
+		if rec, ok := w.Payment(j.PaymentID); ok && !rec.Status.CanTransition(StateCompleting) {
+			log.Printf("[jobs] complete %s skipped: payment is %s", j.PaymentID, rec.Status)
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := w.CompletePayment(ctx, j.PaymentID)
+		return err
+	})
+	m.jobs.Handle(JobCancelExpiry, func(j Job) error {
+		w := m.worker(j.AccountID)
+		if w == nil {
+			return errJobNoWorker
+		}
+		rec, ok := w.Payment(j.PaymentID)
+		if !ok || !rec.Status.CanTransition(StateCancelling) || rec.Status == StateCompleting {
+			return nil // уже оплачена, отменена или забыта
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := w.CancelPayment(ctx, j.PaymentID)
+		return err
+	})
+	m.jobs.Handle(JobEscalate, func(j Job) error {
+		w := m.worker(j.AccountID)
+		if w == nil {
+			return errJobNoWorker
+		}
+		return w.escalate(j)
+	})
+	m.jobs.Handle(JobNotify, func(j Job) error {
+		var n notifyJob
+		if err := json.Unmarshal(j.Data, &n); err != nil {
+			log.Printf("[jobs] %s: bad data: %v", j.ID, err)
+			return nil
+		}
+		// подпись с новой меткой времени: старую получатель отверг бы как повтор
+		if w := m.worker(j.AccountID); w != nil && n.Header.Get(SignatureHeader) != "" {
+			if secret := w.config().WebhookSecret.Reveal(); secret != "" {
+				n.Header.Set(SignatureHeader, signPayload(secret, time.Now(), n.Body))
+			}
+		}
+		_, err := webhookSender(n.URL).post(hookRequest{contentType: n.ContentType, header: n.Header, body: n.Body})
+		return err
+	})
+}
+
+// ScheduleJob adds a deferred complete or cancel of an account's payment.
+func (m *Manager) ScheduleJob(accountID int64, kind, paymentID string, at time.Time) (Job, error) {
+	if kind != JobComplete && kind != JobCancelExpiry {
+		return Job{}, fmt.Errorf("unknown job kind %q", kind)
+	}
+	w := m.worker(accountID)
+	if w == nil {
+		return Job{}, fmt.Errorf("account %d is not running", accountID)
+	}
+	ref := w.ResolvePayment(paymentID)
+	id := ref.Hex
+	if id == "" {
+		id = ref.APIID()
+	}
+	j := Job{ID: kind + ":" + strconv.FormatInt(accountID, 10) + ":" + id, Kind: kind, AccountID: accountID, PaymentID: id, At: at}
+	m.jobs.Schedule(j)
+	return j, nil
+}
+
+// Jobs returns the pending deferred actions of an account.
+func (m *Manager) Jobs(accountID int64) []Job {
+	return m.jobs.List(accountID)
+}
+
+// CancelJob removes a pending job of an account.
+func (m *Manager) CancelJob(accountID int64, id string) bool {
+	for _, j := range m.jobs.List(accountID) {
+		if j.ID == id {
+			return m.jobs.Cancel(id)
+		}
+	}
+	return false
+}
@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/store"
+)
+
+// PaymentRecord is the worker's journal entry for a payment it tried to take.
+type PaymentRecord struct {
+	Ref            PaymentRef        `json:"payment"`
+	AccountID      int64             `json:"account_id"`
+	Status         PaymentState      `json:"status"`
+	BrandName      string            `json:"brand_name"`
+	InAmount       string            `json:"in_amount"`
+	InAsset        string            `json:"in_asset"`
+	OutAsset       string            `json:"out_asset"`
+	ExchangeRate   string            `json:"exchange_rate"`
+	FeeAmount      string            `json:"fee_amount"`
+	URL            string            `json:"url,omitempty"`
+	Provider       string            `json:"provider,omitempty"`
+	Payload        string            `json:"payload,omitempty"` // данные оплаты: ссылка СБП, реквизиты
+	ExpiresAt      string            `json:"expires_at,omitempty"`
+	TakenAt        time.Time         `json:"taken_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Deadline       time.Time         `json:"deadline"` // после него заявка считается истёкшей
+	Delisted       bool              `json:"delisted,omitempty"`
+	ToTakeMs       int64             `json:"to_take_ms"`
+	TakeMs         int64             `json:"take_ms"`
+	CFRay          string            `json:"cf_ray,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"` // ключ попытки take
+	Latency        *LatencyBreakdown `json:"latency,omitempty"`
+	RefRate        float64           `json:"ref_rate,omitempty"` // рыночный курс out_asset на момент take
+	Card           *OrderCard        `json:"card,omitempty"`     // сообщение в Telegram, которое правим по ходу заявки
+	Receipt        *ReceiptCheck     `json:"receipt,omitempty"`  // сверка приложенного плательщиком чека
+	History        []StateChange     `json:"history,omitempty"`
+}
+
+// StateChange is one lifecycle transition of a payment.
+type StateChange struct {
+	State PaymentState `json:"state"`
+	At    time.Time    `json:"at"`
+}
+
+// journal keeps the recent payments of a worker keyed by hex id and drives
+// their state machine. With a store attached it persists every change.
+type journal struct {
+	mu      sync.Mutex
+	records map[string]*PaymentRecord
+	limit   int
+	saver   *docSaver
+	release func(ref PaymentRef)    // вызывается, когда заявка перестала держать аккаунт
+	change  func(ref PaymentRef)    // вызывается после каждой смены состояния
+	settled func(rec PaymentRecord) // вызывается, когда заявка оплачена, отменена или оспорена
+	clock   *p2c.Clock              // переводит времена P2C в локальные, nil — часы совпадают
+}
+
+func newJournal(limit int) *journal {
+	return &journal{records: make(map[string]*PaymentRecord), limit: limit}
+}
+
+// attach loads the account's persisted payments and saves changes in the
+// background so the take path never waits on disk.
+func (j *journal) attach(st *store.Store, accountID int64) {
+	if st == nil {
+		return
+	}
+	doc := fmt.Sprintf("payments/%d", accountID)
+	var saved []PaymentRecord
+	if err := st.Load(doc, &saved); err != nil {
+		log.Printf("[journal %d] load error: %v", accountID, err)
+	}
+	j.mu.Lock()
+	for i := range saved {
+		rec := saved[i]
+		j.records[rec.Ref.Hex] = &rec
+	}
+	j.mu.Unlock()
+	j.saver = newDocSaver(st, doc, 0, func() any { return j.list() })
+}
+
+// close flushes pending changes and stops the saver.
+func (j *journal) close() {
+	j.saver.close()
+}
+
+// begin records a take attempt and returns its idempotency key.
+func (j *journal) begin(accountID int64, p p2c.LivePayment, ref PaymentRef) string {
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := &PaymentRecord{
+		Ref:            ref,
+		AccountID:      accountID,
+		Status:         StateSeen,
+		BrandName:      p.BrandName,
+		InAmount:       p.InAmount,
+		InAsset:        p.InAsset,
+		OutAsset:       p.OutAsset,
+		ExchangeRate:   p.ExchangeRate,
+		FeeAmount:      p.FeeAmount,
+		URL:            p.URL,
+		Provider:       p.Provider,
+		Payload:        p.Payload,
+		ExpiresAt:      p.ExpiresAt,
+		TakenAt:        now,
+		Deadline:       holdDeadline(p.ExpiresAt, now, j.clock),
+		IdempotencyKey: newIdempotencyKey(),
+	}
+	j.records[p.ID] = rec
+	j.setLocked(rec, StateTaking, now)
+	j.trim()
+	return rec.IdempotencyKey
+}
+
+// holdDeadline is expires_at on the local clock plus a margin, or 5
+// minutes when unknown.
+func holdDeadline(expiresAt string, now time.Time, clock *p2c.Clock) time.Time {
+	if t, err := time.Parse(time.RFC3339, expiresAt); err == nil && clock.Local(t).After(now) {
+		return clock.Local(t).Add(10 * time.Second)
+	}
+	return now.Add(5 * time.Minute)
+}
+
+// taken completes a successful take with its timings and numeric id.
+func (j *journal) taken(ref PaymentRef, toTake, take time.Duration, cfRay string, lat *LatencyBreakdown) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := j.find(ref)
+	if rec == nil {
+		return fmt.Errorf("payment %s not in journal", ref)
+	}
+	if ref.Numeric != 0 {
+		rec.Ref.Numeric = ref.Numeric
+	}
+	rec.ToTakeMs = toTake.Milliseconds()
+	rec.TakeMs = take.Milliseconds()
+	rec.CFRay = cfRay
+	rec.Latency = lat
+	if err := j.moveLocked(rec, StateTaken); err != nil {
+		return err
+	}
+	// remove пришёл раньше ответа на take — сразу ждём оплату
+	if rec.Delisted {
+		return j.moveLocked(rec, StateAwaitingPayment)
+	}
+	return nil
+}
+
+// failedRay keeps the CF-RAY of a failed take, to quote it to P2C support.
+func (j *journal) failedRay(ref PaymentRef, cfRay string) {
+	if cfRay == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec := j.find(ref); rec != nil && rec.CFRay == "" {
+		rec.CFRay = cfRay
+		j.saver.changed()
+	}
+}
+
+// priced records the reference rate of the payment at take time.
+func (j *journal) priced(ref PaymentRef, rate float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec := j.find(ref); rec != nil {
+		rec.RefRate = rate
+		j.saver.changed()
+	}
+}
+
+// delisted handles the payment leaving the public list: a taken payment
+// starts awaiting payment and releases the account.
+func (j *journal) delisted(hex string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec, ok := j.records[hex]
+	if !ok {
+		return
+	}
+	rec.Delisted = true
+	if rec.Status == StateTaken {
+		_ = j.moveLocked(rec, StateAwaitingPayment)
+	}
+}
+
+// transition moves a known payment to the next state. found is false when the
+// journal does not know the payment (e.g. taken before a restart without a store).
+func (j *journal) transition(ref PaymentRef, to PaymentState) (found bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := j.find(ref)
+	if rec == nil {
+		return false, nil
+	}
+	if ref.Numeric != 0 && rec.Ref.Numeric == 0 {
+		rec.Ref.Numeric = ref.Numeric
+	}
+	return true, j.moveLocked(rec, to)
+}
+
+// settlePaths are the steps from any open state to the state P2C reports;
+// steps the lifecycle does not allow from the current state are skipped.
+var settlePaths = map[PaymentState][]PaymentState{
+	StateAwaitingPayment: {StateTaken, StateAwaitingPayment},
+	StateCompleted:       {StateTaken, StateAwaitingPayment, StateCompleting, StateCompleted},
+	StateCanceled:        {StateTaken, StateAwaitingPayment, StateCancelling, StateCanceled},
+	StateDisputed:        {StateTaken, StateAwaitingPayment, StateDisputed},
+	StateTakeFailed:      {StateTakeFailed},
+}
+
+// settle moves a payment to the state P2C reports for it, through the
+// intermediate states the lifecycle requires (taken -> awaiting_payment ->
+// completing -> completed). It reports whether the payment ended up in to.
+func (j *journal) settle(ref PaymentRef, to PaymentState) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := j.find(ref)
+	if rec == nil {
+		return false
+	}
+	if ref.Numeric != 0 && rec.Ref.Numeric == 0 {
+		rec.Ref.Numeric = ref.Numeric
+		j.saver.changed()
+	}
+	for _, step := range settlePaths[to] {
+		if rec.Status != to && rec.Status.CanTransition(step) {
+			j.setLocked(rec, step, time.Now())
+		}
+	}
+	return rec.Status == to
+}
+
+// adopt records a payment the account holds on P2C that the journal does
+// not know, e.g. taken before a restart without a store. It is keyed by
+// its numeric id and starts awaiting payment.
+func (j *journal) adopt(accountID int64, p p2c.Payment, now time.Time) PaymentRecord {
+	takenAt, err := time.Parse(time.RFC3339, p.Processing)
+	if err != nil {
+		takenAt = now
+	}
+	takenAt = j.clock.Local(takenAt)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := &PaymentRecord{
+		Ref:          PaymentRef{Hex: p.IDString(), Numeric: p.NumericID()},
+		AccountID:    accountID,
+		BrandName:    p.BrandName,
+		InAmount:     p.AmountFiat,
+		InAsset:      p.Fiat,
+		OutAsset:     p.Asset,
+		ExchangeRate: p.ExchangeRate,
+		FeeAmount:    p.RewardAmount,
+		URL:          p.URL,
+		TakenAt:      takenAt,
+		Deadline:     holdDeadline("", now, nil),
+	}
+	j.records[rec.Ref.Hex] = rec
+	j.setLocked(rec, StateAwaitingPayment, now)
+	j.trim()
+	return *rec
+}
+
+func (j *journal) moveLocked(rec *PaymentRecord, to PaymentState) error {
+	if !rec.Status.CanTransition(to) {
+		return &TransitionError{Ref: rec.Ref, From: rec.Status, To: to}
+	}
+	j.setLocked(rec, to, time.Now())
+	return nil
+}
+
+// onRelease registers fn, called in the background whenever a payment stops
+// holding the account (see PaymentState.holdsAccount).
+func (j *journal) onRelease(fn func(ref PaymentRef)) {
+	j.mu.Lock()
+	j.release = fn
+	j.mu.Unlock()
+}
+
+// onChange registers fn, called in the background after every state change.
+func (j *journal) onChange(fn func(ref PaymentRef)) {
+	j.mu.Lock()
+	j.change = fn
+	j.mu.Unlock()
+}
+
+// onSettled registers fn, called in the background with the record once a
+// payment is completed, canceled or disputed.
+func (j *journal) onSettled(fn func(rec PaymentRecord)) {
+	j.mu.Lock()
+	j.settled = fn
+	j.mu.Unlock()
+}
+
+func (j *journal) setLocked(rec *PaymentRecord, to PaymentState, now time.Time) {
+	if j.release != nil && rec.Status.holdsAccount() && !to.holdsAccount() {
+		go j.release(rec.Ref)
+	}
+	if j.change != nil {
+		go j.change(rec.Ref)
+	}
+	rec.Status = to
+	rec.UpdatedAt = now
+	rec.History = append(rec.History, StateChange{State: to, At: now})
+	if j.settled != nil && (to == StateCompleted || to == StateCanceled || to == StateDisputed) {
+		go j.settled(*rec)
+	}
+	j.saver.changed()
+}
+
+// holding returns the payment that blocks new takes, expiring stale ones.
+func (j *journal) holding(now time.Time) (PaymentRecord, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var hold *PaymentRecord
+	for _, rec := range j.records {
+		if !rec.Status.holdsAccount() && rec.Status != StateAwaitingPayment {
+			continue
+		}
+		if now.After(rec.Deadline) {
+			switch rec.Status {
+			case StateTaking:
+				j.setLocked(rec, StateTakeFailed, now) // ответ на take так и не пришёл
+			default:
+				_ = j.moveLocked(rec, StateExpired)
+			}
+			continue
+		}
+		if rec.Status.holdsAccount() && (hold == nil || rec.TakenAt.After(hold.TakenAt)) {
+			hold = rec
+		}
+	}
+	if hold == nil {
+		return PaymentRecord{}, false
+	}
+	return *hold, true
+}
+
+func (j *journal) get(ref PaymentRef) (PaymentRecord, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec := j.find(ref); rec != nil {
+		return *rec, true
+	}
+	return PaymentRecord{}, false
+}
+
+// refs returns the ids of the records that have both forms known.
+func (j *journal) refs() []PaymentRef {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]PaymentRef, 0, len(j.records))
+	for _, rec := range j.records {
+		if rec.Ref.Hex != "" && rec.Ref.Numeric != 0 {
+			out = append(out, rec.Ref)
+		}
+	}
+	return out
+}
+
+// list returns records newest first.
+func (j *journal) list() []PaymentRecord {
+	j.mu.Lock()
+	out := make([]PaymentRecord, 0, len(j.records))
+	for _, rec := range j.records {
+		out = append(out, *rec)
+	}
+	j.mu.Unlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].TakenAt.After(out[b].TakenAt) })
+	return out
+}
+
+func (j *journal) find(ref PaymentRef) *PaymentRecord {
+	if ref.Hex != "" {
+		if rec, ok := j.records[ref.Hex]; ok {
+			return rec
+		}
+	}
+	if ref.Numeric != 0 {
+		for _, rec := range j.records {
+			if rec.Ref.Numeric == ref.Numeric {
+				return rec
+			}
+		}
+	}
+	return nil
+}
+
+// trim drops the oldest records above the limit.
+func (j *journal) trim() {
+	for len(j.records) > j.limit {
+		var oldestID string
+		var oldest time.Time
+		for id, rec := range j.records {
+			if oldestID == "" || rec.TakenAt.Before(oldest) {
+				oldestID, oldest = id, rec.TakenAt
+			}
+		}
+		delete(j.records, oldestID)
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/tracing"
+)
+
+// LatencyBreakdown splits a live take from the websocket read to the take
+// response, to see where the milliseconds go.
+type LatencyBreakdown struct {
+	ParseUs   int64 `json:"parse_us"`   // чтение кадра -> разобранное обновление
+	QueueUs   int64 `json:"queue_us"`   // разбор -> начало обработки воркером
+	FilterUs  int64 `json:"filter_us"`  // фильтры, стратегия, арбитраж
+	NetworkMs int64 `json:"network_ms"` // take без серверного времени: DNS, TLS, передача
+	ServerMs  int64 `json:"server_ms"`  // запись запроса -> первый байт ответа
+	TotalMs   int64 `json:"total_ms"`   // чтение кадра (или начало обработки) -> ответ
+}
+
+// newLatency builds the breakdown; handled is when the worker got the
+// payment and decided when it sent the take.
+func newLatency(p p2c.LivePayment, handled, decided time.Time, take time.Duration, res *p2c.TakeResult) *LatencyBreakdown {
+	l := &LatencyBreakdown{FilterUs: decided.Sub(handled).Microseconds()}
+	start := handled
+	if !p.ReceivedAt.IsZero() && !p.ParsedAt.IsZero() {
+		l.ParseUs = p.ParsedAt.Sub(p.ReceivedAt).Microseconds()
+		l.QueueUs = handled.Sub(p.ParsedAt).Microseconds()
+		start = p.ReceivedAt
+	}
+	server := time.Duration(0)
+	if res != nil {
+		server = res.Timing.ServerTime
+	}
+	l.ServerMs = server.Milliseconds()
+	l.NetworkMs = (take - server).Milliseconds()
+	l.TotalMs = decided.Add(take).Sub(start).Milliseconds()
+	return l
+}
+
+func (l *LatencyBreakdown) String() string {
+	if l == nil {
+		return "-"
+	}
+	return fmt.Sprintf("parse=%dµs queue=%dµs filter=%dµs net=%dms srv=%dms total=%dms", l.ParseUs, l.QueueUs, l.FilterUs, l.NetworkMs, l.ServerMs, l.TotalMs)
+}
+
+// takeTiming is what a live take measured, carried on to booking.
+type takeTiming struct {
+	toTake  time.Duration
+	take    time.Duration
+	cfRay   string
+	latency *LatencyBreakdown
+	call    *p2c.Call     // операция take: её id и CF-RAY идут в логи и события
+	span    *tracing.Span // трасса take, закрывается после уведомления или отказа
+}
+
+// takeSpan records a live take as a trace: websocket receive, filters and
+// the take request. The span stays open until the take settles.
+func (w *Worker) takeSpan(p p2c.LivePayment, handled, decided time.Time, tt takeTiming, res *p2c.TakeResult, err error) *tracing.Span {
+	start := handled
+	if !p.ReceivedAt.IsZero() {
+		start = p.ReceivedAt
+	}
+	span := tracing.Start("take", start)
+	if span == nil {
+		return nil
+	}
+	span.Set("account.id", w.accountID).Set("payment.id", p.ID).Set("payment.amount", p.InAmount).Set("request.id", tt.call.ID)
+	if !p.ReceivedAt.IsZero() && !p.ParsedAt.IsZero() {
+		span.Step("ws.receive", p.ReceivedAt, p.ParsedAt)
+		span.Step("queue", p.ParsedAt, handled)
+	}
+	span.Step("filter", handled, decided)
+	req := span.Step("take.http", decided, decided.Add(tt.take)).Set("cf.ray", tt.cfRay)
+	if res != nil {
+		req.Set("http.status_code", res.Status).Set("http.proto", res.Proto).
+			Set("dns_ms", res.Timing.DNSLookup.Milliseconds()).
+			Set("tls_ms", res.Timing.TLSHandshake.Milliseconds()).
+			Set("server_ms", res.Timing.ServerTime.Milliseconds()).
+			Set("conn.reused", res.Timing.ReusedConn)
+	}
+	req.Fail(err)
+	return span
+}
@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+const (
+	defaultLeaseTTL = 15 * time.Second
+	leaseDir        = "leases"
+)
+
+// Lease binds an account to the engine instance running its worker. It also
+// carries the worker config (the access token is encrypted by store.Secret),
+// so another instance can take the account over when the owner dies.
+type Lease struct {
+	AccountID int64        `json:"account_id"`
+	Instance  string       `json:"instance"`
+	URL       string       `json:"url"`
+	Expires   time.Time    `json:"expires"`
+	Config    WorkerConfig `json:"config"`
+}
+
+// leases stores account leases in the store shared by all instances. Every
+// change happens under the document lock, so two instances never both
+// believe they own an account.
+//
+// Expires is written by the owner's clock, and the hosts' clocks may
+// disagree, so no instance compares it with its own: a lease of another
+// instance counts as alive until it has stayed unchanged for ttl by this
+// instance's clock, and the owner stops its worker once it could not renew
+// for nearly ttl by its own.
+type leases struct {
+	store    *store.Store
+	instance string
+	url      string
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	renewed  map[int64]time.Time   // наши аренды: последнее удачное продление
+	observed map[int64]leaseChange // чужие аренды: когда мы видели их изменившимися
+}
+
+type leaseChange struct {
+	instance string
+	expires  time.Time
+	at       time.Time
+}
+
+// alive reports whether the owner of cur still renews it: it changed, or
+// was first seen, within the last ttl.
+func (l *leases) alive(cur Lease, now time.Time) bool {
+	if cur.Instance == "" {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seen, ok := l.observed[cur.AccountID]
+	if !ok || seen.instance != cur.Instance || !seen.expires.Equal(cur.Expires) {
+		l.observed[cur.AccountID] = leaseChange{instance: cur.Instance, expires: cur.Expires, at: now}
+		return true
+	}
+	return now.Sub(seen.at) < l.ttl
+}
+
+// markRenewed records that our lease of the account was written at now.
+func (l *leases) markRenewed(accountID int64, now time.Time) {
+	l.mu.Lock()
+	l.renewed[accountID] = now
+	l.mu.Unlock()
+}
+
+// lapsing reports whether our lease of the account may be seen as dead by
+// other instances before the next renewal: it was last renewed nearly ttl
+// ago. The renewals tick every ttl/3.
+func (l *leases) lapsing(accountID int64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at, ok := l.renewed[accountID]
+	return !ok || now.Sub(at) >= l.ttl-l.ttl/3
+}
+
+func leaseDoc(accountID int64) string {
+	return fmt.Sprintf("%s/%d", leaseDir, accountID)
+}
+
+// update runs fn on the current lease under the lock and saves the result
+// when fn returns true.
+func (l *leases) update(accountID int64, fn func(cur *Lease) bool) error {
+	doc := leaseDoc(accountID)
+	unlock, err := l.store.Lock(doc, l.ttl)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var cur Lease
+	if err := l.store.Load(doc, &cur); err != nil {
+		return err
+	}
+	if !fn(&cur) {
+		return nil
+	}
+	return l.store.Save(doc, cur)
+}
+
+// acquire takes or renews the lease with cfg unless another live instance
+// holds it; then the owner's lease is returned with ok=false.
+func (l *leases) acquire(cfg WorkerConfig) (owner Lease, ok bool, err error) {
+	now := time.Now()
+	err = l.update(cfg.AccountID, func(cur *Lease) bool {
+		if cur.Instance != l.instance && l.alive(*cur, now) {
+			owner = *cur
+			return false
+		}
+		*cur = Lease{AccountID: cfg.AccountID, Instance: l.instance, URL: l.url, Expires: now.Add(l.ttl), Config: cfg}
+		ok = true
+		return true
+	})
+	if err == nil && ok {
+		l.markRenewed(cfg.AccountID, now)
+	}
+	return owner, ok, err
+}
+
+// renew extends our lease; held is false when another instance took it.
+func (l *leases) renew(cfg WorkerConfig) (held bool, err error) {
+	now := time.Now()
+	err = l.update(cfg.AccountID, func(cur *Lease) bool {
+		if cur.Instance != l.instance {
+			return false
+		}
+		held = true
+		cur.Expires = now.Add(l.ttl)
+		cur.Config = cfg
+		return true
+	})
+	if err == nil && held {
+		l.markRenewed(cfg.AccountID, now)
+	}
+	return held, err
+}
+
+// release drops our lease, so other instances do not take the account over.
+func (l *leases) release(accountID int64) {
+	l.mu.Lock()
+	delete(l.renewed, accountID)
+	l.mu.Unlock()
+	doc := leaseDoc(accountID)
+	unlock, err := l.store.Lock(doc, l.ttl)
+	if err != nil {
+		log.Printf("[lease] release account=%d: %v", accountID, err)
+		return
+	}
+	defer unlock()
+	var cur Lease
+	if err := l.store.Load(doc, &cur); err != nil || cur.Instance != l.instance {
+		return
+	}
+	if err := l.store.Delete(doc); err != nil {
+		log.Printf("[lease] release account=%d: %v", accountID, err)
+	}
+}
+
+// get returns the lease of the account, if any.
+func (l *leases) get(accountID int64) (Lease, bool) {
+	var cur Lease
+	if err := l.store.Load(leaseDoc(accountID), &cur); err != nil || cur.Instance == "" {
+		return Lease{}, false
+	}
+	return cur, true
+}
+
+// EnableLeases lets several engine instances share one data dir: each
+// account runs on the instance holding its lease, reachable at url. Leases
+// live ttl (0 = 15s) unless renewed; accounts of a dead instance are taken
+// over by the others. Requires a store and a secret key for access tokens.
+func (m *Manager) EnableLeases(instance, url string, ttl time.Duration) error {
+	if m.store == nil {
+		return fmt.Errorf("leases need a data dir shared by the instances")
+	}
+	if instance == "" || url == "" {
+		return fmt.Errorf("leases need an instance id and url")
+	}
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	m.mu.Lock()
+	m.leases = &leases{store: m.store, instance: instance, url: url, ttl: ttl,
+		renewed: make(map[int64]time.Time), observed: make(map[int64]leaseChange)}
+	m.mu.Unlock()
+	return nil
+}
+
+// Instance returns the id of this engine instance ("" without leases).
+func (m *Manager) Instance() string {
+	if m.leases == nil {
+		return ""
+	}
+	return m.leases.instance
+}
+
+// AccountOwner returns the URL of another live instance running the
+// account; ok is false when the account is ours or has no owner.
+func (m *Manager) AccountOwner(accountID int64) (url string, ok bool) {
+	if m.leases == nil {
+		return "", false
+	}
+	l, found := m.leases.get(accountID)
+	if !found || l.Instance == m.leases.instance || !m.leases.alive(l, time.Now()) {
+		return "", false
+	}
+	return l.URL, true
+}
+
+// RunLeases renews the leases of local workers, stops workers whose lease
+// was taken over or could not be renewed in time, and takes over accounts of instances that stopped
+// renewing. It returns when ctx is done.
+func (m *Manager) RunLeases(ctx context.Context) {
+	if m.leases == nil {
+		return
+	}
+	m.takeOver()
+	t := time.NewTicker(m.leases.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.renewLeases()
+			m.takeOver()
+		}
+	}
+}
+
+func (m *Manager) renewLeases() {
+	for _, w := range m.snapshotWorkers() {
+		cfg := w.config()
+		held, err := m.leases.renew(cfg)
+		switch {
+		case err != nil && !m.leases.lapsing(cfg.AccountID, time.Now()):
+			// не смогли продлить — воркер работает дальше, повторим на следующем тике
+			log.Printf("[lease] renew account=%d: %v", cfg.AccountID, err)
+			continue
+		case err != nil:
+			// до следующего тика аренда может истечь, и аккаунт заберёт другой инстанс
+			log.Printf("[lease] renew account=%d: %v; lease is about to lapse, stopping worker", cfg.AccountID, err)
+		case held:
+			continue
+		default:
+			log.Printf("[lease] account=%d taken over by another instance, stopping worker", cfg.AccountID)
+		}
+		m.mu.Lock()
+		if m.workers[cfg.AccountID] == w {
+			w.Stop()
+			delete(m.workers, cfg.AccountID)
+			m.publishWorkers()
+		}
+		m.mu.Unlock()
+	}
+}
+
+// takeOver starts workers for accounts whose owner stopped renewing,
+// including our own leases whose worker was stopped when renewal failed.
+func (m *Manager) takeOver() {
+	names, err := m.store.List(leaseDir)
+	if err != nil {
+		log.Printf("[lease] list: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, name := range names {
+		var l Lease
+		if err := m.store.Load(name, &l); err != nil {
+			log.Printf("[lease] load %s: %v", name, err)
+			continue
+		}
+		if !l.Config.Active || !l.Config.AutoMode {
+			continue
+		}
+		if l.Instance == m.leases.instance {
+			if m.worker(l.AccountID) != nil {
+				continue
+			}
+		} else if m.leases.alive(l, now) {
+			continue
+		}
+		if _, ok, err := m.leases.acquire(l.Config); err != nil || !ok {
+			continue
+		}
+		log.Printf("[lease] take over account=%d from %s", l.AccountID, l.Instance)
+		m.mu.Lock()
+		if _, known := m.tenants[l.AccountID]; !known {
+			m.tenants[l.AccountID] = l.Config.TenantID
+		}
+		m.reloadLocked(l.Config)
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// LiveOrder is an order currently listed in the websocket feed.
+type LiveOrder struct {
+	ID           string    `json:"id"`
+	BrandName    string    `json:"brand_name"`
+	Provider     string    `json:"provider"`
+	InAmount     string    `json:"in_amount"`
+	InAsset      string    `json:"in_asset"`
+	OutValue     float64   `json:"out_value,omitempty"` // сумма в out_asset
+	OutAsset     string    `json:"out_asset"`
+	ExchangeRate string    `json:"exchange_rate"`
+	Boost        float64   `json:"boost"`
+	ExpiresAt    string    `json:"expires_at"`
+	ListedAt     time.Time `json:"listed_at"`
+}
+
+// liveList mirrors the worker's view of the websocket list, in list order,
+// for manual pickers. It is emptied while the socket is down so callers
+// never see stale orders.
+type liveList struct {
+	mu    sync.Mutex
+	items []LiveOrder
+}
+
+func newLiveOrder(p p2c.LivePayment, at time.Time) LiveOrder {
+	out, _ := outValue(p)
+	return LiveOrder{
+		ID:           p.ID,
+		BrandName:    p.BrandName,
+		Provider:     p.Provider,
+		InAmount:     p.InAmount,
+		InAsset:      p.InAsset,
+		OutValue:     out,
+		OutAsset:     p.OutAsset,
+		ExchangeRate: p.ExchangeRate,
+		Boost:        p.Boost,
+		ExpiresAt:    p.ExpiresAt,
+		ListedAt:     at,
+	}
+}
+
+func (l *liveList) load(list []p2c.LivePayment) {
+	now := time.Now()
+	items := make([]LiveOrder, 0, len(list))
+	for _, p := range list {
+		items = append(items, newLiveOrder(p, now))
+	}
+	l.mu.Lock()
+	l.items = items
+	l.mu.Unlock()
+}
+
+func (l *liveList) add(p p2c.LivePayment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeLocked(p.ID)
+	pos := min(max(p.Position, 0), len(l.items))
+	l.items = append(l.items, LiveOrder{})
+	copy(l.items[pos+1:], l.items[pos:])
+	l.items[pos] = newLiveOrder(p, time.Now())
+}
+
+func (l *liveList) remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeLocked(id)
+}
+
+func (l *liveList) removeLocked(id string) {
+	for i := range l.items {
+		if l.items[i].ID == id {
+			l.items = append(l.items[:i], l.items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *liveList) clear() {
+	l.mu.Lock()
+	l.items = nil
+	l.mu.Unlock()
+}
+
+func (l *liveList) list() []LiveOrder {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]LiveOrder, len(l.items))
+	copy(out, l.items)
+	return out
+}
+
+// LiveOrders returns the orders currently listed in the websocket feed and
+// whether the socket is connected.
+func (w *Worker) LiveOrders() ([]LiveOrder, bool) {
+	return w.live.list(), w.wsDownSince.Load() == 0
+}
+
+// LiveOrders returns the account's current view of the websocket list.
+func (m *Manager) LiveOrders(accountID int64) ([]LiveOrder, bool, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, false, ErrNoWorker
+	}
+	orders, connected := w.LiveOrders()
+	return orders, connected, nil
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/store"
+)
+
+const maintenanceDoc = "maintenance"
+
+// Maintenance is the engine-wide pause of auto-takes, e.g. for planned bank
+// downtime: workers keep their websockets and journals, but take nothing
+// until it ends.
+type Maintenance struct {
+	On     bool      `json:"on"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Until  time.Time `json:"until,omitempty"` // пусто — до ручного снятия
+}
+
+// maintenance holds the mode; it is persisted, so a restart during the
+// downtime does not resume taking.
+type maintenance struct {
+	on atomic.Bool // читается на горячем пути каждой заявки
+
+	mu       sync.Mutex
+	state    Maintenance
+	timer    *time.Timer
+	store    *store.Store
+	announce func(text string)
+}
+
+func newMaintenance(st *store.Store, announce func(text string)) *maintenance {
+	mt := &maintenance{store: st, announce: announce}
+	var saved Maintenance
+	if err := st.Load(maintenanceDoc, &saved); err != nil {
+		log.Printf("[maintenance] load error: %v", err)
+	}
+	if saved.On {
+		if !saved.Until.IsZero() && !time.Now().Before(saved.Until) {
+			// окно кончилось, пока движок лежал
+			mt.saveLocked()
+			return mt
+		}
+		log.Printf("[maintenance] restored: %s", saved.Reason)
+		mt.state = saved
+		mt.on.Store(true)
+		mt.scheduleLocked()
+	}
+	return mt
+}
+
+// active reports whether auto-takes are paused engine-wide.
+func (mt *maintenance) active() bool {
+	return mt != nil && mt.on.Load()
+}
+
+func (mt *maintenance) get() Maintenance {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return mt.state
+}
+
+// start turns the mode on, or moves its end if it is on already; d == 0
+// keeps it on until end is called.
+func (mt *maintenance) start(reason string, d time.Duration) Maintenance {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	now := time.Now()
+	if !mt.state.On {
+		mt.state = Maintenance{On: true, Since: now}
+	}
+	mt.state.Reason = reason
+	mt.state.Until = time.Time{}
+	if d > 0 {
+		mt.state.Until = now.Add(d)
+	}
+	mt.on.Store(true)
+	mt.scheduleLocked()
+	mt.saveLocked()
+	log.Printf("[maintenance] on until %v: %s", mt.state.Until, reason)
+	until := "—"
+	if !mt.state.Until.IsZero() {
+		until = mt.state.Until.Local().Format("02.01 15:04")
+	}
+	mt.announce(i18n.T("", "maintenance.on", reason, until))
+	return mt.state
+}
+
+// end turns the mode off; ok is false if it was not on.
+func (mt *maintenance) end() (Maintenance, bool) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if !mt.state.On {
+		return mt.state, false
+	}
+	was := mt.state
+	mt.state = Maintenance{}
+	mt.on.Store(false)
+	if mt.timer != nil {
+		mt.timer.Stop()
+		mt.timer = nil
+	}
+	mt.saveLocked()
+	log.Printf("[maintenance] off after %s", time.Since(was.Since).Round(time.Second))
+	mt.announce(i18n.T("", "maintenance.off", time.Since(was.Since).Round(time.Minute)))
+	return was, true
+}
+
+// scheduleLocked arms the auto-resume timer; mt.mu must be held.
+func (mt *maintenance) scheduleLocked() {
+	if mt.timer != nil {
+		mt.timer.Stop()
+		mt.timer = nil
+	}
+	if mt.state.Until.IsZero() {
+		return
+	}
+	until := mt.state.Until
+	mt.timer = time.AfterFunc(time.Until(until), func() {
+		// ручной start мог сдвинуть конец, пока таймер срабатывал
+		if cur := mt.get(); cur.On && cur.Until.Equal(until) {
+			mt.end()
+		}
+	})
+}
+
+func (mt *maintenance) saveLocked() {
+	if err := mt.store.Save(maintenanceDoc, mt.state); err != nil {
+		log.Printf("[store] save %s error: %v", maintenanceDoc, err)
+	}
+}
+
+// SetOpsChat sets the Telegram chat for engine-wide announcements such as
+// maintenance mode.
+func (m *Manager) SetOpsChat(chatID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opsChat = chatID
+}
+
+// announce sends an engine-wide message to the ops chat, if one is set.
+func (m *Manager) announce(text string) {
+	m.mu.Lock()
+	chatID := m.opsChat
+	m.mu.Unlock()
+	if chatID == 0 || m.botToken == "" {
+		return
+	}
+	telegramSender(m.botToken).enqueue(textMessage(chatID, text, nil))
+}
+
+// StartMaintenance pauses auto-takes of every account, keeping websockets
+// alive; with d > 0 they resume by themselves after d.
+func (m *Manager) StartMaintenance(reason string, d time.Duration) Maintenance {
+	return m.maint.start(reason, d)
+}
+
+// EndMaintenance resumes auto-takes; ok is false if maintenance was off.
+func (m *Manager) EndMaintenance() (Maintenance, bool) {
+	return m.maint.end()
+}
+
+// Maintenance returns the current maintenance mode.
+func (m *Manager) Maintenance() Maintenance {
+	return m.maint.get()
+}
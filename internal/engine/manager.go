@@ -2,55 +2,382 @@ package engine
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"p2c-engine/internal/engine/cache"
+	"p2c-engine/internal/i18n"
 	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/redis"
+	"p2c-engine/internal/store"
 )
 
 // Manager orchestrates account workers.
 type Manager struct {
 	mu      sync.Mutex
 	workers map[int64]*Worker
-	client  *p2c.Client
+	client  *p2c.Client // общие настройки P2C: адрес, таймауты, пробер, зеркала
+	api     func(cfg WorkerConfig) (p2c.API, error) // P2C аккаунта вместо клиента, для тестов
 	botToken string
+	web     *WebLinks
+	arbiter *arbiter
+	fleet   atomic.Pointer[[]*Worker] // lock-free copy of workers for hot-path readers
+	tenants map[int64]string          // account -> tenant, kept after the worker stops
+	store   *store.Store
+	penalties *PenaltyManager
+	seen    map[string]*cache.TTL // заявки, уже взятые аккаунтами тенанта (общий dedup), nil — выключен
+	templates *Templates
+	leases  *leases // nil — один инстанс, аккаунты не делятся
+	redis   *redis.Client // общее с репликами состояние, nil — только локальное
+	registry *activeRegistry
+	alerts  *alerting // правила алертов в ops-чат, nil — выключены
+	jobs    *JobQueue // отложенные действия, переживают рестарт
+	groups  *ownerGroups
+	rr      *rrBook // группы стратегии round-robin по флотам
+	topics  *topicBook
+	sandbox sandboxConfig
+	captureDir string // запись кадров websocket всех аккаунтов, пусто — выключена
+	rates   RateSource // рыночные курсы для маржи и фильтра market_edge_pct, nil — не заданы
+	maint   *maintenance // общая пауза авто-взятия на время работ банков
+	handoffs *handoffs   // заявки, отпущенные одним аккаунтом для другого
+	blackouts *blackouts // календарь окон без авто-взятия: праздники, работы банков
+	outbox  *outbox      // недоставленные уведомления о взятых заявках
+	brands  *brandBook   // исходы заявок по брендам всех аккаунтов
+	ocr     OCR          // распознавание чеков, nil — сверяем только текст
+	opsChat int64        // чат объявлений движка, 0 — не объявлять
+	noIntake bool        // остановка: новые взятия запрещены, см. StopIntake
 }
 
-func NewManager(client *p2c.Client, botToken string) *Manager {
-	return &Manager{
+// NewManager creates a manager; st may be nil to keep state in memory only.
+func NewManager(client *p2c.Client, botToken string, st *store.Store) *Manager {
+	jobs := NewJobQueue(st)
+	m := &Manager{
 		workers: make(map[int64]*Worker),
 		client:  client,
 		botToken: botToken,
+		tenants: make(map[int64]string),
+		store:   st,
+		penalties: NewPenaltyManager(st, jobs),
+		jobs:    jobs,
+	}
+	m.groups = newOwnerGroups(m.snapshotWorkers)
+	m.rr = newRRBook(m.snapshotWorkers)
+	m.topics = newTopicBook(st)
+	m.maint = newMaintenance(st, m.announce)
+	m.handoffs = newHandoffs(m.snapshotWorkers)
+	m.blackouts = newBlackouts(st)
+	m.outbox = newOutbox(st, botToken)
+	m.brands = newBrandBook(st)
+	m.loadTenants()
+	m.loadTLSSessions()
+	m.penalties.OnResume(func(rec PenaltyRecord) {
+		if w := m.worker(rec.AccountID); w != nil {
+			w.onPenaltyEnd(rec)
+		}
+	})
+	if mirrors := client.Mirrors(); mirrors != nil {
+		mirrors.OnFailover(m.onFailover)
+	}
+	m.registerJobs()
+	m.jobs.Start()
+	return m
+}
+
+// onFailover tells every account chat that P2C moved to another mirror.
+func (m *Manager) onFailover(from, to, reason string) {
+	now := time.Now()
+	notified := make(map[int64]bool)
+	for _, w := range m.snapshotWorkers() {
+		cfg := w.config()
+		w.events.publish(Event{Type: EventFailover, AccountID: cfg.AccountID, At: now, Reason: fmt.Sprintf("%s -> %s: %s", from, to, reason)})
+		if cfg.ChatID == 0 || notified[cfg.ChatID] {
+			continue
+		}
+		notified[cfg.ChatID] = true
+		w.notify(i18n.T(cfg.Locale, "p2c.failover", reason, from, to))
+	}
+}
+
+// worker returns the running worker of the account or nil.
+func (m *Manager) worker(accountID int64) *Worker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.workers[accountID]
+}
+
+// Penalties returns the active penalty (if any) and history of the account.
+func (m *Manager) Penalties(accountID int64) (*PenaltyRecord, []PenaltyRecord) {
+	var active *PenaltyRecord
+	if rec, ok := m.penalties.Blocked(accountID, time.Now()); ok {
+		active = &rec
+	}
+	return active, m.penalties.History(accountID)
+}
+
+// ClearPenalty lifts the account penalty ahead of time.
+func (m *Manager) ClearPenalty(accountID int64) bool {
+	return m.penalties.Clear(accountID)
+}
+
+// ResetCursor drops the persisted polling cursor of the account, so the
+// polling fallback starts again from the newest payments.
+func (m *Manager) ResetCursor(accountID int64) (string, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return "", ErrNoWorker
+	}
+	old := w.cursor.reset()
+	w.logf("polling cursor reset (was %q)", old)
+	return old, nil
+}
+
+// ErrForeignAccount is returned when a tenant touches another tenant's account.
+var ErrForeignAccount = errors.New("account belongs to another tenant")
+
+// ClaimAccount binds the account to the tenant unless another tenant owns it.
+func (m *Manager) ClaimAccount(tenant string, accountID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	owner, ok := m.tenants[accountID]
+	if !ok && m.leases != nil {
+		// аккаунт мог быть заведён через другой инстанс
+		if l, found := m.leases.get(accountID); found {
+			owner, ok = l.Config.TenantID, true
+		}
+	}
+	if ok && owner != tenant {
+		return ErrForeignAccount
+	}
+	if _, known := m.tenants[accountID]; !known {
+		m.tenants[accountID] = tenant
+		m.saveTenantsLocked()
+	}
+	return nil
+}
+
+// AccountTenant returns the tenant owning the account ("" in single-tenant
+// mode). An account running on another instance is looked up in its lease.
+func (m *Manager) AccountTenant(accountID int64) string {
+	m.mu.Lock()
+	tenant, ok := m.tenants[accountID]
+	m.mu.Unlock()
+	if !ok && m.leases != nil {
+		if l, found := m.leases.get(accountID); found {
+			return l.Config.TenantID
+		}
+	}
+	return tenant
+}
+
+// AccountLocale returns the locale of the account's operator texts.
+func (m *Manager) AccountLocale(accountID int64) string {
+	if w := m.worker(accountID); w != nil {
+		return i18n.Normalize(w.config().Locale)
+	}
+	return i18n.Default
+}
+
+// EnableArbitration makes workers agree on a single account per live payment
+// instead of racing each other for it.
+func (m *Manager) EnableArbitration() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.arbiter = newArbiter(m.snapshotWorkers)
+	for _, w := range m.workers {
+		w.arbiter = m.arbiter
+	}
+}
+
+// SetTemplates sets notification templates for workers started afterwards.
+func (m *Manager) SetTemplates(t *Templates) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.templates = t
+}
+
+// ReloadTemplates re-reads the template overrides the workers render with.
+func (m *Manager) ReloadTemplates() error {
+	m.mu.Lock()
+	t := m.templates
+	m.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	return t.Reload()
+}
+
+// EnableSharedDedup makes the workers of each tenant share one set of
+// claimed payments, so a payment delivered to several accounts of the
+// marketplace is taken only by the first worker of the tenant whose filters
+// accept it; an account that filters it out leaves it to the others. It
+// conflicts with arbitration, which picks the account itself, and is ignored
+// when arbitration is enabled.
+func (m *Manager) EnableSharedDedup() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.arbiter != nil {
+		log.Printf("[mgr] shared dedup ignored: arbitration is enabled")
+		return
+	}
+	m.seen = make(map[string]*cache.TTL)
+	for _, w := range m.workers {
+		w.claims = m.claimsLocked(w.config())
 	}
 }
 
+// snapshotWorkers returns the current workers without taking m.mu, so it is
+// safe to call from worker goroutines while the manager stops a worker.
+func (m *Manager) snapshotWorkers() []*Worker {
+	if ws := m.fleet.Load(); ws != nil {
+		return *ws
+	}
+	return nil
+}
+
+// publishWorkers refreshes the lock-free worker snapshot; m.mu must be held.
+func (m *Manager) publishWorkers() {
+	out := make([]*Worker, 0, len(m.workers))
+	for _, w := range m.workers {
+		out = append(out, w)
+	}
+	m.fleet.Store(&out)
+}
+
 // ReloadAccount ensures a worker exists and restarts it with fresh settings.
+// With leases the worker starts only if this instance gets the lease.
 func (m *Manager) ReloadAccount(cfg WorkerConfig) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.saveConfig(cfg)
+	if m.leases != nil {
+		if !cfg.Active || !cfg.AutoMode {
+			m.leases.release(cfg.AccountID)
+		} else if owner, ok, err := m.leases.acquire(cfg); err != nil {
+			log.Printf("[mgr] reload account=%d: lease: %v", cfg.AccountID, err)
+			return
+		} else if !ok {
+			log.Printf("[mgr] reload account=%d ignored: leased by %s", cfg.AccountID, owner.Instance)
+			return
+		}
+	}
+	m.reloadLocked(cfg)
+}
 
+// reloadLocked starts, updates or stops the local worker; m.mu must be held.
+func (m *Manager) reloadLocked(cfg WorkerConfig) {
 	// Если выключен аккаунт или авто-режим, гасим воркер и выходим.
 	if !cfg.Active || !cfg.AutoMode {
 		if w, ok := m.workers[cfg.AccountID]; ok {
 			log.Printf("[mgr] stop account=%d active=%v auto=%v", cfg.AccountID, cfg.Active, cfg.AutoMode)
 			w.Stop()
 			delete(m.workers, cfg.AccountID)
+			m.publishWorkers()
 		}
 		return
 	}
 
-	// Перезапускаем с новыми настройками.
 	if w, ok := m.workers[cfg.AccountID]; ok {
+		// Фильтры меняем на лету, без потери websocket и seen-набора.
+		if !needsRestart(w.config(), cfg) {
+			if w.applyConfig(cfg) {
+				log.Printf("[mgr] update account=%d in place min=%.2f max=%.2f chat=%d", cfg.AccountID, deref(cfg.MinAmount), deref(cfg.MaxAmount), cfg.ChatID)
+			} else {
+				log.Printf("[mgr] reload account=%d: no changes", cfg.AccountID)
+			}
+			return
+		}
+		// Перезапускаем с новыми настройками.
 		w.Stop()
 	}
 
-	client := p2c.NewClient(m.client.BaseURL(), cfg.AccessToken)
-	w := NewWorker(cfg, client, m.botToken)
+	client, err := m.accountAPI(cfg)
+	if err != nil {
+		log.Printf("[mgr] reload account=%d: %v", cfg.AccountID, err)
+		delete(m.workers, cfg.AccountID)
+		m.publishWorkers()
+		return
+	}
+	w := NewWorker(cfg, client, m.botToken, m.rr)
+	w.sandboxChat = m.sandbox.chatID
+	w.captureDir = m.captureDir
+	w.journal.attach(m.store, cfg.AccountID)
+	// соответствие hex ↔ numeric хранится в записях журнала
+	w.ids.seed(w.journal.refs())
+	w.stats.attach(m.store, cfg.AccountID)
+	w.cursor.attach(m.store, cfg.AccountID)
+	w.tuner.attach(m.store, cfg.AccountID)
+	w.web = m.web
+	w.arbiter = m.arbiter
+	w.groups = m.groups
+	w.topics = m.topics
+	w.penalties = m.penalties
+	w.jobs = m.jobs
+	w.templates = m.templates
+	w.rates = m.rates
+	w.maint = m.maint
+	w.handoffs = m.handoffs
+	w.blackouts = m.blackouts
+	w.outbox = m.outbox
+	w.brands = m.brands
+	if seen := m.seenLocked(cfg); seen != nil {
+		w.seen = seen
+	}
+	w.claims = m.claimsLocked(cfg)
+	if m.noIntake {
+		w.inflight.stopIntake()
+	}
+	if m.registry != nil {
+		w.setRegistry(m.registry)
+	}
 	m.workers[cfg.AccountID] = w
+	m.publishWorkers()
 	log.Printf("[mgr] reload account=%d active=%v auto=%v min=%.2f max=%.2f chat=%d", cfg.AccountID, cfg.Active, cfg.AutoMode, deref(cfg.MinAmount), deref(cfg.MaxAmount), cfg.ChatID)
 	w.Start()
 }
 
+// UseAPI makes workers started from now on talk to the P2C API returned by
+// f instead of a client of the engine, e.g. a p2ctest.API in tests.
+func (m *Manager) UseAPI(f func(cfg WorkerConfig) (p2c.API, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.api = f
+}
+
+// accountAPI returns the P2C API of the account's worker.
+func (m *Manager) accountAPI(cfg WorkerConfig) (p2c.API, error) {
+	switch {
+	case m.api != nil:
+		return m.api(cfg)
+	case cfg.sandbox():
+		client, err := m.sandboxClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	return m.newClient(cfg.AccessToken.Reveal(), cfg.Transport), nil
+}
+
+// newClient builds a per-account P2C client sharing the engine-wide edge
+// prober; transport overrides the engine-wide timeouts field by field.
+func (m *Manager) newClient(accessToken string, transport p2c.TransportConfig) *p2c.Client {
+	client := p2c.NewClientWith(m.client.BaseURL(), accessToken, m.client.Transport().Merge(transport))
+	client.UseProber(m.client.Prober())
+	client.UseMirrors(m.client.Mirrors())
+	client.UseTLSSessions(m.client.TLSSessions())
+	client.UseResolver(m.client.Resolver())
+	client.UseClock(m.client.Clock())
+	if m.client.HTTP3() {
+		_ = client.UseHTTP3()
+	}
+	return client
+}
+
 func deref(v *float64) float64 {
 	if v == nil {
 		return 0
@@ -58,56 +385,222 @@ func deref(v *float64) float64 {
 	return *v
 }
 
-// StopAll stops all workers.
-func (m *Manager) StopAll() {
+// Status describes the engine state for the status API.
+type Status struct {
+	Workers []WorkerStatus     `json:"workers"`
+	Edge    *p2c.ProbeSnapshot `json:"edge,omitempty"`
+	Mirrors *p2c.MirrorSnapshot `json:"mirrors,omitempty"`
+	TLS     *p2c.TLSStats       `json:"tls,omitempty"` // доля возобновлённых TLS-сессий
+	DNS     *p2c.ResolverSnapshot `json:"dns,omitempty"`
+	Clock   *p2c.ClockSnapshot  `json:"clock,omitempty"` // расхождение с часами P2C
+	Instance string            `json:"instance,omitempty"` // инстанс движка при работе с арендой аккаунтов
+	Alerts  []Alert            `json:"alerts,omitempty"`   // сработавшие правила алертов
+	Maintenance *Maintenance   `json:"maintenance,omitempty"` // включён режим работ
+}
+
+// Status returns a snapshot of the tenant's workers and the edge prober.
+// The default tenant "" sees only its own accounts, which in single-tenant
+// mode are all of them.
+func (m *Manager) Status(tenant string) Status {
+	workers := m.snapshotWorkers()
+	st := Status{Workers: make([]WorkerStatus, 0, len(workers)), Instance: m.Instance()}
+	if mt := m.maint.get(); mt.On {
+		st.Maintenance = &mt
+	}
+	for _, w := range workers {
+		if w.config().TenantID != tenant {
+			continue
+		}
+		st.Workers = append(st.Workers, w.Status())
+	}
+	sort.Slice(st.Workers, func(i, j int) bool { return st.Workers[i].AccountID < st.Workers[j].AccountID })
+	m.mu.Lock()
+	alerts := m.alerts
+	m.mu.Unlock()
+	if alerts != nil {
+		ids := make(map[int64]bool, len(st.Workers))
+		for _, ws := range st.Workers {
+			ids[ws.AccountID] = true
+		}
+		st.Alerts = alerts.active(ids)
+	}
+	if prober := m.client.Prober(); prober != nil {
+		snap := prober.Snapshot()
+		st.Edge = &snap
+	}
+	if mirrors := m.client.Mirrors(); mirrors != nil {
+		snap := mirrors.Snapshot()
+		st.Mirrors = &snap
+	}
+	if resolver := m.client.Resolver(); resolver != nil {
+		snap := resolver.Snapshot()
+		st.DNS = &snap
+	}
+	if sessions := m.client.TLSSessions(); sessions != nil {
+		tls := sessions.Stats()
+		st.TLS = &tls
+	}
+	if clock := m.client.Clock().Snapshot(); clock.Samples > 0 {
+		st.Clock = &clock
+	}
+	return st
+}
+
+// SetWebLinks enables signed links from Telegram cards to the web view.
+func (m *Manager) SetWebLinks(l *WebLinks) {
+	m.mu.Lock()
+	m.web = l
+	m.mu.Unlock()
+}
+
+// WebLinks returns the link signer, nil if the web view is disabled.
+func (m *Manager) WebLinks() *WebLinks {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.web
+}
 
-	for id, w := range m.workers {
-		log.Printf("stopping worker for account %d", id)
-		w.Stop()
+// SetPaused pauses or resumes auto-take keeping the worker running.
+func (m *Manager) SetPaused(accountID int64, paused bool) bool {
+	m.mu.Lock()
+	w, ok := m.workers[accountID]
+	m.mu.Unlock()
+	if !ok {
+		return false
 	}
+	if w.paused.Swap(paused) != paused {
+		log.Printf("[mgr] account=%d paused=%v", accountID, paused)
+	}
+	// resume оператора заодно закрывает сработавший breaker
+	if !paused && w.breaker.reset() {
+		log.Printf("[mgr] account=%d circuit breaker reset", accountID)
+	}
+	return true
 }
 
-// TakeOrder delegates order taking to the worker (stubbed).
-func (m *Manager) TakeOrder(ctx context.Context, accountID int64, externalID string) error {
+// ErrNoWorker is returned for operations on an account without a running worker.
+var ErrNoWorker = errors.New("worker not running")
+
+// UpdateConfig edits filter settings of a running worker in place. Changes
+// that need a new session (token, auto mode) must go through ReloadAccount.
+func (m *Manager) UpdateConfig(accountID int64, edit func(cfg *WorkerConfig)) error {
 	m.mu.Lock()
+	defer m.mu.Unlock()
 	w, ok := m.workers[accountID]
-	m.mu.Unlock()
 	if !ok {
-		// If worker is absent, start it lazily.
-		m.ReloadAccount(WorkerConfig{AccountID: accountID})
-		m.mu.Lock()
-		w = m.workers[accountID]
-		m.mu.Unlock()
+		return ErrNoWorker
 	}
-	return w.TakeOrder(ctx, externalID)
+	old := w.config()
+	cfg := old
+	edit(&cfg)
+	if needsRestart(old, cfg) {
+		return fmt.Errorf("change requires reload")
+	}
+	w.applyConfig(cfg)
+	m.saveConfig(cfg)
+	return nil
+}
+
+// WSTrace returns the recent websocket frames of the account's worker.
+func (m *Manager) WSTrace(accountID int64, limit int) ([]TraceFrame, bool) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, false
+	}
+	return w.WSTrace(limit), true
 }
 
-// CompletePayment delegates completion to worker.
-func (m *Manager) CompletePayment(ctx context.Context, accountID int64, paymentID string) error {
+// Stats returns the account's statistics for a period (today, yesterday, 7d,
+// 30d, YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD).
+func (m *Manager) Stats(accountID int64, period string) (StatsReport, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return StatsReport{}, ErrNoWorker
+	}
+	return w.Stats(period)
+}
+
+// Subscribe streams events of the account's worker; ok is false if it is not running.
+func (m *Manager) Subscribe(accountID int64) (<-chan Event, func(), bool) {
 	m.mu.Lock()
 	w, ok := m.workers[accountID]
 	m.mu.Unlock()
 	if !ok {
-		m.ReloadAccount(WorkerConfig{AccountID: accountID})
-		m.mu.Lock()
-		w = m.workers[accountID]
-		m.mu.Unlock()
+		return nil, nil, false
 	}
-	return w.CompletePayment(ctx, paymentID)
+	ch, cancel := w.Subscribe()
+	return ch, cancel, true
 }
 
-// CancelPayment delegates cancel to worker.
-func (m *Manager) CancelPayment(ctx context.Context, accountID int64, paymentID string) error {
+// Payment returns the journal record of a payment taken by the account.
+func (m *Manager) Payment(accountID int64, paymentID string) (PaymentRecord, bool) {
 	m.mu.Lock()
 	w, ok := m.workers[accountID]
 	m.mu.Unlock()
 	if !ok {
-		m.ReloadAccount(WorkerConfig{AccountID: accountID})
-		m.mu.Lock()
-		w = m.workers[accountID]
-		m.mu.Unlock()
+		return PaymentRecord{}, false
+	}
+	return w.Payment(paymentID)
+}
+
+// Payments returns the account's journal, newest first; limit <= 0 returns all.
+func (m *Manager) Payments(accountID int64, limit int) ([]PaymentRecord, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, ErrNoWorker
+	}
+	recs := w.journal.list()
+	if limit > 0 && limit < len(recs) {
+		recs = recs[:limit]
+	}
+	return recs, nil
+}
+
+// RestartAccount restarts the worker with its current config, e.g. to get a
+// fresh websocket session.
+func (m *Manager) RestartAccount(accountID int64) error {
+	w := m.worker(accountID)
+	if w == nil {
+		return ErrNoWorker
+	}
+	m.ReloadAccount(w.config())
+	return nil
+}
+
+// StopAll stops all workers.
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, w := range m.workers {
+		log.Printf("stopping worker for account %d", id)
+		w.Stop()
+	}
+}
+
+// TakeOrder takes a payment by id on the account's worker.
+func (m *Manager) TakeOrder(ctx context.Context, accountID int64, externalID string) (TakeResult, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return TakeResult{}, ErrNoWorker
+	}
+	return w.TakeOrder(ctx, externalID)
+}
+
+// CompletePayment delegates completion to worker. paymentID may be hex or numeric.
+func (m *Manager) CompletePayment(ctx context.Context, accountID int64, paymentID string) (PaymentRef, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return PaymentRef{}, ErrNoWorker
+	}
+	return w.CompletePayment(ctx, paymentID)
+}
+
+// CancelPayment delegates cancel to worker. paymentID may be hex or numeric.
+func (m *Manager) CancelPayment(ctx context.Context, accountID int64, paymentID string) (PaymentRef, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return PaymentRef{}, ErrNoWorker
 	}
 	return w.CancelPayment(ctx, paymentID)
 }
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"strconv"
+
+	"p2c-engine/internal/p2c"
+)
+
+// checkMarket takes a payment only when its exchange rate beats the
+// reference market price by MarketEdgePct: in_amount/rate buys that much
+// more out_asset than the market would. Without a known market price the
+// payment is skipped rather than taken blind.
+func checkMarket(cfg WorkerConfig, p p2c.LivePayment, rates RateSource) Decision {
+	if cfg.MarketEdgePct == 0 {
+		return take()
+	}
+	rate, err := strconv.ParseFloat(p.ExchangeRate, 64)
+	if err != nil || rate <= 0 {
+		return skip(SkipMarket, "bad rate %q", p.ExchangeRate)
+	}
+	var market float64
+	if rates != nil {
+		market, _ = rates.Rate(p.InAsset, p.OutAsset)
+	}
+	if market <= 0 {
+		return skip(SkipMarket, "no market rate for %s/%s", p.OutAsset, p.InAsset)
+	}
+	if edge := (market/rate - 1) * 100; edge < cfg.MarketEdgePct {
+		return skip(SkipMarket, "rate %.4f vs market %.4f: edge %.2f%% < %.2f%%", rate, market, edge, cfg.MarketEdgePct)
+	}
+	return take()
+}
@@ -1,13 +1,10 @@
 package engine
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
-	"strings"
 
+	"p2c-engine/internal/i18n"
 	"p2c-engine/internal/p2c"
 )
 
@@ -24,127 +21,64 @@ func formatAmountWei(val string) float64 {
 	return f / 1e18
 }
 
-func buildMessage(p p2c.Payment, success bool, errText string) string {
-	outAmount := formatAmountWei(p.Amount)
-	reward := formatAmountWei(p.RewardAmount)
-	idStr := p.IDString()
-
-	var sb strings.Builder
-	if success {
-		sb.WriteString("🤖 Заявка взята автоматически ✅\n")
-	} else {
-		sb.WriteString("⚠️ Не удалось взять заявку\n")
-	}
-
-	sb.WriteString(fmt.Sprintf("Бренд: %s\n", p.BrandName))
-	sb.WriteString(fmt.Sprintf("Сумма: %s %s\n", p.AmountFiat, p.Fiat))
-	sb.WriteString(fmt.Sprintf("Получает: %.6f %s\n", outAmount, p.Asset))
-	sb.WriteString(fmt.Sprintf("Курс: %s\n", p.ExchangeRate))
-	sb.WriteString(fmt.Sprintf("Вознаграждение: %.6f %s\n", reward, p.Asset))
-	if p.URL != "" {
-		sb.WriteString(fmt.Sprintf("QR: %s\n", p.URL))
-	}
-	sb.WriteString(fmt.Sprintf("ID: %s\n", idStr))
-	if !success && errText != "" {
-		sb.WriteString(fmt.Sprintf("Ошибка: %s\n", errText))
-	}
-	return sb.String()
-}
-
-func sendMessage(botToken string, chatID int64, text string) error {
-	body := map[string]any{
-		"chat_id":    chatID,
-		"text":       text,
-		"parse_mode": "HTML",
-	}
-	data, _ := json.Marshal(body)
-	resp, err := http.Post(
-		fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", botToken),
-		"application/json",
-		bytes.NewReader(data),
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("telegram status %d", resp.StatusCode)
-	}
-	return nil
-}
-
-// sendPhoto sends a photo by URL with caption and optional reply_markup.
-func sendPhoto(botToken string, chatID int64, photoURL, caption string, markup map[string]any) error {
-	body := map[string]any{
-		"chat_id": chatID,
-		"photo":   photoURL,
-	}
-	if caption != "" {
-		body["caption"] = caption
-		body["parse_mode"] = "HTML"
-	}
-	if markup != nil {
-		body["reply_markup"] = markup
-	}
-	data, _ := json.Marshal(body)
-	resp, err := http.Post(
-		fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto", botToken),
-		"application/json",
-		bytes.NewReader(data),
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("telegram status %d", resp.StatusCode)
-	}
-	return nil
-}
+// render executes a notification template by name.
+type renderFunc func(name string, data any) string
 
 // buildLiveCaption formats live payment info with status text.
-func buildLiveCaption(p p2c.LivePayment, status string) string {
-	var sb strings.Builder
-	if status != "" {
-		sb.WriteString(status + "\n")
+func buildLiveCaption(render renderFunc, acc AccountInfo, p p2c.LivePayment, ref PaymentRef, status string) string {
+	if ref.Hex == "" {
+		ref.Hex = p.ID
 	}
-	sb.WriteString(fmt.Sprintf("ID: %s\n", p.ID))
-	reward := formatAmountWei(p.FeeAmount)
 	outAsset := p.OutAsset
 	if outAsset == "" {
 		outAsset = "USDT"
 	}
-
-	sb.WriteString(fmt.Sprintf("Бренд: %s\n", p.BrandName))
-	sb.WriteString(fmt.Sprintf("Сумма: %s %s\n", p.InAmount, p.InAsset))
-	sb.WriteString(fmt.Sprintf("Курс: %s\n", p.ExchangeRate))
-	sb.WriteString(fmt.Sprintf("Вознаграждение: %.4f %s\n", reward, outAsset))
-	return sb.String()
+	return render(tmplLiveTaken, liveTakenData{
+		Account:  acc,
+		Status:   status,
+		Ref:      ref,
+		P:        p,
+		Reward:   formatAmountWei(p.FeeAmount),
+		OutAsset: outAsset,
+		Pay:      payInstructions(p),
+	})
 }
 
 // buildPaidKeyboard builds inline keyboard with callback payload carrying account/payment and amounts.
-func buildPaidKeyboard(accID int64, p p2c.LivePayment) map[string]any {
+// webURL, if set, adds a button opening the payment page in the engine web view.
+// With oneTap the paid button confirms right away instead of asking first.
+func buildPaidKeyboard(locale string, accID int64, p p2c.LivePayment, webURL string, oneTap bool) map[string]any {
 	if p.ID == "" || accID == 0 {
 		return nil
 	}
-	// payload: paid:<acc>:<payID>:<amount>:<rate>:<fee>
+	// payload: paid:<acc>:<payID>:<amount>:<rate>:<fee>; paid_ok: — без шага «Точно?»
+	prefix := "paid"
+	if oneTap {
+		prefix = "paid_ok"
+	}
 	paidPayload := fmt.Sprintf(
-		"paid:%d:%s:%s:%s:%s",
-		accID, p.ID, p.InAmount, p.ExchangeRate, p.FeeAmount,
+		"%s:%d:%s:%s:%s:%s",
+		prefix, accID, p.ID, p.InAmount, p.ExchangeRate, p.FeeAmount,
 	)
 	cancelPayload := fmt.Sprintf("cancel:%d:%s", accID, p.ID)
-	return map[string]any{
-		"inline_keyboard": [][]map[string]string{
+	rows := [][]map[string]string{
+		{
 			{
-				{
-					"text":         "✅ Я оплатил",
-					"callback_data": paidPayload,
-				},
-				{
-					"text":         "❌ Отменить",
-					"callback_data": cancelPayload,
-				},
+				"text":         i18n.T(locale, "button.paid"),
+				"callback_data": paidPayload,
+			},
+			{
+				"text":         i18n.T(locale, "button.cancel"),
+				"callback_data": cancelPayload,
 			},
 		},
 	}
+	if sbp := payInstructions(p).SBP; sbp != "" {
+		// https-ссылку СБП телефон сам предложит открыть в приложении банка
+		rows = append(rows, []map[string]string{{"text": i18n.T(locale, "button.bank"), "url": sbp}})
+	}
+	if webURL != "" {
+		rows = append(rows, []map[string]string{{"text": i18n.T(locale, "button.web"), "url": webURL}})
+	}
+	return map[string]any{"inline_keyboard": rows}
 }
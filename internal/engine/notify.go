@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Notification is a message to the people behind an account. Text is
+// Telegram HTML; other channels convert it to their markup. Markup is a
+// Telegram reply_markup: channels without callbacks keep only its URL
+// buttons, as links.
+type Notification struct {
+	Text   string
+	Photo  []byte // PNG, например QR оплаты; nil — только текст
+	Markup map[string]any
+	Sent   func(chatID, messageID int64, photo bool) // Telegram: куда легло сообщение, чтобы потом его править
+	Key    string                                    // непусто — в Telegram идёт через outbox и повторяется до доставки
+	Until  time.Time                                 // для Key: после этого повторять бессмысленно, обычно срок заявки
+	// Dedup, with Key, is the event and the payment the message is about
+	// (see dedupKey): Telegram gets it once until Until, whatever path or
+	// restart sends it again.
+	Dedup string
+}
+
+// Notifier delivers notifications of an account to one channel. Send must
+// not block: implementations queue the message and deliver it in the
+// background.
+type Notifier interface {
+	Send(n Notification)
+}
+
+// telegramNotifier sends to a Telegram chat through the engine bot.
+type telegramNotifier struct {
+	token     string
+	chatID    int64
+	thread    int64 // тема форума, 0 — общий чат
+	accountID int64
+	outbox    *outbox // для уведомлений с Key, nil — без гарантии доставки
+}
+
+func (t telegramNotifier) Send(n Notification) {
+	if n.Key != "" && t.outbox != nil {
+		t.outbox.add(t.accountID, t, n)
+		return
+	}
+	telegramSender(t.token).enqueue(t.message(n))
+}
+
+// message builds the Bot API call of the notification.
+func (t telegramNotifier) message(n Notification) tgMessage {
+	fallback := textMessage(t.chatID, n.Text, n.Markup).inThread(t.thread)
+	if n.Sent != nil {
+		fallback.sent = func(id int64) { n.Sent(t.chatID, id, false) }
+	}
+	if n.Photo == nil {
+		return fallback
+	}
+	// если Telegram не примет фото, уйдёт подпись текстом с той же клавиатурой
+	msg := photoMessage(t.chatID, n.Photo, n.Text, n.Markup).inThread(t.thread)
+	msg.fallback = &fallback
+	if n.Sent != nil {
+		msg.sent = func(id int64) { n.Sent(t.chatID, id, true) }
+	}
+	return msg
+}
+
+// notifiers returns the channels configured for the account: the Telegram
+// chat (or its forum topic) and the Slack and Discord webhooks.
+func (w *Worker) notifiers() []Notifier {
+	cfg := w.config()
+	var out []Notifier
+	switch {
+	case w.botToken == "":
+	case cfg.sandbox():
+		// sandbox не пишет в боевые чаты: только тестовый, без тем
+		if w.sandboxChat != 0 {
+			out = append(out, telegramNotifier{token: w.botToken, chatID: w.sandboxChat, accountID: cfg.AccountID, outbox: w.outbox})
+		}
+	case cfg.ChatID != 0:
+		out = append(out, telegramNotifier{token: w.botToken, chatID: cfg.ChatID, thread: w.topics.thread(w.botToken, cfg), accountID: cfg.AccountID, outbox: w.outbox})
+	}
+	if url := cfg.SlackWebhook.Reveal(); url != "" {
+		out = append(out, slackNotifier{url: url})
+	}
+	if url := cfg.DiscordWebhook.Reveal(); url != "" {
+		out = append(out, discordNotifier{url: url})
+	}
+	return out
+}
+
+// notify sends a text message to every channel of the account.
+func (w *Worker) notify(text string) {
+	w.send(Notification{Text: text})
+}
+
+func (w *Worker) send(n Notification) {
+	channels := w.notifiers()
+	if len(channels) == 0 {
+		w.logf("skip notify: no chat_id or webhook")
+		return
+	}
+	for _, c := range channels {
+		c.Send(n)
+	}
+}
+
+// urlButtons returns the URL buttons of a Telegram inline keyboard.
+func urlButtons(markup map[string]any) (labels, urls []string) {
+	rows, _ := markup["inline_keyboard"].([][]map[string]string)
+	for _, row := range rows {
+		for _, b := range row {
+			if b["url"] != "" {
+				labels = append(labels, b["text"])
+				urls = append(urls, b["url"])
+			}
+		}
+	}
+	return labels, urls
+}
+
+var (
+	reLink = regexp.MustCompile(`(?s)<a\s+href="([^"]*)"\s*>(.*?)</a>`)
+	reTag  = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*(\s[^>]*)?>`)
+)
+
+// markdownStyle maps Telegram HTML to a markdown dialect.
+type markdownStyle struct {
+	bold, italic, strike string
+	link                 func(url, text string) string
+	escaped              bool // Slack ждёт &amp; &lt; &gt; как в HTML
+}
+
+var (
+	slackStyle = markdownStyle{bold: "*", italic: "_", strike: "~", escaped: true, link: func(url, text string) string {
+		return "<" + url + "|" + text + ">"
+	}}
+	discordStyle = markdownStyle{bold: "**", italic: "*", strike: "~~", link: func(url, text string) string {
+		return "[" + text + "](" + url + ")"
+	}}
+)
+
+// markdown converts the Telegram HTML subset used in notifications.
+func (s markdownStyle) markdown(text string) string {
+	text = reLink.ReplaceAllStringFunc(text, func(m string) string {
+		sub := reLink.FindStringSubmatch(m)
+		url := sub[1]
+		if !s.escaped {
+			url = html.UnescapeString(url)
+		}
+		return s.link(url, sub[2])
+	})
+	r := strings.NewReplacer(
+		"<b>", s.bold, "</b>", s.bold, "<strong>", s.bold, "</strong>", s.bold,
+		"<i>", s.italic, "</i>", s.italic, "<em>", s.italic, "</em>", s.italic,
+		"<s>", s.strike, "</s>", s.strike,
+		"<code>", "`", "</code>", "`", "<pre>", "```\n", "</pre>", "\n```",
+	)
+	text = reTag.ReplaceAllStringFunc(r.Replace(text), func(string) string { return "" })
+	if s.escaped {
+		return strings.NewReplacer("&quot;", `"`, "&#39;", "'", "&#34;", `"`).Replace(text)
+	}
+	return html.UnescapeString(text)
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// outboundEvents are the event types posted to the account webhook.
+var outboundEvents = map[string]bool{
+	EventTaken:      true,
+	EventCompleted:  true,
+	EventCanceled:   true,
+	EventPenalty:    true,
+	EventPenaltyEnd: true,
+}
+
+// outboundAttempts is how many times an event is posted before it is
+// dropped: about a minute of exponential backoff.
+const outboundAttempts = 6
+
+// SignatureHeader carries "t=<unix>,v1=<hex>", where v1 is the HMAC-SHA256
+// of "<t>.<body>" with the account's webhook secret. Receivers should
+// recompute it and reject old timestamps to stop replays.
+const SignatureHeader = "X-P2C-Signature"
+
+// OutboundEvent is the JSON body posted to the account webhook. ID is the
+// same across retries, so the receiver can deduplicate deliveries.
+type OutboundEvent struct {
+	ID string `json:"id"`
+	Event
+}
+
+// postEvent queues ev for the account webhook if one is configured.
+func (w *Worker) postEvent(ev Event) {
+	if !outboundEvents[ev.Type] {
+		return
+	}
+	cfg := w.config()
+	if cfg.WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(OutboundEvent{ID: deliveryID(), Event: ev})
+	if err != nil {
+		w.logf("webhook event: %v", err)
+		return
+	}
+	header := http.Header{}
+	header.Set("X-P2C-Event", ev.Type)
+	if secret := cfg.WebhookSecret.Reveal(); secret != "" {
+		header.Set(SignatureHeader, signPayload(secret, time.Now(), body))
+	}
+	webhookSender(cfg.WebhookURL).enqueue(hookRequest{contentType: "application/json", body: body, header: header, attempts: outboundAttempts, dropped: w.deferEvent(cfg.WebhookURL)})
+}
+
+// deferEvent returns the callback moving an event the endpoint did not
+// accept into the durable job queue, to be retried for hours instead of
+// being lost.
+func (w *Worker) deferEvent(url string) func(hookRequest) {
+	if w.jobs == nil {
+		return nil
+	}
+	return func(r hookRequest) {
+		data, err := json.Marshal(notifyJob{URL: url, ContentType: r.contentType, Header: r.header, Body: r.body})
+		if err != nil {
+			return
+		}
+		w.jobs.Schedule(Job{Kind: JobNotify, AccountID: w.accountID, At: time.Now().Add(jobRetryBase), Data: data})
+	}
+}
+
+// signPayload returns the SignatureHeader value of body.
+func signPayload(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliveryID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
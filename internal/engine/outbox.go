@@ -0,0 +1,359 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/store"
+)
+
+const (
+	outboxDir        = "outbox"
+	outboxSentDoc    = "notified" // dedup-ключи доставленных сообщений
+	outboxTick       = 5 * time.Second
+	outboxRetryBase  = 15 * time.Second
+	outboxRetryMax   = 5 * time.Minute
+	outboxMaxAge     = 6 * time.Hour    // у заявки без срока дольше повторять бессмысленно
+	outboxRestoreLag = reconcileTimeout // после рестарта сначала даём reconcile прислать свежую карточку
+)
+
+// errTgQueueFull is reported to the outbox when the bot queue dropped a message.
+var errTgQueueFull = errors.New("telegram queue is full")
+
+// OutboxEntry is a Telegram notification the payer depends on, such as the
+// QR of a taken order. It is saved before it is sent and removed once
+// Telegram accepted it, so neither a crash nor a Telegram outage loses it.
+// Key identifies what the message is about: a newer message with the same
+// key replaces a pending one. Dedup, once delivered, keeps the same message
+// from going out again, e.g. after a reconnect or a restart.
+type OutboxEntry struct {
+	ID        string         `json:"id"`
+	Key       string         `json:"key"`
+	Dedup     string         `json:"dedup,omitempty"`
+	AccountID int64          `json:"account_id"`
+	ChatID    int64          `json:"chat_id"`
+	Thread    int64          `json:"thread,omitempty"`
+	Text      string         `json:"text"`
+	Photo     []byte         `json:"photo,omitempty"`
+	Markup    map[string]any `json:"markup,omitempty"`
+	Created   time.Time      `json:"created"`
+	Until     time.Time      `json:"until"` // дальше не повторяем: заявка истекла
+	Attempts  int            `json:"attempts"`
+	NextAt    time.Time      `json:"next_at"`
+	LastError string         `json:"last_error,omitempty"`
+}
+
+// outbox keeps the undelivered notifications in the store, one document per
+// message, and retries them with backoff until they are delivered, replaced
+// or out of date.
+type outbox struct {
+	store *store.Store
+	token string
+
+	mu      sync.Mutex
+	entries map[string]*outboxItem
+	keys    map[string]string   // key -> id
+	sent    map[string]sentMark // dedup -> доставлено, до срока заявки
+}
+
+// sentMark is a delivered message that must not be sent again.
+type sentMark struct {
+	Dedup     string    `json:"dedup"`
+	AccountID int64     `json:"account_id"`
+	Until     time.Time `json:"until"`
+}
+
+type outboxItem struct {
+	OutboxEntry
+	sending bool
+	sent    func(chatID, messageID int64, photo bool) // только в памяти: после рестарта карточку не отследить
+}
+
+func outboxDoc(id string) string {
+	return outboxDir + "/" + id
+}
+
+// newOutbox loads the messages left undelivered by the previous run and
+// starts the retry loop.
+func newOutbox(st *store.Store, token string) *outbox {
+	o := &outbox{store: st, token: token, entries: make(map[string]*outboxItem), keys: make(map[string]string), sent: make(map[string]sentMark)}
+	var sent []sentMark
+	if err := st.Load(outboxSentDoc, &sent); err != nil {
+		log.Printf("[outbox] load %s: %v", outboxSentDoc, err)
+	}
+	for _, s := range sent {
+		o.sent[s.Dedup] = s
+	}
+	names, err := st.List(outboxDir)
+	if err != nil {
+		log.Printf("[outbox] list: %v", err)
+	}
+	restore := time.Now().Add(outboxRestoreLag)
+	for _, name := range names {
+		var e OutboxEntry
+		if err := st.Load(name, &e); err != nil || e.ID == "" {
+			log.Printf("[outbox] load %s: %v", name, err)
+			continue
+		}
+		if e.NextAt.Before(restore) {
+			e.NextAt = restore
+		}
+		o.entries[e.ID] = &outboxItem{OutboxEntry: e}
+		o.keys[e.Key] = e.ID
+	}
+	if len(o.entries) > 0 {
+		log.Printf("[outbox] %d undelivered notifications restored", len(o.entries))
+	}
+	if token != "" {
+		go o.run()
+	}
+	return o
+}
+
+// add saves the notification and sends it. A message whose Dedup was
+// delivered already, or is on its way, is dropped.
+func (o *outbox) add(accountID int64, t telegramNotifier, n Notification) {
+	now := time.Now()
+	until := n.Until
+	if until.IsZero() || until.After(now.Add(outboxMaxAge)) {
+		until = now.Add(outboxMaxAge)
+	}
+	item := &outboxItem{
+		OutboxEntry: OutboxEntry{
+			ID:        deliveryID(),
+			Key:       n.Key,
+			Dedup:     n.Dedup,
+			AccountID: accountID,
+			ChatID:    t.chatID,
+			Thread:    t.thread,
+			Text:      n.Text,
+			Photo:     n.Photo,
+			Markup:    n.Markup,
+			Created:   now,
+			Until:     until,
+			Attempts:  1,
+			NextAt:    now,
+		},
+		sending: true,
+		sent:    n.Sent,
+	}
+	o.mu.Lock()
+	if o.duplicateLocked(n.Key, n.Dedup, now) {
+		o.mu.Unlock()
+		log.Printf("[outbox] account=%d %s: %s already sent, duplicate dropped", accountID, n.Key, n.Dedup)
+		return
+	}
+	if id, ok := o.keys[n.Key]; ok {
+		o.removeLocked(id)
+	}
+	o.entries[item.ID] = item
+	o.keys[n.Key] = item.ID
+	// пишем до отправки: упадём посреди доставки — сообщение повторится после рестарта
+	o.saveLocked(item)
+	o.mu.Unlock()
+	o.send(item)
+}
+
+// send hands the message to the bot queue; the outcome comes to result.
+func (o *outbox) send(item *outboxItem) {
+	n := Notification{Text: item.Text, Photo: item.Photo, Markup: item.Markup, Sent: item.sent}
+	t := telegramNotifier{token: o.token, chatID: item.ChatID, thread: item.Thread}
+	msg := t.message(n)
+	msg.done = func(err error) { o.result(item, err) }
+	telegramSender(o.token).enqueue(msg)
+}
+
+// result marks the message delivered or schedules the next attempt.
+func (o *outbox) result(item *outboxItem, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	item.sending = false
+	if o.entries[item.ID] != item {
+		return // заменено более свежим или аккаунт удалён
+	}
+	if err == nil {
+		if item.Attempts > 1 {
+			log.Printf("[outbox] account=%d %s delivered on attempt %d", item.AccountID, item.Key, item.Attempts)
+		}
+		if item.Dedup != "" {
+			o.sent[item.Dedup] = sentMark{Dedup: item.Dedup, AccountID: item.AccountID, Until: item.Until}
+			o.saveSentLocked(time.Now())
+		}
+		o.removeLocked(item.ID)
+		return
+	}
+	now := time.Now()
+	item.LastError = err.Error()
+	delay := min(outboxRetryBase<<min(item.Attempts-1, 10), outboxRetryMax)
+	if !now.Add(delay).Before(item.Until) {
+		log.Printf("[outbox] account=%d %s not delivered after %d attempts, dropped: %v", item.AccountID, item.Key, item.Attempts, err)
+		o.removeLocked(item.ID)
+		return
+	}
+	item.NextAt = now.Add(delay)
+	log.Printf("[outbox] account=%d %s: %v, retry in %s", item.AccountID, item.Key, err, delay)
+	o.saveLocked(item)
+}
+
+// run retries the due messages until the process exits, like the bot queues.
+func (o *outbox) run() {
+	ticker := time.NewTicker(outboxTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		o.retryDue(now)
+	}
+}
+
+func (o *outbox) retryDue(now time.Time) {
+	o.mu.Lock()
+	var due []*outboxItem
+	for id, item := range o.entries {
+		if item.sending || item.NextAt.After(now) {
+			continue
+		}
+		if !now.Before(item.Until) {
+			log.Printf("[outbox] account=%d %s expired undelivered after %d attempts: %s", item.AccountID, item.Key, item.Attempts, item.LastError)
+			o.removeLocked(id)
+			continue
+		}
+		item.sending = true
+		item.Attempts++
+		o.saveLocked(item)
+		due = append(due, item)
+	}
+	o.mu.Unlock()
+	for _, item := range due {
+		o.send(item)
+	}
+}
+
+// duplicateLocked reports whether a message with the dedup key was delivered or
+// is on its way. A pending one under the same key is not: the newer
+// message replaces it, unless it is being sent right now.
+func (o *outbox) duplicateLocked(key, dedup string, now time.Time) bool {
+	if dedup == "" {
+		return false
+	}
+	if s, ok := o.sent[dedup]; ok && now.Before(s.Until) {
+		return true
+	}
+	for _, item := range o.entries {
+		if item.Dedup == dedup && (item.sending || item.Key != key) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSentLocked drops the expired dedup keys and saves the rest.
+func (o *outbox) saveSentLocked(now time.Time) {
+	out := make([]sentMark, 0, len(o.sent))
+	for k, s := range o.sent {
+		if !now.Before(s.Until) {
+			delete(o.sent, k)
+			continue
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+	if err := o.store.Save(outboxSentDoc, out); err != nil {
+		log.Printf("[store] save %s error: %v", outboxSentDoc, err)
+	}
+}
+
+// drop forgets the pending message with the key, e.g. of an order that was
+// paid or canceled meanwhile.
+func (o *outbox) drop(key string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if id, ok := o.keys[key]; ok {
+		o.removeLocked(id)
+	}
+}
+
+// forget drops the pending messages of a removed account.
+func (o *outbox) forget(accountID int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, item := range o.entries {
+		if item.AccountID == accountID {
+			o.removeLocked(id)
+		}
+	}
+	for k, s := range o.sent {
+		if s.AccountID == accountID {
+			delete(o.sent, k)
+		}
+	}
+	o.saveSentLocked(time.Now())
+}
+
+// pending counts the undelivered messages of the account.
+func (o *outbox) pending(accountID int64) int {
+	if o == nil {
+		return 0
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, item := range o.entries {
+		if item.AccountID == accountID {
+			n++
+		}
+	}
+	return n
+}
+
+func (o *outbox) saveLocked(item *outboxItem) {
+	if err := o.store.Save(outboxDoc(item.ID), item.OutboxEntry); err != nil {
+		log.Printf("[store] save %s error: %v", outboxDoc(item.ID), err)
+	}
+}
+
+func (o *outbox) removeLocked(id string) {
+	item, ok := o.entries[id]
+	if !ok {
+		return
+	}
+	delete(o.entries, id)
+	if o.keys[item.Key] == id {
+		delete(o.keys, item.Key)
+	}
+	if err := o.store.Delete(outboxDoc(id)); err != nil {
+		log.Printf("[store] delete %s error: %v", outboxDoc(id), err)
+	}
+}
+
+// orderKey is the outbox key of the "order taken, pay it" message.
+func orderKey(accountID int64, ref PaymentRef) string {
+	id := ref.Hex
+	if id == "" {
+		id = ref.APIID()
+	}
+	return fmt.Sprintf("order/%d/%s", accountID, id)
+}
+
+// dedupKey identifies a message by its event and payment, e.g. the card of
+// a taken order.
+func dedupKey(event string, accountID int64, ref PaymentRef) string {
+	id := ref.Hex
+	if id == "" {
+		id = ref.APIID()
+	}
+	return fmt.Sprintf("%s/%d/%s", event, accountID, id)
+}
+
+// orderUntil parses the expires_at of an order into the local clock; zero
+// if it is unknown.
+func orderUntil(expiresAt string, clock *p2c.Clock) time.Time {
+	at, _ := time.Parse(time.RFC3339, expiresAt)
+	return clock.Local(at)
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"p2c-engine/internal/i18n"
+)
+
+const defaultPayerAck = 2 * time.Minute
+
+var (
+	// ErrNotAssigned is returned when acknowledging a payment that has no
+	// pending assignment: it was acknowledged already or is not open.
+	ErrNotAssigned = errors.New("payment has no pending assignment")
+	// ErrNotPayer is returned when the user is not in the account's payer pool.
+	ErrNotPayer = errors.New("user is not a payer of the account")
+)
+
+// payerJob is the data of a JobEscalate: who holds the assignment now.
+type payerJob struct {
+	Payer int64 `json:"payer"`
+	Hops  int   `json:"hops"` // сколько раз уже передавали дальше
+}
+
+func payerJobID(accountID int64, paymentID string) string {
+	return JobEscalate + ":" + strconv.FormatInt(accountID, 10) + ":" + paymentID
+}
+
+func (cfg WorkerConfig) payerAck() time.Duration {
+	if cfg.PayerAckSec > 0 {
+		return time.Duration(cfg.PayerAckSec) * time.Second
+	}
+	return defaultPayerAck
+}
+
+// nextPayer picks the payer of a new order round-robin.
+func (w *Worker) nextPayer(payers []int64) int64 {
+	n := w.payerTurn.Add(1) - 1
+	return payers[n%uint64(len(payers))]
+}
+
+// assignPayer hands a taken order to the next payer of the pool and arms
+// the escalation; it returns 0 when the account has no pool.
+func (w *Worker) assignPayer(ref PaymentRef) int64 {
+	cfg := w.config()
+	if len(cfg.Payers) == 0 || w.jobs == nil {
+		return 0
+	}
+	payer := w.nextPayer(cfg.Payers)
+	w.armEscalation(ref.Hex, payerJob{Payer: payer}, cfg.payerAck())
+	return payer
+}
+
+func (w *Worker) armEscalation(paymentID string, pj payerJob, after time.Duration) {
+	data, _ := json.Marshal(pj)
+	w.jobs.Schedule(Job{ID: payerJobID(w.accountID, paymentID), Kind: JobEscalate, AccountID: w.accountID, PaymentID: paymentID, At: time.Now().Add(after), Data: data})
+}
+
+// payerMention tags a Telegram user by id in an HTML message.
+func payerMention(userID int64) string {
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%d</a>`, userID, userID)
+}
+
+// ackRow is the keyboard row the assigned payer confirms with.
+func ackRow(locale string, accountID int64, paymentID string) []map[string]string {
+	return []map[string]string{{"text": i18n.T(locale, "button.ack"), "callback_data": fmt.Sprintf("ack:%d:%s", accountID, paymentID)}}
+}
+
+// withAck adds the acknowledge button on top of an order keyboard.
+func withAck(markup map[string]any, locale string, accountID int64, paymentID string) map[string]any {
+	rows, _ := markup["inline_keyboard"].([][]map[string]string)
+	return map[string]any{"inline_keyboard": append([][]map[string]string{ackRow(locale, accountID, paymentID)}, rows...)}
+}
+
+// escalate passes an unacknowledged order to the next payer; after a full
+// round without an answer the chat is told and escalation stops.
+func (w *Worker) escalate(j Job) error {
+	var pj payerJob
+	if err := json.Unmarshal(j.Data, &pj); err != nil {
+		w.logf("escalate %s: bad data: %v", j.PaymentID, err)
+		return nil
+	}
+	rec, ok := w.Payment(j.PaymentID)
+	if !ok || (rec.Status != StateTaken && rec.Status != StateAwaitingPayment) {
+		return nil // оплачена, отменена или истекла
+	}
+	cfg := w.config()
+	locale := cfg.Locale
+	if len(cfg.Payers) == 0 {
+		return nil
+	}
+	pj.Hops++
+	if pj.Hops >= len(cfg.Payers) {
+		w.notify(i18n.T(locale, "payer.unacked", cfg.account(), rec.Ref, payerMention(pj.Payer)))
+		return nil
+	}
+	i := slices.Index(cfg.Payers, pj.Payer)
+	prev := pj.Payer
+	pj.Payer = cfg.Payers[(i+1)%len(cfg.Payers)]
+	// правка карточки не уведомляет, поэтому нового плательщика отмечаем отдельным сообщением
+	w.setCardPayer(rec.Ref, pj.Payer)
+	markup := withAck(buildPaidKeyboard(locale, w.accountID, rec.livePayment(), w.web.URL(w.accountID, j.PaymentID), cfg.PaidOneTap), locale, w.accountID, j.PaymentID)
+	w.send(Notification{Text: i18n.T(locale, "payer.escalated", cfg.account(), rec.Ref, rec.InAmount, payerMention(prev), payerMention(pj.Payer)), Markup: markup})
+	w.armEscalation(j.PaymentID, pj, cfg.payerAck())
+	return nil
+}
+
+// AckPayment records that userID took the assigned order: escalation stops
+// and the chat is told who pays. Any payer of the pool may pick it up.
+func (m *Manager) AckPayment(accountID int64, paymentID string, userID int64) error {
+	w := m.worker(accountID)
+	if w == nil {
+		return fmt.Errorf("account %d is not running", accountID)
+	}
+	cfg := w.config()
+	if !slices.Contains(cfg.Payers, userID) {
+		return ErrNotPayer
+	}
+	ref := w.ResolvePayment(paymentID)
+	id := ref.Hex
+	if id == "" {
+		id = ref.APIID()
+	}
+	if !m.jobs.Cancel(payerJobID(accountID, id)) {
+		return ErrNotAssigned
+	}
+	if !cfg.OrderCard || !w.setCardPayer(ref, userID) {
+		w.notify(i18n.T(cfg.Locale, "payer.acked", cfg.account(), ref, payerMention(userID)))
+	}
+	return nil
+}
+
+// LinkUser stands for whoever opened a signed web view link: the link names
+// no Telegram user, so accounts with PaidUsers refuse it.
+const LinkUser int64 = -1
+
+// MayConfirmPaid reports whether the Telegram user may confirm payments of
+// the account; API requests without a user (0) are not restricted.
+func (m *Manager) MayConfirmPaid(accountID, userID int64) bool {
+	w := m.worker(accountID)
+	if w == nil || userID == 0 {
+		return true
+	}
+	users := w.config().PaidUsers
+	return len(users) == 0 || slices.Contains(users, userID)
+}
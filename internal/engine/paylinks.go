@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"net/url"
+	"strings"
+
+	"p2c-engine/internal/p2c"
+)
+
+// sbpHosts serve the links of the Faster Payments System (SBP) QR codes.
+var sbpHosts = map[string]bool{"qr.nspk.ru": true, "sub.nspk.ru": true}
+
+// SBPBank is a bank app that opens SBP links through the NSPK scheme
+// bank<member id>://.
+type SBPBank struct {
+	Name   string
+	Member string
+}
+
+// sbpBanks are offered on the payment page. Telegram opens only http(s)
+// links, so the card itself carries the https link and the phone asks
+// which app to use.
+var sbpBanks = []SBPBank{
+	{Name: "Сбербанк", Member: "100000000111"},
+	{Name: "Т-Банк", Member: "100000000004"},
+	{Name: "Альфа-Банк", Member: "100000000008"},
+	{Name: "ВТБ", Member: "110000000005"},
+	{Name: "Райффайзенбанк", Member: "100000000007"},
+	{Name: "Газпромбанк", Member: "100000000001"},
+}
+
+// PayInstructions is how to pay an order without scanning its QR: the SBP
+// link and the requisites found in the payment payload, each ready to be
+// copied as is.
+type PayInstructions struct {
+	SBP         string `json:"sbp,omitempty"`
+	Card        string `json:"card,omitempty"`
+	Phone       string `json:"phone,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Account     string `json:"account,omitempty"`
+	BIC         string `json:"bic,omitempty"`
+	Bank        string `json:"bank,omitempty"`
+	CorrAccount string `json:"corr_account,omitempty"`
+	INN         string `json:"inn,omitempty"`
+	Purpose     string `json:"purpose,omitempty"`
+}
+
+// BankLink opens an SBP payment in one bank app.
+type BankLink struct {
+	Bank string `json:"bank"`
+	URL  string `json:"url"`
+}
+
+// HasRequisites reports whether there is anything to copy besides the link.
+func (pi PayInstructions) HasRequisites() bool {
+	return pi.Card != "" || pi.Phone != "" || pi.Account != ""
+}
+
+// BankLinks returns the deep links of the SBP link into the bank apps.
+func (pi PayInstructions) BankLinks() []BankLink {
+	u, err := url.Parse(pi.SBP)
+	if err != nil || pi.SBP == "" {
+		return nil
+	}
+	out := make([]BankLink, 0, len(sbpBanks))
+	for _, b := range sbpBanks {
+		deep := *u
+		deep.Scheme = "bank" + b.Member
+		out = append(out, BankLink{Bank: b.Name, URL: deep.String()})
+	}
+	return out
+}
+
+// payInstructions reads the payment: an SBP link in its url or payload, a
+// GOST R 56042 payment string ("ST00012|Name=...|PersonalAcc=...") or a bare
+// card number or phone.
+func payInstructions(p p2c.LivePayment) PayInstructions {
+	var pi PayInstructions
+	payload := strings.TrimSpace(p.Payload)
+	for _, s := range []string{p.URL, payload} {
+		if isSBPLink(s) {
+			pi.SBP = strings.TrimSpace(s)
+			break
+		}
+	}
+	switch {
+	case payload == "" || payload == pi.SBP:
+	case strings.HasPrefix(payload, "ST00012|"):
+		pi.readGOST(payload)
+	default:
+		if card := cardNumber(payload); card != "" {
+			pi.Card = card
+		} else if phone := phoneNumber(payload); phone != "" && strings.EqualFold(p.Provider, "sbp") {
+			pi.Phone = phone
+		}
+	}
+	return pi
+}
+
+func isSBPLink(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && u.Scheme == "https" && sbpHosts[strings.ToLower(u.Host)]
+}
+
+// readGOST fills the requisites from a UTF-8 payment string; other
+// encodings of the standard are not used by P2C.
+func (pi *PayInstructions) readGOST(s string) {
+	for _, field := range strings.Split(s, "|")[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(key) {
+		case "name":
+			pi.Name = value
+		case "personalacc":
+			pi.Account = value
+		case "bic":
+			pi.BIC = value
+		case "bankname":
+			pi.Bank = value
+		case "correspacc":
+			pi.CorrAccount = value
+		case "payeeinn":
+			pi.INN = value
+		case "purpose":
+			pi.Purpose = value
+		}
+	}
+}
+
+// cardNumber returns the digits of a card number with a valid check digit.
+func cardNumber(s string) string {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(digits) < 16 || len(digits) > 19 || strings.Trim(digits, "0123456789") != "" {
+		return ""
+	}
+	sum := 0
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	if sum%10 != 0 {
+		return ""
+	}
+	return digits
+}
+
+// phoneNumber returns a Russian mobile number as +7XXXXXXXXXX.
+func phoneNumber(s string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		if strings.ContainsRune(" +-()", r) {
+			return -1
+		}
+		return 'x'
+	}, s)
+	if len(digits) != 11 || strings.ContainsRune(digits, 'x') || (digits[0] != '7' && digits[0] != '8') || digits[1] != '9' {
+		return ""
+	}
+	return "+7" + digits[1:]
+}
+
+// PayInstructions returns how to pay the order, for the payment page.
+func (rec PaymentRecord) PayInstructions() PayInstructions {
+	return payInstructions(rec.livePayment())
+}
@@ -0,0 +1,73 @@
+package engine
+
+import "fmt"
+
+// PaymentState is a step of a payment lifecycle on our side.
+type PaymentState string
+
+// Payment states. A payment holds the account (no new takes) while it is
+// taking or taken; it is released once it leaves the public list
+// (awaiting_payment) or reaches a final state.
+const (
+	StateSeen            PaymentState = "seen"
+	StateTaking          PaymentState = "taking"
+	StateTaken           PaymentState = "taken"
+	StateTakeFailed      PaymentState = "take_failed"
+	StateAwaitingPayment PaymentState = "awaiting_payment"
+	StateCompleting      PaymentState = "completing"
+	StateCompleted       PaymentState = "completed"
+	StateCancelling      PaymentState = "cancelling"
+	StateCanceled        PaymentState = "canceled"
+	StateExpired         PaymentState = "expired"
+	StateDisputed        PaymentState = "disputed"
+)
+
+var paymentTransitions = map[PaymentState][]PaymentState{
+	StateSeen:            {StateTaking},
+	StateTaking:          {StateTaken, StateTakeFailed},
+	StateTaken:           {StateAwaitingPayment, StateCompleting, StateCancelling, StateExpired},
+	StateAwaitingPayment: {StateCompleting, StateCancelling, StateExpired, StateDisputed},
+	StateCompleting:      {StateCompleted, StateAwaitingPayment, StateDisputed},
+	StateCancelling:      {StateCanceled, StateAwaitingPayment},
+	StateExpired:         {StateCompleting, StateCancelling, StateDisputed},
+	StateCompleted:       {StateDisputed},
+}
+
+// CanTransition reports whether the lifecycle allows moving from s to next.
+func (s PaymentState) CanTransition(next PaymentState) bool {
+	for _, to := range paymentTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Final reports whether no further transitions are expected.
+func (s PaymentState) Final() bool {
+	switch s {
+	case StateTakeFailed, StateCanceled, StateDisputed:
+		return true
+	}
+	return false
+}
+
+// Open reports whether the payment can still be completed or canceled.
+func (s PaymentState) Open() bool {
+	return s.CanTransition(StateCompleting) || s.CanTransition(StateCancelling)
+}
+
+// holdsAccount reports whether the payment still blocks new takes.
+func (s PaymentState) holdsAccount() bool {
+	return s == StateTaking || s == StateTaken
+}
+
+// TransitionError is returned for a transition the lifecycle forbids.
+type TransitionError struct {
+	Ref      PaymentRef
+	From, To PaymentState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment %s: invalid transition %s -> %s", e.Ref, e.From, e.To)
+}
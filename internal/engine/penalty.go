@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+// PenaltyRecord describes a MerchantPenalized block of an account.
+type PenaltyRecord struct {
+	AccountID int64     `json:"account_id"`
+	Reason    string    `json:"reason"`
+	StartedAt time.Time `json:"started_at"`
+	Until     time.Time `json:"until"`      // penalty_end_at from P2C
+	Cooldown  int64     `json:"cooldown_s"` // extra operator cooldown after Until
+	ResumedAt time.Time `json:"resumed_at,omitempty"`
+}
+
+// ResumeAt is the moment takes are allowed again.
+func (r PenaltyRecord) ResumeAt() time.Time {
+	return r.Until.Add(time.Duration(r.Cooldown) * time.Second)
+}
+
+const (
+	penaltyDoc        = "penalties"
+	penaltyHistoryMax = 50
+)
+
+// PenaltyManager owns penalty state of all accounts: it persists penalties,
+// resumes takes by a deferred job at the penalty end plus cooldown, and
+// keeps history.
+type PenaltyManager struct {
+	store    *store.Store
+	jobs     *JobQueue
+	onResume func(rec PenaltyRecord)
+
+	mu      sync.Mutex
+	active  map[int64]PenaltyRecord
+	history map[int64][]PenaltyRecord
+}
+
+type penaltyState struct {
+	Active  map[int64]PenaltyRecord   `json:"active"`
+	History map[int64][]PenaltyRecord `json:"history"`
+}
+
+// NewPenaltyManager loads persisted penalties and schedules their resume
+// on jobs.
+func NewPenaltyManager(st *store.Store, jobs *JobQueue) *PenaltyManager {
+	pm := &PenaltyManager{
+		store:   st,
+		jobs:    jobs,
+		active:  make(map[int64]PenaltyRecord),
+		history: make(map[int64][]PenaltyRecord),
+	}
+	jobs.Handle(JobResumePenalty, func(j Job) error {
+		pm.mu.Lock()
+		rec, ok := pm.active[j.AccountID]
+		pm.mu.Unlock()
+		if ok {
+			pm.resume(rec)
+		}
+		return nil
+	})
+	var state penaltyState
+	if err := st.Load(penaltyDoc, &state); err != nil {
+		log.Printf("[penalty] load error: %v", err)
+	}
+	if state.History != nil {
+		pm.history = state.History
+	}
+	for id, rec := range state.Active {
+		pm.active[id] = rec
+		pm.armLocked(rec)
+	}
+	return pm
+}
+
+// OnResume sets the callback invoked when a penalty (plus cooldown) ends.
+func (pm *PenaltyManager) OnResume(fn func(rec PenaltyRecord)) {
+	pm.mu.Lock()
+	pm.onResume = fn
+	pm.mu.Unlock()
+}
+
+// Apply registers a penalty. isNew is false when the account is already
+// blocked until the same or a later moment, so callers notify only once.
+func (pm *PenaltyManager) Apply(accountID int64, until time.Time, reason string, cooldown time.Duration) (rec PenaltyRecord, isNew bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if cur, ok := pm.active[accountID]; ok && !until.After(cur.Until) {
+		return cur, false
+	}
+	rec = PenaltyRecord{
+		AccountID: accountID,
+		Reason:    reason,
+		StartedAt: time.Now(),
+		Until:     until,
+		Cooldown:  int64(cooldown / time.Second),
+	}
+	pm.active[accountID] = rec
+	pm.appendHistoryLocked(rec)
+	pm.armLocked(rec)
+	pm.saveLocked()
+	return rec, true
+}
+
+// Blocked returns the active penalty if takes are still blocked at now.
+func (pm *PenaltyManager) Blocked(accountID int64, now time.Time) (PenaltyRecord, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	rec, ok := pm.active[accountID]
+	if !ok || !now.Before(rec.ResumeAt()) {
+		return PenaltyRecord{}, false
+	}
+	return rec, true
+}
+
+// History returns the account's penalties, newest last.
+func (pm *PenaltyManager) History(accountID int64) []PenaltyRecord {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	out := make([]PenaltyRecord, len(pm.history[accountID]))
+	copy(out, pm.history[accountID])
+	return out
+}
+
+// Clear lifts the account's penalty immediately (operator override).
+func (pm *PenaltyManager) Clear(accountID int64) bool {
+	pm.mu.Lock()
+	rec, ok := pm.active[accountID]
+	pm.mu.Unlock()
+	if !ok {
+		return false
+	}
+	pm.resume(rec)
+	return true
+}
+
+// Forget drops the account's active penalty and history without resuming it.
+func (pm *PenaltyManager) Forget(accountID int64) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.jobs.Cancel(penaltyJobID(accountID))
+	_, active := pm.active[accountID]
+	_, hist := pm.history[accountID]
+	if !active && !hist {
+		return
+	}
+	delete(pm.active, accountID)
+	delete(pm.history, accountID)
+	pm.saveLocked()
+}
+
+func (pm *PenaltyManager) armLocked(rec PenaltyRecord) {
+	pm.jobs.Schedule(Job{ID: penaltyJobID(rec.AccountID), Kind: JobResumePenalty, AccountID: rec.AccountID, At: rec.ResumeAt()})
+}
+
+// resume ends the penalty if rec is still the active one.
+func (pm *PenaltyManager) resume(rec PenaltyRecord) {
+	pm.mu.Lock()
+	cur, ok := pm.active[rec.AccountID]
+	if !ok || !cur.Until.Equal(rec.Until) {
+		pm.mu.Unlock()
+		return
+	}
+	delete(pm.active, rec.AccountID)
+	pm.jobs.Cancel(penaltyJobID(rec.AccountID))
+	cur.ResumedAt = time.Now()
+	hist := pm.history[rec.AccountID]
+	for i := range hist {
+		if hist[i].Until.Equal(cur.Until) && hist[i].StartedAt.Equal(cur.StartedAt) {
+			hist[i].ResumedAt = cur.ResumedAt
+		}
+	}
+	pm.saveLocked()
+	onResume := pm.onResume
+	pm.mu.Unlock()
+
+	log.Printf("[penalty] account=%d resumed (until=%s cooldown=%ds)", cur.AccountID, cur.Until.Format(time.RFC3339), cur.Cooldown)
+	if onResume != nil {
+		onResume(cur)
+	}
+}
+
+func (pm *PenaltyManager) appendHistoryLocked(rec PenaltyRecord) {
+	hist := append(pm.history[rec.AccountID], rec)
+	if len(hist) > penaltyHistoryMax {
+		hist = hist[len(hist)-penaltyHistoryMax:]
+	}
+	pm.history[rec.AccountID] = hist
+}
+
+func (pm *PenaltyManager) saveLocked() {
+	if err := pm.store.Save(penaltyDoc, penaltyState{Active: pm.active, History: pm.history}); err != nil {
+		log.Printf("[penalty] save error: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"log"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+// docSaver writes a store document in the background: changes are coalesced
+// and saved at most once per interval, so hot paths never wait on disk.
+type docSaver struct {
+	store    *store.Store
+	doc      string
+	snapshot func() any
+	interval time.Duration
+
+	kick chan struct{}
+	done chan struct{}
+}
+
+// newDocSaver returns nil for a nil store; all methods accept a nil saver.
+func newDocSaver(st *store.Store, doc string, interval time.Duration, snapshot func() any) *docSaver {
+	if st == nil {
+		return nil
+	}
+	s := &docSaver{
+		store:    st,
+		doc:      doc,
+		snapshot: snapshot,
+		interval: interval,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// changed schedules a save.
+func (s *docSaver) changed() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// close saves pending changes and stops the saver.
+func (s *docSaver) close() {
+	if s == nil {
+		return
+	}
+	close(s.kick)
+	<-s.done
+}
+
+func (s *docSaver) run() {
+	defer close(s.done)
+	for range s.kick {
+		if err := s.store.Save(s.doc, s.snapshot()); err != nil {
+			log.Printf("[store] save %s error: %v", s.doc, err)
+		}
+		if s.interval > 0 {
+			time.Sleep(s.interval)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+const defaultPollInterval = 3 * time.Second
+
+// runPollFallback polls ListPayments while the websocket has been down for
+// longer than PollFallbackAfterSec and stops as soon as it reconnects.
+// Requests still go through allowRequest, so the API limit is respected.
+func (w *Worker) runPollFallback(ctx context.Context) {
+	polling := false
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		cfg := w.config()
+		next := time.Second
+		down := w.wsDownSince.Load()
+		after := time.Duration(cfg.PollFallbackAfterSec) * time.Second
+		active := cfg.PollFallbackAfterSec > 0 && down != 0 && time.Since(time.Unix(0, down)) >= after
+		if active != polling {
+			polling = active
+			note := "poll fallback off"
+			if active {
+				note = "poll fallback on"
+				w.logf("websocket down for %s, polling fallback on", time.Since(time.Unix(0, down)).Round(time.Second))
+			} else {
+				w.logf("polling fallback off")
+			}
+			w.trace.add(TraceFrame{At: time.Now(), Note: note})
+		}
+		if active {
+			now := time.Now()
+			_, penalized := w.penalties.Blocked(w.accountID, now)
+			_, open := w.breaker.blocked(now)
+			_, blackout := w.blackouts.active(w.tenant, w.accountID, now)
+			if !w.paused.Load() && !w.maint.active() && !blackout && !penalized && !open && !w.isActiveLocked(now) {
+				w.pollOnce(ctx, now)
+			}
+			next = defaultPollInterval
+			if cfg.PollIntervalMs > 0 {
+				next = time.Duration(cfg.PollIntervalMs) * time.Millisecond
+			}
+		}
+		timer.Reset(next)
+	}
+}
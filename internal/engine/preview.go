@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+const (
+	seenHistorySize = 5000 // сколько последних увиденных заявок помнит воркер для предпросмотра
+	PreviewMaxHours = 24
+)
+
+// FilterSet is the part of an account config that decides which payments
+// are taken. JSON names match the reload request.
+type FilterSet struct {
+	MinAmount      *float64       `json:"min_amount"`
+	MaxAmount      *float64       `json:"max_amount"`
+	MinOutAmount   *float64       `json:"min_out_amount"`
+	MaxOutAmount   *float64       `json:"max_out_amount"`
+	ProvidersAllow []string       `json:"providers_allow"`
+	ProvidersDeny  []string       `json:"providers_deny"`
+	AmountsAllow   []string       `json:"amounts_allow"`
+	AmountsDeny    []string       `json:"amounts_deny"`
+	Strategy       StrategyConfig `json:"strategy"`
+	DailyCap       float64        `json:"daily_cap"`
+	MarketEdgePct  float64        `json:"market_edge_pct"`
+}
+
+func (cfg WorkerConfig) filters() FilterSet {
+	return FilterSet{
+		MinAmount:      cfg.MinAmount,
+		MaxAmount:      cfg.MaxAmount,
+		MinOutAmount:   cfg.MinOutAmount,
+		MaxOutAmount:   cfg.MaxOutAmount,
+		ProvidersAllow: cfg.ProvidersAllow,
+		ProvidersDeny:  cfg.ProvidersDeny,
+		AmountsAllow:   cfg.AmountsAllow,
+		AmountsDeny:    cfg.AmountsDeny,
+		Strategy:       cfg.Strategy,
+		DailyCap:       cfg.DailyCap,
+		MarketEdgePct:  cfg.MarketEdgePct,
+	}
+}
+
+func (f FilterSet) apply(cfg WorkerConfig) WorkerConfig {
+	cfg.MinAmount, cfg.MaxAmount = f.MinAmount, f.MaxAmount
+	cfg.MinOutAmount, cfg.MaxOutAmount = f.MinOutAmount, f.MaxOutAmount
+	cfg.ProvidersAllow, cfg.ProvidersDeny = f.ProvidersAllow, f.ProvidersDeny
+	cfg.AmountsAllow, cfg.AmountsDeny = f.AmountsAllow, f.AmountsDeny
+	cfg.Strategy = f.Strategy
+	cfg.DailyCap = f.DailyCap
+	cfg.MarketEdgePct = f.MarketEdgePct
+	return cfg
+}
+
+// seenHistory keeps the last payments the worker saw, like frameRing does
+// for frames, so filter changes can be previewed against real traffic.
+type seenHistory struct {
+	mu   sync.Mutex
+	buf  []p2c.LivePayment
+	next int
+	full bool
+}
+
+func newSeenHistory(size int) *seenHistory {
+	return &seenHistory{buf: make([]p2c.LivePayment, size)}
+}
+
+func (h *seenHistory) add(p p2c.LivePayment, now time.Time) {
+	p.Payload = "" // сырой кадр не нужен, а памяти занимает больше всего
+	if p.ReceivedAt.IsZero() {
+		p.ReceivedAt = now
+	}
+	h.mu.Lock()
+	h.buf[h.next] = p
+	h.next++
+	if h.next == len(h.buf) {
+		h.next = 0
+		h.full = true
+	}
+	h.mu.Unlock()
+}
+
+// since returns the payments seen after t, oldest first.
+func (h *seenHistory) since(t time.Time) []p2c.LivePayment {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var all []p2c.LivePayment
+	if h.full {
+		all = append(all, h.buf[h.next:]...)
+	}
+	all = append(all, h.buf[:h.next]...)
+	out := all[:0]
+	for _, p := range all {
+		if p.ReceivedAt.After(t) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// PreviewDecision is what the candidate filters would have done with one
+// seen payment, next to what the current ones decide.
+type PreviewDecision struct {
+	At       time.Time `json:"at"`
+	ID       string    `json:"payment_id"`
+	Amount   string    `json:"amount"`
+	Provider string    `json:"provider,omitempty"`
+	Take     bool      `json:"take"`
+	Code     SkipCode  `json:"code,omitempty"`
+	Reason   string    `json:"reason,omitempty"` // причина пропуска
+	Current  bool      `json:"current"`          // текущие фильтры взяли бы
+}
+
+// FilterPreview sums up a preview; Gained and Lost count the payments only
+// the candidate or only the current filters would take.
+type FilterPreview struct {
+	AccountID    int64             `json:"account_id"`
+	Since        time.Time         `json:"since"`
+	Seen         int               `json:"seen"`
+	Taken        int               `json:"taken"`
+	TakenVolume  float64           `json:"taken_volume"`
+	Skipped      map[SkipCode]int  `json:"skipped"`
+	CurrentTaken int               `json:"current_taken"`
+	Gained       int               `json:"gained"`
+	Lost         int               `json:"lost"`
+	Decisions    []PreviewDecision `json:"decisions"`
+}
+
+// Filters returns the filter set the account runs with.
+func (m *Manager) Filters(accountID int64) (FilterSet, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return FilterSet{}, ErrNoWorker
+	}
+	return w.config().filters(), nil
+}
+
+// PreviewFilters replays the payments the account saw in the last hours
+// (at most PreviewMaxHours and seenHistorySize payments) through the
+// candidate filters and the current ones, without touching P2C. As in
+// Replay, a payment that passes counts against the daily cap from zero at
+// the start of the window; market rates are today's, active orders,
+// penalties and the arbiter are not simulated.
+func (m *Manager) PreviewFilters(accountID int64, set FilterSet, hours int) (FilterPreview, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return FilterPreview{}, ErrNoWorker
+	}
+	cfg := w.config()
+	candidate, err := previewWorker(set.apply(cfg), w.rates)
+	if err != nil {
+		return FilterPreview{}, err
+	}
+	defer candidate.closeStrategy()
+	current, err := previewWorker(cfg, w.rates)
+	if err != nil {
+		return FilterPreview{}, err
+	}
+	defer current.closeStrategy()
+
+	hours = min(max(hours, 1), PreviewMaxHours)
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	res := FilterPreview{AccountID: accountID, Since: since, Skipped: make(map[SkipCode]int), Decisions: []PreviewDecision{}}
+	for _, p := range w.history.since(since) {
+		d := candidate.filter(p, p.ReceivedAt)
+		was := current.filter(p, p.ReceivedAt).Take
+		res.Seen++
+		if d.Take {
+			candidate.addDayVolume(p.InAmount, p.ReceivedAt)
+			res.Taken++
+			amount, _ := strconv.ParseFloat(p.InAmount, 64)
+			res.TakenVolume += amount
+		} else {
+			res.Skipped[d.Code]++
+		}
+		if was {
+			current.addDayVolume(p.InAmount, p.ReceivedAt)
+			res.CurrentTaken++
+		}
+		switch {
+		case d.Take && !was:
+			res.Gained++
+		case !d.Take && was:
+			res.Lost++
+		}
+		res.Decisions = append(res.Decisions, PreviewDecision{At: p.ReceivedAt, ID: p.ID, Amount: p.InAmount, Provider: p.Provider, Take: d.Take, Code: d.Code, Reason: d.Reason, Current: was})
+	}
+	return res, nil
+}
+
+// previewWorker is a detached worker that only runs filters, like the one of
+// Replay.
+func previewWorker(cfg WorkerConfig, rates RateSource) (*Worker, error) {
+	strategy, err := newStrategy(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Worker{cfg: cfg, strategy: strategy, rates: rates}, nil
+}
+
+func (w *Worker) closeStrategy() {
+	if c, ok := w.strategy.(strategyCloser); ok {
+		c.Close()
+	}
+}
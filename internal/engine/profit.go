@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateSource gives the reference (market) price of one unit of asset in
+// fiat, e.g. Rate("RUB", "USDT") = 95.3.
+type RateSource interface {
+	Rate(fiat, asset string) (float64, bool)
+}
+
+// chainRates asks each source in turn, e.g. a live oracle before fixed prices.
+type chainRates []RateSource
+
+func (c chainRates) Rate(fiat, asset string) (float64, bool) {
+	for _, src := range c {
+		if v, ok := src.Rate(fiat, asset); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// StaticRates is a RateSource with fixed prices keyed "ASSET/FIAT", e.g.
+// ENGINE_REFERENCE_RATES=USDT/RUB:95.3,USDT/KZT:480.
+type StaticRates map[string]float64
+
+// ParseStaticRates parses "USDT/RUB" -> "95.3" pairs.
+func ParseStaticRates(pairs map[string]string) (StaticRates, error) {
+	rates := make(StaticRates, len(pairs))
+	for pair, v := range pairs {
+		asset, fiat, ok := strings.Cut(pair, "/")
+		rate, err := strconv.ParseFloat(v, 64)
+		if !ok || err != nil || rate <= 0 {
+			return nil, fmt.Errorf("bad reference rate %s:%s, want ASSET/FIAT:price", pair, v)
+		}
+		rates[strings.ToUpper(asset)+"/"+strings.ToUpper(fiat)] = rate
+	}
+	return rates, nil
+}
+
+func (r StaticRates) Rate(fiat, asset string) (float64, bool) {
+	v, ok := r[strings.ToUpper(asset)+"/"+strings.ToUpper(fiat)]
+	return v, ok
+}
+
+// PaymentMargin is the outcome of one completed payment: fiat paid out,
+// out asset received (at the P2C rate, plus the reward) and what that is
+// worth at the reference rate.
+type PaymentMargin struct {
+	Payment   PaymentRef `json:"payment"`
+	AccountID int64      `json:"account_id"`
+	Brand     string     `json:"brand"`
+	Fiat      string     `json:"fiat"`
+	Asset     string     `json:"asset"`
+	Paid      float64    `json:"paid"`     // in_amount, fiat
+	Rate      float64    `json:"rate"`     // exchange_rate P2C
+	RefRate   float64    `json:"ref_rate"` // рыночный курс, 0 — неизвестен
+	Received  float64    `json:"received"` // out_asset: in_amount/rate + вознаграждение
+	Reward    float64    `json:"reward"`   // out_asset
+	Margin    float64    `json:"margin"`   // fiat: received*ref_rate - paid
+	MarginPct float64    `json:"margin_pct"`
+}
+
+// paymentMargin prices a record; ok is false without a usable rate.
+func paymentMargin(rec PaymentRecord, rates RateSource) (PaymentMargin, bool) {
+	pm := PaymentMargin{Payment: rec.Ref, AccountID: rec.AccountID, Brand: rec.BrandName, Fiat: rec.InAsset, Asset: rec.OutAsset, Reward: formatAmountWei(rec.FeeAmount)}
+	pm.Paid, _ = strconv.ParseFloat(rec.InAmount, 64)
+	pm.Rate, _ = strconv.ParseFloat(rec.ExchangeRate, 64)
+	if pm.Paid <= 0 || pm.Rate <= 0 {
+		return pm, false
+	}
+	pm.Received = pm.Paid/pm.Rate + pm.Reward
+	// курс на момент take, если его записали, иначе текущий
+	pm.RefRate = rec.RefRate
+	if pm.RefRate == 0 && rates != nil {
+		pm.RefRate, _ = rates.Rate(rec.InAsset, rec.OutAsset)
+	}
+	if pm.RefRate <= 0 {
+		return pm, false
+	}
+	pm.Margin = pm.Received*pm.RefRate - pm.Paid
+	pm.MarginPct = pm.Margin / pm.Paid * 100
+	return pm, true
+}
+
+// ProfitTotal sums the margins of a fiat, optionally of one brand.
+type ProfitTotal struct {
+	Fiat      string  `json:"fiat"`
+	Brand     string  `json:"brand,omitempty"`
+	Payments  int     `json:"payments"`
+	Losing    int     `json:"losing"` // с отрицательной маржой
+	Paid      float64 `json:"paid"`
+	Reward    float64 `json:"reward"`
+	Margin    float64 `json:"margin"`
+	MarginPct float64 `json:"margin_pct"` // margin / paid
+}
+
+func (t *ProfitTotal) add(pm PaymentMargin) {
+	t.Payments++
+	if pm.Margin < 0 {
+		t.Losing++
+	}
+	t.Paid += pm.Paid
+	t.Reward += pm.Reward
+	t.Margin += pm.Margin
+	t.MarginPct = t.Margin / t.Paid * 100
+}
+
+// ProfitReport is the profitability of completed payments over a period,
+// per fiat and per brand within a fiat. AccountID 0 aggregates all accounts.
+type ProfitReport struct {
+	AccountID int64           `json:"account_id,omitempty"`
+	Period    string          `json:"period"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Totals    []ProfitTotal   `json:"totals"`
+	Brands    []ProfitTotal   `json:"brands"`
+	Unpriced  int             `json:"unpriced"` // без курса: не вошли в итоги
+	Payments  []PaymentMargin `json:"payments,omitempty"`
+}
+
+// Profit reports the margins of completed payments of the tenant's accounts
+// (or of one account) for a period as in stats. details adds every payment.
+func (m *Manager) Profit(tenant string, accountID int64, period string, details bool) (ProfitReport, error) {
+	from, to, err := statsPeriod(period, time.Now())
+	if err != nil {
+		return ProfitReport{}, err
+	}
+	if period == "" {
+		period = "today"
+	}
+	start, _ := time.ParseInLocation(statsDayFormat, from, time.Local)
+	end, _ := time.ParseInLocation(statsDayFormat, to, time.Local)
+	recs := m.SearchPayments(PaymentQuery{Tenant: tenant, AccountID: accountID, Status: StateCompleted, From: start, To: end.AddDate(0, 0, 1)})
+
+	m.mu.Lock()
+	rates := m.rates
+	m.mu.Unlock()
+	report := ProfitReport{AccountID: accountID, Period: period, From: from, To: to}
+	totals := make(map[string]*ProfitTotal)
+	brands := make(map[string]*ProfitTotal)
+	for _, rec := range recs {
+		pm, ok := paymentMargin(rec, rates)
+		if !ok {
+			report.Unpriced++
+			continue
+		}
+		if details {
+			report.Payments = append(report.Payments, pm)
+		}
+		if totals[pm.Fiat] == nil {
+			totals[pm.Fiat] = &ProfitTotal{Fiat: pm.Fiat}
+		}
+		totals[pm.Fiat].add(pm)
+		key := pm.Fiat + "\x00" + pm.Brand
+		if brands[key] == nil {
+			brands[key] = &ProfitTotal{Fiat: pm.Fiat, Brand: pm.Brand}
+		}
+		brands[key].add(pm)
+	}
+	report.Totals = sortedTotals(totals)
+	report.Brands = sortedTotals(brands)
+	return report, nil
+}
+
+// sortedTotals orders by fiat, then the worst margin first, so losing
+// brands head the list.
+func sortedTotals(m map[string]*ProfitTotal) []ProfitTotal {
+	out := make([]ProfitTotal, 0, len(m))
+	for _, t := range m {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Fiat != out[b].Fiat {
+			return out[a].Fiat < out[b].Fiat
+		}
+		return out[a].MarginPct < out[b].MarginPct
+	})
+	return out
+}
+
+// SetReferenceRates sets the market prices margins and the market_edge_pct
+// filter are measured against; earlier sources win.
+func (m *Manager) SetReferenceRates(sources ...RateSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rates = chainRates(sources)
+}
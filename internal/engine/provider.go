@@ -0,0 +1,22 @@
+package engine
+
+import "strings"
+
+// checkProvider applies the account's provider allow/deny lists to a live
+// payment. Matching is case-insensitive; an empty allow list allows all.
+func checkProvider(cfg WorkerConfig, provider string) Decision {
+	for _, deny := range cfg.ProvidersDeny {
+		if strings.EqualFold(deny, provider) {
+			return skip(SkipProvider, "provider %q denied", provider)
+		}
+	}
+	if len(cfg.ProvidersAllow) == 0 {
+		return take()
+	}
+	for _, allow := range cfg.ProvidersAllow {
+		if strings.EqualFold(allow, provider) {
+			return take()
+		}
+	}
+	return skip(SkipProvider, "provider %q not allowed", provider)
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package engine
+
+// raceEnabled: под -race рантайм аллоцирует сам, бюджеты аллокаций не проверяем.
+const raceEnabled = true
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ocrTimeout  = 20 * time.Second
+	ocrMaxText  = 64 << 10
+	receiptTail = 4 // столько последних цифр реквизитов ищем в чеке
+)
+
+// ErrNoPayment is returned for a payment the account's journal does not know.
+var ErrNoPayment = errors.New("payment not found")
+
+// Receipt mismatch reasons.
+const (
+	ReceiptAmount     = "amount"     // суммы заявки в чеке нет
+	ReceiptRequisites = "requisites" // последних цифр реквизитов в чеке нет
+)
+
+// ReceiptCheck is the sanity check of a receipt the payer attached before
+// confirming: the order amount and the last digits of its requisites must
+// appear in the receipt text. It is a hint against wrong-amount payments,
+// not a proof of payment.
+type ReceiptCheck struct {
+	At       time.Time `json:"at"`
+	OCR      bool      `json:"ocr,omitempty"`      // текст распознан с изображения
+	Unread   bool      `json:"unread,omitempty"`   // текста нет: проверить нечем
+	Amount   string    `json:"amount"`             // сумма заявки
+	Found    []string  `json:"found,omitempty"`    // суммы, найденные в чеке
+	Tail     string    `json:"tail,omitempty"`     // последние цифры реквизитов, пусто — реквизиты неизвестны
+	Mismatch []string  `json:"mismatch,omitempty"` // ReceiptAmount, ReceiptRequisites
+}
+
+// OK reports whether the receipt matches the order.
+func (c ReceiptCheck) OK() bool {
+	return !c.Unread && len(c.Mismatch) == 0
+}
+
+// OCR turns a receipt image into text.
+type OCR interface {
+	Text(ctx context.Context, image []byte, contentType string) (string, error)
+}
+
+// httpOCR posts the image to an OCR service (e.g. a tesseract server) and
+// reads the recognized text from the response body.
+type httpOCR struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPOCR returns an OCR backed by the service at url.
+func NewHTTPOCR(url string) OCR {
+	return httpOCR{url: url, client: &http.Client{Timeout: ocrTimeout}}
+}
+
+func (o httpOCR) Text(ctx context.Context, image []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ocrMaxText))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("ocr status %d", resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// SetOCR enables reading receipt images; without it only the text sent
+// with the receipt is checked.
+func (m *Manager) SetOCR(o OCR) {
+	m.mu.Lock()
+	m.ocr = o
+	m.mu.Unlock()
+}
+
+// CheckReceipt checks a receipt of the order against its amount and
+// requisites and keeps the result on the payment. text is the caption or a
+// text receipt; image, if any, is read with the OCR.
+func (m *Manager) CheckReceipt(ctx context.Context, accountID int64, paymentID, text string, image []byte, contentType string) (ReceiptCheck, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return ReceiptCheck{}, ErrNoWorker
+	}
+	rec, ok := w.Payment(paymentID)
+	if !ok {
+		return ReceiptCheck{}, ErrNoPayment
+	}
+	m.mu.Lock()
+	ocr := m.ocr
+	m.mu.Unlock()
+	read := false
+	if len(image) > 0 && ocr != nil {
+		ctx, cancel := context.WithTimeout(ctx, ocrTimeout)
+		got, err := ocr.Text(ctx, image, contentType)
+		cancel()
+		if err != nil {
+			w.logf("receipt %s: ocr: %v", rec.Ref, err)
+		} else {
+			text += "\n" + got
+			read = true
+		}
+	}
+	c := checkReceipt(rec, text)
+	c.OCR = read
+	w.journal.setReceipt(rec.Ref, c)
+	w.logf("receipt %s: ok=%v found=%v mismatch=%v", rec.Ref, c.OK(), c.Found, c.Mismatch)
+	return c, nil
+}
+
+var reReceiptAmount = regexp.MustCompile(`\d{1,3}(?:[ \x{00a0}\x{202f}]\d{3})+(?:[.,]\d{1,2})?|\d+(?:[.,]\d{1,2})?`)
+
+// checkReceipt matches the receipt text with the order.
+func checkReceipt(rec PaymentRecord, text string) ReceiptCheck {
+	c := ReceiptCheck{At: time.Now(), Amount: rec.InAmount}
+	if strings.TrimSpace(text) == "" {
+		c.Unread = true
+		return c
+	}
+	want, err := strconv.ParseFloat(rec.InAmount, 64)
+	amountOK := err != nil // сумма заявки неизвестна — не с чем сверять
+	for _, s := range reReceiptAmount.FindAllString(text, -1) {
+		v, err := strconv.ParseFloat(strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", ",", ".").Replace(s), 64)
+		if err != nil || v < 1 {
+			continue
+		}
+		// в чеке полно чисел: номера операций, даты, счета — показываем только похожие на сумму
+		if len(c.Found) < 5 && (strings.ContainsAny(s, ".,") || math.Abs(v-want) < 0.01) {
+			c.Found = append(c.Found, s)
+		}
+		if math.Abs(v-want) < 0.01 {
+			amountOK = true
+		}
+	}
+	if !amountOK {
+		c.Mismatch = append(c.Mismatch, ReceiptAmount)
+	}
+	if c.Tail = requisitesTail(rec.PayInstructions()); c.Tail != "" {
+		// в телефоне цифры разбиты: +7 912 345-67-89
+		tail := `(^|\D)` + strings.Join(strings.Split(c.Tail, ""), `[\s-]?`) + `(\D|$)`
+		if !regexp.MustCompile(tail).MatchString(text) {
+			c.Mismatch = append(c.Mismatch, ReceiptRequisites)
+		}
+	}
+	return c
+}
+
+// requisitesTail returns the last digits of the card, phone or account the
+// order is paid to.
+func requisitesTail(pi PayInstructions) string {
+	for _, s := range []string{pi.Card, pi.Phone, pi.Account} {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, s)
+		if len(digits) >= receiptTail {
+			return digits[len(digits)-receiptTail:]
+		}
+	}
+	return ""
+}
+
+// setReceipt keeps the last receipt check of the payment.
+func (j *journal) setReceipt(ref PaymentRef, c ReceiptCheck) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec := j.find(ref); rec != nil {
+		rec.Receipt = &c
+		j.saver.changed()
+	}
+}
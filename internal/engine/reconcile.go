@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/p2c"
+)
+
+const (
+	reconcileTimeout  = 30 * time.Second
+	reconcilePages    = 5 // страниц processing-заявок по reconcilePageSize
+	reconcilePageSize = 50
+	reconcileWindow   = 48 * time.Hour // старее этого незакрытые записи журнала не сверяем
+)
+
+// reconcile runs once when the worker starts. It lists the payments the
+// account holds in processing on P2C and matches them with the journal:
+// unknown ones (taken before a restart without a store, or by hand) are
+// adopted, open records are settled to the status P2C reports, expiry
+// cancels are re-armed, and every order that still needs payment is posted
+// to the chat again with its buttons.
+func (w *Worker) reconcile(ctx context.Context) {
+	if w.client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+	defer cancel()
+
+	var processing []p2c.Payment
+	listed := true
+	cursor := ""
+	for page := 0; page < reconcilePages; page++ {
+		resp, err := w.client.ListPayments(ctx, p2c.ListPaymentsParams{Size: reconcilePageSize, Status: p2c.StatusProcessing, Cursor: cursor})
+		if err != nil {
+			w.logf("reconcile: list processing: %v", err)
+			listed = false
+			break
+		}
+		processing = append(processing, resp.Data...)
+		if resp.Cursor == "" || len(resp.Data) < reconcilePageSize {
+			break
+		}
+		cursor = resp.Cursor
+	}
+
+	now := time.Now()
+	var pending []PaymentRecord
+	known := make(map[string]bool)
+	for _, p := range processing {
+		if p.Status != p2c.StatusProcessing {
+			continue
+		}
+		rec, ok := w.journal.get(w.ids.resolve(p.IDString()))
+		if !ok {
+			rec = w.journal.adopt(w.accountID, p, now)
+			w.ids.store(rec.Ref.Hex, rec.Ref.Numeric)
+			w.logf("reconcile: adopted %s amount=%s %s", rec.Ref, p.AmountFiat, p.Fiat)
+		} else {
+			w.journal.settle(PaymentRef{Hex: rec.Ref.Hex, Numeric: p.NumericID()}, StateAwaitingPayment)
+		}
+		known[rec.Ref.Hex] = true
+		if rec, ok = w.journal.get(rec.Ref); ok && rec.Status.Open() {
+			pending = append(pending, rec)
+		}
+	}
+
+	// Открытые записи журнала, которых нет среди processing, уже закрылись на стороне P2C.
+	for _, rec := range w.journal.list() {
+		if known[rec.Ref.Hex] || now.Sub(rec.TakenAt) > reconcileWindow || !reconcilable(rec.Status) {
+			continue
+		}
+		if listed && rec.Status == StateTaking {
+			// take не дошёл до P2C: в processing заявки нет
+			if w.journal.settle(rec.Ref, StateTakeFailed) {
+				w.logf("reconcile: %s was never taken", rec.Ref)
+			}
+			continue
+		}
+		p, err := w.client.GetPayment(ctx, rec.Ref.APIID())
+		if errors.Is(err, p2c.ErrPaymentNotFound) {
+			continue
+		}
+		if err != nil {
+			w.logf("reconcile: get %s: %v", rec.Ref, err)
+			continue
+		}
+		to := stateOf(p.Status)
+		if !w.journal.settle(PaymentRef{Hex: rec.Ref.Hex, Numeric: p.NumericID()}, to) {
+			w.logf("reconcile: %s is %s on P2C, journal stays %s", rec.Ref, p.Status, rec.Status)
+			continue
+		}
+		w.logf("reconcile: %s settled as %s", rec.Ref, to)
+		if rec, ok := w.journal.get(rec.Ref); ok && to == StateAwaitingPayment && rec.Status.Open() {
+			pending = append(pending, rec)
+		}
+	}
+
+	for _, rec := range pending {
+		w.scheduleExpiry(rec.Ref, rec.ExpiresAt)
+		w.notifyPending(rec)
+	}
+	if len(pending) > 0 {
+		w.logf("reconcile: %d orders accepted before the start still need payment", len(pending))
+	}
+}
+
+// reconcilable reports whether a journal state may be stale after a
+// restart: the payment was taken or in a call that the restart interrupted.
+func reconcilable(s PaymentState) bool {
+	switch s {
+	case StateTaking, StateTaken, StateAwaitingPayment, StateExpired, StateCompleting, StateCancelling:
+		return true
+	}
+	return false
+}
+
+// stateOf maps a P2C payment status onto the journal lifecycle.
+func stateOf(s p2c.PaymentStatus) PaymentState {
+	switch s {
+	case p2c.StatusCompleted:
+		return StateCompleted
+	case p2c.StatusCanceled, p2c.StatusRefunded:
+		return StateCanceled
+	case p2c.StatusDisputed:
+		return StateDisputed
+	}
+	return StateAwaitingPayment
+}
+
+// notifyPending posts an order accepted before the start to the chat again,
+// with the paid and cancel buttons of a fresh take.
+func (w *Worker) notifyPending(rec PaymentRecord) {
+	cfg := w.config()
+	id := rec.Ref.Hex
+	if id == "" {
+		id = rec.Ref.APIID()
+	}
+	p := p2c.LivePayment{
+		ID:           id,
+		URL:          rec.URL,
+		Provider:     rec.Provider,
+		Payload:      rec.Payload,
+		BrandName:    rec.BrandName,
+		InAsset:      rec.InAsset,
+		OutAsset:     rec.OutAsset,
+		InAmount:     rec.InAmount,
+		ExchangeRate: rec.ExchangeRate,
+		FeeAmount:    rec.FeeAmount,
+		ExpiresAt:    rec.ExpiresAt,
+	}
+	caption := buildLiveCaption(w.render, cfg.account(), p, rec.Ref, i18n.T(cfg.Locale, "live.pending_restart"))
+	markup := buildPaidKeyboard(cfg.Locale, cfg.AccountID, p, w.web.URL(cfg.AccountID, id), cfg.PaidOneTap)
+	// тот же ключ, что у карточки взятия: не доставленная до рестарта заменяется этой,
+	// а доставленная не дублируется — в чате она уже есть и правится на месте
+	w.send(Notification{Text: caption, Markup: markup, Sent: w.cardSent(rec.Ref, 0), Key: orderKey(w.accountID, rec.Ref), Until: orderUntil(rec.ExpiresAt, w.clock), Dedup: dedupKey(EventTaken, w.accountID, rec.Ref)})
+}
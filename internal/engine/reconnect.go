@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+const (
+	wsBackoffMin   = time.Second
+	wsBackoffMax   = time.Minute
+	wsHealthyAfter = time.Minute // сеанс, проживший столько, сбрасывает паузу
+	wsRestartDelay = time.Second // пауза после 1012: сервер перезапускается
+	wsRestartFast  = 3           // столько 1012 подряд переподключаемся без backoff
+)
+
+// reconnectBackoff spaces the reconnects of the websocket: the pause doubles
+// after every session that failed or died young, up to wsBackoffMax, and
+// drops back once a session stays up for wsHealthyAfter. Pauses are
+// jittered so the accounts of the engine do not reconnect in step after an
+// outage.
+type reconnectBackoff struct {
+	attempt  int
+	restarts int // 1012 подряд
+}
+
+// next returns the pause before the next connect after a session that ran
+// for up (healthy if it got connected) and ended with err.
+func (b *reconnectBackoff) next(up time.Duration, connected bool, err error) time.Duration {
+	if connected && up >= wsHealthyAfter {
+		b.attempt, b.restarts = 0, 0
+	}
+	if p2c.IsServiceRestart(err) && b.restarts < wsRestartFast {
+		// сервер сам просит вернуться; счёт backoff не трогаем — если он не поднимется, пауза продолжит расти
+		b.restarts++
+		return jitter(wsRestartDelay)
+	}
+	d := wsBackoffMax
+	if b.attempt < 6 { // 1s << 6 уже больше минуты
+		d = min(wsBackoffMin<<b.attempt, wsBackoffMax)
+		b.attempt++
+	}
+	return jitter(d)
+}
+
+// jitter spreads d over [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int64N(half+1))
+}
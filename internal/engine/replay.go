@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// SetCaptureDir makes every worker write its raw websocket frames to
+// dir/<account>-<start time>.jsonl, the input of p2c-replay. Applies to
+// workers started afterwards.
+func (m *Manager) SetCaptureDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captureDir = dir
+}
+
+// openCapture creates the recording of this worker run; nil when capture
+// is off or the file cannot be created.
+func (w *Worker) openCapture() *p2c.Recorder {
+	if w.captureDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(w.captureDir, 0o755); err != nil {
+		w.logf("ws capture: %v", err)
+		return nil
+	}
+	path := filepath.Join(w.captureDir, fmt.Sprintf("%d-%s.jsonl", w.accountID, time.Now().UTC().Format("20060102-150405")))
+	f, err := os.Create(path)
+	if err != nil {
+		w.logf("ws capture: %v", err)
+		return nil
+	}
+	w.logf("ws capture to %s", path)
+	return p2c.NewRecorder(f)
+}
+
+// ReplayDecision is what the account would have done with one payment of
+// a recorded stream.
+type ReplayDecision struct {
+	At       time.Time
+	ID       string
+	Amount   string
+	Provider string
+	Take     bool
+	Code     SkipCode
+	Reason   string // причина пропуска
+}
+
+// Replay runs a recorded stream through the filters and strategy of cfg
+// without touching P2C: a payment that passes counts as taken, holds the
+// account until it leaves the list and is accounted against the daily cap,
+// like a real take would. Decisions are reported to fn in stream order;
+// speed is as in p2c.Replay. Penalties, the arbiter and replicas are not
+// simulated.
+func Replay(ctx context.Context, cfg WorkerConfig, r io.Reader, speed float64, fn func(ReplayDecision)) error {
+	strategy, err := newStrategy(cfg, nil)
+	if err != nil {
+		return err
+	}
+	w := &Worker{cfg: cfg, strategy: strategy}
+	defer func() {
+		if c, ok := strategy.(strategyCloser); ok {
+			c.Close()
+		}
+	}()
+	seen := make(map[string]bool)
+	active, synced := "", false
+	decide := func(p p2c.LivePayment) {
+		if seen[p.ID] {
+			return
+		}
+		seen[p.ID] = true
+		d := w.filter(p, p.ReceivedAt)
+		if d.Take && active != "" {
+			d = skip(SkipActive, "active order in progress")
+		}
+		if d.Take {
+			active = p.ID
+			w.addDayVolume(p.InAmount, p.ReceivedAt)
+		}
+		fn(ReplayDecision{At: p.ReceivedAt, ID: p.ID, Amount: p.InAmount, Provider: p.Provider, Take: d.Take, Code: d.Code, Reason: d.Reason})
+	}
+	var at time.Time
+	h := p2c.SocketHandlers{
+		OnFrame: func(t time.Time, _ []byte) { at = t },
+		OnAdd:   decide,
+		OnRemove: func(id string) {
+			if id == active {
+				active = ""
+			}
+		},
+		OnSnapshot: func(list []p2c.LivePayment) {
+			// первый список — то, что висело до начала записи; после
+			// переподключений это бэкфилл пропущенного, как в handleSnapshot
+			if !synced {
+				synced = true
+				for _, p := range list {
+					seen[p.ID] = true
+				}
+				return
+			}
+			for _, p := range list {
+				p.ReceivedAt = at
+				decide(p)
+			}
+		},
+	}
+	return p2c.Replay(ctx, r, h, speed)
+}
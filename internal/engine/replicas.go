@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"p2c-engine/internal/engine/cache"
+	"p2c-engine/internal/redis"
+)
+
+// seenSet deduplicates payment ids: *cache.TTL inside one engine,
+// redisSeen across replicas.
+type seenSet interface {
+	Add(key string, now time.Time) bool
+	Has(key string, now time.Time) bool
+}
+
+// redisTimeout bounds Redis calls on the take path: a slow Redis must not
+// cost us the payment.
+const redisTimeout = 150 * time.Millisecond
+
+// redisSeen is a seen set shared by engine replicas: SET NX with the seen
+// TTL. The local set answers repeated frames without a round trip. When
+// Redis is unavailable the payment counts as new — the active-order lock
+// and P2C itself still prevent a double take.
+type redisSeen struct {
+	rdb    *redis.Client
+	prefix string
+	local  *cache.TTL
+}
+
+func (s *redisSeen) Add(key string, now time.Time) bool {
+	if !s.local.Add(key, now) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	reply, err := s.rdb.Do(ctx, "SET", s.prefix+key, "1", "NX", "PX", strconv.FormatInt(seenTTL.Milliseconds(), 10))
+	if err != nil {
+		log.Printf("[redis] seen %s: %v", key, err)
+		return true
+	}
+	return reply == "OK"
+}
+
+func (s *redisSeen) Has(key string, now time.Time) bool {
+	return s.local.Has(key, now)
+}
+
+// releaseScript deletes the lock only if it still holds our payment.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// activeRegistry is the per-account active-order lock shared by replicas:
+// a replica takes a payment only after it set the account key to the
+// payment id. The key lives until the payment stops holding the account or
+// its deadline passes.
+type activeRegistry struct {
+	rdb *redis.Client
+}
+
+func activeKey(accountID int64) string {
+	return "p2c:active:" + strconv.FormatInt(accountID, 10)
+}
+
+// acquire locks the account for the payment; holder is the payment that
+// holds the lock when ok is false. Redis errors do not block the take.
+func (r *activeRegistry) acquire(accountID int64, paymentID string, ttl time.Duration) (holder string, ok bool) {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key := activeKey(accountID)
+	reply, err := r.rdb.Do(ctx, "SET", key, paymentID, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		log.Printf("[redis] active lock account=%d: %v", accountID, err)
+		return "", true
+	}
+	if reply == "OK" {
+		return "", true
+	}
+	cur, _ := r.rdb.Do(ctx, "GET", key)
+	holder, _ = cur.(string)
+	return holder, holder == paymentID
+}
+
+// release unlocks the account if the lock belongs to the payment.
+func (r *activeRegistry) release(accountID int64, paymentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := r.rdb.Do(ctx, "EVAL", releaseScript, "1", activeKey(accountID), paymentID); err != nil {
+		log.Printf("[redis] release account=%d payment=%s: %v", accountID, paymentID, err)
+	}
+}
+
+// EnableRedis shares the seen set and the per-account active-order lock
+// with other replicas through Redis at rawURL (redis://host:port/db).
+func (m *Manager) EnableRedis(rawURL string) error {
+	rdb, err := redis.New(rawURL, 16)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx); err != nil {
+		return fmt.Errorf("redis ping: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redis = rdb
+	m.registry = &activeRegistry{rdb: rdb}
+	for _, w := range m.workers {
+		w.seen = m.seenLocked(w.config())
+		w.claims = m.claimsLocked(w.config())
+		w.setRegistry(m.registry)
+	}
+	return nil
+}
+
+// seenLocked returns the seen set for a new worker of cfg, backed by Redis
+// when it is enabled; nil keeps the worker's own set. m.mu must be held.
+func (m *Manager) seenLocked(cfg WorkerConfig) seenSet {
+	if m.redis == nil {
+		return nil
+	}
+	return &redisSeen{rdb: m.redis, prefix: fmt.Sprintf("p2c:seen:a%d:", cfg.AccountID), local: cache.NewTTL(seenTTL)}
+}
+
+// claimsLocked returns the tenant's claimed payments for a worker of cfg
+// with shared dedup, backed by Redis when it is enabled; nil without shared
+// dedup. m.mu must be held.
+func (m *Manager) claimsLocked(cfg WorkerConfig) seenSet {
+	shared := m.sharedSeenLocked(cfg.TenantID)
+	if shared == nil {
+		return nil
+	}
+	if m.redis == nil {
+		return shared
+	}
+	return &redisSeen{rdb: m.redis, prefix: "p2c:claim:t" + cfg.TenantID + ":", local: shared}
+}
+
+// setRegistry makes the worker lock its account in the shared registry
+// before every take.
+func (w *Worker) setRegistry(r *activeRegistry) {
+	w.registry = r
+	w.journal.onRelease(func(ref PaymentRef) {
+		id := ref.Hex
+		if id == "" {
+			id = ref.APIID()
+		}
+		r.release(w.accountID, id)
+	})
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"log"
+
+	"p2c-engine/internal/p2c"
+)
+
+// Requisites lists the account's payout requisites.
+func (m *Manager) Requisites(ctx context.Context, accountID int64) ([]p2c.Requisite, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, ErrNoWorker
+	}
+	return w.client.ListRequisites(ctx)
+}
+
+// AddRequisite creates a payout requisite for the account.
+func (m *Manager) AddRequisite(ctx context.Context, accountID int64, r p2c.NewRequisite) (*p2c.Requisite, error) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, ErrNoWorker
+	}
+	added, err := w.client.AddRequisite(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[worker %d] requisite %s added (%s)", accountID, added.ID, added.Type)
+	return added, nil
+}
+
+// SetRequisiteEnabled enables or disables a requisite, e.g. to rotate away
+// from a blocked card.
+func (m *Manager) SetRequisiteEnabled(ctx context.Context, accountID int64, requisiteID string, enabled bool) error {
+	w := m.worker(accountID)
+	if w == nil {
+		return ErrNoWorker
+	}
+	if err := w.client.SetRequisiteEnabled(ctx, requisiteID, enabled); err != nil {
+		return err
+	}
+	log.Printf("[worker %d] requisite %s enabled=%v", accountID, requisiteID, enabled)
+	return nil
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PaymentQuery filters the payment history of all accounts; zero fields
+// match anything.
+type PaymentQuery struct {
+	Tenant    string  // видны только аккаунты этого тенанта
+	AccountID int64   // только этот аккаунт, 0 — все
+	Amount    float64 // сумма in_amount
+	Fiat      string  // in_asset, без учёта регистра
+	Status    PaymentState
+	Brand     string    // подстрока brand_name, без учёта регистра
+	From      time.Time // taken_at >= From
+	To        time.Time // taken_at < To
+	Limit     int
+}
+
+func (q PaymentQuery) match(rec PaymentRecord) bool {
+	if q.Amount != 0 {
+		v, err := strconv.ParseFloat(rec.InAmount, 64)
+		if err != nil || math.Abs(v-q.Amount) >= 0.005 {
+			return false
+		}
+	}
+	if q.Fiat != "" && !strings.EqualFold(rec.InAsset, q.Fiat) {
+		return false
+	}
+	if q.Status != "" && rec.Status != q.Status {
+		return false
+	}
+	if q.Brand != "" && !strings.Contains(strings.ToLower(rec.BrandName), strings.ToLower(q.Brand)) {
+		return false
+	}
+	if !q.From.IsZero() && rec.TakenAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !rec.TakenAt.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// SearchPayments finds payments in the journals of every account the tenant
+// owns, newest first: running workers answer from memory, the rest (other
+// instances, stopped accounts) from the store.
+func (m *Manager) SearchPayments(q PaymentQuery) []PaymentRecord {
+	journals := make(map[int64][]PaymentRecord)
+	m.mu.Lock()
+	workers := make([]*Worker, 0, len(m.workers))
+	for _, w := range m.workers {
+		workers = append(workers, w)
+	}
+	m.mu.Unlock()
+	for _, w := range workers {
+		journals[w.accountID] = w.journal.list()
+	}
+	names, err := m.store.List("payments")
+	if err != nil {
+		log.Printf("[search] list journals: %v", err)
+	}
+	for _, name := range names {
+		id, err := strconv.ParseInt(strings.TrimPrefix(name, "payments/"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := journals[id]; ok || (q.AccountID != 0 && id != q.AccountID) {
+			continue
+		}
+		var saved []PaymentRecord
+		if err := m.store.Load(name, &saved); err != nil {
+			log.Printf("[search] load %s: %v", name, err)
+			continue
+		}
+		journals[id] = saved
+	}
+
+	var out []PaymentRecord
+	for id, recs := range journals {
+		if (q.AccountID != 0 && id != q.AccountID) || m.AccountTenant(id) != q.Tenant {
+			continue
+		}
+		for _, rec := range recs {
+			if q.match(rec) {
+				out = append(out, rec)
+			}
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].TakenAt.After(out[b].TakenAt) })
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrShuttingDown is returned for operations refused while the engine drains.
+var ErrShuttingDown = errors.New("engine is shutting down")
+
+// inflight counts operations that must finish before a worker is stopped:
+// takes, complete/cancel calls and Telegram notifications of taken orders.
+type inflight struct {
+	mu       sync.Mutex
+	n        int
+	draining bool
+	noTakes  bool // новые взятия запрещены, complete/cancel ещё идут
+	idle     chan struct{}
+}
+
+// begin registers a new operation; it fails once draining has started.
+func (f *inflight) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.n++
+	return true
+}
+
+// beginTake is begin for a new take, which is refused once intake stopped.
+func (f *inflight) beginTake() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining || f.noTakes {
+		return false
+	}
+	f.n++
+	return true
+}
+
+// stopIntake refuses new takes; running ones and other operations go on.
+func (f *inflight) stopIntake() {
+	f.mu.Lock()
+	f.noTakes = true
+	f.mu.Unlock()
+}
+
+// follow registers work spawned by a running operation (e.g. the
+// notification of a take), so it is accepted even while draining.
+func (f *inflight) follow() {
+	f.mu.Lock()
+	f.n++
+	f.mu.Unlock()
+}
+
+func (f *inflight) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	if f.n == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+// drain refuses new operations and waits for running ones until ctx ends.
+func (f *inflight) drain(ctx context.Context) (pending int, err error) {
+	f.mu.Lock()
+	f.draining = true
+	if f.n == 0 {
+		f.mu.Unlock()
+		return 0, nil
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.mu.Unlock()
+	select {
+	case <-idle:
+		return 0, nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.n, ctx.Err()
+	}
+}
+
+// Drain stops taking new payments and waits for in-flight takes,
+// complete/cancel calls and pending notifications, then stops the worker.
+func (w *Worker) Drain(ctx context.Context) {
+	start := time.Now()
+	if pending, err := w.inflight.drain(ctx); err != nil {
+		w.logf("grace period over, %d operations still running", pending)
+	} else {
+		w.logf("drained in %dms", time.Since(start).Milliseconds())
+	}
+	w.Stop()
+}
+
+// StopIntake makes every worker, and any started later, refuse new takes,
+// automatic and manual, while complete/cancel calls and takes already under
+// way go on. It is the first step of a shutdown: the HTTP server is then
+// drained with the engine still able to serve it, and Shutdown comes last.
+func (m *Manager) StopIntake() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.noIntake = true
+	for _, w := range m.workers {
+		w.inflight.stopIntake()
+	}
+}
+
+// Shutdown drains all workers in parallel within ctx and stops them. Their
+// leases are released, so other instances take the accounts over at once.
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, w := range m.workers {
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			w.Drain(ctx)
+			if m.leases != nil {
+				m.leases.release(w.accountID)
+			}
+		}(w)
+	}
+	wg.Wait()
+	m.workers = make(map[int64]*Worker)
+	m.publishWorkers()
+	m.saveTLSSessions()
+	m.jobs.Close()
+	flushTelegram(ctx)
+	flushWebhooks(ctx)
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/i18n"
+)
+
+// SkipCode is the kind of reason a payment was not taken.
+type SkipCode string
+
+// Skip reasons.
+const (
+	SkipBelowMin      SkipCode = "below_min"
+	SkipAboveMax      SkipCode = "above_max"
+	SkipProvider      SkipCode = "provider"       // провайдер запрещён или не в списке разрешённых
+	SkipBrand         SkipCode = "brand"          // у бренда много споров или отмен, см. brand_guard
+	SkipAmountPattern SkipCode = "amount_pattern" // сумма не подходит под шаблоны
+	SkipBoost         SkipCode = "boost"
+	SkipRate          SkipCode = "rate"
+	SkipMarket        SkipCode = "market"      // курс хуже рыночного или рыночный неизвестен
+	SkipRoundRobin    SkipCode = "round_robin" // очередь другого аккаунта
+	SkipDailyCap      SkipCode = "daily_cap"
+	SkipPaused        SkipCode = "paused"
+	SkipMaintenance   SkipCode = "maintenance" // режим работ движка
+	SkipBlackout      SkipCode = "blackout"    // окно календаря без авто-взятия
+	SkipActive        SkipCode = "active"      // у аккаунта неоплаченная заявка
+	SkipPenalty       SkipCode = "penalty"
+	SkipBreaker       SkipCode = "breaker"
+	SkipArbitrated    SkipCode = "arbitrated" // отдана другому нашему аккаунту
+	SkipHandoff       SkipCode = "handoff"    // отпущена для перехвата другим аккаунтом
+	SkipOwnerGroup    SkipCode = "owner_group"
+	SkipReplica       SkipCode = "replica" // аккаунт занят на другой реплике
+	SkipClaimed       SkipCode = "claimed" // её берёт другой аккаунт тенанта (общий dedup)
+	SkipShutdown      SkipCode = "shutdown"
+	SkipGone          SkipCode = "gone" // ушла из списка за время паузы перед take
+	SkipOther         SkipCode = "other"
+)
+
+const (
+	skipLogSize      = 2000 // сколько последних пропусков помнит воркер
+	skipSummaryEvery = time.Hour
+)
+
+// SkipRecord is one skip decision.
+type SkipRecord struct {
+	At        time.Time `json:"at"`
+	PaymentID string    `json:"payment_id"`
+	Amount    string    `json:"amount,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Code      SkipCode  `json:"code"`
+	Reason    string    `json:"reason"`
+}
+
+// SkipQuery selects skips; zero fields match everything.
+type SkipQuery struct {
+	PaymentID string
+	Code      SkipCode
+	Since     time.Time
+	Limit     int // самые свежие, 0 — все
+}
+
+// skipLog keeps the last skips of a worker, like frameRing does for frames,
+// and counts all of them by code for the summary: the ring may not hold a
+// busy hour.
+type skipLog struct {
+	mu     sync.Mutex
+	buf    []SkipRecord
+	next   int
+	full   bool
+	counts map[SkipCode]int // с прошлой сводки
+}
+
+func newSkipLog(size int) *skipLog {
+	return &skipLog{buf: make([]SkipRecord, size), counts: make(map[SkipCode]int)}
+}
+
+func (l *skipLog) add(r SkipRecord) {
+	if r.Code == "" {
+		r.Code = SkipOther
+	}
+	l.mu.Lock()
+	l.counts[r.Code]++
+	l.buf[l.next] = r
+	l.next++
+	if l.next == len(l.buf) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// find returns the matching skips, newest first.
+func (l *skipLog) find(q SkipQuery) []SkipRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []SkipRecord
+	n := l.next
+	if l.full {
+		n = len(l.buf)
+	}
+	for i := 1; i <= n; i++ {
+		r := l.buf[(l.next-i+len(l.buf))%len(l.buf)]
+		if r.At.Before(q.Since) {
+			break
+		}
+		if (q.PaymentID != "" && r.PaymentID != q.PaymentID) || (q.Code != "" && r.Code != q.Code) {
+			continue
+		}
+		out = append(out, r)
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// drain returns the counts since the previous call and resets them.
+func (l *skipLog) drain() map[SkipCode]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := l.counts
+	l.counts = make(map[SkipCode]int)
+	return out
+}
+
+// Skips returns the account's recent skip decisions, newest first.
+func (w *Worker) Skips(q SkipQuery) []SkipRecord {
+	return w.skips.find(q)
+}
+
+// Skips returns the recent skip decisions of an account.
+func (m *Manager) Skips(accountID int64, q SkipQuery) ([]SkipRecord, bool) {
+	w := m.worker(accountID)
+	if w == nil {
+		return nil, false
+	}
+	return w.Skips(q), true
+}
+
+// skipSummary renders "skipped 14: 9 below min, 3 provider, 2 penalty".
+func skipSummary(locale string, account AccountInfo, counts map[SkipCode]int) string {
+	codes := make([]SkipCode, 0, len(counts))
+	total := 0
+	for c, n := range counts {
+		codes = append(codes, c)
+		total += n
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	parts := make([]string, len(codes))
+	for i, c := range codes {
+		parts[i] = fmt.Sprintf("%d %s", counts[c], i18n.T(locale, "skip."+string(c)))
+	}
+	return i18n.T(locale, "skip.summary", account, total, strings.Join(parts, ", "))
+}
+
+// runSkipSummary sends the hourly skip summary while SkipSummary is on.
+func (w *Worker) runSkipSummary(ctx context.Context) {
+	ticker := time.NewTicker(skipSummaryEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		counts := w.skips.drain()
+		cfg := w.config()
+		if !cfg.SkipSummary || len(counts) == 0 {
+			continue
+		}
+		w.notify(skipSummary(cfg.Locale, cfg.account(), counts))
+	}
+}
@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+const (
+	statsDayFormat = "2006-01-02"
+	statsKeepDays  = 90
+)
+
+// DayStats aggregates one account's activity over a local calendar day.
+type DayStats struct {
+	Day        string  `json:"day"`
+	Seen       int     `json:"seen"`
+	Taken      int     `json:"taken"`
+	TakeFailed int     `json:"take_failed"`
+	Completed  int     `json:"completed"`
+	Canceled   int     `json:"canceled"`
+	Volume     float64 `json:"volume"` // fiat volume of taken payments
+	Reward     float64 `json:"reward"` // fee of completed payments, out asset
+	TakeMsSum  int64   `json:"take_ms_sum"`
+}
+
+// AvgTakeMs is the mean latency of successful takes.
+func (d DayStats) AvgTakeMs() float64 {
+	if d.Taken == 0 {
+		return 0
+	}
+	return float64(d.TakeMsSum) / float64(d.Taken)
+}
+
+// WinRate is the share of seen payments we managed to take.
+func (d DayStats) WinRate() float64 {
+	if d.Seen == 0 {
+		return 0
+	}
+	return float64(d.Taken) / float64(d.Seen)
+}
+
+func (d *DayStats) add(o DayStats) {
+	d.Seen += o.Seen
+	d.Taken += o.Taken
+	d.TakeFailed += o.TakeFailed
+	d.Completed += o.Completed
+	d.Canceled += o.Canceled
+	d.Volume += o.Volume
+	d.Reward += o.Reward
+	d.TakeMsSum += o.TakeMsSum
+}
+
+// StatsReport is the answer of GET /accounts/{id}/stats.
+type StatsReport struct {
+	AccountID int64      `json:"account_id"`
+	Period    string     `json:"period"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Total     DayStats   `json:"total"`
+	AvgTakeMs float64    `json:"avg_take_ms"`
+	WinRate   float64    `json:"win_rate"`
+	Days      []DayStats `json:"days"`
+}
+
+// statsBook keeps per-day counters of a worker, persisted when a store is set.
+type statsBook struct {
+	mu    sync.Mutex
+	days  map[string]*DayStats
+	saver *docSaver
+}
+
+func newStatsBook() *statsBook {
+	return &statsBook{days: make(map[string]*DayStats)}
+}
+
+func (b *statsBook) attach(st *store.Store, accountID int64) {
+	if st == nil {
+		return
+	}
+	doc := fmt.Sprintf("stats/%d", accountID)
+	var saved []DayStats
+	if err := st.Load(doc, &saved); err != nil {
+		log.Printf("[stats %d] load error: %v", accountID, err)
+	}
+	b.mu.Lock()
+	for i := range saved {
+		d := saved[i]
+		b.days[d.Day] = &d
+	}
+	b.mu.Unlock()
+	// seen считается на каждую заявку ленты — пишем не чаще раза в 10 секунд
+	b.saver = newDocSaver(st, doc, 10*time.Second, func() any { return b.snapshot() })
+}
+
+func (b *statsBook) close() {
+	b.saver.close()
+}
+
+// record applies fn to the counters of the day of at.
+func (b *statsBook) record(at time.Time, fn func(d *DayStats)) {
+	key := at.Format(statsDayFormat)
+	b.mu.Lock()
+	d, ok := b.days[key]
+	if !ok {
+		d = &DayStats{Day: key}
+		b.days[key] = d
+		b.trimLocked(at)
+	}
+	fn(d)
+	b.mu.Unlock()
+	b.saver.changed()
+}
+
+func (b *statsBook) trimLocked(now time.Time) {
+	oldest := now.AddDate(0, 0, -statsKeepDays).Format(statsDayFormat)
+	for key := range b.days {
+		if key < oldest {
+			delete(b.days, key)
+		}
+	}
+}
+
+func (b *statsBook) snapshot() []DayStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]DayStats, 0, len(b.days))
+	for _, d := range b.days {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out
+}
+
+// rangeStats returns the days within [from, to] (inclusive, day keys).
+func (b *statsBook) rangeStats(from, to string) ([]DayStats, DayStats) {
+	var days []DayStats
+	var total DayStats
+	for _, d := range b.snapshot() {
+		if d.Day < from || d.Day > to {
+			continue
+		}
+		days = append(days, d)
+		total.add(d)
+	}
+	return days, total
+}
+
+func (w *Worker) statSeen(at time.Time) {
+	w.stats.record(at, func(d *DayStats) { d.Seen++ })
+}
+
+func (w *Worker) statTaken(at time.Time, amount string, take time.Duration) {
+	v, _ := strconv.ParseFloat(amount, 64)
+	w.stats.record(at, func(d *DayStats) {
+		d.Taken++
+		d.Volume += v
+		d.TakeMsSum += take.Milliseconds()
+	})
+}
+
+func (w *Worker) statTakeFailed(at time.Time) {
+	w.stats.record(at, func(d *DayStats) { d.TakeFailed++ })
+}
+
+func (w *Worker) statFinished(at time.Time, ref PaymentRef, completed bool) {
+	reward := 0.0
+	if rec, ok := w.journal.get(ref); ok && completed {
+		reward = formatAmountWei(rec.FeeAmount)
+	}
+	w.stats.record(at, func(d *DayStats) {
+		if completed {
+			d.Completed++
+			d.Reward += reward
+		} else {
+			d.Canceled++
+		}
+	})
+}
+
+// statsPeriod maps a period name onto an inclusive day range:
+// today, yesterday, 7d, 30d, YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD.
+func statsPeriod(period string, now time.Time) (from, to string, err error) {
+	today := now.Format(statsDayFormat)
+	switch period {
+	case "", "today":
+		return today, today, nil
+	case "yesterday":
+		y := now.AddDate(0, 0, -1).Format(statsDayFormat)
+		return y, y, nil
+	}
+	if n, ok := strings.CutSuffix(period, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days < 1 || days > statsKeepDays {
+			return "", "", fmt.Errorf("bad period %q", period)
+		}
+		return now.AddDate(0, 0, 1-days).Format(statsDayFormat), today, nil
+	}
+	from, to, isRange := strings.Cut(period, "..")
+	if !isRange {
+		to = from
+	}
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse(statsDayFormat, d); err != nil {
+			return "", "", fmt.Errorf("bad period %q", period)
+		}
+	}
+	return from, to, nil
+}
+
+// Stats aggregates the account's statistics for a period (see statsPeriod).
+func (w *Worker) Stats(period string) (StatsReport, error) {
+	from, to, err := statsPeriod(period, time.Now())
+	if err != nil {
+		return StatsReport{}, err
+	}
+	days, total := w.stats.rangeStats(from, to)
+	if period == "" {
+		period = "today"
+	}
+	return StatsReport{
+		AccountID: w.accountID,
+		Period:    period,
+		From:      from,
+		To:        to,
+		Total:     total,
+		AvgTakeMs: total.AvgTakeMs(),
+		WinRate:   total.WinRate(),
+		Days:      days,
+	}, nil
+}
+
+// RunDailyReports sends each account's summary to its chat every day at the
+// local time at ("HH:MM") until ctx is done.
+func (m *Manager) RunDailyReports(ctx context.Context, at string) error {
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return fmt.Errorf("bad report time %q: %w", at, err)
+	}
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+			day := next.Format(statsDayFormat)
+			for _, w := range m.snapshotWorkers() {
+				report, err := w.Stats(day)
+				if err != nil || w.config().ChatID == 0 {
+					continue
+				}
+				data := dailySummaryData{AccountID: w.accountID, Account: w.config().account(), Report: report}
+				if profit, err := m.Profit(m.AccountTenant(w.accountID), w.accountID, day, false); err == nil {
+					data.Profit = profit.Totals
+				}
+				w.notify(w.render(tmplDailySummary, data))
+			}
+		}
+	}()
+	return nil
+}
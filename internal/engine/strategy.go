@@ -0,0 +1,468 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// Decision is a strategy verdict for a live payment.
+type Decision struct {
+	Take   bool
+	Code   SkipCode // why the payment was skipped, for counting
+	Reason string   // the same with the numbers, for people
+}
+
+func take() Decision { return Decision{Take: true} }
+func skip(code SkipCode, format string, args ...any) Decision {
+	return Decision{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Strategy decides whether a worker should try to take a live payment.
+type Strategy interface {
+	Evaluate(p p2c.LivePayment) Decision
+}
+
+// strategyCloser is implemented by strategies holding shared state that must
+// be released when the worker stops.
+type strategyCloser interface {
+	Close()
+}
+
+// StrategyParams are free-form strategy parameters from the reload request.
+type StrategyParams map[string]any
+
+// Float returns a numeric parameter, accepting JSON numbers and numeric strings.
+func (p StrategyParams) Float(key string, def float64) float64 {
+	switch v := p[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// String returns a string parameter.
+func (p StrategyParams) String(key, def string) string {
+	if v, ok := p[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// StrategyConfig selects a strategy by name.
+type StrategyConfig struct {
+	Name   string         `json:"name"`
+	Params StrategyParams `json:"params,omitempty"`
+}
+
+type strategyFactory func(params StrategyParams, cfg WorkerConfig, rr *rrBook) Strategy
+
+// Strategy names.
+const (
+	StrategyTakeAll     = "take-all"
+	StrategyAmountBand  = "amount-band"
+	StrategyTopBoost    = "top-boost-first"
+	StrategyRate        = "rate-threshold"
+	StrategyRoundRobin  = "round-robin-across-accounts"
+	defaultStrategyName = StrategyAmountBand
+)
+
+var strategies = map[string]strategyFactory{
+	StrategyTakeAll:    alone(func(StrategyParams, WorkerConfig) Strategy { return takeAll{} }),
+	StrategyAmountBand: alone(newAmountBand),
+	StrategyTopBoost:   alone(newTopBoost),
+	StrategyRate:       alone(newRateThreshold),
+	StrategyRoundRobin: newRoundRobin,
+}
+
+// alone adapts a strategy that shares no state between accounts.
+func alone(f func(StrategyParams, WorkerConfig) Strategy) strategyFactory {
+	return func(params StrategyParams, cfg WorkerConfig, _ *rrBook) Strategy { return f(params, cfg) }
+}
+
+// HasStrategy reports whether name is a known strategy ("" means default).
+func HasStrategy(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := strategies[name]
+	return ok
+}
+
+// newStrategy builds the strategy selected in cfg; round-robin joins its
+// group in rr, nil keeps it to itself (preview, replay).
+func newStrategy(cfg WorkerConfig, rr *rrBook) (Strategy, error) {
+	name := cfg.Strategy.Name
+	if name == "" {
+		name = defaultStrategyName
+	}
+	factory, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+	return factory(cfg.Strategy.Params, cfg, rr), nil
+}
+
+// takeAll takes every payment.
+type takeAll struct{}
+
+func (takeAll) Evaluate(p2c.LivePayment) Decision { return take() }
+
+// amountBand takes payments with in_amount inside [min, max], worth in
+// out_asset inside [min_out, max_out] and, when min_boost is set, boost
+// above min_boost; params override the account limits.
+type amountBand struct {
+	min, max       float64
+	minOut, maxOut float64 // в out_asset (USDT), не зависят от фиата заявки
+	minBoost       float64
+	boosted        bool // min_boost задан: без него берём и заявки без буста
+}
+
+func newAmountBand(params StrategyParams, cfg WorkerConfig) Strategy {
+	return amountBand{
+		min:      params.Float("min", deref(cfg.MinAmount)),
+		max:      params.Float("max", deref(cfg.MaxAmount)),
+		minOut:   params.Float("min_out", deref(cfg.MinOutAmount)),
+		maxOut:   params.Float("max_out", deref(cfg.MaxOutAmount)),
+		minBoost: params.Float("min_boost", 0),
+		boosted:  params["min_boost"] != nil,
+	}
+}
+
+// accountBand is the plain account min/max band.
+func accountBand(cfg WorkerConfig) amountBand {
+	return amountBand{
+		min:    deref(cfg.MinAmount),
+		max:    deref(cfg.MaxAmount),
+		minOut: deref(cfg.MinOutAmount),
+		maxOut: deref(cfg.MaxOutAmount),
+	}
+}
+
+func (s amountBand) Evaluate(p p2c.LivePayment) Decision {
+	if s.boosted && p.Boost <= s.minBoost {
+		return skip(SkipBoost, "boost %.2f <= %.2f", p.Boost, s.minBoost)
+	}
+	if d := s.checkOut(p); !d.Take {
+		return d
+	}
+	amount, err := strconv.ParseFloat(p.InAmount, 64)
+	if err != nil {
+		return take()
+	}
+	return s.check(amount)
+}
+
+// checkOut applies the out_asset limits.
+func (s amountBand) checkOut(p p2c.LivePayment) Decision {
+	if s.minOut <= 0 && s.maxOut <= 0 {
+		return take()
+	}
+	out, ok := outValue(p)
+	if !ok {
+		return skip(SkipOther, "unknown out amount (out_amount=%q rate=%q)", p.OutAmount, p.ExchangeRate)
+	}
+	if s.minOut > 0 && out < s.minOut {
+		return skip(SkipBelowMin, "below min out %.2f < %.2f %s", out, s.minOut, p.OutAsset)
+	}
+	if s.maxOut > 0 && out > s.maxOut {
+		return skip(SkipAboveMax, "above max out %.2f > %.2f %s", out, s.maxOut, p.OutAsset)
+	}
+	return take()
+}
+
+// outValue returns the payment worth in out_asset: out_amount (wei) when
+// present, otherwise in_amount divided by the exchange rate.
+func outValue(p p2c.LivePayment) (float64, bool) {
+	if out := formatAmountWei(p.OutAmount); out > 0 {
+		return out, true
+	}
+	in, err1 := strconv.ParseFloat(p.InAmount, 64)
+	rate, err2 := strconv.ParseFloat(p.ExchangeRate, 64)
+	if err1 != nil || err2 != nil || rate <= 0 {
+		return 0, false
+	}
+	return in / rate, true
+}
+
+func (s amountBand) check(amount float64) Decision {
+	if s.min > 0 && amount < s.min {
+		return skip(SkipBelowMin, "below min %.2f < %.2f", amount, s.min)
+	}
+	if s.max > 0 && amount > s.max {
+		return skip(SkipAboveMax, "above max %.2f > %.2f", amount, s.max)
+	}
+	return take()
+}
+
+// rateThreshold takes payments whose exchange rate is within [min_rate, max_rate].
+type rateThreshold struct {
+	band    amountBand
+	minRate float64
+	maxRate float64
+}
+
+func newRateThreshold(params StrategyParams, cfg WorkerConfig) Strategy {
+	return rateThreshold{
+		band:    newAmountBand(params, cfg).(amountBand),
+		minRate: params.Float("min_rate", 0),
+		maxRate: params.Float("max_rate", 0),
+	}
+}
+
+func (s rateThreshold) Evaluate(p p2c.LivePayment) Decision {
+	if d := s.band.Evaluate(p); !d.Take {
+		return d
+	}
+	rate, err := strconv.ParseFloat(p.ExchangeRate, 64)
+	if err != nil {
+		return skip(SkipRate, "bad rate %q", p.ExchangeRate)
+	}
+	if s.minRate > 0 && rate < s.minRate {
+		return skip(SkipRate, "rate %.4f < %.4f", rate, s.minRate)
+	}
+	if s.maxRate > 0 && rate > s.maxRate {
+		return skip(SkipRate, "rate %.4f > %.4f", rate, s.maxRate)
+	}
+	return take()
+}
+
+// topBoost takes a payment only if it has the highest boost of its
+// list:update batch and, with window_ms set, of every payment seen within
+// that window, so boosted orders win over plain ones arriving together.
+type topBoost struct {
+	band   amountBand
+	window time.Duration
+
+	mu     sync.Mutex
+	recent []boostMark
+}
+
+type boostMark struct {
+	at    time.Time
+	boost float64
+}
+
+func newTopBoost(params StrategyParams, cfg WorkerConfig) Strategy {
+	return &topBoost{
+		band:   newAmountBand(params, cfg).(amountBand),
+		window: time.Duration(params.Float("window_ms", 0)) * time.Millisecond,
+	}
+}
+
+func (s *topBoost) Evaluate(p p2c.LivePayment) Decision {
+	if d := s.band.Evaluate(p); !d.Take {
+		return d
+	}
+	if p.Boost < p.BatchMaxBoost {
+		return skip(SkipBoost, "boost %.2f below batch top %.2f", p.Boost, p.BatchMaxBoost)
+	}
+	if s.window <= 0 {
+		return take()
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.recent[:0]
+	best := 0.0
+	for _, m := range s.recent {
+		if now.Sub(m.at) <= s.window {
+			kept = append(kept, m)
+			if m.boost > best {
+				best = m.boost
+			}
+		}
+	}
+	s.recent = append(kept, boostMark{at: now, boost: p.Boost})
+	if p.Boost < best {
+		return skip(SkipBoost, "boost %.2f below recent top %.2f", p.Boost, best)
+	}
+	return take()
+}
+
+// roundRobin rotates payments between accounts of the same group: the first
+// member to see a payment assigns it to the next account in turn that could
+// take it now and the others follow that assignment.
+type roundRobin struct {
+	band      amountBand
+	group     *rrGroup
+	accountID int64
+}
+
+// rrBook holds the round-robin groups of the Manager. Groups are per fleet
+// (tenant and environment), like the arbiter's decisions.
+type rrBook struct {
+	workers func() []*Worker
+
+	mu     sync.Mutex
+	groups map[string]*rrGroup
+}
+
+func newRRBook(workers func() []*Worker) *rrBook {
+	return &rrBook{workers: workers, groups: make(map[string]*rrGroup)}
+}
+
+// group returns the named group of the fleet, creating it on first use; a
+// nil book returns a group of its own.
+func (b *rrBook) group(fleet, name string) *rrGroup {
+	if b == nil {
+		return &rrGroup{assigned: make(map[string]rrAssignment)}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := fleet + "/" + name
+	g, ok := b.groups[key]
+	if !ok {
+		g = &rrGroup{assigned: make(map[string]rrAssignment), ready: b.ready}
+		b.groups[key] = g
+	}
+	return g
+}
+
+// ready reports whether the account could take the payment now, as the
+// arbiter's eligible does: it runs and is free, its filters pass and its
+// owner group has room. The turn itself is left out of its strategy.
+func (b *rrBook) ready(accountID int64, p p2c.LivePayment, now time.Time) bool {
+	for _, w := range b.workers() {
+		if w.accountID != accountID {
+			continue
+		}
+		if !w.available(now) {
+			return false
+		}
+		w.mu.Lock()
+		strategy := w.strategy
+		w.mu.Unlock()
+		if d := w.filterWith(p, now, withoutTurn(strategy)); !d.Take {
+			return false
+		}
+		amount, _ := strconv.ParseFloat(p.InAmount, 64)
+		return w.groups.room(w, amount, now)
+	}
+	return false
+}
+
+// withoutTurn returns the strategy a member is checked with while its group
+// decides the turn: round-robin's own band, so the check does not assign.
+func withoutTurn(s Strategy) Strategy {
+	if rr, ok := s.(*roundRobin); ok {
+		return rr.band
+	}
+	return s
+}
+
+type rrGroup struct {
+	ready func(accountID int64, p p2c.LivePayment, now time.Time) bool // nil — готовы все
+
+	mu       sync.Mutex
+	members  []int64
+	next     int
+	assigned map[string]rrAssignment
+}
+
+type rrAssignment struct {
+	accountID int64
+	at        time.Time
+}
+
+func newRoundRobin(params StrategyParams, cfg WorkerConfig, rr *rrBook) Strategy {
+	g := rr.group(cfg.TenantID+"/"+cfg.env(), params.String("group", "default"))
+	g.join(cfg.AccountID)
+	return &roundRobin{
+		band:      newAmountBand(params, cfg).(amountBand),
+		group:     g,
+		accountID: cfg.AccountID,
+	}
+}
+
+func (s *roundRobin) Evaluate(p p2c.LivePayment) Decision {
+	if d := s.band.Evaluate(p); !d.Take {
+		return d
+	}
+	if owner := s.group.assign(p, time.Now()); owner != s.accountID {
+		return skip(SkipRoundRobin, "round-robin turn of account %d", owner)
+	}
+	return take()
+}
+
+func (s *roundRobin) Close() {
+	s.group.leave(s.accountID)
+}
+
+func (g *rrGroup) join(accountID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range g.members {
+		if id == accountID {
+			return
+		}
+	}
+	g.members = append(g.members, accountID)
+	sort.Slice(g.members, func(i, j int) bool { return g.members[i] < g.members[j] })
+}
+
+func (g *rrGroup) leave(accountID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, id := range g.members {
+		if id == accountID {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// assign returns the account whose turn the payment is: the next member
+// after the previous assignment that is ready to take it now. When none is
+// ready the turn goes to the next member anyway.
+func (g *rrGroup) assign(p p2c.LivePayment, now time.Time) int64 {
+	paymentID := p.ID
+	g.mu.Lock()
+	if a, ok := g.assigned[paymentID]; ok {
+		g.mu.Unlock()
+		return a.accountID
+	}
+	members := slices.Clone(g.members)
+	g.mu.Unlock()
+	// готовность проверяем без g.mu: воркер держит свой mu, пока закрывает стратегию
+	ready := make(map[int64]bool, len(members))
+	for _, id := range members {
+		ready[id] = g.ready == nil || g.ready(id, p, now)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if a, ok := g.assigned[paymentID]; ok {
+		return a.accountID
+	}
+	for id, a := range g.assigned {
+		if now.Sub(a.at) > time.Minute {
+			delete(g.assigned, id)
+		}
+	}
+	if len(g.members) == 0 {
+		return 0
+	}
+	turn := g.next % len(g.members)
+	for i := range g.members {
+		if k := (g.next + i) % len(g.members); ready[g.members[k]] {
+			turn = k
+			break
+		}
+	}
+	owner := g.members[turn]
+	g.next = turn + 1
+	g.assigned[paymentID] = rrAssignment{accountID: owner, at: now}
+	return owner
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	restartBase   = time.Second
+	restartMax    = 5 * time.Minute
+	restartStable = time.Minute // проработала столько — следующий рестарт снова через restartBase
+)
+
+// PanicInfo describes a recovered panic of a worker.
+type PanicInfo struct {
+	At    time.Time `json:"at"`
+	Where string    `json:"where"` // обработчик или цикл воркера
+	Error string    `json:"error"`
+}
+
+// crashes counts the recovered panics of a worker for its status and the
+// "panics" alert rule.
+type crashes struct {
+	total    atomic.Int64
+	restarts atomic.Int64
+	last     atomic.Pointer[PanicInfo]
+}
+
+// recovered books a panic: the stack goes to the log, the count to status
+// and alerts.
+func (w *Worker) recovered(where string, r any) {
+	now := time.Now()
+	w.logf("panic in %s: %v\n%s", where, r, debug.Stack())
+	w.crashes.total.Add(1)
+	w.crashes.last.Store(&PanicInfo{At: now, Where: where, Error: fmt.Sprint(r)})
+	w.health.panic(now)
+}
+
+// guard recovers a panic of one feed event, so a payment that breaks a
+// handler is lost alone instead of the account's whole feed. Use it as
+// `defer w.guard("live.add")`.
+func (w *Worker) guard(where string) {
+	if r := recover(); r != nil {
+		w.recovered(where, r)
+	}
+}
+
+// supervise runs a loop of the worker until ctx ends. A panic restarts it
+// after restartBase, doubling up to restartMax while it keeps crashing; a
+// normal return ends supervision.
+func (w *Worker) supervise(ctx context.Context, name string, fn func(ctx context.Context)) {
+	delay := restartBase
+	for {
+		start := time.Now()
+		if !w.runGuarded(ctx, name, fn) || ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) >= restartStable {
+			delay = restartBase
+		}
+		w.crashes.restarts.Add(1)
+		w.logf("restarting %s in %s", name, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, restartMax)
+	}
+}
+
+// runGuarded runs fn once and reports whether it panicked.
+func (w *Worker) runGuarded(ctx context.Context, name string, fn func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.recovered(name, r)
+			panicked = true
+		}
+	}()
+	fn(ctx)
+	return false
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"io"
+	"log"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/p2c/p2ctest"
+)
+
+// takeAllocBudget bounds the allocations of one live take through p2ctest,
+// from the filters' yes to the booked and notified order, including the
+// status check and the fake market's own bookkeeping. Raising it, say in the
+// commit what the take pays for.
+const takeAllocBudget = 180 // сейчас 164
+
+// settle waits until the goroutines a take spawned are done.
+func (f *inflight) settle() {
+	for {
+		f.mu.Lock()
+		n := f.n
+		f.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestTakeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts differ under -race")
+	}
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	api := p2ctest.New()
+	w := NewWorker(WorkerConfig{AccountID: 1, Active: true, AutoMode: true}, api, "", nil)
+	t.Cleanup(w.journal.close)
+	const runs = 100
+	ids := make([]string, runs+1) // AllocsPerRun прогревает одним лишним вызовом
+	for i := range ids {
+		ids[i] = "6f1c2a9e" + strconv.Itoa(1000+i)
+	}
+	i := 0
+	allocs := testing.AllocsPerRun(runs, func() {
+		p := p2c.LivePayment{ID: ids[i], InAmount: "4500.00", InAsset: "RUB", OutAsset: "USDT", ExpiresAt: "2026-01-01T12:00:00Z"}
+		i++
+		api.List(p)
+		w.take(p, time.Now())
+		w.inflight.settle()
+		// оплачена: аккаунт свободен для следующего взятия
+		if !api.SetStatus(p.ID, p2c.StatusCompleted) {
+			t.Fatalf("take %d: %s was not taken", i, p.ID)
+		}
+	})
+	if got := api.Count("take"); got != runs+1 {
+		t.Fatalf("takes = %d, want %d", got, runs+1)
+	}
+	if allocs > takeAllocBudget {
+		t.Fatalf("take: %.0f allocs per take, budget %d", allocs, takeAllocBudget)
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// takeDelay returns the configured pause before take with ±20% jitter, so
+// the account does not take at a fixed, recognisable offset.
+func takeDelay(ms int) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	d := time.Duration(ms) * time.Millisecond
+	jitter := int64(d / 5)
+	return d + time.Duration(rand.Int64N(2*jitter+1)-jitter)
+}
+
+// delayedTakes tracks the payments waiting out TakeDelayMs; a payment that
+// leaves the feed meanwhile (taken by someone else) is dropped right away.
+type delayedTakes struct {
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+func (d *delayedTakes) add(id string) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		d.pending = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	d.pending[id] = ch
+	return ch
+}
+
+func (d *delayedTakes) gone(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ch, ok := d.pending[id]; ok {
+		close(ch)
+		delete(d.pending, id)
+	}
+}
+
+func (d *delayedTakes) done(id string) {
+	d.mu.Lock()
+	delete(d.pending, id)
+	d.mu.Unlock()
+}
+
+// delayTake waits before taking the payment without blocking the socket
+// reader, then re-checks what may have changed during the pause.
+func (w *Worker) delayTake(p p2c.LivePayment, eventStart time.Time, delay time.Duration) {
+	gone := w.delayed.add(p.ID)
+	go func() {
+		defer w.delayed.done(p.ID)
+		defer w.guard("take.delayed")
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-gone:
+			w.skip(p, skip(SkipGone, "gone during take delay"))
+			return
+		case <-w.stopCh:
+			w.skip(p, skip(SkipShutdown, "shutting down"))
+			return
+		}
+		now := time.Now()
+		if w.paused.Load() {
+			w.skip(p, skip(SkipPaused, "paused"))
+			return
+		}
+		if w.maint.active() {
+			w.skip(p, skip(SkipMaintenance, "maintenance"))
+			return
+		}
+		if bo, on := w.blackouts.active(w.tenant, w.accountID, now); on {
+			w.skip(p, skip(SkipBlackout, "blackout until %s", bo.To.Local().Format("02.01 15:04")))
+			return
+		}
+		if w.isActiveLocked(now) {
+			w.skip(p, skip(SkipActive, "active order in progress"))
+			return
+		}
+		if _, blocked := w.penalties.Blocked(w.accountID, now); blocked {
+			w.skipped(p, skip(SkipPenalty, "penalty"))
+			return
+		}
+		w.take(p, eventStart)
+	}()
+}
@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tgQueueSize    = 256
+	tgMaxAttempts  = 4
+	tgGlobalPace   = 40 * time.Millisecond // ~25 сообщений/с на бота, лимит Telegram — 30
+	tgChatPace     = time.Second           // личный чат: не чаще раза в секунду
+	tgGroupPace    = 3 * time.Second       // группы: 20 сообщений в минуту
+	tgRequestLimit = 10 * time.Second
+	tgDeliverLimit = 2 * time.Minute // все попытки одного сообщения, дальше очередь не ждёт
+)
+
+// tgMessage is one Bot API call. fallback is sent instead when the call
+// fails for good (e.g. Telegram cannot fetch the photo). With file set the
+// call is sent as multipart/form-data upload.
+type tgMessage struct {
+	method   string
+	chatID   int64
+	body     map[string]any
+	file     *tgFile
+	fallback *tgMessage
+	sent     func(messageID int64) // id доставленного сообщения, для последующих правок
+	done     func(err error)       // итог доставки вместе с fallback, для outbox
+}
+
+// tgFile is a file uploaded in the field of a multipart call.
+type tgFile struct {
+	field string
+	name  string
+	data  []byte
+}
+
+// tgSender delivers messages of one bot, pacing them to Telegram rate
+// limits and honouring retry_after on 429. Every chat has its own FIFO queue
+// drained by its own goroutine, so the pacing, retry_after and retries of one
+// chat never hold up the others; the global pace is shared through send
+// slots. Enqueueing never blocks: past tgQueueSize pending messages the
+// message is dropped and logged; messages that must not be lost go through
+// the outbox.
+type tgSender struct {
+	token  string
+	client *http.Client
+
+	pending  atomic.Int64
+	mu       sync.Mutex
+	chats    map[int64][]tgMessage // очереди чатов; есть ключ — горутина чата работает
+	lastSent time.Time             // последний выданный слот отправки бота
+	chatNext map[int64]time.Time
+}
+
+var (
+	tgSendersMu sync.Mutex
+	tgSenders   = make(map[string]*tgSender)
+)
+
+// telegramSender returns the shared sender of the bot.
+func telegramSender(token string) *tgSender {
+	tgSendersMu.Lock()
+	defer tgSendersMu.Unlock()
+	if s, ok := tgSenders[token]; ok {
+		return s
+	}
+	s := &tgSender{
+		token:    token,
+		client:   &http.Client{Timeout: tgRequestLimit},
+		chats:    make(map[int64][]tgMessage),
+		chatNext: make(map[int64]time.Time),
+	}
+	tgSenders[token] = s
+	return s
+}
+
+func (s *tgSender) enqueue(m tgMessage) bool {
+	if s.pending.Add(1) > tgQueueSize {
+		s.pending.Add(-1)
+		log.Printf("[tg] queue full, dropped %s to chat %d", m.method, m.chatID)
+		if m.done != nil {
+			m.done(errTgQueueFull)
+		}
+		return false
+	}
+	s.mu.Lock()
+	queue, running := s.chats[m.chatID]
+	s.chats[m.chatID] = append(queue, m)
+	s.mu.Unlock()
+	if !running {
+		go s.run(m.chatID)
+	}
+	return true
+}
+
+// run delivers the queue of one chat in order and exits when it is empty.
+func (s *tgSender) run(chatID int64) {
+	for {
+		s.mu.Lock()
+		queue := s.chats[chatID]
+		if len(queue) == 0 {
+			delete(s.chats, chatID)
+			s.mu.Unlock()
+			return
+		}
+		m := queue[0]
+		queue[0] = tgMessage{}
+		s.chats[chatID] = queue[1:]
+		s.mu.Unlock()
+
+		err := s.deliver(m)
+		if err != nil {
+			log.Printf("[tg] %s to chat %d failed: %v", m.method, m.chatID, err)
+			if m.fallback != nil {
+				if err = s.deliver(*m.fallback); err != nil {
+					log.Printf("[tg] fallback %s to chat %d failed: %v", m.fallback.method, m.chatID, err)
+				}
+			}
+		}
+		if m.done != nil {
+			m.done(err)
+		}
+		s.pending.Add(-1)
+	}
+}
+
+// deliver sends m with retries on 429, 5xx and network errors, giving up
+// after tgDeliverLimit so one stuck message does not hold its chat's queue.
+func (s *tgSender) deliver(m tgMessage) error {
+	deadline := time.Now().Add(tgDeliverLimit)
+	var err error
+	for attempt := 1; attempt <= tgMaxAttempts; attempt++ {
+		s.pace(m.chatID)
+		var retryAfter time.Duration
+		retryAfter, err = s.call(m)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 {
+			return err // постоянная ошибка (400/403), повтор не поможет
+		}
+		if retryAfter == 0 {
+			retryAfter = time.Duration(attempt) * time.Second
+		}
+		if time.Now().Add(retryAfter).After(deadline) {
+			return fmt.Errorf("gave up after %s: %w", tgDeliverLimit, err)
+		}
+		log.Printf("[tg] %s to chat %d: %v, retry in %s", m.method, m.chatID, err, retryAfter)
+		time.Sleep(retryAfter)
+	}
+	return err
+}
+
+// pace waits for the chat's own interval, then for a free send slot of the
+// bot. Slots are handed out under mu, so chats sending at once are spread by
+// tgGlobalPace instead of bursting together; a chat waiting for its own
+// interval holds no slot meanwhile.
+func (s *tgSender) pace(chatID int64) {
+	s.mu.Lock()
+	chatAt := s.chatNext[chatID]
+	s.mu.Unlock()
+	// chatNext чата меняет только его горутина
+	if wait := time.Until(chatAt); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	slot := s.lastSent.Add(tgGlobalPace)
+	if slot.Before(now) {
+		slot = now
+	}
+	s.lastSent = slot
+	interval := tgChatPace
+	if chatID < 0 {
+		interval = tgGroupPace
+	}
+	s.chatNext[chatID] = slot.Add(interval)
+	for id, t := range s.chatNext {
+		if now.After(t) && id != chatID {
+			delete(s.chatNext, id)
+		}
+	}
+	s.mu.Unlock()
+	if wait := time.Until(slot); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// call performs the request. retryAfter is negative for errors that must not
+// be retried and positive when Telegram asked to wait.
+func (s *tgSender) call(m tgMessage) (retryAfter time.Duration, err error) {
+	contentType, data, err := m.encode()
+	if err != nil {
+		return -1, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tgRequestLimit)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://api.telegram.org/bot%s/%s", s.token, m.method), bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+		Result struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"result"`
+	}
+	if resp.StatusCode < 300 {
+		if m.sent != nil {
+			if json.NewDecoder(resp.Body).Decode(&out) == nil && out.Result.MessageID != 0 {
+				m.sent(out.Result.MessageID)
+			}
+		}
+		return 0, nil
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if strings.Contains(out.Description, "message is not modified") {
+		return 0, nil // правка без изменений — не ошибка
+	}
+	err = fmt.Errorf("telegram status %d: %s", resp.StatusCode, out.Description)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return time.Duration(max(out.Parameters.RetryAfter, 1)) * time.Second, err
+	case resp.StatusCode >= 500:
+		return 0, err
+	default:
+		return -1, err
+	}
+}
+
+// encode returns the request body: JSON, or multipart when a file is attached.
+func (m tgMessage) encode() (contentType string, data []byte, err error) {
+	if m.file == nil {
+		data, err = json.Marshal(m.body)
+		return "application/json", data, err
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for key, v := range m.body {
+		var field string
+		switch v := v.(type) {
+		case string:
+			field = v
+		case int64, int, float64, bool:
+			field = fmt.Sprint(v)
+		default:
+			// reply_markup и прочие объекты передаются JSON-строкой
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return "", nil, err
+			}
+			field = string(raw)
+		}
+		if err := mw.WriteField(key, field); err != nil {
+			return "", nil, err
+		}
+	}
+	fw, err := mw.CreateFormFile(m.file.field, m.file.name)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := fw.Write(m.file.data); err != nil {
+		return "", nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return mw.FormDataContentType(), buf.Bytes(), nil
+}
+
+// flush waits until queued messages are delivered or ctx ends.
+func (s *tgSender) flush(ctx context.Context) error {
+	for s.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// flushTelegram waits for the queues of all bots.
+func flushTelegram(ctx context.Context) {
+	tgSendersMu.Lock()
+	senders := make([]*tgSender, 0, len(tgSenders))
+	for _, s := range tgSenders {
+		senders = append(senders, s)
+	}
+	tgSendersMu.Unlock()
+	for _, s := range senders {
+		if err := s.flush(ctx); err != nil {
+			log.Printf("[tg] %d messages not delivered before shutdown", s.pending.Load())
+		}
+	}
+}
+
+// textMessage builds a sendMessage call with HTML parse mode.
+func textMessage(chatID int64, text string, markup map[string]any) tgMessage {
+	body := map[string]any{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+	if markup != nil {
+		body["reply_markup"] = markup
+	}
+	return tgMessage{method: "sendMessage", chatID: chatID, body: body}
+}
+
+// photoMessage builds a sendPhoto call uploading a PNG with caption and
+// optional reply_markup.
+func photoMessage(chatID int64, photo []byte, caption string, markup map[string]any) tgMessage {
+	body := map[string]any{
+		"chat_id": chatID,
+	}
+	if caption != "" {
+		body["caption"] = caption
+		body["parse_mode"] = "HTML"
+	}
+	if markup != nil {
+		body["reply_markup"] = markup
+	}
+	return tgMessage{
+		method: "sendPhoto",
+		chatID: chatID,
+		body:   body,
+		file:   &tgFile{field: "photo", name: "qr.png", data: photo},
+	}
+}
+
+// documentMessage builds a sendDocument call uploading a file.
+func documentMessage(chatID int64, name string, data []byte, caption string) tgMessage {
+	body := map[string]any{
+		"chat_id": chatID,
+	}
+	if caption != "" {
+		body["caption"] = caption
+		body["parse_mode"] = "HTML"
+	}
+	return tgMessage{
+		method: "sendDocument",
+		chatID: chatID,
+		body:   body,
+		file:   &tgFile{field: "document", name: name, data: data},
+	}
+}
+
+// editMessage builds the edit of a sent card: its caption when it is a
+// photo, its text otherwise. An empty inline_keyboard removes the buttons.
+func editMessage(card OrderCard, text string, markup map[string]any) tgMessage {
+	body := map[string]any{
+		"chat_id":    card.ChatID,
+		"message_id": card.MessageID,
+		"parse_mode": "HTML",
+	}
+	method := "editMessageText"
+	if card.Photo {
+		method = "editMessageCaption"
+		body["caption"] = text
+	} else {
+		body["text"] = text
+	}
+	if markup != nil {
+		body["reply_markup"] = markup
+	}
+	return tgMessage{method: method, chatID: card.ChatID, body: body}
+}
@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"embed"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/p2c"
+)
+
+// Notification template names; files are <name>.tmpl.
+const (
+	tmplLiveTaken    = "live_taken"
+	tmplPenalty      = "penalty"
+	tmplPenaltyEnd   = "penalty_end"
+	tmplDailySummary = "daily_summary"
+)
+
+//go:embed templates
+var defaultTemplateFS embed.FS
+
+var templateFuncs = template.FuncMap{
+	"wei":   formatAmountWei,
+	"clock": func(t time.Time) string { return t.Local().Format("15:04:05") },
+	"pct":   func(v float64) float64 { return v * 100 },
+	"esc":   html.EscapeString,
+}
+
+// Templates renders Telegram notification texts in the account locale.
+// Defaults are embedded per locale (templates/<locale>/*.tmpl); files in
+// dir override them for all locales, dir/<locale>/ for one locale and
+// dir/<account_id>/ for one account.
+type Templates struct {
+	dir string
+
+	mu       sync.Mutex
+	base     map[string]*template.Template // locale -> set
+	accounts map[accountLocale]*template.Template
+}
+
+type accountLocale struct {
+	accountID int64
+	locale    string
+}
+
+// NewTemplates loads the embedded defaults and the overrides from dir
+// ("" — defaults only). Broken override files are reported as errors.
+func NewTemplates(dir string) (*Templates, error) {
+	locales, err := fs.ReadDir(defaultTemplateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	t := &Templates{dir: dir, base: make(map[string]*template.Template), accounts: make(map[accountLocale]*template.Template)}
+	for _, l := range locales {
+		set, err := template.New("").Funcs(templateFuncs).ParseFS(defaultTemplateFS, "templates/"+l.Name()+"/*.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			if set, err = overlay(set, dir); err != nil {
+				return nil, err
+			}
+			if set, err = overlay(set, filepath.Join(dir, l.Name())); err != nil {
+				return nil, err
+			}
+		}
+		t.base[l.Name()] = set
+	}
+	if t.base[i18n.Default] == nil {
+		return nil, fmt.Errorf("no templates for default locale %q", i18n.Default)
+	}
+	return t, nil
+}
+
+var defaultTemplates = func() *Templates {
+	t, err := NewTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// overlay clones base and replaces templates with *.tmpl files found in dir.
+func overlay(base *template.Template, dir string) (*template.Template, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil || len(files) == 0 {
+		return base, err
+	}
+	out, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.New(filepath.Base(file)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("template %s: %w", file, err)
+		}
+	}
+	return out, nil
+}
+
+// Reload re-reads the overrides from dir, e.g. on SIGHUP; on error the
+// templates in use stay.
+func (t *Templates) Reload() error {
+	fresh, err := NewTemplates(t.dir)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.base, t.accounts = fresh.base, fresh.accounts
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Templates) localeSet(locale string) *template.Template {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.localeSetLocked(locale)
+}
+
+func (t *Templates) localeSetLocked(locale string) *template.Template {
+	if set, ok := t.base[i18n.Normalize(locale)]; ok {
+		return set
+	}
+	return t.base[i18n.Default]
+}
+
+// forAccount returns the template set of the account, cached after first use.
+func (t *Templates) forAccount(accountID int64, locale string) *template.Template {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	base := t.localeSetLocked(locale)
+	if t.dir == "" || accountID == 0 {
+		return base
+	}
+	key := accountLocale{accountID, i18n.Normalize(locale)}
+	if set, ok := t.accounts[key]; ok {
+		return set
+	}
+	set, err := overlay(base, filepath.Join(t.dir, strconv.FormatInt(accountID, 10)))
+	if err != nil {
+		log.Printf("[templates] account %d overrides ignored: %v", accountID, err)
+		set = base
+	}
+	t.accounts[key] = set
+	return set
+}
+
+// Render executes the named template for the account and locale. If an
+// override fails at execution time the embedded default is used instead.
+func (t *Templates) Render(accountID int64, locale, name string, data any) string {
+	if t == nil {
+		t = defaultTemplates
+	}
+	out, err := execTemplate(t.forAccount(accountID, locale), name, data)
+	if err != nil {
+		log.Printf("[templates] %s for account %d: %v", name, accountID, err)
+		out, err = execTemplate(defaultTemplates.localeSet(locale), name, data)
+		if err != nil {
+			log.Printf("[templates] default %s: %v", name, err)
+		}
+	}
+	return out
+}
+
+func execTemplate(set *template.Template, name string, data any) (string, error) {
+	var sb strings.Builder
+	if err := set.ExecuteTemplate(&sb, name+".tmpl", data); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// render is Render with the worker's account and locale.
+func (w *Worker) render(name string, data any) string {
+	return w.templates.Render(w.accountID, w.config().Locale, name, data)
+}
+
+// Template data of the notifications.
+
+type liveTakenData struct {
+	Account  AccountInfo
+	Status   string
+	Ref      PaymentRef
+	P        p2c.LivePayment
+	Reward   float64
+	OutAsset string
+	Pay      PayInstructions
+}
+
+type penaltyData struct {
+	Account  AccountInfo
+	Reason   string
+	Until    time.Time
+	ResumeAt time.Time
+	Cooldown time.Duration
+}
+
+type dailySummaryData struct {
+	AccountID int64
+	Account   AccountInfo
+	Report    StatsReport
+	Profit    []ProfitTotal // маржа оплаченных заявок по фиатам, пусто без курсов
+}
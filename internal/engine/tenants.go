@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"log"
+
+	"p2c-engine/internal/engine/cache"
+)
+
+// tenantsDoc persists the account -> tenant bindings, so after a restart a
+// tenant cannot claim another tenant's account before its frontend reloads it.
+const tenantsDoc = "tenants"
+
+// loadTenants restores persisted bindings; m.mu is not needed yet.
+func (m *Manager) loadTenants() {
+	if err := m.store.Load(tenantsDoc, &m.tenants); err != nil {
+		log.Printf("[mgr] load tenants: %v", err)
+	}
+	if m.tenants == nil {
+		m.tenants = make(map[int64]string)
+	}
+}
+
+// saveTenantsLocked writes the bindings; m.mu must be held.
+func (m *Manager) saveTenantsLocked() {
+	if err := m.store.Save(tenantsDoc, m.tenants); err != nil {
+		log.Printf("[mgr] save tenants: %v", err)
+	}
+}
+
+// sharedSeenLocked returns the tenant's claimed payments with shared dedup
+// enabled, nil otherwise. Tenants never share one: a payment claimed by
+// another tenant's account must still reach ours. m.mu must be held.
+func (m *Manager) sharedSeenLocked(tenant string) *cache.TTL {
+	if m.seen == nil {
+		return nil
+	}
+	seen, ok := m.seen[tenant]
+	if !ok {
+		seen = cache.NewTTL(seenTTL)
+		m.seen[tenant] = seen
+	}
+	return seen
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"log"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/store"
+)
+
+// tlsSessionsDoc keeps TLS sessions across restarts, so the first takes
+// after a deploy resume instead of doing a full handshake.
+const tlsSessionsDoc = "tls-sessions"
+
+// savedSession holds resumption secrets, so they are stored as secrets:
+// without a secret key the sessions are not persisted at all.
+type savedSession struct {
+	Key    string       `json:"key"`
+	Ticket store.Secret `json:"ticket"`
+	State  store.Secret `json:"state"`
+}
+
+func (m *Manager) loadTLSSessions() {
+	sessions := m.client.TLSSessions()
+	if sessions == nil {
+		return
+	}
+	var saved []savedSession
+	if err := m.store.Load(tlsSessionsDoc, &saved); err != nil {
+		log.Printf("[mgr] load tls sessions: %v", err)
+		return
+	}
+	list := make([]p2c.SavedSession, 0, len(saved))
+	for _, s := range saved {
+		list = append(list, p2c.SavedSession{Key: s.Key, Ticket: []byte(s.Ticket.Reveal()), State: []byte(s.State.Reveal())})
+	}
+	if n := sessions.Import(list); n > 0 {
+		log.Printf("[mgr] restored %d tls sessions", n)
+	}
+}
+
+func (m *Manager) saveTLSSessions() {
+	sessions := m.client.TLSSessions()
+	if sessions == nil || m.store == nil {
+		return
+	}
+	var saved []savedSession
+	for _, s := range sessions.Export() {
+		saved = append(saved, savedSession{Key: s.Key, Ticket: store.Secret(s.Ticket), State: store.Secret(s.State)})
+	}
+	if err := m.store.Save(tlsSessionsDoc, saved); err != nil {
+		log.Printf("[mgr] save tls sessions: %v", err)
+	}
+}
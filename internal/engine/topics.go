@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/store"
+)
+
+const (
+	topicsDoc   = "topics"
+	topicRetry  = 5 * time.Minute // после неудачи шлём в общий чат и не пробуем создать тему
+	topicMaxLen = 128             // лимит Telegram на название темы
+)
+
+// topicBook remembers the forum topics created for accounts, keyed by chat
+// and account, so a reload or restart reuses the topic instead of opening a
+// new one.
+type topicBook struct {
+	store *store.Store
+
+	mu     sync.Mutex
+	ids    map[string]int64
+	failed map[string]time.Time
+}
+
+func newTopicBook(st *store.Store) *topicBook {
+	b := &topicBook{store: st, ids: make(map[string]int64), failed: make(map[string]time.Time)}
+	if err := st.Load(topicsDoc, &b.ids); err != nil {
+		log.Printf("[topics] load error: %v", err)
+	}
+	if b.ids == nil {
+		b.ids = make(map[string]int64)
+	}
+	return b
+}
+
+func topicKey(chatID, accountID int64) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(accountID, 10)
+}
+
+// thread returns the topic of the account in its chat: the configured one,
+// or with AutoTopic the one created on first use. 0 means the general chat.
+// Creating the topic is a single blocking call, once per account and chat.
+func (b *topicBook) thread(token string, cfg WorkerConfig) int64 {
+	if cfg.MessageThreadID != 0 || !cfg.AutoTopic || b == nil || token == "" || cfg.ChatID == 0 {
+		return cfg.MessageThreadID
+	}
+	key := topicKey(cfg.ChatID, cfg.AccountID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id, ok := b.ids[key]; ok {
+		return id
+	}
+	if time.Since(b.failed[key]) < topicRetry {
+		return 0
+	}
+	name := []rune(cfg.account().String())
+	if len(name) > topicMaxLen {
+		name = name[:topicMaxLen]
+	}
+	id, err := createForumTopic(token, cfg.ChatID, string(name))
+	if err != nil {
+		log.Printf("[worker %d] create topic in chat %d: %v", cfg.AccountID, cfg.ChatID, err)
+		b.failed[key] = time.Now()
+		return 0
+	}
+	log.Printf("[worker %d] created topic %d in chat %d", cfg.AccountID, id, cfg.ChatID)
+	b.ids[key] = id
+	delete(b.failed, key)
+	if err := b.store.Save(topicsDoc, b.ids); err != nil {
+		log.Printf("[topics] save error: %v", err)
+	}
+	return id
+}
+
+// forget drops the topics of a deleted account.
+func (b *topicBook) forget(accountID int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	suffix := ":" + strconv.FormatInt(accountID, 10)
+	changed := false
+	for key := range b.ids {
+		if strings.HasSuffix(key, suffix) {
+			delete(b.ids, key)
+			changed = true
+		}
+	}
+	if changed {
+		if err := b.store.Save(topicsDoc, b.ids); err != nil {
+			log.Printf("[topics] save error: %v", err)
+		}
+	}
+}
+
+// createForumTopic opens a topic in a forum supergroup; the bot needs the
+// can_manage_topics right.
+func createForumTopic(token string, chatID int64, name string) (int64, error) {
+	body, _ := json.Marshal(map[string]any{"chat_id": chatID, "name": name})
+	client := &http.Client{Timeout: tgRequestLimit}
+	resp, err := client.Post(fmt.Sprintf("https://api.telegram.org/bot%s/createForumTopic", token), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			MessageThreadID int64 `json:"message_thread_id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	if !out.OK || out.Result.MessageThreadID == 0 {
+		return 0, fmt.Errorf("telegram status %d: %s", resp.StatusCode, out.Description)
+	}
+	return out.Result.MessageThreadID, nil
+}
+
+// inThread sends the message into a forum topic; 0 keeps the general chat.
+func (m tgMessage) inThread(threadID int64) tgMessage {
+	if threadID != 0 {
+		m.body["message_thread_id"] = threadID
+	}
+	return m
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"p2c-engine/internal/i18n"
+	"p2c-engine/internal/p2c"
+)
+
+// verifyDelays are the pauses before each status check of a take; P2C may
+// list a fresh payment with a small lag, so "not found" is retried too.
+var verifyDelays = []time.Duration{0, 500 * time.Millisecond, time.Second, 2 * time.Second}
+
+// verifyTake checks that a take went through on the P2C side: the payment is
+// ours if the account sees it in processing. err is set when the check
+// itself kept failing and the outcome is still unknown.
+func (w *Worker) verifyTake(ctx context.Context, ref PaymentRef, key string) (numeric int64, taken bool, err error) {
+	for _, d := range verifyDelays {
+		select {
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		case <-time.After(d):
+		}
+		var p *p2c.Payment
+		p, err = w.client.GetPayment(ctx, ref.APIID())
+		if errors.Is(err, p2c.ErrPaymentNotFound) {
+			continue
+		}
+		if err != nil {
+			w.logf("verify take %s (key=%s): %v", ref, key, err)
+			continue
+		}
+		if p.Status != p2c.StatusProcessing {
+			w.logf("verify take %s: status %s", ref, p.Status)
+			return 0, false, nil
+		}
+		return p.NumericID(), true, nil
+	}
+	if errors.Is(err, p2c.ErrPaymentNotFound) {
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("take outcome unknown: %w", err)
+}
+
+// confirmTake settles a live take before anything is booked or sent to the
+// chat. takeErr is nil when P2C answered 2xx and set for a timeout or a
+// gateway error. Until it is settled the journal keeps the payment in
+// taking, so the account is not retried into ActiveOrderExists.
+func (w *Worker) confirmTake(p p2c.LivePayment, ref PaymentRef, key string, tt takeTiming, takeErr error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(p2c.WithCall(w.life, tt.call), verifyTimeout)
+	defer cancel()
+	num, taken, err := w.verifyTake(ctx, ref, key)
+	tt.span.Step("verify", start, time.Now()).Set("taken", taken)
+	switch {
+	case taken:
+		if num != 0 && ref.Numeric == 0 {
+			ref.Numeric = num
+			w.ids.store(p.ID, num)
+		}
+		if takeErr != nil {
+			w.logf("take %s confirmed by status check (%s) after: %v", ref, tt.call, takeErr)
+		}
+		w.accepted(p, ref, tt)
+	case err != nil && takeErr == nil:
+		// проверить не удалось — верим ответу take
+		w.logf("take %s not verified, trusting take response: %v", ref, err)
+		w.accepted(p, ref, tt)
+	case err != nil:
+		// запись сама истечёт по дедлайну заявки и перейдёт в take_failed
+		w.logf("take %s (%s): %v", ref, tt.call, err)
+		w.emitCall(tt.call, EventTakeFailed, &ref, p.InAmount, err.Error())
+		tt.span.Fail(err)
+		tt.span.Finish(time.Now())
+	default:
+		_, _ = w.journal.transition(ref, StateTakeFailed)
+		w.journal.failedRay(ref, tt.cfRay)
+		w.statTakeFailed(time.Now())
+		w.recordTake(false)
+		reason := "payment not attributed to the account after take"
+		if takeErr != nil {
+			reason = takeErr.Error()
+		} else {
+			// P2C ответил 2xx, но заявки у нас нет — предупреждаем вместо карточки
+			w.notify(i18n.T(w.config().Locale, "take.unverified", w.config().account(), ref, tt.call))
+		}
+		w.emitCall(tt.call, EventTakeFailed, &ref, p.InAmount, reason)
+		w.logf("take %s not taken (%s): %s", ref, tt.call, reason)
+		tt.span.Fail(errors.New(reason))
+		tt.span.Finish(time.Now())
+	}
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hookQueueSize   = 256
+	hookMaxAttempts = 4
+	hookPace        = time.Second // Slack — 1 сообщение/с на вебхук, Discord — 5 за 2с
+	discordMaxText  = 2000
+)
+
+// hookRequest is one webhook POST.
+type hookRequest struct {
+	contentType string
+	body        []byte
+	header      http.Header
+	attempts    int               // 0 — hookMaxAttempts
+	pace        time.Duration     // пауза после отправки (лимиты Slack и Discord)
+	dropped     func(hookRequest) // все попытки исчерпаны
+}
+
+// hookSender delivers the posts of one webhook URL (Slack, Discord or the
+// account's own endpoint) in order through a bounded queue, like tgSender
+// does for a bot: pacing, retries on 429 and 5xx, never blocking the caller.
+type hookSender struct {
+	url     string
+	client  *http.Client
+	queue   chan hookRequest
+	pending atomic.Int64
+}
+
+var (
+	hookSendersMu sync.Mutex
+	hookSenders   = make(map[string]*hookSender)
+)
+
+// webhookSender returns the shared sender of url, starting it on first use.
+func webhookSender(url string) *hookSender {
+	hookSendersMu.Lock()
+	defer hookSendersMu.Unlock()
+	if s, ok := hookSenders[url]; ok {
+		return s
+	}
+	s := &hookSender{url: url, client: &http.Client{Timeout: tgRequestLimit}, queue: make(chan hookRequest, hookQueueSize)}
+	hookSenders[url] = s
+	go s.run()
+	return s
+}
+
+func (s *hookSender) enqueue(r hookRequest) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- r:
+	default:
+		s.pending.Add(-1)
+		log.Printf("[hook] queue full, dropped message to %s", redactHook(s.url))
+	}
+}
+
+func (s *hookSender) run() {
+	for r := range s.queue {
+		if err := s.deliver(r); err != nil {
+			log.Printf("[hook] %s: %v", redactHook(s.url), err)
+			if r.dropped != nil {
+				r.dropped(r)
+			}
+		}
+		s.pending.Add(-1)
+		time.Sleep(r.pace)
+	}
+}
+
+func (s *hookSender) deliver(r hookRequest) error {
+	attempts := r.attempts
+	if attempts <= 0 {
+		attempts = hookMaxAttempts
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = s.post(r)
+		if err == nil || retryAfter < 0 {
+			return err
+		}
+		if retryAfter == 0 {
+			retryAfter = time.Second << (attempt - 1)
+		}
+		time.Sleep(retryAfter)
+	}
+	return err
+}
+
+// post sends r; retryAfter is negative for errors that must not be retried.
+func (s *hookSender) post(r hookRequest) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(r.body))
+	if err != nil {
+		return -1, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", r.contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("webhook status %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// Slack отдаёт секунды в Retry-After, Discord — ещё и дробные
+		sec, _ := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		return max(time.Duration(sec*float64(time.Second)), time.Second), err
+	case resp.StatusCode >= 500:
+		return 0, err
+	default:
+		return -1, err
+	}
+}
+
+// flushWebhooks waits for the queues of all webhooks.
+func flushWebhooks(ctx context.Context) {
+	hookSendersMu.Lock()
+	senders := make([]*hookSender, 0, len(hookSenders))
+	for _, s := range hookSenders {
+		senders = append(senders, s)
+	}
+	hookSendersMu.Unlock()
+	for _, s := range senders {
+		for s.pending.Load() > 0 {
+			select {
+			case <-ctx.Done():
+				log.Printf("[hook] %d messages to %s not delivered before shutdown", s.pending.Load(), redactHook(s.url))
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// redactHook hides the secret path of a webhook URL in logs.
+func redactHook(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		if j := strings.Index(url[i+3:], "/"); j >= 0 {
+			return url[:i+3+j] + "/…"
+		}
+	}
+	return "webhook"
+}
+
+// slackNotifier posts to a Slack incoming webhook. Incoming webhooks
+// cannot upload files, so the QR photo is dropped; URL buttons become links.
+type slackNotifier struct {
+	url string
+}
+
+func (s slackNotifier) Send(n Notification) {
+	text := slackStyle.markdown(n.Text)
+	labels, urls := urlButtons(n.Markup)
+	for i := range urls {
+		text += "\n" + slackStyle.link(html.EscapeString(urls[i]), html.EscapeString(labels[i]))
+	}
+	body, _ := json.Marshal(map[string]any{"text": text, "unfurl_links": false})
+	webhookSender(s.url).enqueue(hookRequest{contentType: "application/json", body: body, pace: hookPace})
+}
+
+// discordNotifier posts to a Discord webhook, attaching the photo.
+type discordNotifier struct {
+	url string
+}
+
+func (d discordNotifier) Send(n Notification) {
+	text := discordStyle.markdown(n.Text)
+	labels, urls := urlButtons(n.Markup)
+	for i := range urls {
+		text += "\n" + discordStyle.link(urls[i], labels[i])
+	}
+	if r := []rune(text); len(r) > discordMaxText {
+		text = string(r[:discordMaxText-1]) + "…"
+	}
+	payload, _ := json.Marshal(map[string]any{"content": text, "allowed_mentions": map[string]any{"parse": []string{}}})
+	if n.Photo == nil {
+		webhookSender(d.url).enqueue(hookRequest{contentType: "application/json", body: payload, pace: hookPace})
+		return
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("payload_json", string(payload))
+	part, err := mw.CreateFormFile("files[0]", "qr.png")
+	if err == nil {
+		_, err = part.Write(n.Photo)
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		log.Printf("[hook] discord photo: %v", err)
+		webhookSender(d.url).enqueue(hookRequest{contentType: "application/json", body: payload, pace: hookPace})
+		return
+	}
+	webhookSender(d.url).enqueue(hookRequest{contentType: mw.FormDataContentType(), body: buf.Bytes(), pace: hookPace})
+}
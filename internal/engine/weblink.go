@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webLinkTTL bounds how long a link from a card opens the page: a payment is
+// worked and disputed well within a day, a leaked link is not good forever.
+const webLinkTTL = 24 * time.Hour
+
+// WebLinks signs per-payment links to the engine web view so a link from the
+// Telegram card grants access to exactly one payment.
+type WebLinks struct {
+	baseURL string
+	secret  []byte
+}
+
+func NewWebLinks(baseURL, secret string) *WebLinks {
+	if baseURL == "" || secret == "" {
+		return nil
+	}
+	return &WebLinks{baseURL: strings.TrimRight(baseURL, "/"), secret: []byte(secret)}
+}
+
+// URL returns the signed page link for the payment, valid for webLinkTTL,
+// or "" if disabled.
+func (l *WebLinks) URL(accountID int64, hexID string) string {
+	if l == nil || hexID == "" {
+		return ""
+	}
+	exp := time.Now().Add(webLinkTTL).Unix()
+	return fmt.Sprintf("%s/p/%d/%s?exp=%d&sig=%s", l.baseURL, accountID, hexID, exp, l.sign(accountID, hexID, exp))
+}
+
+// Verify checks the link signature in constant time and that the link has
+// not expired; exp is the unix time from the link and is part of the signature.
+func (l *WebLinks) Verify(accountID int64, hexID, exp, sig string) bool {
+	if l == nil || sig == "" {
+		return false
+	}
+	until, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > until {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(l.sign(accountID, hexID, until)))
+}
+
+func (l *WebLinks) sign(accountID int64, hexID string, exp int64) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%d:%s:%d", accountID, hexID, exp)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
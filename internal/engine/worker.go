@@ -5,86 +5,315 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"p2c-engine/internal/engine/cache"
+	"p2c-engine/internal/i18n"
 	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/qr"
+	"p2c-engine/internal/store"
+)
+
+// seenTTL is how long a payment id stays deduplicated.
+const seenTTL = 10 * time.Minute
+
+// qrSize is the minimal side of the payment QR image in pixels.
+const qrSize = 300
+
+// Deadlines of the worker's calls to P2C, on top of the caller's context, so
+// a stuck upstream cannot hold a handler or an account forever.
+const (
+	takeTimeout   = 10 * time.Second
+	verifyTimeout = 15 * time.Second // проверка спорного take со всеми повторами
+	settleTimeout = 15 * time.Second // complete и cancel
+	pollTimeout   = 10 * time.Second
 )
 
 // Worker is a stub that will later connect to P2C and process orders.
 type Worker struct {
-	cfg         WorkerConfig
+	cfg         WorkerConfig // под mu: applyConfig меняет на лету, читать через config()
+	accountID   int64        // cfg.AccountID, cfg.TenantID и cfg.env() не меняются за жизнь
+	tenant      string       // воркера (их смена перезапускает его), поэтому читаются без mu
+	env         string
 	stopCh      chan struct{}
 	doneCh      chan struct{}
-	client      *p2c.Client
-	bgCtx       context.Context
+	client      p2c.API
+	clock       *p2c.Clock // расхождение с часами P2C, nil — не измерено
+	life        context.Context    // авто-взятия и их проверки: переживают остановку циклов, отменяется после drain в Stop
+	endLife     context.CancelFunc
 	botToken    string
-	cursor      string
-	seen        map[string]time.Time
+	cursor      pollCursor // курсор ListPayments для polling, переживает рестарт
+	tuner       autoTuner  // предложение по границам суммы, ждущее оператора
+	seen        seenSet // id заявок, которые уже обработали
+	claims      seenSet // общий dedup тенанта: заявку берёт первый прошедший фильтры аккаунт; nil — выключен
+	registry    *activeRegistry // общий с репликами замок активной заявки, nil — только локальный
 	reqHistory  []time.Time
 	cancel      context.CancelFunc
-	p2cAccountID string
-	penaltyUntil time.Time
-	penaltyReason string
-	takeMap     map[string]int64 // hex -> numeric id
-	activePaymentID string
-	activeLockUntil time.Time
-	lastPenaltyNotified time.Time
+	ids         *idStore // hex <-> numeric id
+	backoffUntil time.Time // пауза после ActiveOrderExists
+	penalties   *PenaltyManager
+	jobs        *JobQueue
+	warmer      p2c.Pool
+	journal     *journal
+	web         *WebLinks
+	strategy    Strategy
+	rr          *rrBook // группы round-robin менеджера
+	arbiter     *arbiter
+	groups      *ownerGroups
+	topics      *topicBook // темы форума, созданные для аккаунтов
+	sandboxChat int64      // sandbox: куда вместо ChatID идут уведомления, 0 — никуда
+	captureDir  string     // куда писать кадры websocket для p2c-replay, пусто — не пишем
+	rates       RateSource // рыночные курсы: фильтр market_edge_pct и курс на момент take для маржи
+	maint       *maintenance // режим работ движка: авто-взятие стоит у всех
+	handoffs    *handoffs    // заявки, отпущенные аккаунтами для перехвата другим
+	blackouts   *blackouts   // календарь окон без авто-взятия
+	outbox      *outbox      // уведомления о взятых заявках, доставляются и после сбоев
+	brands      *brandBook   // исходы заявок по брендам: споры и отмены
+	payerTurn   atomic.Uint64 // чей черёд в пуле плательщиков
+	dayKey      string
+	dayVolume   float64
+	events      *eventBus
+	dayCount    int
+	paused      atomic.Bool // пауза авто-взятия без остановки websocket
+	trace       *frameRing
+	frames      frameLog // сколько кадров websocket писать в лог, меняется на лету
+	skips       *skipLog
+	history     *seenHistory // последние увиденные заявки для предпросмотра фильтров
+	inflight    inflight // операции, которые дожидаемся при остановке
+	stats       *statsBook
+	breaker     breaker // пауза после серии неудачных take
+	health      health  // сигналы для правил алертов
+	crashes     crashes // перехваченные паники и рестарты циклов
+	synced      atomic.Bool // первый list:snapshot уже получен
+	wsDownSince atomic.Int64 // unix nano разрыва websocket, 0 — подключены
+	live        liveList     // текущая лента заявок для ручного выбора
+	delayed     delayedTakes // заявки, ждущие TakeDelayMs
+	templates   *Templates // nil — встроенные шаблоны
 	mu sync.Mutex
 }
 
 type WorkerConfig struct {
 	AccountID   int64
-	AccessToken string
+	AccessToken store.Secret // печатается как [redacted]
 	ChatID      int64
 	MinAmount   *float64
 	MaxAmount   *float64
+	MinOutAmount *float64 // границы в out_asset (USDT-эквивалент), nil — без ограничения
+	MaxOutAmount *float64
 	AutoMode    bool
 	Active      bool
 	P2CAccountID string
+	WarmConns   int // число прогретых соединений к P2C, 0 = default
+	Strategy    StrategyConfig
+	Priority    int     // выше — раньше получает заявку при арбитраже
+	Weight      int     // доля заявок среди аккаунтов одного приоритета при арбитраже, 0 = 1
+	DailyCap    float64 // дневной лимит объёма в фиате, 0 = без лимита
+	PenaltyCooldownSec int // доп. пауза после окончания блока P2C
+	WSTraceSize int // сколько последних ws-кадров хранить для /ws/trace
+	PollFallbackAfterSec int // через сколько секунд без websocket включать опрос ListPayments, 0 = выкл
+	PollIntervalMs       int // период опроса в fallback-режиме, 0 = 3000
+	TenantID    string
+	ProvidersAllow []string // пусто — любые провайдеры (например, только "sbp")
+	ProvidersDeny  []string
+	AmountsAllow   []string // шаблоны суммы: "%100" — кратные 100, "*.99" — glob; пусто — любые
+	AmountsDeny    []string
+	Locale         string // язык уведомлений: ru (по умолчанию), en
+	Breaker        BreakerConfig
+	Label          string // подпись аккаунта в уведомлениях и статусе, например «Тинькофф *1234»
+	Owner          string // владелец карты
+	OwnerGroup     string  // аккаунты одного оператора: общий лимит открытого объёма
+	OwnerGroupCap  float64 // лимит группы в фиате, действует минимальный из группы; 0 — без лимита
+	Note           string // заметка оператора, только в статусе
+	Transport      p2c.TransportConfig // таймауты и пулы соединений поверх общих настроек движка
+	WSStandby      bool                // держать второй, уже открытый websocket на замену упавшему
+	SlackWebhook   store.Secret        // уведомления ещё и в Slack (incoming webhook)
+	DiscordWebhook store.Secret        // уведомления ещё и в Discord (webhook канала)
+	WebhookURL     string              // события заявок JSON-ом в систему мерчанта
+	WebhookSecret  store.Secret        // ключ HMAC-подписи событий, пусто — без подписи
+	CancelAtExpiry bool                // отменять взятую заявку, если к expires_at она не оплачена
+	TakeDelayMs    int                 // пауза перед take, мс (±20% случайно), 0 — брать сразу
+	Payers         []int64             // Telegram id плательщиков: заявки раздаются по кругу, пусто — без назначения
+	PayerAckSec    int                 // сколько ждать подтверждения, потом передать следующему, 0 = 120
+	PaidOneTap     bool                // «Я оплатил» подтверждает сразу, без вопроса с суммой
+	PaidUsers      []int64             // Telegram id, кому можно подтверждать оплату; пусто — всем в чате
+	OrderCard      bool                // править сообщение заявки (статус, отсчёт, итог) вместо новых сообщений
+	MessageThreadID int64              // тема форум-группы для уведомлений аккаунта, 0 — общий чат
+	AutoTopic      bool                // без MessageThreadID создать тему аккаунта при первом уведомлении
+	Environment    string              // prod (по умолчанию) или sandbox — тестовая площадка P2C
+	SkipSummary    bool                // раз в час сводка пропусков по причинам в чат
+	DeafAfterSec   int                 // websocket подключён, но лента молчит столько секунд при живом рынке — переподключить, 0 = выкл
+	MarketEdgePct  float64             // брать, только если exchange_rate лучше рыночного хотя бы на столько %, 0 — не сравнивать
+	AutoTune       bool                // раз в час предлагать оператору сузить/расширить min/max по исходам заявок
+	BrandGuard     BrandGuardConfig    // не брать заявки бренда после серии споров или отмен
+	FrameLog       FrameLogConfig      // кадры websocket в лог: off, errors (по умолчанию), sample, full
 }
 
-func NewWorker(cfg WorkerConfig, client *p2c.Client, botToken string) *Worker {
-	return &Worker{
+// WorkerStatus is the worker state exposed in the status API.
+type WorkerStatus struct {
+	AccountID       int64         `json:"account_id"`
+	Label           string        `json:"label,omitempty"`
+	Owner           string        `json:"owner,omitempty"`
+	Note            string        `json:"note,omitempty"`
+	TenantID        string        `json:"tenant_id,omitempty"`
+	Environment     string        `json:"environment"`
+	ChatID          int64         `json:"chat_id"`
+	Active          bool          `json:"active"`
+	AutoMode        bool          `json:"auto_mode"`
+	MinAmount       *float64      `json:"min_amount,omitempty"`
+	MaxAmount       *float64      `json:"max_amount,omitempty"`
+	MinOutAmount    *float64      `json:"min_out_amount,omitempty"`
+	MaxOutAmount    *float64      `json:"max_out_amount,omitempty"`
+	ActivePayment   *PaymentRef   `json:"active_payment,omitempty"`
+	ActiveLockUntil time.Time     `json:"active_lock_until,omitempty"`
+	PenaltyUntil    time.Time     `json:"penalty_until,omitempty"`
+	PenaltyReason   string        `json:"penalty_reason,omitempty"`
+	BreakerUntil    time.Time     `json:"breaker_until,omitempty"`
+	Warm            p2c.WarmStats `json:"warm"`
+	Priority        int           `json:"priority"`
+	Weight          int           `json:"weight"`
+	DailyCap        float64       `json:"daily_cap,omitempty"`
+	OwnerGroup      string        `json:"owner_group,omitempty"`
+	OwnerGroupCap   float64       `json:"owner_group_cap,omitempty"`
+	TakeDelayMs     int           `json:"take_delay_ms,omitempty"`
+	DayVolume       float64       `json:"day_volume"`
+	DayCount        int           `json:"day_count"`
+	Paused          bool          `json:"paused"`
+	Panics          int64         `json:"panics,omitempty"`   // перехваченных паник с запуска
+	Restarts        int64         `json:"restarts,omitempty"` // перезапусков циклов после паники
+	LastPanic       *PanicInfo    `json:"last_panic,omitempty"`
+	Blackout        *Blackout     `json:"blackout,omitempty"` // идёт окно календаря без авто-взятия
+	Outbox          int           `json:"outbox,omitempty"`   // недоставленных уведомлений о заявках
+}
+
+// NewWorker creates a worker; round-robin strategies join their groups in rr.
+func NewWorker(cfg WorkerConfig, client p2c.API, botToken string, rr *rrBook) *Worker {
+	w := &Worker{
 		cfg:      cfg,
+		accountID: cfg.AccountID,
+		tenant:   cfg.TenantID,
+		env:      cfg.env(),
 		stopCh:   make(chan struct{}),
 		doneCh:   make(chan struct{}),
 		client:   client,
-		bgCtx:    context.Background(),
 		botToken: botToken,
-		seen:     make(map[string]time.Time),
-		p2cAccountID: cfg.P2CAccountID,
-		takeMap:  make(map[string]int64),
+		seen:     cache.NewTTL(seenTTL),
+		ids:      newIDStore(),
+		warmer:   client.WarmPool(cfg.WarmConns, 8*time.Second),
+		journal:  newJournal(200),
+		rr:       rr,
+		events:   newEventBus(),
+		trace:    newFrameRing(cfg.WSTraceSize),
+		skips:    newSkipLog(skipLogSize),
+		history:  newSeenHistory(seenHistorySize),
+		stats:    newStatsBook(),
 	}
+	w.life, w.endLife = context.WithCancel(context.Background())
+	w.strategy = w.newStrategy(cfg)
+	w.events.hook = w.postEvent
+	w.clock = client.Clock()
+	w.journal.clock = w.clock
+	w.frames.set(cfg.FrameLog, false)
+	w.journal.onChange(w.paymentChanged)
+	w.journal.onSettled(w.recordOutcome)
+	return w
 }
 
 func (w *Worker) Start() {
+	// cancel заводим до горутины: Stop сразу после Start иначе не увидел бы его и ждал вечно
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
 	go func() {
 		defer close(w.doneCh)
-		log.Printf("[worker %d] start (active=%v auto=%v)", w.cfg.AccountID, w.cfg.Active, w.cfg.AutoMode)
-		if !w.cfg.Active || !w.cfg.AutoMode {
-			log.Printf("[worker %d] stopped (inactive/auto off)", w.cfg.AccountID)
+		cfg := w.config()
+		w.logf("start (active=%v auto=%v env=%s)", cfg.Active, cfg.AutoMode, w.env)
+		if !cfg.Active || !cfg.AutoMode {
+			w.logf("stopped (inactive/auto off)")
 			return
 		}
-		// Прогреваем HTTP-клиент, чтобы держать TLS/keepalive тёплым.
-		w.client.Warmup(context.Background())
-		go w.keepAliveLoop()
-		ctx, cancel := context.WithCancel(context.Background())
-		w.cancel = cancel
-		for {
-			if err := p2c.SubscribeSocket(ctx, w.client.BaseURL(), w.cfg.AccessToken, w.handleLivePayment, w.handleLiveRemove); err != nil {
-				log.Printf("[worker %d] websocket error: %v", w.cfg.AccountID, err)
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(5 * time.Second):
-				log.Printf("[worker %d] reconnecting...", w.cfg.AccountID)
+		// Держим пул тёплых соединений к take-эндпоинту, чтобы не платить за handshake.
+		w.client.Warmup(ctx)
+		// Циклы воркера под присмотром: паника перезапускает цикл, а не роняет аккаунт.
+		go w.supervise(ctx, "warmer", w.warmer.Run)
+		w.wsDownSince.Store(time.Now().UnixNano())
+		w.health.event(time.Now()) // молчание считаем от старта
+		go w.supervise(ctx, "poll", w.runPollFallback)
+		go w.supervise(ctx, "cards", w.runCards)
+		go w.supervise(ctx, "skip-summary", w.runSkipSummary)
+		go w.supervise(ctx, "auto-tune", w.runAutoTune)
+		// Заявки, взятые до рестарта: сверить с P2C и снова показать в чате.
+		go w.supervise(ctx, "reconcile", w.reconcile)
+		handlers := p2c.SocketHandlers{
+			OnAdd: func(p p2c.LivePayment) {
+				defer w.guard("live.add")
+				w.health.event(time.Now())
+				w.live.add(p)
+				w.handleLivePayment(p)
+			},
+			OnRemove: func(id string) {
+				defer w.guard("live.remove")
+				w.health.event(time.Now())
+				w.live.remove(id)
+				w.handleLiveRemove(id)
+			},
+			OnSnapshot: func(list []p2c.LivePayment) {
+				defer w.guard("live.snapshot")
+				w.health.event(time.Now())
+				w.live.load(list)
+				w.handleSnapshot(list)
+			},
+			OnConnect:  func() { w.wsDownSince.Store(0) },
+			OnFrame: func(at time.Time, frame []byte) {
+				w.trace.add(TraceFrame{At: at, Data: string(frame)})
+				w.logFrame(frame)
+			},
+			OnFrameError: w.frameError,
+		}
+		if rec := w.openCapture(); rec != nil {
+			defer rec.Close()
+			trace := handlers.OnFrame
+			handlers.OnFrame = func(at time.Time, frame []byte) {
+				trace(at, frame)
+				rec.Write(at, frame)
 			}
 		}
+		sock := w.client.OpenFeed(cfg.WSStandby)
+		go w.supervise(ctx, "deaf-check", func(ctx context.Context) { w.runDeafCheck(ctx, sock) })
+		w.supervise(ctx, "websocket", func(ctx context.Context) {
+			var backoff reconnectBackoff
+			for {
+				start := time.Now()
+				w.trace.add(TraceFrame{At: start, Note: "connect"})
+				err := sock.Run(ctx, handlers)
+				if err != nil {
+					w.logf("websocket error: %v", err)
+					w.trace.add(TraceFrame{At: time.Now(), Note: "error: " + err.Error()})
+				}
+				connected := w.wsDownSince.Load() == 0
+				w.wsDownSince.CompareAndSwap(0, time.Now().UnixNano())
+				w.health.reconnect(time.Now())
+				w.live.clear()
+				if ctx.Err() != nil {
+					return
+				}
+				pause := backoff.next(time.Since(start), connected, err)
+				if p2c.IsServiceRestart(err) {
+					w.logf("server restart (1012), reconnecting in %s", pause.Round(time.Millisecond))
+				}
+				w.trace.add(TraceFrame{At: time.Now(), Note: "reconnect in " + pause.Round(time.Millisecond).String()})
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(pause):
+					w.logf("reconnecting (paused %s)...", pause.Round(time.Millisecond))
+				}
+			}
+		})
 	}()
 }
 
@@ -94,170 +323,357 @@ func (w *Worker) Stop() {
 	}
 	close(w.stopCh)
 	<-w.doneCh
+	// Drain уже дождался взятий; без него (перезапуск, потеря аренды) оставшиеся обрываем — журнал ниже закрывается
+	w.endLife()
+	w.events.close()
+	w.journal.close()
+	w.stats.close()
+	if c, ok := w.strategy.(strategyCloser); ok {
+		c.Close()
+	}
 }
 
-func (w *Worker) keepAliveLoop() {
-	ticker := time.NewTicker(8 * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-w.stopCh:
-			return
-		case <-ticker.C:
-			w.client.Warmup(context.Background())
+// newStrategy builds the strategy of cfg, falling back to the default one.
+func (w *Worker) newStrategy(cfg WorkerConfig) Strategy {
+	strategy, err := newStrategy(cfg, w.rr)
+	if err != nil {
+		log.Printf("[worker %d] %v, fallback to %s", cfg.AccountID, err, defaultStrategyName)
+		return newAmountBand(nil, cfg)
+	}
+	return strategy
+}
+
+// config returns a copy of the current settings; filters may change in place.
+func (w *Worker) config() WorkerConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cfg
+}
+
+// needsRestart reports whether switching from old to new settings requires a
+// new websocket session; filter-only changes are applied in place.
+func needsRestart(old, new WorkerConfig) bool {
+	return old.AccountID != new.AccountID ||
+		old.TenantID != new.TenantID ||
+		old.AccessToken != new.AccessToken ||
+		old.Active != new.Active ||
+		old.AutoMode != new.AutoMode ||
+		old.WarmConns != new.WarmConns ||
+		old.Transport != new.Transport ||
+		old.WSStandby != new.WSStandby ||
+		old.Environment != new.Environment
+}
+
+// applyConfig swaps filter settings in place keeping the websocket, seen set
+// and counters. It returns false when nothing changed.
+func (w *Worker) applyConfig(cfg WorkerConfig) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if reflect.DeepEqual(w.cfg, cfg) {
+		return false
+	}
+	if !reflect.DeepEqual(w.cfg.Strategy, cfg.Strategy) || !reflect.DeepEqual(w.cfg.MinAmount, cfg.MinAmount) || !reflect.DeepEqual(w.cfg.MaxAmount, cfg.MaxAmount) ||
+		!reflect.DeepEqual(w.cfg.MinOutAmount, cfg.MinOutAmount) || !reflect.DeepEqual(w.cfg.MaxOutAmount, cfg.MaxOutAmount) {
+		// старую стратегию закрываем до создания новой, чтобы round-robin не потерял участника
+		if c, ok := w.strategy.(strategyCloser); ok {
+			c.Close()
 		}
+		w.strategy = w.newStrategy(cfg)
 	}
+	if w.cfg.FrameLog != cfg.FrameLog {
+		w.frames.set(cfg.FrameLog, false)
+	}
+	w.cfg = cfg
+	return true
 }
 
-// TakeOrder is a stub for manual mode; will later hit P2C API.
-func (w *Worker) TakeOrder(_ context.Context, externalID string) error {
-	log.Printf("[worker %d] received request to take order %s (stub)", w.cfg.AccountID, externalID)
-	return nil
+// Status returns a snapshot of the worker state.
+func (w *Worker) Status() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := WorkerStatus{
+		AccountID:       w.accountID,
+		Label:           w.cfg.Label,
+		Owner:           w.cfg.Owner,
+		Note:            w.cfg.Note,
+		TenantID:        w.tenant,
+		Environment:     w.cfg.env(),
+		ChatID:          w.cfg.ChatID,
+		Active:          w.cfg.Active,
+		AutoMode:        w.cfg.AutoMode,
+		MinAmount:       w.cfg.MinAmount,
+		MaxAmount:       w.cfg.MaxAmount,
+		MinOutAmount:    w.cfg.MinOutAmount,
+		MaxOutAmount:    w.cfg.MaxOutAmount,
+		ActiveLockUntil: w.backoffUntil,
+		Warm:            w.warmer.Stats(),
+		Priority:        w.cfg.Priority,
+		Weight:          w.cfg.weight(),
+		DailyCap:        w.cfg.DailyCap,
+		OwnerGroup:      w.cfg.OwnerGroup,
+		OwnerGroupCap:   w.cfg.OwnerGroupCap,
+		TakeDelayMs:     w.cfg.TakeDelayMs,
+		Paused:          w.paused.Load(),
+		Panics:          w.crashes.total.Load(),
+		Restarts:        w.crashes.restarts.Load(),
+		LastPanic:       w.crashes.last.Load(),
+		Outbox:          w.outbox.pending(w.accountID),
+	}
+	if w.dayKey == time.Now().Format("2006-01-02") {
+		st.DayVolume = w.dayVolume
+		st.DayCount = w.dayCount
+	}
+	if bo, on := w.blackouts.active(w.tenant, w.accountID, time.Now()); on {
+		st.Blackout = &bo
+	}
+	if rec, ok := w.journal.holding(time.Now()); ok {
+		ref := rec.Ref
+		st.ActivePayment = &ref
+		if rec.Deadline.After(st.ActiveLockUntil) {
+			st.ActiveLockUntil = rec.Deadline
+		}
+	}
+	if rec, ok := w.penalties.Blocked(w.accountID, time.Now()); ok {
+		st.PenaltyUntil = rec.ResumeAt()
+		st.PenaltyReason = rec.Reason
+	}
+	if until, open := w.breaker.blocked(time.Now()); open {
+		st.BreakerUntil = until
+	}
+	return st
 }
 
-// CompletePayment confirms payment in manual mode.
-func (w *Worker) CompletePayment(ctx context.Context, paymentID string) error {
-	if w.p2cAccountID == "" {
-		return fmt.Errorf("no p2c account id configured")
+// TakeResult describes a successful take so callers can render the order
+// without a follow-up lookup.
+type TakeResult struct {
+	Payment    PaymentRef      `json:"payment"`
+	ExpiresAt  string          `json:"expires_at,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	Requisites []p2c.Requisite `json:"requisites,omitempty"`
+	TakeMs     int64           `json:"take_ms"`
+	CFRay      string          `json:"cf_ray,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+// TakeOrder takes a payment by id in manual mode. externalID may be hex or numeric.
+func (w *Worker) TakeOrder(ctx context.Context, externalID string) (TakeResult, error) {
+	ref := w.ids.resolve(externalID)
+	res := TakeResult{Payment: ref}
+	if !w.inflight.beginTake() {
+		return res, ErrShuttingDown
+	}
+	defer w.inflight.done()
+	ctx, cancel := context.WithTimeout(ctx, takeTimeout)
+	defer cancel()
+	id := ref.Hex
+	if id == "" {
+		id = ref.APIID()
 	}
-	// если paymentID в hex, попробуем найти numeric id
-	hexID := paymentID
-	if num, ok := w.lookupTakeID(paymentID); ok {
-		paymentID = fmt.Sprintf("%d", num)
+	if w.registry != nil {
+		if holder, ok := w.registry.acquire(w.accountID, id, time.Until(holdDeadline("", time.Now(), nil))); !ok {
+			return res, fmt.Errorf("active order %s on another replica", holder)
+		}
+	}
+	key := w.journal.begin(w.accountID, p2c.LivePayment{ID: id}, ref)
+	// ключ попытки take и есть id операции: его P2C видит в Idempotency-Key
+	call := p2c.NewCall(key)
+	ctx = p2c.WithCall(ctx, call)
+	res.RequestID = call.ID
+	// проверка take не должна оборваться вместе с ним: это её случай
+	vctx, vcancel := context.WithTimeout(context.WithoutCancel(ctx), verifyTimeout)
+	defer vcancel()
+	start := time.Now()
+	takeRes, err := w.client.TakeLivePayment(ctx, id, key)
+	takeDur := time.Since(start)
+	res.TakeMs = takeDur.Milliseconds()
+	if takeRes != nil {
+		res.CFRay = takeRes.CFRay
 	}
-	if err := w.client.CompletePayment(ctx, paymentID, w.p2cAccountID); err != nil {
-		return err
+	verified := false
+	if p2c.IsAmbiguousTake(takeRes, err) {
+		// take мог пройти на стороне P2C — проверяем заявку, а не считаем ошибкой
+		if num, ok, _ := w.verifyTake(vctx, ref, key); ok {
+			verified = true
+			w.logf("manual take %s confirmed after %v", ref, err)
+			err = nil
+			ref.Numeric = num
+			if ref.Hex != "" && num != 0 {
+				w.ids.store(ref.Hex, num)
+			}
+			takeRes = &p2c.TakeResult{CFRay: res.CFRay}
+		}
 	}
-	w.clearActiveLock(hexID)
-	return nil
+	if err != nil {
+		_, _ = w.journal.transition(ref, StateTakeFailed)
+		w.journal.failedRay(ref, call.CFRay())
+		if takeRes != nil {
+			if until, reason, ok := parsePenaltyBody(takeRes.Body); ok {
+				w.applyPenalty(until, reason)
+			}
+		}
+		w.logf("manual take %s error in %dms (%s): %v", ref, res.TakeMs, call, err)
+		return res, err
+	}
+	var tr p2c.TakeResponse
+	if err := json.Unmarshal(takeRes.Body, &tr); err == nil && tr.Data != nil {
+		if num, err := tr.Data.ID.Int64(); err == nil {
+			ref.Numeric = num
+			if ref.Hex != "" {
+				w.ids.store(ref.Hex, num)
+			}
+		}
+		res.ExpiresAt = tr.Data.ExpiresAt
+		res.URL = tr.Data.URL
+		res.Requisites = tr.Data.Requisites
+	}
+	res.Payment = ref
+	if !verified {
+		if _, ok, verr := w.verifyTake(vctx, ref, key); !ok && verr == nil {
+			_, _ = w.journal.transition(ref, StateTakeFailed)
+			w.logf("manual take %s not attributed to the account (%s)", ref, call)
+			return res, fmt.Errorf("take %s: payment not attributed to the account (%s)", ref, call)
+		}
+	}
+	if err := w.journal.taken(ref, 0, takeDur, takeRes.CFRay, nil); err != nil {
+		w.logf("journal: %v", err)
+	}
+	w.events.publish(Event{Type: EventTaken, AccountID: w.accountID, At: time.Now(), Payment: &ref, TakeMs: res.TakeMs, RequestID: call.ID, CFRay: res.CFRay})
+	w.logf("manual take %s in %dms (%s)", ref, res.TakeMs, call)
+	return res, nil
 }
 
-// CancelPayment cancels accepted payment.
-func (w *Worker) CancelPayment(ctx context.Context, paymentID string) error {
-	if w.p2cAccountID == "" {
-		return fmt.Errorf("no p2c account id configured")
+// CompletePayment confirms payment in manual mode. paymentID may be hex or numeric.
+func (w *Worker) CompletePayment(ctx context.Context, paymentID string) (PaymentRef, error) {
+	ref := w.ids.resolve(paymentID)
+	if !w.inflight.begin() {
+		return ref, ErrShuttingDown
 	}
-	hexID := paymentID
-	if num, ok := w.lookupTakeID(paymentID); ok {
-		paymentID = fmt.Sprintf("%d", num)
+	defer w.inflight.done()
+	p2cAccountID := w.config().P2CAccountID
+	if p2cAccountID == "" {
+		return ref, fmt.Errorf("no p2c account id configured")
+	}
+	known, err := w.journal.transition(ref, StateCompleting)
+	if err != nil {
+		return ref, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, settleTimeout)
+	defer cancel()
+	call := p2c.NewCall("")
+	if err := w.client.CompletePayment(p2c.WithCall(ctx, call), ref.APIID(), p2cAccountID); err != nil {
+		if known {
+			_, _ = w.journal.transition(ref, StateAwaitingPayment)
+		}
+		w.logf("complete %s error (%s): %v", ref, call, err)
+		return ref, fmt.Errorf("%w (%s)", err, call)
+	}
+	w.logf("completed %s (%s)", ref, call)
+	if known {
+		_, _ = w.journal.transition(ref, StateCompleted)
+	}
+	w.statFinished(time.Now(), ref, true)
+	w.emitCall(call, EventCompleted, &ref, "", "")
+	return ref, nil
+}
+
+// CancelPayment cancels accepted payment. paymentID may be hex or numeric.
+func (w *Worker) CancelPayment(ctx context.Context, paymentID string) (PaymentRef, error) {
+	ref := w.ids.resolve(paymentID)
+	if !w.inflight.begin() {
+		return ref, ErrShuttingDown
+	}
+	defer w.inflight.done()
+	if w.config().P2CAccountID == "" {
+		return ref, fmt.Errorf("no p2c account id configured")
+	}
+	known, err := w.journal.transition(ref, StateCancelling)
+	if err != nil {
+		return ref, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, settleTimeout)
+	defer cancel()
 	// P2C ожидает reason (enum). Используем допустимый вариант из фронта.
 	const cancelReason = "balance"
-	if err := w.client.CancelPayment(ctx, paymentID, cancelReason); err != nil {
-		return err
+	call := p2c.NewCall("")
+	if err := w.client.CancelPayment(p2c.WithCall(ctx, call), ref.APIID(), cancelReason); err != nil {
+		if known {
+			_, _ = w.journal.transition(ref, StateAwaitingPayment)
+		}
+		w.logf("cancel %s error (%s): %v", ref, call, err)
+		return ref, fmt.Errorf("%w (%s)", err, call)
+	}
+	w.logf("canceled %s (%s)", ref, call)
+	if known {
+		_, _ = w.journal.transition(ref, StateCanceled)
 	}
-	w.clearActiveLock(hexID)
-	return nil
+	w.statFinished(time.Now(), ref, false)
+	w.emitCall(call, EventCanceled, &ref, "", "")
+	return ref, nil
+}
+
+// ResolvePayment maps either id form to both forms known to the worker.
+func (w *Worker) ResolvePayment(paymentID string) PaymentRef {
+	return w.ids.resolve(paymentID)
 }
 
-func (w *Worker) pollOnce(t time.Time) {
+// Payment returns the journal record for a payment id in either form.
+func (w *Worker) Payment(paymentID string) (PaymentRecord, bool) {
+	return w.journal.get(w.ids.resolve(paymentID))
+}
+
+// pollOnce lists processing payments within ctx, the poll loop's, and hands
+// them to handleLivePayment until one holds the account; the take itself
+// runs on the worker's life.
+func (w *Worker) pollOnce(ctx context.Context, t time.Time) {
 	if w.client == nil {
 		return
 	}
-	if !w.cfg.Active || !w.cfg.AutoMode {
+	cfg := w.config()
+	if !cfg.Active || !cfg.AutoMode {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
 	// Warmup HTTP client to prime TLS/keepalive.
-	w.client.Warmup(context.Background())
+	w.client.Warmup(ctx)
 
 	if !w.allowRequest(t) {
-		log.Printf("[worker %d] poll skipped: rate limit window", w.cfg.AccountID)
+		w.logf("poll skipped: rate limit window")
 		return
 	}
 
 	// release active lock after 30s to avoid perma-block
-	payments, err := w.client.ListPayments(w.bgCtx, p2c.ListPaymentsParams{
+	payments, err := w.client.ListPayments(ctx, p2c.ListPaymentsParams{
 		Size:   10,
 		Status: p2c.StatusProcessing,
-		Cursor: w.cursor,
+		Cursor: w.cursor.get(),
 		// статус не фильтруем, смотрим все и логируем
 	})
 	if err != nil {
-		log.Printf("[worker %d] poll error: %v", w.cfg.AccountID, err)
+		w.logf("poll error: %v", err)
 		return
 	}
 	if len(payments.Data) == 0 {
-		log.Printf("[worker %d] poll: empty", w.cfg.AccountID)
+		w.logf("poll: empty")
 		return
 	}
 
-	if payments.Cursor != "" {
-		w.cursor = payments.Cursor
-	}
-
-	now := time.Now()
-	w.evictSeen(now)
+	w.cursor.set(payments.Cursor)
 
 	for _, p := range payments.Data {
-		if _, ok := w.seen[p.IDString()]; ok {
-			continue
-		}
-		w.seen[p.IDString()] = now
-
-		log.Printf(
-			"[worker %d] seen payment id=%s status=%s amount=%s %s",
-			w.cfg.AccountID, p.IDString(), p.Status, p.AmountFiat, p.Fiat,
-		)
-
 		// пропускаем явно завершенные/отмененные
 		if p.Status == p2c.StatusCompleted || p.Status == p2c.StatusDisputed || p.Status == p2c.StatusCanceled || p.Status == p2c.StatusRefunded {
 			continue
 		}
-
-		amountFiat := p.AmountFiatValue()
-		if w.cfg.MinAmount != nil && amountFiat < *w.cfg.MinAmount {
-			log.Printf("[worker %d] skip %s: below min %.2f < %.2f", w.cfg.AccountID, p.ID, amountFiat, *w.cfg.MinAmount)
-			continue
+		// Те же фильтры, claim, арбитр и журнал, что у заявок из ленты;
+		// известный hex id нужен, чтобы dedup узнал заявку, уже пришедшую по сокету.
+		lp := p.Live()
+		if ref := w.ids.resolve(lp.ID); ref.Hex != "" {
+			lp.ID = ref.Hex
 		}
-		if w.cfg.MaxAmount != nil && amountFiat > *w.cfg.MaxAmount {
-			log.Printf("[worker %d] skip %s: above max %.2f > %.2f", w.cfg.AccountID, p.ID, amountFiat, *w.cfg.MaxAmount)
-			continue
-		}
-
-		log.Printf("[worker %d] trying take payment %s amount=%.2f %s", w.cfg.AccountID, p.IDString(), amountFiat, p.Fiat)
-		if err := w.client.TakePayment(context.Background(), p.IDString()); err != nil {
-			log.Printf("[worker %d] take payment %s error: %v", w.cfg.AccountID, p.IDString(), err)
-			w.sendTelegram(buildMessage(p, false, err.Error()))
-			continue
-		}
-
-		log.Printf("[worker %d] took payment %s amount=%.2f %s", w.cfg.AccountID, p.IDString(), amountFiat, p.Fiat)
-		w.sendTelegram(buildMessage(p, true, ""))
-		break // берем по одной
-	}
-}
-
-func (w *Worker) sendTelegram(text string) {
-	if w.botToken == "" {
-		log.Printf("[worker %d] skip tg send: empty bot token", w.cfg.AccountID)
-		return
-	}
-	if w.cfg.ChatID == 0 {
-		log.Printf("[worker %d] skip tg send: chat_id=0", w.cfg.AccountID)
-		return
-	}
-	if err := sendMessage(w.botToken, w.cfg.ChatID, text); err != nil {
-		log.Printf("[worker %d] telegram send error: %v", w.cfg.AccountID, err)
-	}
-}
-
-func (w *Worker) sendTelegramPhoto(photoURL, caption string, markup map[string]any) error {
-	if w.botToken == "" {
-		log.Printf("[worker %d] skip tg send: empty bot token", w.cfg.AccountID)
-		return fmt.Errorf("empty bot token")
-	}
-	if w.cfg.ChatID == 0 {
-		log.Printf("[worker %d] skip tg send: chat_id=0", w.cfg.AccountID)
-		return fmt.Errorf("empty chat")
-	}
-	return sendPhoto(w.botToken, w.cfg.ChatID, photoURL, caption, markup)
-}
-
-func (w *Worker) evictSeen(now time.Time) {
-	ttl := 10 * time.Minute
-	for id, ts := range w.seen {
-		if now.Sub(ts) > ttl {
-			delete(w.seen, id)
+		w.handleLivePayment(lp)
+		if w.isActiveLocked(time.Now()) {
+			break // берем по одной
 		}
 	}
 }
@@ -289,62 +705,187 @@ func (w *Worker) allowRequest(now time.Time) bool {
 }
 
 func (w *Worker) handleLivePayment(p p2c.LivePayment) {
-	if _, ok := w.seen[p.ID]; ok {
+	now := time.Now()
+	// Отпущенная заявка вернулась в ленту с тем же id: dedup её уже видел.
+	if winner, take, ok := w.handoffs.route(p, w); ok {
+		w.seen.Add(p.ID, now)
+		w.emit(EventSeen, &PaymentRef{Hex: p.ID}, p.InAmount, "")
+		if !take {
+			w.skip(p, skip(SkipHandoff, "handed off to account %d", winner))
+			return
+		}
+		w.take(p, now)
+		return
+	}
+	if !w.seen.Add(p.ID, now) {
 		return
 	}
-	now := time.Now()
 	eventStart := now
-	w.seen[p.ID] = now
+	w.emit(EventSeen, &PaymentRef{Hex: p.ID}, p.InAmount, "")
+	w.statSeen(now)
+	w.history.add(p, now)
+
+	if w.paused.Load() {
+		w.skip(p, skip(SkipPaused, "paused"))
+		return
+	}
+	if w.maint.active() {
+		w.skip(p, skip(SkipMaintenance, "maintenance"))
+		return
+	}
+	if bo, on := w.blackouts.active(w.tenant, w.accountID, now); on {
+		w.skip(p, skip(SkipBlackout, "blackout until %s", bo.To.Local().Format("02.01 15:04")))
+		return
+	}
 
 	// Если уже есть активный ордер, не дергаем take, чтобы не ловить 400/ActiveOrderExists.
 	if w.isActiveLocked(now) {
-		log.Printf("[worker %d] skip %s: active order in progress", w.cfg.AccountID, p.ID)
+		w.skip(p, skip(SkipActive, "active order in progress"))
 		return
 	}
 
 	// Если есть актуальный блок, не трогаем заявки
-	if now.Before(w.penaltyUntil) {
+	if _, blocked := w.penalties.Blocked(w.accountID, now); blocked {
+		w.skipped(p, skip(SkipPenalty, "penalty"))
+		return
+	}
+	if until, open := w.breaker.blocked(now); open {
+		w.skip(p, skip(SkipBreaker, "circuit breaker open until %s", until.Format("15:04:05")))
 		return
 	}
 
-	// Фильтр по сумме
-	if amount, err := strconv.ParseFloat(p.InAmount, 64); err == nil {
-		if w.cfg.MinAmount != nil && amount < *w.cfg.MinAmount {
-			log.Printf("[worker %d] skip %s: below min %.2f < %.2f", w.cfg.AccountID, p.ID, amount, *w.cfg.MinAmount)
+	if d := w.filter(p, now); !d.Take {
+		w.skip(p, d)
+		return
+	}
+	if !w.claim(p.ID, now) {
+		w.skip(p, skip(SkipClaimed, "claimed by another account of the tenant"))
+		return
+	}
+	// Арбитраж: из всех наших аккаунтов заявку берёт только один.
+	if w.arbiter != nil {
+		if winner := w.arbiter.decide(p, w); winner != w.accountID {
+			w.skip(p, skip(SkipArbitrated, "arbitrated to account %d", winner))
 			return
 		}
-		if w.cfg.MaxAmount != nil && *w.cfg.MaxAmount > 0 && amount > *w.cfg.MaxAmount {
-			log.Printf("[worker %d] skip %s: above max %.2f > %.2f", w.cfg.AccountID, p.ID, amount, *w.cfg.MaxAmount)
+	}
+	if delay := takeDelay(w.config().TakeDelayMs); delay > 0 {
+		w.delayTake(p, eventStart, delay)
+		return
+	}
+	w.take(p, eventStart)
+}
+
+// filter applies the account's own filters: providers, amount patterns, the
+// market rate, the strategy and the daily cap. p2c-replay and the arbiter
+// (eligible) run the same checks.
+func (w *Worker) filter(p p2c.LivePayment, now time.Time) Decision {
+	// Стратегия решает, брать ли заявку (по умолчанию — фильтр по сумме).
+	w.mu.Lock()
+	strategy := w.strategy
+	w.mu.Unlock()
+	return w.filterWith(p, now, strategy)
+}
+
+// filterWith runs filter with strategy in place of the account's own.
+func (w *Worker) filterWith(p p2c.LivePayment, now time.Time, strategy Strategy) Decision {
+	if d := checkProvider(w.config(), p.Provider); !d.Take {
+		return d
+	}
+	if d := w.brands.check(w.config(), p.BrandName, now); !d.Take {
+		return d
+	}
+	if d := checkAmount(w.config(), p.InAmount); !d.Take {
+		return d
+	}
+	if d := checkMarket(w.config(), p, w.rates); !d.Take {
+		return d
+	}
+	if d := strategy.Evaluate(p); !d.Take {
+		return d
+	}
+	if amount, err := strconv.ParseFloat(p.InAmount, 64); err == nil && w.remainingCap(now) < amount {
+		return skip(SkipDailyCap, "daily cap left %.2f < %.2f", w.remainingCap(now), amount)
+	}
+	return take()
+}
+
+// claim reports whether the worker may go on with a payment its filters
+// accepted: with shared dedup only the first such account of the tenant does.
+func (w *Worker) claim(id string, now time.Time) bool {
+	return w.claims == nil || w.claims.Add(id, now)
+}
+
+// take runs the take of a payment that passed the filters.
+func (w *Worker) take(p p2c.LivePayment, eventStart time.Time) {
+	now := time.Now()
+	// Общий лимит открытого объёма аккаунтов одного владельца.
+	release, d := w.groups.reserve(w, p, now)
+	defer release()
+	if !d.Take {
+		w.skip(p, d)
+		return
+	}
+	// При остановке новые заявки не берём, начатые доводим до конца.
+	if !w.inflight.beginTake() {
+		w.skip(p, skip(SkipShutdown, "shutting down"))
+		return
+	}
+	defer w.inflight.done()
+	// Реплики движка с тем же аккаунтом: заявку берёт та, что заняла замок.
+	if w.registry != nil {
+		if holder, ok := w.registry.acquire(w.accountID, p.ID, time.Until(holdDeadline(p.ExpiresAt, now, w.clock))); !ok {
+			w.skip(p, skip(SkipReplica, "active order %s on another replica", holder))
 			return
 		}
 	}
 
+	ref := PaymentRef{Hex: p.ID}
+	key := w.journal.begin(w.accountID, p, ref)
+	release() // дальше объём учитывает журнал
+	call := p2c.NewCall(key)
 	takeStart := time.Now()
 	toTake := takeStart.Sub(eventStart)
-	takeRes, err := w.client.TakeLivePayment(w.bgCtx, p.ID)
+	ctx, cancel := context.WithTimeout(p2c.WithCall(w.life, call), takeTimeout)
+	takeRes, err := w.client.TakeLivePayment(ctx, p.ID, key)
+	cancel()
 	takeDur := time.Since(takeStart)
+	tt := takeTiming{toTake: toTake, take: takeDur, latency: newLatency(p, eventStart, takeStart, takeDur, takeRes), call: call}
+	if takeRes != nil {
+		tt.cfRay = takeRes.CFRay
+	}
+	tt.span = w.takeSpan(p, eventStart, takeStart, tt, takeRes, err)
+	if p2c.IsAmbiguousTake(takeRes, err) {
+		// запись остаётся в taking и держит аккаунт, пока не проверим заявку
+		w.logf("take %s outcome unknown in %dms (%s): %v", p.ID, takeDur.Milliseconds(), call, err)
+		w.inflight.follow()
+		go func() {
+			defer w.inflight.done()
+			defer w.guard("confirm")
+			w.confirmTake(p, ref, key, tt, err)
+		}()
+		return
+	}
 	if err != nil {
+		tt.span.Fail(err)
+		tt.span.Finish(time.Now())
+		_, _ = w.journal.transition(ref, StateTakeFailed)
+		w.journal.failedRay(ref, tt.cfRay)
+		w.statTakeFailed(time.Now())
 		if takeRes != nil {
 			if until, reason, ok := parsePenaltyBody(takeRes.Body); ok {
-				w.penaltyUntil = until
-				w.penaltyReason = reason
-				if w.shouldNotifyPenalty(until) {
-					msg := fmt.Sprintf("⛔️ Блок до %s\nПричина: %s\nЗаявки временно не принимаем.", until.Local().Format("15:04:05"), reason)
-					w.sendTelegram(msg)
-				}
+				w.applyPenalty(until, reason)
 				return
 			}
 		}
 		if until, reason, ok := parsePenalty(err); ok {
-			w.penaltyUntil = until
-			w.penaltyReason = reason
-			if w.shouldNotifyPenalty(until) {
-				msg := fmt.Sprintf("⛔️ Блок до %s\nПричина: %s\nЗаявки временно не принимаем.", until.Local().Format("15:04:05"), reason)
-				w.sendTelegram(msg)
-			}
+			w.applyPenalty(until, reason)
 		} else if isActiveExists(err) {
+			w.emitCall(call, EventTakeFailed, &PaymentRef{Hex: p.ID}, p.InAmount, "ActiveOrderExists")
 			w.bumpActiveLock()
 		} else {
+			w.recordTake(false)
+			w.emitCall(call, EventTakeFailed, &PaymentRef{Hex: p.ID}, p.InAmount, err.Error())
 			cfRay := ""
 			dnsMs := int64(-1)
 			connMs := int64(-1)
@@ -359,35 +900,160 @@ func (w *Worker) handleLivePayment(p p2c.LivePayment) {
 				srvMs = takeRes.Timing.ServerTime.Milliseconds()
 				reused = takeRes.Timing.ReusedConn
 			}
-			log.Printf("[worker %d] take %s error in %dms (toTake=%dms amount=%s cfRay=%s dns=%dms conn=%dms tls=%dms srv=%dms reused=%v): %v", w.cfg.AccountID, p.ID, takeDur.Milliseconds(), toTake.Milliseconds(), p.InAmount, cfRay, dnsMs, connMs, tlsMs, srvMs, reused, err)
+			w.logf("take %s error in %dms (req=%s toTake=%dms amount=%s cfRay=%s dns=%dms conn=%dms tls=%dms srv=%dms reused=%v lat=[%s]): %v", p.ID, takeDur.Milliseconds(), call.ID, toTake.Milliseconds(), p.InAmount, cfRay, dnsMs, connMs, tlsMs, srvMs, reused, tt.latency, err)
 		}
 		return
 	}
-	w.setActiveLock(p.ID, p.ExpiresAt)
-
-	var numericID int64
 	var tr p2c.TakeResponse
 	if err := json.Unmarshal(takeRes.Body, &tr); err == nil && tr.Data != nil {
 		if num, err := tr.Data.ID.Int64(); err == nil {
-			numericID = num
-			w.storeTakeID(p.ID, num)
+			ref.Numeric = num
+			w.ids.store(p.ID, num)
 		}
 	}
+	// уведомляем только после того, как заявка видна у аккаунта
+	w.inflight.follow()
+	go func() {
+		defer w.inflight.done()
+		defer w.guard("confirm")
+		w.confirmTake(p, ref, key, tt, nil)
+	}()
+	w.logf("took %s amount=%s rate=%s in %dms (req=%s toTake=%dms cfRay=%s dns=%dms conn=%dms tls=%dms srv=%dms reused=%v lat=[%s])", ref, p.InAmount, p.ExchangeRate, takeDur.Milliseconds(), call.ID, toTake.Milliseconds(), takeRes.CFRay, takeRes.Timing.DNSLookup.Milliseconds(), takeRes.Timing.TCPConnection.Milliseconds(), takeRes.Timing.TLSHandshake.Milliseconds(), takeRes.Timing.ServerTime.Milliseconds(), takeRes.Timing.ReusedConn, tt.latency)
+}
 
-	go w.notifyLiveAccepted(p, numericID)
-	log.Printf("[worker %d] took %s amount=%s rate=%s in %dms (toTake=%dms cfRay=%s dns=%dms conn=%dms tls=%dms srv=%dms reused=%v)", w.cfg.AccountID, p.ID, p.InAmount, p.ExchangeRate, takeDur.Milliseconds(), toTake.Milliseconds(), takeRes.CFRay, takeRes.Timing.DNSLookup.Milliseconds(), takeRes.Timing.TCPConnection.Milliseconds(), takeRes.Timing.TLSHandshake.Milliseconds(), takeRes.Timing.ServerTime.Milliseconds(), takeRes.Timing.ReusedConn)
+// accepted books a successful live take and notifies the chat.
+func (w *Worker) accepted(p p2c.LivePayment, ref PaymentRef, tt takeTiming) {
+	w.addDayVolume(p.InAmount, time.Now())
+	w.statTaken(time.Now(), p.InAmount, tt.take)
+	w.recordTake(true)
+	if err := w.journal.taken(ref, tt.toTake, tt.take, tt.cfRay, tt.latency); err != nil {
+		w.logf("journal: %v", err)
+	}
+	if w.rates != nil {
+		if rate, ok := w.rates.Rate(p.InAsset, p.OutAsset); ok {
+			w.journal.priced(ref, rate)
+		}
+	}
+	w.events.publish(Event{Type: EventTaken, AccountID: w.accountID, At: time.Now(), Payment: &ref, Amount: p.InAmount, TakeMs: tt.take.Milliseconds(), Latency: tt.latency, RequestID: tt.call.ID, CFRay: tt.cfRay})
+	w.scheduleExpiry(ref, p.ExpiresAt)
+	payer := w.assignPayer(ref)
+	w.inflight.follow()
+	go func() {
+		defer w.inflight.done()
+		defer w.guard("notify")
+		start := time.Now()
+		w.notifyLiveAccepted(p, ref, payer)
+		end := time.Now()
+		tt.span.Step("notify", start, end)
+		tt.span.Finish(end)
+	}()
 }
 
-func (w *Worker) handleLiveRemove(id string) {
+// scheduleExpiry queues the cancel of an unpaid payment at its expiry when
+// the account asks for it.
+func (w *Worker) scheduleExpiry(ref PaymentRef, expiresAt string) {
+	if !w.config().CancelAtExpiry || w.jobs == nil {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		w.logf("cancel at expiry %s: no expires_at", ref)
+		return
+	}
+	at = w.clock.Local(at)
+	id := ref.Hex
 	if id == "" {
+		id = ref.APIID()
+	}
+	w.jobs.Schedule(Job{ID: JobCancelExpiry + ":" + strconv.FormatInt(w.accountID, 10) + ":" + id, Kind: JobCancelExpiry, AccountID: w.accountID, PaymentID: id, At: at})
+}
+
+// skip logs, records and publishes a skip decision.
+func (w *Worker) skip(p p2c.LivePayment, d Decision) {
+	w.logf("skip %s: %s", p.ID, d.Reason)
+	w.skipped(p, d)
+}
+
+// skipped records and publishes a skip decision without logging it: a
+// penalty would log every payment listed while it lasts.
+func (w *Worker) skipped(p p2c.LivePayment, d Decision) {
+	now := time.Now()
+	w.skips.add(SkipRecord{At: now, PaymentID: p.ID, Amount: p.InAmount, Provider: p.Provider, Code: d.Code, Reason: d.Reason})
+	w.events.publish(Event{Type: EventSkipped, AccountID: w.accountID, At: now, Payment: &PaymentRef{Hex: p.ID}, Amount: p.InAmount, Code: d.Code, Reason: d.Reason})
+}
+
+// applyPenalty blocks takes until the penalty end (plus the account cooldown)
+// and notifies once per penalty.
+func (w *Worker) applyPenalty(until time.Time, reason string) {
+	if until.IsZero() {
+		w.logf("penalty without end time (reason=%s), ignored", reason)
+		return
+	}
+	// penalty_end_at по часам P2C: при расхождении часов возобновились бы раньше штрафа или простаивали
+	until = w.clock.Local(until)
+	cooldown := time.Duration(w.config().PenaltyCooldownSec) * time.Second
+	rec, isNew := w.penalties.Apply(w.accountID, until, reason, cooldown)
+	if !isNew {
+		return
+	}
+	resumeAt := rec.ResumeAt()
+	w.events.publish(Event{Type: EventPenalty, AccountID: w.accountID, At: time.Now(), Reason: reason, Until: &resumeAt})
+	w.notify(w.render(tmplPenalty, penaltyData{
+		Account:  w.config().account(),
+		Reason:   reason,
+		Until:    until,
+		ResumeAt: resumeAt,
+		Cooldown: cooldown,
+	}))
+}
+
+// onPenaltyEnd is called by the PenaltyManager when takes are allowed again.
+func (w *Worker) onPenaltyEnd(rec PenaltyRecord) {
+	w.events.publish(Event{Type: EventPenaltyEnd, AccountID: w.accountID, At: time.Now(), Reason: rec.Reason})
+	w.notify(w.render(tmplPenaltyEnd, penaltyData{Account: w.config().account(), Reason: rec.Reason, Until: rec.Until, ResumeAt: rec.ResumeAt()}))
+}
+
+// handleSnapshot backfills after a reconnect: payments of the new snapshot
+// the worker has not seen were added while the socket was down, so they go
+// through the usual take path, highest boost first. The very first snapshot
+// only marks the current list as seen, as before.
+func (w *Worker) handleSnapshot(list []p2c.LivePayment) {
+	now := time.Now()
+	if !w.synced.Swap(true) {
+		for _, p := range list {
+			w.seen.Add(p.ID, now)
+		}
+		return
+	}
+	var missed []p2c.LivePayment
+	maxBoost := 0.0
+	for _, p := range list {
+		if w.seen.Has(p.ID, now) {
+			continue
+		}
+		missed = append(missed, p)
+		maxBoost = max(maxBoost, p.Boost)
+	}
+	if len(missed) == 0 {
 		return
 	}
-	// снимаем лок, чтобы следующая заявка не блокировалась после remove
-	w.clearActiveLock(id)
+	w.logf("backfill %d of %d payments listed while disconnected", len(missed), len(list))
+	w.trace.add(TraceFrame{At: now, Note: fmt.Sprintf("backfill %d", len(missed))})
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].Boost > missed[j].Boost })
+	for _, p := range missed {
+		p.BatchSize = len(missed)
+		p.BatchMaxBoost = maxBoost
+		w.handleLivePayment(p)
+	}
 }
 
-func urlEncode(s string) string {
-	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+func (w *Worker) handleLiveRemove(id string) {
+	if id == "" {
+		return
+	}
+	w.delayed.gone(id)
+	// наша заявка ушла из ленты — ждём оплату, аккаунт свободен для следующей
+	w.journal.delisted(id)
 }
 
 type penaltyPayload struct {
@@ -446,89 +1112,42 @@ func isActiveExists(err error) bool {
 	return strings.Contains(err.Error(), "ActiveOrderExists")
 }
 
-func (w *Worker) shouldNotifyPenalty(until time.Time) bool {
-	if until.IsZero() {
-		return false
-	}
-	if until.After(w.lastPenaltyNotified) {
-		w.lastPenaltyNotified = until
-		return true
-	}
-	return false
-}
-
+// isActiveLocked reports whether a payment of ours still holds the account
+// (see PaymentState) or we are backing off after ActiveOrderExists.
 func (w *Worker) isActiveLocked(now time.Time) bool {
 	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	if w.activeLockUntil.IsZero() && w.activePaymentID == "" {
-		return false
-	}
-	if now.Before(w.activeLockUntil) {
+	backoff := now.Before(w.backoffUntil)
+	w.mu.Unlock()
+	if backoff {
 		return true
 	}
-	w.activePaymentID = ""
-	w.activeLockUntil = time.Time{}
-	return false
-}
-
-func (w *Worker) setActiveLock(id string, expiresAt string) {
-	lockUntil := time.Now().Add(5 * time.Minute)
-	if expiresAt != "" {
-		if t, err := time.Parse(time.RFC3339, expiresAt); err == nil && t.After(time.Now()) {
-			lockUntil = t.Add(10 * time.Second)
-		}
-	}
-	w.mu.Lock()
-	w.activePaymentID = id
-	w.activeLockUntil = lockUntil
-	w.mu.Unlock()
+	_, held := w.journal.holding(now)
+	return held
 }
 
 func (w *Worker) bumpActiveLock() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	backoff := time.Now().Add(2 * time.Second)
-	if w.activeLockUntil.Before(backoff) {
-		w.activeLockUntil = backoff
+	if w.backoffUntil.Before(backoff) {
+		w.backoffUntil = backoff
 	}
 }
 
-func (w *Worker) clearActiveLock(id string) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	if id == "" || id == w.activePaymentID {
-		w.activePaymentID = ""
-		w.activeLockUntil = time.Time{}
+func (w *Worker) notifyLiveAccepted(p p2c.LivePayment, ref PaymentRef, payer int64) {
+	locale := w.config().Locale
+	status := i18n.T(locale, "live.taken_auto")
+	caption := buildLiveCaption(w.render, w.config().account(), p, ref, status)
+	markup := buildPaidKeyboard(locale, w.accountID, p, w.web.URL(w.accountID, p.ID), w.config().PaidOneTap)
+	if payer != 0 {
+		caption += "\n" + i18n.T(locale, "payer.assigned", payerMention(payer))
+		markup = withAck(markup, locale, w.accountID, p.ID)
 	}
-}
-
-func (w *Worker) storeTakeID(hexID string, numericID int64) {
-	if hexID == "" || numericID == 0 {
-		return
-	}
-	w.mu.Lock()
-	w.takeMap[hexID] = numericID
-	w.mu.Unlock()
-}
-
-func (w *Worker) lookupTakeID(hexID string) (int64, bool) {
-	if hexID == "" {
-		return 0, false
-	}
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	num, ok := w.takeMap[hexID]
-	return num, ok
-}
-
-func (w *Worker) notifyLiveAccepted(p p2c.LivePayment, numericID int64) {
-	status := "🤖 Заявка принята автоматически ✅"
-	qrURL := fmt.Sprintf("https://quickchart.io/qr?text=%s&size=200", urlEncode(p.URL))
-	caption := buildLiveCaption(p, status)
-	if err := w.sendTelegramPhoto(qrURL, caption, buildPaidKeyboard(w.cfg.AccountID, p)); err != nil {
-		log.Printf("[worker %d] telegram photo error: %v", w.cfg.AccountID, err)
-		w.sendTelegram(caption)
-		return
+	// QR строится локально: ссылка на оплату не уходит сторонним сервисам
+	photo, err := qr.PNG(p.URL, qrSize)
+	if err != nil {
+		w.logf("qr for %s: %v", p.ID, err)
+		photo = nil
 	}
+	w.send(Notification{Text: caption, Photo: photo, Markup: markup, Sent: w.cardSent(ref, payer), Key: orderKey(w.accountID, ref), Until: orderUntil(p.ExpiresAt, w.clock), Dedup: dedupKey(EventTaken, w.accountID, ref)})
 }
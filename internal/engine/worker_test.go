@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"p2c-engine/internal/p2c"
+	"p2c-engine/internal/p2c/p2ctest"
+)
+
+// startWorker runs one account of a Manager against the fake market and
+// waits until the worker listens to its feed.
+func startWorker(t *testing.T, cfg WorkerConfig) (*Manager, *Worker, *p2ctest.API) {
+	t.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	api := p2ctest.New()
+	m := NewManager(p2c.NewClient("https://example.invalid", ""), "", nil)
+	m.UseAPI(func(WorkerConfig) (p2c.API, error) { return api, nil })
+	cfg.Active, cfg.AutoMode = true, true
+	m.ReloadAccount(cfg)
+	t.Cleanup(func() { m.Shutdown(context.Background()) })
+	w := m.worker(cfg.AccountID)
+	if w == nil {
+		t.Fatalf("account %d did not start", cfg.AccountID)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !api.Feed().Connected(); {
+		if time.Now().After(deadline) {
+			t.Fatal("worker did not connect to the feed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return m, w, api
+}
+
+// list puts the payment on the market, which delivers it to the worker, and
+// waits for the take it started.
+func list(w *Worker, api *p2ctest.API, p p2c.LivePayment) {
+	api.List(p)
+	w.inflight.settle()
+}
+
+func livePayment(id, amount string) p2c.LivePayment {
+	return p2c.LivePayment{ID: id, InAmount: amount, InAsset: "RUB", OutAsset: "USDT", Provider: "sbp", ExpiresAt: time.Now().Add(15 * time.Minute).UTC().Format(time.RFC3339)}
+}
+
+func TestFilterSkipsPayment(t *testing.T) {
+	minAmount := 5000.0
+	_, w, api := startWorker(t, WorkerConfig{AccountID: 1, MinAmount: &minAmount})
+
+	p := livePayment("6f1c2a9e0001", "4500.00")
+	list(w, api, p)
+	if got := api.Count("take"); got != 0 {
+		t.Fatalf("takes = %d, want 0 below min_amount", got)
+	}
+	skips := w.Skips(SkipQuery{PaymentID: p.ID})
+	if len(skips) != 1 || skips[0].Code != SkipBelowMin {
+		t.Fatalf("skips = %+v, want one %s", skips, SkipBelowMin)
+	}
+}
+
+func TestTakeHoldsAccount(t *testing.T) {
+	_, w, api := startWorker(t, WorkerConfig{AccountID: 2})
+
+	first := livePayment("6f1c2a9e0002", "4500.00")
+	list(w, api, first)
+	if taken, ok := api.Payment(first.ID); !ok || taken.Status != p2c.StatusProcessing {
+		t.Fatalf("first payment: status %q, want %q", taken.Status, p2c.StatusProcessing)
+	}
+	if rec, ok := w.Payment(first.ID); !ok || rec.Status != StateAwaitingPayment {
+		t.Fatalf("journal: %+v, want %s", rec, StateAwaitingPayment)
+	}
+
+	// ушла из ленты — рынок держит аккаунт до оплаты: ActiveOrderExists, и воркер отступает
+	second := livePayment("6f1c2a9e0003", "4600.00")
+	list(w, api, second)
+	if taken, _ := api.Payment(second.ID); taken.Status != "" {
+		t.Fatalf("second payment taken (%s) while the first is unpaid", taken.Status)
+	}
+	if !w.isActiveLocked(time.Now()) {
+		t.Fatal("account not held after ActiveOrderExists")
+	}
+	third := livePayment("6f1c2a9e0004", "4700.00")
+	list(w, api, third)
+	if got := api.Count("take"); got != 2 {
+		t.Fatalf("takes = %d, want 2: the third must not be tried", got)
+	}
+	if skips := w.Skips(SkipQuery{PaymentID: third.ID}); len(skips) != 1 || skips[0].Code != SkipActive {
+		t.Fatalf("skips = %+v, want one %s", skips, SkipActive)
+	}
+}
+
+func TestTakePenalty(t *testing.T) {
+	m, w, api := startWorker(t, WorkerConfig{AccountID: 3})
+	end := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	body := []byte(`{"error":"MerchantPenalized","penalty_type":"cancel_rate","penalty_end_at":"` + end.Format(time.RFC3339) + `"}`)
+	api.Take = func(id, idemKey string, take func() (*p2c.TakeResult, error)) (*p2c.TakeResult, error) {
+		return &p2c.TakeResult{Status: http.StatusBadRequest, Body: body}, &p2c.StatusError{Op: "take payment", Status: http.StatusBadRequest, Body: body}
+	}
+
+	list(w, api, livePayment("6f1c2a9e0005", "4500.00"))
+	active, _ := m.Penalties(w.accountID)
+	if active == nil || active.Reason != "cancel_rate" || !active.Until.Equal(end) {
+		t.Fatalf("penalty = %+v, want cancel_rate until %s", active, end)
+	}
+
+	p := livePayment("6f1c2a9e0006", "4500.00")
+	list(w, api, p)
+	if got := api.Count("take"); got != 1 {
+		t.Fatalf("takes = %d, want 1 under penalty", got)
+	}
+	if skips := w.Skips(SkipQuery{PaymentID: p.ID}); len(skips) != 1 || skips[0].Code != SkipPenalty {
+		t.Fatalf("skips = %+v, want one %s", skips, SkipPenalty)
+	}
+}
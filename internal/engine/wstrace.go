@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// TraceFrame is a raw websocket frame (or a connection note) with its receive time.
+type TraceFrame struct {
+	At   time.Time `json:"at"`
+	Data string    `json:"data,omitempty"`
+	Note string    `json:"note,omitempty"`
+}
+
+// frameRing keeps the last N frames of a worker for race/desync analysis.
+type frameRing struct {
+	mu   sync.Mutex
+	buf  []TraceFrame
+	next int
+	full bool
+}
+
+func newFrameRing(size int) *frameRing {
+	if size <= 0 {
+		size = 500
+	}
+	return &frameRing{buf: make([]TraceFrame, size)}
+}
+
+func (r *frameRing) add(f TraceFrame) {
+	r.mu.Lock()
+	r.buf[r.next] = f
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// last returns up to limit most recent frames, oldest first; limit <= 0 means all.
+func (r *frameRing) last(limit int) []TraceFrame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []TraceFrame
+	if r.full {
+		out = append(out, r.buf[r.next:]...)
+	}
+	out = append(out, r.buf[:r.next]...)
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// WSTrace returns up to limit most recent websocket frames, oldest first.
+func (w *Worker) WSTrace(limit int) []TraceFrame {
+	return w.trace.last(limit)
+}
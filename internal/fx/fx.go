@@ -0,0 +1,142 @@
+// Package fx keeps reference market prices of crypto assets in fiat from
+// public sources (CBR, Binance). Prices are refreshed in the background and
+// served from memory, so filters on the take path never wait on the network.
+package fx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pair is an asset priced in a fiat, e.g. USDT/RUB.
+type Pair struct {
+	Asset string
+	Fiat  string
+}
+
+func (p Pair) String() string { return p.Asset + "/" + p.Fiat }
+
+// ParsePairs parses "USDT/RUB,USDT/KZT".
+func ParsePairs(items []string) ([]Pair, error) {
+	pairs := make([]Pair, 0, len(items))
+	for _, item := range items {
+		asset, fiat, ok := strings.Cut(item, "/")
+		if !ok || asset == "" || fiat == "" {
+			return nil, fmt.Errorf("bad pair %q, want ASSET/FIAT", item)
+		}
+		pairs = append(pairs, Pair{Asset: strings.ToUpper(asset), Fiat: strings.ToUpper(fiat)})
+	}
+	return pairs, nil
+}
+
+// Source fetches the current prices of pairs. Pairs it does not quote are
+// left out of the result.
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context, pairs []Pair) (map[Pair]float64, error)
+}
+
+// NewSource returns a source by name: "cbr" or "binance".
+func NewSource(name string) (Source, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch name {
+	case "cbr":
+		return &CBR{client: client}, nil
+	case "binance":
+		return &Binance{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown fx source %q (cbr, binance)", name)
+	}
+}
+
+type quote struct {
+	price float64
+	at    time.Time
+}
+
+// Oracle caches the prices of a Source. A price older than maxAge is not
+// served: a filter must not decide on yesterday's market.
+type Oracle struct {
+	src      Source
+	pairs    []Pair
+	interval time.Duration
+	maxAge   time.Duration
+
+	mu     sync.RWMutex
+	quotes map[Pair]quote
+}
+
+// NewOracle polls src for pairs every interval; prices are served for up to
+// maxAge after the last successful fetch.
+func NewOracle(src Source, pairs []Pair, interval, maxAge time.Duration) *Oracle {
+	return &Oracle{src: src, pairs: pairs, interval: interval, maxAge: maxAge, quotes: make(map[Pair]quote)}
+}
+
+// Run refreshes prices until ctx is done.
+func (o *Oracle) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		o.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Oracle) refresh(ctx context.Context) {
+	prices, err := o.src.Fetch(ctx, o.pairs)
+	if err != nil {
+		log.Printf("[fx] %s: %v", o.src.Name(), err)
+	}
+	now := time.Now()
+	o.mu.Lock()
+	for p, v := range prices {
+		if v > 0 {
+			o.quotes[p] = quote{price: v, at: now}
+		}
+	}
+	o.mu.Unlock()
+}
+
+// Rate returns the price of one unit of asset in fiat.
+func (o *Oracle) Rate(fiat, asset string) (float64, bool) {
+	o.mu.RLock()
+	q, ok := o.quotes[Pair{Asset: strings.ToUpper(asset), Fiat: strings.ToUpper(fiat)}]
+	o.mu.RUnlock()
+	if !ok || time.Since(q.at) > o.maxAge {
+		return 0, false
+	}
+	return q.price, true
+}
+
+// Quote is a cached price for the status API.
+type Quote struct {
+	Pair   string    `json:"pair"`
+	Price  float64   `json:"price"`
+	At     time.Time `json:"at"`
+	Stale  bool      `json:"stale"`
+	Source string    `json:"source"`
+}
+
+// Quotes lists the cached prices.
+func (o *Oracle) Quotes() []Quote {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	out := make([]Quote, 0, len(o.pairs))
+	for _, p := range o.pairs {
+		q, ok := o.quotes[p]
+		if !ok {
+			continue
+		}
+		out = append(out, Quote{Pair: p.String(), Price: q.price, At: q.at, Stale: time.Since(q.at) > o.maxAge, Source: o.src.Name()})
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// stablecoins are priced as the currency they track.
+var stablecoins = map[string]string{"USDT": "USD", "USDC": "USD", "FDUSD": "USD"}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CBR is the official rate of the Bank of Russia: RUB pairs only, with
+// stablecoins priced as the currency they track.
+type CBR struct {
+	client *http.Client
+	URL    string // по умолчанию зеркало cbr-xml-daily.ru
+}
+
+func (s *CBR) Name() string { return "cbr" }
+
+func (s *CBR) Fetch(ctx context.Context, pairs []Pair) (map[Pair]float64, error) {
+	url := s.URL
+	if url == "" {
+		url = "https://www.cbr-xml-daily.ru/daily_json.js"
+	}
+	var daily struct {
+		Valute map[string]struct {
+			Nominal float64 `json:"Nominal"`
+			Value   float64 `json:"Value"`
+		} `json:"Valute"`
+	}
+	if err := getJSON(ctx, s.client, url, &daily); err != nil {
+		return nil, err
+	}
+	out := make(map[Pair]float64)
+	for _, p := range pairs {
+		if p.Fiat != "RUB" {
+			continue
+		}
+		code := p.Asset
+		if c, ok := stablecoins[code]; ok {
+			code = c
+		}
+		if v, ok := daily.Valute[code]; ok && v.Nominal > 0 {
+			out[p] = v.Value / v.Nominal
+		}
+	}
+	return out, nil
+}
+
+// Binance is the last trade price of the ASSETFIAT spot ticker, e.g. USDTRUB.
+type Binance struct {
+	client *http.Client
+	URL    string // по умолчанию api.binance.com
+}
+
+func (s *Binance) Name() string { return "binance" }
+
+func (s *Binance) Fetch(ctx context.Context, pairs []Pair) (map[Pair]float64, error) {
+	base := s.URL
+	if base == "" {
+		base = "https://api.binance.com"
+	}
+	out := make(map[Pair]float64)
+	var lastErr error
+	for _, p := range pairs {
+		var ticker struct {
+			Price string `json:"price"`
+		}
+		if err := getJSON(ctx, s.client, base+"/api/v3/ticker/price?symbol="+p.Asset+p.Fiat, &ticker); err != nil {
+			lastErr = err
+			continue
+		}
+		if v, err := strconv.ParseFloat(ticker.Price, 64); err == nil {
+			out[p] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil, lastErr
+	}
+	return out, nil
+}
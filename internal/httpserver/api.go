@@ -0,0 +1,170 @@
+package httpserver
+
+import (
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/p2c"
+)
+
+// JSON contract of the control API. Handlers decode and encode these types
+// and /openapi.json is generated from the same struct tags.
+
+type reloadRequest struct {
+	AccountID            int64                   `json:"account_id"`
+	AccessToken          string                  `json:"access_token"`
+	ChatID               int64                   `json:"chat_id"`
+	MinAmount            *float64                `json:"min_amount"`
+	MaxAmount            *float64                `json:"max_amount"`
+	MinOutAmount         *float64                `json:"min_out_amount"`
+	MaxOutAmount         *float64                `json:"max_out_amount"`
+	AutoMode             *bool                   `json:"auto_mode"`
+	IsActive             *bool                   `json:"is_active"`
+	P2CAccountID         string                  `json:"p2c_account_id"`
+	WarmConns            int                     `json:"warm_conns"`
+	Strategy             engine.StrategyConfig   `json:"strategy"`
+	Priority             int                     `json:"priority"`
+	Weight               int                     `json:"weight"`
+	DailyCap             float64                 `json:"daily_cap"`
+	OwnerGroup           string                  `json:"owner_group"`
+	OwnerGroupCap        float64                 `json:"owner_group_cap"`
+	PenaltyCooldownSec   int                     `json:"penalty_cooldown_sec"`
+	WSTraceSize          int                     `json:"ws_trace_size"`
+	PollFallbackAfterSec int                     `json:"poll_fallback_after_sec"`
+	PollIntervalMs       int                     `json:"poll_interval_ms"`
+	ProvidersAllow       []string                `json:"providers_allow"`
+	ProvidersDeny        []string                `json:"providers_deny"`
+	AmountsAllow         []string                `json:"amounts_allow"`
+	AmountsDeny          []string                `json:"amounts_deny"`
+	Locale               string                  `json:"locale"`
+	Breaker              engine.BreakerConfig    `json:"breaker"`
+	Label                string                  `json:"label"`
+	Owner                string                  `json:"owner"`
+	Note                 string                  `json:"note"`
+	Transport            p2c.TransportConfig     `json:"transport"`
+	WSStandby            bool                    `json:"ws_standby"`
+	SlackWebhook         string                  `json:"slack_webhook"`
+	DiscordWebhook       string                  `json:"discord_webhook"`
+	WebhookURL           string                  `json:"webhook_url"`
+	WebhookSecret        string                  `json:"webhook_secret"`
+	CancelAtExpiry       bool                    `json:"cancel_at_expiry"`
+	TakeDelayMs          int                     `json:"take_delay_ms"`
+	Payers               []int64                 `json:"payers"`
+	PayerAckSec          int                     `json:"payer_ack_sec"`
+	PaidOneTap           bool                    `json:"paid_one_tap"`
+	PaidUsers            []int64                 `json:"paid_users"`
+	OrderCard            bool                    `json:"order_card"`
+	MessageThreadID      int64                   `json:"message_thread_id"`
+	AutoTopic            bool                    `json:"auto_topic"`
+	Environment          string                  `json:"environment"` // prod | sandbox
+	SkipSummary          bool                    `json:"skip_summary"`
+	DeafAfterSec         int                     `json:"deaf_after_sec"`
+	MarketEdgePct        float64                 `json:"market_edge_pct"`
+	AutoTune             bool                    `json:"auto_tune"`
+	BrandGuard           engine.BrandGuardConfig `json:"brand_guard"`
+	FrameLog             engine.FrameLogConfig   `json:"frame_log"`
+}
+
+type takeRequest struct {
+	AccountID       int64  `json:"account_id"`
+	OrderExternalID string `json:"order_external_id"`
+}
+
+// paymentRequest is the body of complete/cancel; payment_id accepts the hex
+// or the numeric id.
+type paymentRequest struct {
+	AccountID int64  `json:"account_id"`
+	PaymentID string `json:"payment_id"`
+	UserID    int64  `json:"user_id,omitempty"` // Telegram id нажавшего кнопку
+}
+
+type okResponse struct {
+	Status    string `json:"status"`
+	OK        bool   `json:"ok"`
+	AccountID int64  `json:"account_id,omitempty"`
+}
+
+type statusOnlyResponse struct {
+	Status string `json:"status"`
+}
+
+type errorResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+type paymentResponse struct {
+	Status  string            `json:"status"`
+	Payment engine.PaymentRef `json:"payment"`
+	Error   string            `json:"error,omitempty"` // с req= и cf-ray= для поддержки P2C
+}
+
+// batchRequest is the body of /payments/complete-batch and /payments/cancel-batch.
+type batchRequest struct {
+	Items []engine.BatchItem `json:"items"`
+}
+
+type batchResponse struct {
+	Status  string               `json:"status"` // ok | partial
+	Done    int                  `json:"done"`
+	Failed  int                  `json:"failed"`
+	Results []engine.BatchResult `json:"results"`
+}
+
+type penaltiesResponse struct {
+	Active  *engine.PenaltyRecord  `json:"active"`
+	History []engine.PenaltyRecord `json:"history"`
+}
+
+type deleteResponse struct {
+	Status    string              `json:"status"`
+	AccountID int64               `json:"account_id"`
+	Stopped   bool                `json:"stopped"`
+	Canceled  []engine.PaymentRef `json:"canceled,omitempty"`
+	Failed    map[string]string   `json:"failed,omitempty"`
+}
+
+// takeResponse carries the take details, on errors as far as known.
+type takeResponse struct {
+	Status string             `json:"status"`
+	OK     bool               `json:"ok"`
+	Error  string             `json:"error,omitempty"`
+	Take   *engine.TakeResult `json:"take,omitempty"`
+}
+
+type searchResponse struct {
+	Count    int                    `json:"count"`
+	Payments []engine.PaymentRecord `json:"payments"`
+}
+
+type paymentsResponse struct {
+	AccountID int64                  `json:"account_id"`
+	Payments  []engine.PaymentRecord `json:"payments"`
+}
+
+type liveOrdersResponse struct {
+	AccountID int64              `json:"account_id"`
+	Connected bool               `json:"connected"`
+	Orders    []engine.LiveOrder `json:"orders"`
+}
+
+type clearPenaltyResponse struct {
+	Status  string `json:"status"`
+	Cleared bool   `json:"cleared"`
+}
+
+type cursorResetResponse struct {
+	Status    string `json:"status"`
+	OK        bool   `json:"ok"`
+	AccountID int64  `json:"account_id"`
+	Previous  string `json:"previous,omitempty"` // курсор до сброса
+}
+
+type traceResponse struct {
+	AccountID int64               `json:"account_id"`
+	Frames    []engine.TraceFrame `json:"frames"`
+}
+
+type skipsResponse struct {
+	AccountID int64                   `json:"account_id"`
+	Counts    map[engine.SkipCode]int `json:"counts"`
+	Skips     []engine.SkipRecord     `json:"skips"` // новые первыми
+}
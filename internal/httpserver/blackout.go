@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"p2c-engine/internal/engine"
+)
+
+// blackoutRequest adds a calendar window without auto-take. Bounds are
+// YYYY-MM-DD, YYYY-MM-DDTHH:MM (engine local time) or RFC 3339; without to
+// the window is the whole day of from. Without account_id it covers every
+// account of the tenant.
+type blackoutRequest struct {
+	AccountID int64  `json:"account_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+}
+
+type blackoutsResponse struct {
+	Blackouts []engine.Blackout `json:"blackouts"`
+}
+
+type blackoutResponse struct {
+	Status   string          `json:"status"`
+	OK       bool            `json:"ok"`
+	Blackout engine.Blackout `json:"blackout"`
+}
+
+// handleBlackouts lists the current and upcoming blackouts of the tenant;
+// ?account_id= narrows them to the ones covering that account.
+func (s *Server) handleBlackouts(w http.ResponseWriter, r *http.Request) {
+	var accountID int64
+	if v := r.URL.Query().Get("account_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid account_id"})
+			return
+		}
+		if !s.authorizeAccount(w, r, id) {
+			return
+		}
+		accountID = id
+	}
+	bos := s.mgr.Blackouts(tenantFrom(r.Context()), accountID)
+	if bos == nil {
+		bos = []engine.Blackout{}
+	}
+	writeJSON(w, http.StatusOK, blackoutsResponse{Blackouts: bos})
+}
+
+func (s *Server) handleAddBlackout(w http.ResponseWriter, r *http.Request) {
+	var req blackoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" || req.AccountID < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "from is required"})
+		return
+	}
+	if req.AccountID != 0 && !s.authorizeAccount(w, r, req.AccountID) {
+		return
+	}
+	from, err := engine.ParseBlackoutTime(req.From, false)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	end := req.To
+	if end == "" {
+		end = req.From
+	}
+	to, err := engine.ParseBlackoutTime(end, true)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	bo, err := s.mgr.AddBlackout(tenantFrom(r.Context()), req.AccountID, from, to, req.Reason)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, blackoutResponse{Status: "added", OK: true, Blackout: bo})
+}
+
+func (s *Server) handleRemoveBlackout(w http.ResponseWriter, r *http.Request) {
+	err := s.mgr.RemoveBlackout(tenantFrom(r.Context()), r.PathValue("blackout"))
+	if errors.Is(err, engine.ErrNoBlackout) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOnlyResponse{Status: "removed"})
+}
@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"p2c-engine/internal/engine"
+)
+
+type brandsResponse struct {
+	Days   int                 `json:"days"`
+	Brands []engine.BrandStats `json:"brands"`
+}
+
+// handleBrands reports the outcomes of the tenant's orders per brand over
+// ?days= (default engine.BrandReportDays), the most disputed first.
+func (s *Server) handleBrands(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days := engine.BrandReportDays
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid days"})
+			return
+		}
+		days = min(n, engine.BrandReportMaxDays)
+	}
+	var accountID int64
+	if v := q.Get("account_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid account_id"})
+			return
+		}
+		if !s.authorizeAccount(w, r, id) {
+			return
+		}
+		accountID = id
+	}
+	brands, err := s.mgr.BrandReport(tenantFrom(r.Context()), accountID, days)
+	if errors.Is(err, engine.ErrNoWorker) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, brandsResponse{Days: days, Brands: brands})
+}
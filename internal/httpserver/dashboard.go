@@ -0,0 +1,26 @@
+package httpserver
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// dashboardFS holds the admin UI. The assets carry no data: the page calls
+// the API with the operator's token, so they are served without auth.
+//
+//go:embed dashboard
+var dashboardFS embed.FS
+
+func dashboardHandler() http.Handler {
+	sub, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/admin/", http.FileServerFS(sub))
+}
+
+func isDashboardAsset(r *http.Request) bool {
+	return r.Method == http.MethodGet && (r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/"))
+}
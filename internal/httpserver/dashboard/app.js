@@ -0,0 +1,211 @@
+"use strict";
+
+// Админка движка: опрашивает API раз в несколько секунд, токен хранится в localStorage.
+const REFRESH_MS = 3000;
+let token = localStorage.getItem("p2c_token") || "";
+let selected = null;
+
+const $ = (sel) => document.querySelector(sel);
+
+function el(tag, text, cls) {
+  const node = document.createElement(tag);
+  if (text !== undefined && text !== null) node.textContent = String(text);
+  if (cls) node.className = cls;
+  return node;
+}
+
+function row(cells) {
+  const tr = document.createElement("tr");
+  for (const c of cells) {
+    const td = document.createElement("td");
+    if (c instanceof Node) td.appendChild(c);
+    else td.textContent = c === undefined || c === null ? "" : String(c);
+    tr.appendChild(td);
+  }
+  return tr;
+}
+
+async function api(path, opts = {}) {
+  const headers = Object.assign({}, opts.headers);
+  if (token) headers["Authorization"] = "Bearer " + token;
+  const resp = await fetch(path, Object.assign({}, opts, { headers }));
+  const body = await resp.json().catch(() => ({}));
+  if (!resp.ok) throw new Error(body.error || resp.status + " " + resp.statusText);
+  return body;
+}
+
+function showError(err) {
+  const p = $("#error");
+  p.hidden = !err;
+  p.textContent = err ? String(err.message || err) : "";
+}
+
+function isSet(t) {
+  return t && !t.startsWith("0001-");
+}
+
+function clock(t) {
+  return isSet(t) ? new Date(t).toLocaleTimeString() : "";
+}
+
+function stamp(t) {
+  return isSet(t) ? new Date(t).toLocaleString() : "";
+}
+
+function future(t) {
+  return isSet(t) && new Date(t) > new Date();
+}
+
+function accountName(w) {
+  let name = String(w.account_id);
+  if (w.label) name += " «" + w.label + "»";
+  if (w.owner) name += " (" + w.owner + ")";
+  return name;
+}
+
+function paymentName(ref) {
+  if (!ref) return "";
+  if (ref.numeric_id) return "#" + ref.numeric_id;
+  return ref.id || "";
+}
+
+function action(label, path, confirmText) {
+  const b = el("button", label);
+  b.addEventListener("click", async (ev) => {
+    ev.stopPropagation();
+    if (confirmText && !confirm(confirmText)) return;
+    try {
+      await api(path, { method: "POST" });
+      showError(null);
+      refresh();
+    } catch (err) {
+      showError(err);
+    }
+  });
+  return b;
+}
+
+function renderWorkers(status) {
+  const tbody = $("#workers tbody");
+  tbody.replaceChildren();
+  for (const w of status.workers || []) {
+    let mode;
+    if (!w.active) mode = el("span", "остановлен", "muted");
+    else if (w.paused) mode = el("span", "пауза", "warn");
+    else mode = el("span", w.auto_mode ? "авто" : "ручной", "ok");
+
+    let block = "";
+    if (future(w.penalty_until)) block = el("span", "P2C до " + clock(w.penalty_until) + " " + (w.penalty_reason || ""), "bad");
+    else if (future(w.breaker_until)) block = el("span", "breaker до " + clock(w.breaker_until), "warn");
+
+    const buttons = el("span");
+    buttons.append(
+      w.paused ? action("Продолжить", `/accounts/${w.account_id}/resume`) : action("Пауза", `/accounts/${w.account_id}/pause`),
+      " ",
+      action("Перезапуск", `/accounts/${w.account_id}/restart`, "Перезапустить воркер " + accountName(w) + "?"),
+    );
+
+    const tr = row([
+      accountName(w),
+      mode,
+      (w.min_amount ?? "—") + " … " + (w.max_amount ?? "—"),
+      w.day_count + " / " + Number(w.day_volume || 0).toFixed(2),
+      paymentName(w.active_payment),
+      block,
+      // HTTP/2 мультиплексирует пинги в одно соединение: показываем реальные соединения
+      w.warm ? w.warm.last_ok + "/" + w.warm.conns + " (" + w.warm.live_conns + " conn)" : "",
+      buttons,
+    ]);
+    tr.className = "worker" + (w.account_id === selected ? " selected" : "");
+    tr.addEventListener("click", () => {
+      selected = w.account_id;
+      refresh();
+    });
+    tbody.appendChild(tr);
+  }
+
+  const m = status.mirrors;
+  $("#mirrors").textContent = m ? "P2C: " + m.current + (m.reason ? " (переключено " + stamp(m.switched_at) + ": " + m.reason + ")" : "") : "";
+}
+
+function latencyText(l) {
+  if (!l) return "";
+  return `разбор ${l.parse_us}µs · очередь ${l.queue_us}µs · фильтры ${l.filter_us}µs · сеть ${l.network_ms}ms · сервер ${l.server_ms}ms · всего ${l.total_ms}ms`;
+}
+
+function card(title, value) {
+  const c = el("div", null, "card");
+  c.append(el("span", title, "muted"), el("b", value));
+  return c;
+}
+
+async function renderDetails() {
+  const section = $("#details");
+  if (selected === null) {
+    section.hidden = true;
+    return;
+  }
+  const id = selected;
+  const [stats, payments, penalties] = await Promise.all([
+    api(`/accounts/${id}/stats?period=today`),
+    api(`/accounts/${id}/payments?limit=20`),
+    api(`/accounts/${id}/penalties`),
+  ]);
+  section.hidden = false;
+  $("#details-title").textContent = id;
+
+  const t = stats.total || {};
+  $("#stats").replaceChildren(
+    card("Увидено", t.seen),
+    card("Взято", t.taken),
+    card("Ошибок take", t.take_failed),
+    card("Оплачено", t.completed),
+    card("Объём", Number(t.volume || 0).toFixed(2)),
+    card("Вознаграждение", Number(t.reward || 0).toFixed(4)),
+    card("Средний take", Math.round(stats.avg_take_ms || 0) + " мс"),
+    card("Win rate", ((stats.win_rate || 0) * 100).toFixed(1) + "%"),
+  );
+
+  const pb = $("#payments tbody");
+  pb.replaceChildren();
+  for (const p of payments.payments || []) {
+    pb.appendChild(row([
+      stamp(p.taken_at),
+      paymentName(p.payment),
+      p.status,
+      (p.in_amount || "") + " " + (p.in_asset || ""),
+      p.brand_name,
+      p.take_ms || "",
+      latencyText(p.latency),
+    ]));
+  }
+
+  const nb = $("#penalties tbody");
+  nb.replaceChildren();
+  const list = (penalties.active ? [penalties.active] : []).concat(penalties.history || []);
+  for (const p of list) {
+    nb.appendChild(row([stamp(p.started_at), stamp(p.until), p.reason, stamp(p.resumed_at)]));
+  }
+}
+
+async function refresh() {
+  try {
+    renderWorkers(await api("/status"));
+    await renderDetails();
+    showError(null);
+    $("#updated").textContent = "обновлено " + new Date().toLocaleTimeString();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+$("#token").value = token;
+$("#token-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  token = $("#token").value.trim();
+  localStorage.setItem("p2c_token", token);
+  refresh();
+});
+
+refresh();
+setInterval(refresh, REFRESH_MS);
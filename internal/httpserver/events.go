@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleEvents streams worker events as Server-Sent Events. The stream ends
+// when the worker stops; EventSource then reconnects to its successor.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	events, cancel, ok := s.mgr.Subscribe(accountID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "error": "worker not running"})
+		return
+	}
+	defer cancel()
+
+	// поток живёт дольше WriteTimeout сервера — снимаем дедлайн для этого ответа
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-events:
+			if !ok {
+				// воркер остановлен или перезапущен: клиент переподключится к новому
+				return
+			}
+			data, _ := json.Marshal(ev)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
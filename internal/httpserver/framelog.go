@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"p2c-engine/internal/engine"
+)
+
+type frameLogResponse struct {
+	Status    string                `json:"status"`
+	OK        bool                  `json:"ok"`
+	AccountID int64                 `json:"account_id"`
+	FrameLog  engine.FrameLogStatus `json:"frame_log"`
+}
+
+// handleFrameLog returns how the worker logs websocket frames.
+func (s *Server) handleFrameLog(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	st, err := s.mgr.FrameLog(accountID)
+	s.writeFrameLog(w, accountID, st, err)
+}
+
+// handleSetFrameLog switches the frame logging of a running worker, e.g. to
+// full for the duration of an incident; the account config is not changed.
+func (s *Server) handleSetFrameLog(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	var req engine.FrameLogConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Mode == "" || !engine.ValidFrameLogMode(req.Mode) || req.Every < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "mode must be off, errors, sample or full"})
+		return
+	}
+	st, err := s.mgr.SetFrameLog(accountID, req)
+	s.writeFrameLog(w, accountID, st, err)
+}
+
+func (s *Server) writeFrameLog(w http.ResponseWriter, accountID int64, st engine.FrameLogStatus, err error) {
+	if errors.Is(err, engine.ErrNoWorker) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, frameLogResponse{Status: "ok", OK: true, AccountID: accountID, FrameLog: st})
+}
@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"p2c-engine/internal/engine"
+)
+
+// handoffRequest names the account to re-take the order; without
+// to_account_id any eligible account of the tenant may take it.
+type handoffRequest struct {
+	ToAccountID int64 `json:"to_account_id"`
+}
+
+type handoffResponse struct {
+	Status  string         `json:"status"`
+	OK      bool           `json:"ok"`
+	Handoff engine.Handoff `json:"handoff"`
+}
+
+// handleHandoff releases an accepted order the account cannot pay, for
+// another account to re-take once P2C lists it again.
+func (s *Server) handleHandoff(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	var req handoffRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ToAccountID < 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid body"})
+			return
+		}
+	}
+	ho, err := s.mgr.Handoff(r.Context(), accountID, r.PathValue("payment"), req.ToAccountID)
+	switch {
+	case errors.Is(err, engine.ErrNoWorker):
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+	case errors.Is(err, engine.ErrForeignAccount):
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: err.Error()})
+	case errors.Is(err, engine.ErrNoHandoff):
+		writeJSON(w, http.StatusConflict, errorResponse{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadGateway, errorResponse{Status: "error", Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, handoffResponse{Status: "released", OK: true, Handoff: ho})
+	}
+}
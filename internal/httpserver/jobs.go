@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"p2c-engine/internal/engine"
+)
+
+// scheduleRequest defers complete or cancel of a payment: at a moment, or
+// after a delay.
+type scheduleRequest struct {
+	Action   string    `json:"action"` // complete | cancel
+	At       time.Time `json:"at"`
+	AfterSec int       `json:"after_sec"`
+}
+
+// ackRequest names the Telegram user who picked up an assigned order.
+type ackRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+type jobsResponse struct {
+	AccountID int64        `json:"account_id"`
+	Jobs      []engine.Job `json:"jobs"`
+}
+
+type jobResponse struct {
+	Status string     `json:"status"`
+	Job    engine.Job `json:"job"`
+}
+
+// handleJobs lists the pending deferred actions of the account.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, jobsResponse{AccountID: accountID, Jobs: s.mgr.Jobs(accountID)})
+}
+
+// handleSchedule defers complete or cancel of a taken payment.
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.At.IsZero() && req.AfterSec <= 0) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "action and at or after_sec are required"})
+		return
+	}
+	kind := engine.JobComplete
+	switch req.Action {
+	case "complete":
+	case "cancel":
+		kind = engine.JobCancelExpiry
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "action must be complete or cancel"})
+		return
+	}
+	at := req.At
+	if at.IsZero() {
+		at = time.Now().Add(time.Duration(req.AfterSec) * time.Second)
+	}
+	job, err := s.mgr.ScheduleJob(accountID, kind, r.PathValue("payment"), at)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, jobResponse{Status: "scheduled", Job: job})
+}
+
+// handleCancelJob drops a pending deferred action.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	if !s.mgr.CancelJob(accountID, r.PathValue("job")) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: "job not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, okResponse{Status: "canceled", OK: true, AccountID: accountID})
+}
+
+// handleAck stops the escalation of an order assigned to the payer pool.
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	var req ackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "user_id is required"})
+		return
+	}
+	err := s.mgr.AckPayment(accountID, r.PathValue("payment"), req.UserID)
+	switch {
+	case errors.Is(err, engine.ErrNotPayer):
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: err.Error()})
+	case errors.Is(err, engine.ErrNotAssigned):
+		writeJSON(w, http.StatusConflict, errorResponse{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, okResponse{Status: "acknowledged", OK: true, AccountID: accountID})
+	}
+}
@@ -0,0 +1,48 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"p2c-engine/internal/logx"
+)
+
+// logLevelRequest switches the engine log level; with duration_sec the level
+// returns to the previous one afterwards, e.g. debug for ten minutes during
+// an incident.
+type logLevelRequest struct {
+	Level       string `json:"level"`
+	DurationSec int    `json:"duration_sec"`
+}
+
+type logLevelResponse struct {
+	Status string     `json:"status"`
+	OK     bool       `json:"ok"`
+	Log    logx.State `json:"log"`
+}
+
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelResponse{Status: "ok", OK: true, Log: logx.Current()})
+}
+
+// handleSetLogLevel changes the level of the whole process, so only the
+// default tenant may call it, as with maintenance.
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if tenantFrom(r.Context()) != "" {
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: "log level is engine-wide"})
+		return
+	}
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Level == "" || req.DurationSec < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "level is required"})
+		return
+	}
+	level, err := logx.ParseLevel(req.Level)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	st := logx.SetLevel(level, time.Duration(req.DurationSec)*time.Second)
+	writeJSON(w, http.StatusOK, logLevelResponse{Status: "ok", OK: true, Log: st})
+}
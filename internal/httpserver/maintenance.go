@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"p2c-engine/internal/engine"
+)
+
+// maintenanceRequest turns maintenance mode on; without duration_sec it
+// lasts until DELETE /maintenance.
+type maintenanceRequest struct {
+	Reason      string `json:"reason"`
+	DurationSec int    `json:"duration_sec"`
+}
+
+type maintenanceResponse struct {
+	Status      string             `json:"status"`
+	OK          bool               `json:"ok"`
+	Maintenance engine.Maintenance `json:"maintenance"`
+}
+
+// handleReady is the readiness probe: 503 during maintenance, so load
+// balancers and orchestrators see the engine as not taking orders.
+func (s *Server) handleReady(w http.ResponseWriter, _ *http.Request) {
+	if mt := s.mgr.Maintenance(); mt.On {
+		writeJSON(w, http.StatusServiceUnavailable, maintenanceResponse{Status: "maintenance", Maintenance: mt})
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOnlyResponse{Status: "ok"})
+}
+
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: "ok", OK: true, Maintenance: s.mgr.Maintenance()})
+}
+
+// handleStartMaintenance pauses auto-takes engine-wide. It affects every
+// tenant, so only the default tenant may call it.
+func (s *Server) handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	if tenantFrom(r.Context()) != "" {
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: "maintenance is engine-wide"})
+		return
+	}
+	var req maintenanceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DurationSec < 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid body"})
+			return
+		}
+	}
+	mt := s.mgr.StartMaintenance(req.Reason, time.Duration(req.DurationSec)*time.Second)
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: "maintenance", OK: true, Maintenance: mt})
+}
+
+// handleEndMaintenance resumes auto-takes ahead of the planned end.
+func (s *Server) handleEndMaintenance(w http.ResponseWriter, r *http.Request) {
+	if tenantFrom(r.Context()) != "" {
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: "maintenance is engine-wide"})
+		return
+	}
+	mt, ok := s.mgr.EndMaintenance()
+	if !ok {
+		writeJSON(w, http.StatusConflict, errorResponse{Status: "error", Error: "maintenance is off"})
+		return
+	}
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: "resumed", OK: true, Maintenance: mt})
+}
@@ -0,0 +1,244 @@
+package httpserver
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/p2c"
+)
+
+// apiRoute registers one endpoint and documents it. Request and Response are
+// zero values of the types the handler actually decodes and encodes.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Control  bool // needs the control scope
+	Request  any
+	Response any
+	Stream   bool   // text/event-stream of Response
+	File     string // тип файла, который отдаёт ручка вместо JSON
+	Handler  http.HandlerFunc
+}
+
+// apiRoutes is the control API: New registers these handlers on the mux and
+// the OpenAPI document is built from the same table, so neither can miss a
+// route of the other.
+func (s *Server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{Method: "GET", Path: "/health", Summary: "Liveness probe", Response: statusOnlyResponse{}, Handler: s.handleHealth},
+		{Method: "GET", Path: "/ready", Summary: "Readiness probe; 503 during maintenance", Response: statusOnlyResponse{}, Handler: s.handleReady},
+		{Method: "GET", Path: "/maintenance", Summary: "Engine-wide maintenance mode", Response: maintenanceResponse{}, Handler: s.handleGetMaintenance},
+		{Method: "POST", Path: "/maintenance", Summary: "Pause auto-takes on all accounts, keeping websockets; resumes after duration_sec if set", Control: true, Request: maintenanceRequest{}, Response: maintenanceResponse{}, Handler: s.handleStartMaintenance},
+		{Method: "DELETE", Path: "/maintenance", Summary: "End maintenance and resume auto-takes", Control: true, Response: maintenanceResponse{}, Handler: s.handleEndMaintenance},
+		{Method: "GET", Path: "/blackouts", Summary: "Current and upcoming calendar windows without auto-take, ?account_id= for one account", Response: blackoutsResponse{}, Handler: s.handleBlackouts},
+		{Method: "POST", Path: "/blackouts", Summary: "Add a window without auto-take (holiday, bank maintenance) for an account or, without account_id, all accounts", Control: true, Request: blackoutRequest{}, Response: blackoutResponse{}, Handler: s.handleAddBlackout},
+		{Method: "DELETE", Path: "/blackouts/{blackout}", Summary: "Remove a blackout window", Control: true, Response: statusOnlyResponse{}, Handler: s.handleRemoveBlackout},
+		{Method: "GET", Path: "/log-level", Summary: "Engine log level and when a temporary one ends", Response: logLevelResponse{}, Handler: s.handleGetLogLevel},
+		{Method: "POST", Path: "/log-level", Summary: "Switch the log level (debug, info, warn) without a restart, for duration_sec if set", Control: true, Request: logLevelRequest{}, Response: logLevelResponse{}, Handler: s.handleSetLogLevel},
+		{Method: "GET", Path: "/brands", Summary: "Completed, canceled and disputed orders per brand over ?days= (30 by default), ?account_id= marks the brands its brand_guard denies", Response: brandsResponse{}, Handler: s.handleBrands},
+		{Method: "GET", Path: "/status", Summary: "Workers state and edge probe results", Response: engine.Status{}, Handler: s.handleStatus},
+		{Method: "POST", Path: "/accounts/reload", Summary: "Create, update or stop an account worker; 422 lists every invalid field", Control: true, Request: reloadRequest{}, Response: okResponse{}, Handler: s.handleReloadAccount},
+		{Method: "DELETE", Path: "/accounts/{id}", Summary: "Stop the worker and wipe account state, ?cancel_open=true cancels open payments", Control: true, Response: deleteResponse{}, Handler: s.handleDeleteAccount},
+		{Method: "POST", Path: "/orders/take", Summary: "Take an order manually", Control: true, Request: takeRequest{}, Response: takeResponse{}, Handler: s.handleTakeOrder},
+		{Method: "POST", Path: "/orders/complete", Summary: "Confirm a taken payment as paid", Control: true, Request: paymentRequest{}, Response: paymentResponse{}, Handler: s.handleComplete},
+		{Method: "POST", Path: "/orders/cancel", Summary: "Cancel a taken payment", Control: true, Request: paymentRequest{}, Response: paymentResponse{}, Handler: s.handleCancel},
+		{Method: "GET", Path: "/payments/search", Summary: "Find payments of all accounts, ?amount=&fiat=&status=&brand=&from=&to=&limit= (dates as 2006-01-02 or 2006-01-02T15:04, local time)", Response: searchResponse{}, Handler: s.handleSearchPayments},
+		{Method: "POST", Path: "/payments/complete-batch", Summary: "Confirm many payments, paced per account, with a per-item report", Control: true, Request: batchRequest{}, Response: batchResponse{}, Handler: s.handleBatch((*engine.Manager).CompleteBatch)},
+		{Method: "POST", Path: "/payments/cancel-batch", Summary: "Cancel many payments, paced per account, with a per-item report", Control: true, Request: batchRequest{}, Response: batchResponse{}, Handler: s.handleBatch((*engine.Manager).CancelBatch)},
+		{Method: "GET", Path: "/accounts/{id}/events", Summary: "Worker events (SSE)", Response: engine.Event{}, Stream: true, Handler: s.handleEvents},
+		{Method: "POST", Path: "/accounts/{id}/pause", Summary: "Pause auto-take", Control: true, Response: okResponse{}, Handler: s.handlePause(true)},
+		{Method: "POST", Path: "/accounts/{id}/resume", Summary: "Resume auto-take", Control: true, Response: okResponse{}, Handler: s.handlePause(false)},
+		{Method: "GET", Path: "/accounts/{id}/penalties", Summary: "Active penalty and history", Response: penaltiesResponse{}, Handler: s.handlePenalties},
+		{Method: "POST", Path: "/accounts/{id}/penalties/clear", Summary: "Lift the penalty ahead of time", Control: true, Response: clearPenaltyResponse{}, Handler: s.handleClearPenalty},
+		{Method: "GET", Path: "/accounts/{id}/ws/trace", Summary: "Last raw websocket frames", Response: traceResponse{}, Handler: s.handleWSTrace},
+		{Method: "GET", Path: "/accounts/{id}/ws/frames", Summary: "Websocket frame logging of the worker and its counters", Response: frameLogResponse{}, Handler: s.handleFrameLog},
+		{Method: "POST", Path: "/accounts/{id}/ws/frames", Summary: "Change websocket frame logging (off, errors, sample every N, full) without a restart", Control: true, Request: engine.FrameLogConfig{}, Response: frameLogResponse{}, Handler: s.handleSetFrameLog},
+		{Method: "GET", Path: "/accounts/{id}/skips", Summary: "Recent skip decisions with counts by reason, ?payment=&code=&since=1h&limit=", Response: skipsResponse{}, Handler: s.handleSkips},
+		{Method: "GET", Path: "/accounts/{id}/stats", Summary: "Aggregated statistics, ?period=today|yesterday|7d|30d|YYYY-MM-DD[..YYYY-MM-DD]", Response: engine.StatsReport{}, Handler: s.handleStats},
+		{Method: "GET", Path: "/accounts/{id}/profit", Summary: "Margin of completed payments against the reference rate, per fiat and brand, ?period=&details=1", Response: engine.ProfitReport{}, Handler: s.handleProfit},
+		{Method: "GET", Path: "/profit", Summary: "Margin of completed payments of all accounts, ?period=&details=1", Response: engine.ProfitReport{}, Handler: s.handleProfit},
+		{Method: "GET", Path: "/accounts/{id}/requisites", Summary: "List payout requisites", Response: requisitesResponse{}, Handler: s.handleRequisites},
+		{Method: "POST", Path: "/accounts/{id}/requisites", Summary: "Add a payout requisite", Control: true, Request: p2c.NewRequisite{}, Response: requisiteResponse{}, Handler: s.handleAddRequisite},
+		{Method: "POST", Path: "/accounts/{id}/requisites/{requisite}/enable", Summary: "Enable a requisite", Control: true, Response: okResponse{}, Handler: s.handleRequisiteToggle(true)},
+		{Method: "POST", Path: "/accounts/{id}/requisites/{requisite}/disable", Summary: "Disable a requisite", Control: true, Response: okResponse{}, Handler: s.handleRequisiteToggle(false)},
+		{Method: "GET", Path: "/accounts/{id}/payments/{payment}", Summary: "Journal record of a taken payment", Response: engine.PaymentRecord{}, Handler: s.handlePayment},
+		{Method: "GET", Path: "/accounts/{id}/live-orders", Summary: "Orders currently listed in the websocket feed, ?limit=", Response: liveOrdersResponse{}, Handler: s.handleLiveOrders},
+		{Method: "GET", Path: "/accounts/{id}/payments", Summary: "Latest journal records with take latencies, ?limit= (default 50)", Response: paymentsResponse{}, Handler: s.handlePayments},
+		{Method: "GET", Path: "/accounts/{id}/export", Summary: "Payment history with totals as a CSV or XLSX file, ?from=&to=&format=csv|xlsx", File: "text/csv", Handler: s.handleExport},
+		{Method: "POST", Path: "/accounts/{id}/restart", Summary: "Restart the worker with its current config", Control: true, Response: okResponse{}, Handler: s.handleRestart},
+		{Method: "POST", Path: "/accounts/{id}/cursor/reset", Summary: "Drop the persisted polling cursor; polling restarts from the newest payments", Control: true, Response: cursorResetResponse{}, Handler: s.handleResetCursor},
+		{Method: "GET", Path: "/accounts/{id}/jobs", Summary: "Pending deferred actions (complete, cancel at expiry, webhook retries, penalty resume)", Response: jobsResponse{}, Handler: s.handleJobs},
+		{Method: "DELETE", Path: "/accounts/{id}/jobs/{job}", Summary: "Drop a pending deferred action", Control: true, Response: okResponse{}, Handler: s.handleCancelJob},
+		{Method: "POST", Path: "/accounts/{id}/payments/{payment}/schedule", Summary: "Complete or cancel a payment later; survives restarts", Control: true, Request: scheduleRequest{}, Response: jobResponse{}, Handler: s.handleSchedule},
+		{Method: "POST", Path: "/accounts/{id}/payments/{payment}/ack", Summary: "Payer acknowledges an assigned order; stops escalation", Control: true, Request: ackRequest{}, Response: okResponse{}, Handler: s.handleAck},
+		{Method: "POST", Path: "/accounts/{id}/payments/{payment}/handoff", Summary: "Cancel an order the account cannot pay so another account (to_account_id or any eligible) re-takes it when P2C lists it again", Control: true, Request: handoffRequest{}, Response: handoffResponse{}, Handler: s.handleHandoff},
+		{Method: "POST", Path: "/accounts/{id}/payments/{payment}/receipt", Summary: "Check a receipt against the order amount and requisites before confirming; multipart form with text and an optional image file", Control: true, Response: receiptResponse{}, Handler: s.handleReceipt},
+		{Method: "POST", Path: "/accounts/{id}/filters/preview", Summary: "Replay the payments seen in the last ?hours= (max 24) through candidate filters; omitted fields keep current values", Control: true, Request: engine.FilterSet{}, Response: engine.FilterPreview{}, Handler: s.handleFilterPreview},
+		{Method: "GET", Path: "/accounts/{id}/tune", Summary: "Pending auto-tune proposal for the amount filter with outcomes by band", Response: tuneResponse{}, Handler: s.handleTune},
+		{Method: "POST", Path: "/accounts/{id}/tune/{proposal}/apply", Summary: "Apply the proposed min/max amounts", Control: true, Response: tuneAppliedResponse{}, Handler: s.handleTuneAnswer(true)},
+		{Method: "POST", Path: "/accounts/{id}/tune/{proposal}/reject", Summary: "Reject the proposal; the next one comes a day later at the earliest", Control: true, Response: okResponse{}, Handler: s.handleTuneAnswer(false)},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]any
+)
+
+// handleOpenAPI serves the OpenAPI 3 document built from apiRoutes.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	openAPIOnce.Do(func() { openAPIDoc = buildOpenAPI(s.apiRoutes()) })
+	writeJSON(w, http.StatusOK, openAPIDoc)
+}
+
+func buildOpenAPI(routes []apiRoute) map[string]any {
+	g := &schemaGen{components: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	for _, rt := range routes {
+		op := map[string]any{
+			"summary":   rt.Summary,
+			"responses": g.responses(rt),
+		}
+		if rt.Control {
+			op["description"] = "Requires a token with control scope."
+		}
+		if params := pathParams(rt.Path); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(rt.Request))}},
+			}
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = make(map[string]any)
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return map[string]any{
+		"openapi":  "3.0.3",
+		"info":     map[string]any{"title": "p2c-engine control API", "version": "1"},
+		"paths":    paths,
+		"security": []any{map[string]any{"bearer": []string{}}},
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func (g *schemaGen) responses(rt apiRoute) map[string]any {
+	contentType := "application/json"
+	if rt.Stream {
+		contentType = "text/event-stream"
+	}
+	content := map[string]any{contentType: map[string]any{"schema": g.schema(reflect.TypeOf(rt.Response))}}
+	if rt.File != "" {
+		content = map[string]any{rt.File: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	}
+	return map[string]any{
+		"200": map[string]any{
+			"description": "OK",
+			"content":     content,
+		},
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(errorResponse{}))}},
+		},
+	}
+}
+
+func pathParams(path string) []any {
+	var params []any
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params = append(params, map[string]any{
+				"name":     strings.Trim(seg, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+// schemaGen derives JSON schemas from Go types via their json tags; named
+// structs go to components and are referenced.
+type schemaGen struct {
+	components map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == nil || t.Kind() == reflect.Interface:
+		return map[string]any{}
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = map[string]any{} // заглушка против рекурсии
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
@@ -0,0 +1,52 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"p2c-engine/internal/engine"
+)
+
+// handleFilterPreview replays the payments the account saw in the last
+// ?hours= (default and max engine.PreviewMaxHours) through a candidate
+// filter set. The body uses the field names of the reload request; omitted
+// fields keep their current values, so a body may change a single filter.
+func (s *Server) handleFilterPreview(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	hours := engine.PreviewMaxHours
+	if v := r.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid hours"})
+			return
+		}
+		hours = n
+	}
+	set, err := s.mgr.Filters(accountID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid body"})
+		return
+	}
+	if !engine.HasStrategy(set.Strategy.Name) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "unknown strategy " + set.Strategy.Name})
+		return
+	}
+	res, err := s.mgr.PreviewFilters(accountID, set, hours)
+	switch {
+	case errors.Is(err, engine.ErrNoWorker):
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxiedHeader marks requests forwarded by another instance, so two
+// instances that disagree about a lease never bounce a request forever.
+const proxiedHeader = "X-P2C-Proxied"
+
+// Request body limits: JSON requests and receipts with a photo.
+const (
+	maxProxyBody   = 1 << 20
+	maxReceiptBody = 10 << 20
+)
+
+// bodyLimit returns the largest body accepted for the request.
+func bodyLimit(r *http.Request) int64 {
+	if strings.HasSuffix(r.URL.Path, "/receipt") {
+		return maxReceiptBody
+	}
+	return maxProxyBody
+}
+
+// withReplayableBody buffers request bodies up to bodyLimit, so a handler
+// that already decoded the body can still forward the request to the owning
+// instance. Larger bodies get 413: a cut body must not reach a handler.
+func withReplayableBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody && r.Method != http.MethodGet {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, bodyLimit(r)))
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge):
+				writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Status: "error", Error: fmt.Sprintf("request body over %d bytes", tooLarge.Limit)})
+				return
+			case err != nil:
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyToOwner forwards the request to the instance holding the account
+// lease and reports whether it did; the caller then must not answer.
+func (s *Server) proxyToOwner(w http.ResponseWriter, r *http.Request, accountID int64) bool {
+	owner, ok := s.mgr.AccountOwner(accountID)
+	if !ok {
+		return false
+	}
+	if r.Header.Get(proxiedHeader) != "" {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "error", "error": "account lease is moving, retry"})
+		return true
+	}
+	target, err := url.Parse(owner)
+	if err != nil {
+		log.Printf("proxy account=%d: bad owner url %q: %v", accountID, owner, err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"status": "error", "error": "bad owner url"})
+		return true
+	}
+	if r.GetBody != nil {
+		r.Body, _ = r.GetBody()
+	}
+	r.Header.Set(proxiedHeader, s.mgr.Instance())
+	// SSE живёт дольше WriteTimeout сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+		log.Printf("proxy account=%d to %s: %v", accountID, owner, err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"status": "error", "error": "owner instance unavailable"})
+	}
+	proxy.ServeHTTP(w, r)
+	return true
+}
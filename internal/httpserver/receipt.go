@@ -0,0 +1,49 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"p2c-engine/internal/engine"
+)
+
+type receiptResponse struct {
+	Status  string              `json:"status"`
+	OK      bool                `json:"ok"` // чек совпал с заявкой
+	Receipt engine.ReceiptCheck `json:"receipt"`
+}
+
+// handleReceipt checks a receipt the payer attached against the order. The
+// body is a multipart form: "text" with the caption or a text receipt and
+// an optional "file" with the image; the whole body must fit maxReceiptBody.
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	if err := r.ParseMultipartForm(maxReceiptBody); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid multipart body"})
+		return
+	}
+	var image []byte
+	var contentType string
+	if f, hdr, err := r.FormFile("file"); err == nil {
+		image, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "invalid file"})
+			return
+		}
+		contentType = hdr.Header.Get("Content-Type")
+	}
+	c, err := s.mgr.CheckReceipt(r.Context(), accountID, r.PathValue("payment"), r.FormValue("text"), image, contentType)
+	switch {
+	case errors.Is(err, engine.ErrNoWorker), errors.Is(err, engine.ErrNoPayment):
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Status: "error", Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, receiptResponse{Status: "checked", OK: c.OK(), Receipt: c})
+	}
+}
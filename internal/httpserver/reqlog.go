@@ -0,0 +1,103 @@
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"p2c-engine/internal/logx"
+)
+
+const defaultSlowRequest = 2 * time.Second
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush and write deadlines.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// SetSlowRequest sets the latency above which a request is logged as slow;
+// 0 turns the warning off.
+func (s *Server) SetSlowRequest(d time.Duration) {
+	s.slow = d
+}
+
+// withRequestLog logs every control request (anything but GET) with its
+// caller, status and latency, so a hanging call such as /orders/take leaves a
+// trace on the server side. Reads, polled by the dashboard every few
+// seconds, are logged only when slow or failed; probes and dashboard assets
+// never. At the warn log level only slow and failed requests are logged.
+func (s *Server) withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || isDashboardAsset(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			took := time.Since(start)
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// поток событий открыт, пока клиент слушает: его длительность не задержка
+			stream := strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream")
+			if s.slow > 0 && took >= s.slow && !stream {
+				logx.Warnf("[http] SLOW %s %s caller=%s status=%d took=%s", r.Method, r.URL.Path, s.caller(r), status, took.Round(time.Millisecond))
+				return
+			}
+			if r.Method == http.MethodGet && status < http.StatusInternalServerError {
+				return
+			}
+			if status >= http.StatusInternalServerError {
+				logx.Warnf("[http] %s %s caller=%s status=%d took=%s", r.Method, r.URL.Path, s.caller(r), status, took.Round(time.Millisecond))
+				return
+			}
+			logx.Infof("[http] %s %s caller=%s status=%d took=%s", r.Method, r.URL.Path, s.caller(r), status, took.Round(time.Millisecond))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// caller names who made the request for the log: the tenant and scope of the
+// bearer token, or "anonymous", and the remote address.
+func (s *Server) caller(r *http.Request) string {
+	who := "anonymous"
+	if t, ok := s.lookupToken(bearerToken(r)); ok {
+		who = t.scope
+		if t.tenant != "" {
+			who = t.tenant + "/" + t.scope
+		}
+	} else if len(s.tenants) == 0 {
+		who = "open"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if fwd := r.Header.Get(proxiedHeader); fwd != "" {
+		host += " via " + fwd
+	}
+	return who + "@" + host
+}
@@ -0,0 +1,78 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/p2c"
+)
+
+type requisitesResponse struct {
+	AccountID  int64           `json:"account_id"`
+	Requisites []p2c.Requisite `json:"requisites"`
+}
+
+type requisiteResponse struct {
+	Status    string         `json:"status"`
+	Requisite *p2c.Requisite `json:"requisite"`
+}
+
+// handleRequisites lists the account's payout requisites.
+func (s *Server) handleRequisites(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	list, err := s.mgr.Requisites(r.Context(), accountID)
+	if err != nil {
+		writeRequisiteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, requisitesResponse{AccountID: accountID, Requisites: list})
+}
+
+// handleAddRequisite creates a requisite (card, phone, account).
+func (s *Server) handleAddRequisite(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	var req p2c.NewRequisite
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Type == "" || req.Value == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "type and value are required"})
+		return
+	}
+	added, err := s.mgr.AddRequisite(r.Context(), accountID, req)
+	if err != nil {
+		writeRequisiteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, requisiteResponse{Status: "ok", Requisite: added})
+}
+
+// handleRequisiteToggle enables or disables a requisite.
+func (s *Server) handleRequisiteToggle(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, ok := pathAccountID(w, r)
+		if !ok || !s.authorizeAccount(w, r, accountID) {
+			return
+		}
+		if err := s.mgr.SetRequisiteEnabled(r.Context(), accountID, r.PathValue("requisite"), enabled); err != nil {
+			writeRequisiteError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, okResponse{Status: "ok", OK: true, AccountID: accountID})
+	}
+}
+
+func writeRequisiteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, engine.ErrNoWorker) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	log.Printf("requisites error: %v", err)
+	writeJSON(w, http.StatusBadGateway, errorResponse{Status: "error", Error: err.Error()})
+}
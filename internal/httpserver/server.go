@@ -1,40 +1,59 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"p2c-engine/internal/engine"
+	"p2c-engine/internal/store"
 )
 
 type Server struct {
-	addr string
-	mgr  *engine.Manager
-	srv  *http.Server
+	addr    string
+	mgr     *engine.Manager
+	srv     *http.Server
+	tenants []tenantToken
+	slow    time.Duration // запросы дольше логируются как медленные, 0 — не предупреждать
+
+	streams    context.Context // отменяется в начале Shutdown: SSE-потоки не ждут грейс-период
+	endStreams context.CancelFunc
 }
 
 func New(addr string, mgr *engine.Manager) *Server {
 	s := &Server{
 		addr: addr,
 		mgr:  mgr,
+		slow: defaultSlowRequest,
 	}
+	s.streams, s.endStreams = context.WithCancel(context.Background())
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/accounts/reload", s.handleReloadAccount)
-	mux.HandleFunc("/orders/take", s.handleTakeOrder)
-	mux.HandleFunc("/orders/complete", s.handleComplete)
-	mux.HandleFunc("/orders/cancel", s.handleCancel)
+	// API — из таблицы apiRoutes, по ней же строится /openapi.json
+	for _, rt := range s.apiRoutes() {
+		mux.HandleFunc(rt.Method+" "+rt.Path, rt.Handler)
+	}
+	mux.Handle("GET /admin/", dashboardHandler())
+	mux.Handle("GET /admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("GET /p/{account}/{payment}", s.handlePaymentPage)
+	mux.HandleFunc("POST /p/{account}/{payment}/{action}", s.handlePaymentAction)
 
 	s.srv = &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      s.withRequestLog(s.withTenant(withReplayableBody(mux))),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	// Shutdown ждёт, пока обработчики вернутся; бесконечные потоки закрываем сразу
+	s.srv.RegisterOnShutdown(s.endStreams)
 	return s
 }
 
@@ -47,7 +66,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, statusOnlyResponse{Status: "ok"})
+}
+
+// handleStatus returns workers state and edge probe measurements.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.mgr.Status(tenantFrom(r.Context())))
 }
 
 func (s *Server) handleReloadAccount(w http.ResponseWriter, r *http.Request) {
@@ -55,32 +83,331 @@ func (s *Server) handleReloadAccount(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var req struct {
-		AccountID   int64    `json:"account_id"`
-		AccessToken string   `json:"access_token"`
-		ChatID      int64    `json:"chat_id"`
-		MinAmount   *float64 `json:"min_amount"`
-		MaxAmount   *float64 `json:"max_amount"`
-		AutoMode    *bool    `json:"auto_mode"`
-		IsActive    *bool    `json:"is_active"`
-		P2CAccountID string  `json:"p2c_account_id"`
-	}
+	var req reloadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AccountID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if s.proxyToOwner(w, r, req.AccountID) {
+		return
+	}
+	// невалидный запрос не должен закрепить аккаунт за тенантом
+	if validateReload(req).write(w) {
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	if err := s.mgr.ClaimAccount(tenant, req.AccountID); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "error": "account not found"})
+		return
+	}
 	cfg := engine.WorkerConfig{
 		AccountID:   req.AccountID,
-		AccessToken: req.AccessToken,
+		AccessToken: store.Secret(req.AccessToken),
 		ChatID:      req.ChatID,
 		MinAmount:   req.MinAmount,
 		MaxAmount:   req.MaxAmount,
+		MinOutAmount: req.MinOutAmount,
+		MaxOutAmount: req.MaxOutAmount,
 		AutoMode:    req.AutoMode != nil && *req.AutoMode,
 		Active:      req.IsActive == nil || *req.IsActive,
 		P2CAccountID: req.P2CAccountID,
+		WarmConns:   req.WarmConns,
+		Strategy:    req.Strategy,
+		Priority:    req.Priority,
+		Weight:      req.Weight,
+		DailyCap:    req.DailyCap,
+		PenaltyCooldownSec: req.PenaltyCooldownSec,
+		WSTraceSize: req.WSTraceSize,
+		PollFallbackAfterSec: req.PollFallbackAfterSec,
+		PollIntervalMs:       req.PollIntervalMs,
+		TenantID:    tenant,
+		ProvidersAllow: req.ProvidersAllow,
+		ProvidersDeny:  req.ProvidersDeny,
+		AmountsAllow:   req.AmountsAllow,
+		AmountsDeny:    req.AmountsDeny,
+		Locale:         req.Locale,
+		Breaker:        req.Breaker,
+		Label:          req.Label,
+		Owner:          req.Owner,
+		OwnerGroup:     req.OwnerGroup,
+		OwnerGroupCap:  req.OwnerGroupCap,
+		Note:           req.Note,
+		Transport:      req.Transport,
+		WSStandby:      req.WSStandby,
+		SlackWebhook:   store.Secret(req.SlackWebhook),
+		DiscordWebhook: store.Secret(req.DiscordWebhook),
+		WebhookURL:     req.WebhookURL,
+		WebhookSecret:  store.Secret(req.WebhookSecret),
+		CancelAtExpiry: req.CancelAtExpiry,
+		TakeDelayMs:    req.TakeDelayMs,
+		Payers:         req.Payers,
+		PayerAckSec:    req.PayerAckSec,
+		PaidOneTap:     req.PaidOneTap,
+		PaidUsers:      req.PaidUsers,
+		OrderCard:      req.OrderCard,
+		MessageThreadID: req.MessageThreadID,
+		AutoTopic:      req.AutoTopic,
+		Environment:    req.Environment,
+		SkipSummary:    req.SkipSummary,
+		DeafAfterSec:   req.DeafAfterSec,
+		MarketEdgePct:  req.MarketEdgePct,
+		AutoTune:       req.AutoTune,
+		BrandGuard:     req.BrandGuard,
+		FrameLog:       req.FrameLog,
 	}
 	s.mgr.ReloadAccount(cfg)
-	writeJSON(w, http.StatusOK, map[string]any{"status": "reloaded", "ok": true})
+	writeJSON(w, http.StatusOK, okResponse{Status: "reloaded", OK: true})
+}
+
+// handlePause toggles auto-take without restarting the worker.
+func (s *Server) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, ok := pathAccountID(w, r)
+		if !ok || !s.authorizeAccount(w, r, accountID) {
+			return
+		}
+		if !s.mgr.SetPaused(accountID, paused) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "error": "worker not running"})
+			return
+		}
+		status := "resumed"
+		if paused {
+			status = "paused"
+		}
+		writeJSON(w, http.StatusOK, okResponse{Status: status, OK: true, AccountID: accountID})
+	}
+}
+
+// handlePenalties returns the active penalty and penalty history of the account.
+func (s *Server) handlePenalties(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	active, history := s.mgr.Penalties(accountID)
+	writeJSON(w, http.StatusOK, penaltiesResponse{Active: active, History: history})
+}
+
+// handleClearPenalty lifts the penalty ahead of time (operator override).
+func (s *Server) handleClearPenalty(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, clearPenaltyResponse{Status: "ok", Cleared: s.mgr.ClearPenalty(accountID)})
+}
+
+// handleDeleteAccount stops the worker and wipes the account state;
+// ?cancel_open=true also cancels its open payments first.
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	cancelOpen, _ := strconv.ParseBool(r.URL.Query().Get("cancel_open"))
+	res := s.mgr.DeleteAccount(r.Context(), accountID, cancelOpen)
+	writeJSON(w, http.StatusOK, deleteResponse{
+		Status:    "deleted",
+		AccountID: accountID,
+		Stopped:   res.Stopped,
+		Canceled:  res.Canceled,
+		Failed:    res.Failed,
+	})
+}
+
+// handleLiveOrders returns the orders currently listed in the worker's
+// websocket feed; ?limit= caps the list.
+func (s *Server) handleLiveOrders(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	orders, connected, err := s.mgr.LiveOrders(accountID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 && limit < len(orders) {
+		orders = orders[:limit]
+	}
+	writeJSON(w, http.StatusOK, liveOrdersResponse{AccountID: accountID, Connected: connected, Orders: orders})
+}
+
+// handlePayments returns the latest journal records, ?limit= (default 50).
+func (s *Server) handlePayments(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	payments, err := s.mgr.Payments(accountID, limit)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, paymentsResponse{AccountID: accountID, Payments: payments})
+}
+
+// handleExport downloads the account's payment history with totals,
+// ?from=&to= (see parseSearchTime) and ?format=csv|xlsx.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = engine.ExportCSV
+	}
+	from, err := parseSearchTime(q.Get("from"), false)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "from: " + err.Error()})
+		return
+	}
+	to, err := parseSearchTime(q.Get("to"), true)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "to: " + err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := s.mgr.ExportPayments(&buf, accountID, from, to, format); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == engine.ExportXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, engine.ExportName(accountID, from, to, format)))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// handleResetCursor drops the persisted polling cursor of the account.
+func (s *Server) handleResetCursor(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	prev, err := s.mgr.ResetCursor(accountID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, cursorResetResponse{Status: "reset", OK: true, AccountID: accountID, Previous: prev})
+}
+
+// handleRestart restarts the account worker with its current config.
+func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	if err := s.mgr.RestartAccount(accountID); err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, okResponse{Status: "restarted", OK: true, AccountID: accountID})
+}
+
+// handleStats returns aggregated statistics for ?period= (default today).
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	report, err := s.mgr.Stats(accountID, r.URL.Query().Get("period"))
+	switch {
+	case errors.Is(err, engine.ErrNoWorker):
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// handleProfit returns the margins of completed payments for ?period= of
+// one account, or of all the caller's accounts on /profit; ?details=1 lists
+// every payment.
+func (s *Server) handleProfit(w http.ResponseWriter, r *http.Request) {
+	var accountID int64
+	if r.PathValue("id") != "" {
+		var ok bool
+		if accountID, ok = pathAccountID(w, r); !ok || !s.authorizeAccount(w, r, accountID) {
+			return
+		}
+	}
+	q := r.URL.Query()
+	details, _ := strconv.ParseBool(q.Get("details"))
+	report, err := s.mgr.Profit(tenantFrom(r.Context()), accountID, q.Get("period"), details)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleWSTrace returns the last raw websocket frames of the worker.
+func (s *Server) handleWSTrace(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	frames, ok := s.mgr.WSTrace(accountID, limit)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "error": "worker not running"})
+		return
+	}
+	writeJSON(w, http.StatusOK, traceResponse{AccountID: accountID, Frames: frames})
+}
+
+// handleSkips returns the recent skip decisions with counts by reason.
+func (s *Server) handleSkips(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	q := r.URL.Query()
+	query := engine.SkipQuery{PaymentID: q.Get("payment"), Code: engine.SkipCode(q.Get("code"))}
+	query.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if v := q.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "since: duration like 1h or 30m"})
+			return
+		}
+		query.Since = time.Now().Add(-d)
+	}
+	skips, ok := s.mgr.Skips(accountID, query)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: "worker not running"})
+		return
+	}
+	counts := make(map[engine.SkipCode]int)
+	for _, rec := range skips {
+		counts[rec.Code]++
+	}
+	writeJSON(w, http.StatusOK, skipsResponse{AccountID: accountID, Counts: counts, Skips: skips})
+}
+
+// handlePayment returns the journal record of a payment taken by the account.
+func (s *Server) handlePayment(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	rec, ok := s.mgr.Payment(accountID, r.PathValue("payment"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: "payment not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
 }
 
 func (s *Server) handleTakeOrder(w http.ResponseWriter, r *http.Request) {
@@ -88,20 +415,21 @@ func (s *Server) handleTakeOrder(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var req struct {
-		AccountID      int64  `json:"account_id"`
-		OrderExternalID string `json:"order_external_id"`
-	}
+	var req takeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AccountID == 0 || req.OrderExternalID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if err := s.mgr.TakeOrder(r.Context(), req.AccountID, req.OrderExternalID); err != nil {
+	if !s.authorizeAccount(w, r, req.AccountID) {
+		return
+	}
+	res, err := s.mgr.TakeOrder(r.Context(), req.AccountID, req.OrderExternalID)
+	if err != nil {
 		log.Printf("take order error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error"})
+		writeJSON(w, http.StatusInternalServerError, takeResponse{Status: "error", Error: err.Error(), Take: &res})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, takeResponse{Status: "ok", OK: true, Take: &res})
 }
 
 // handleComplete marks payment as completed (manual confirm).
@@ -110,20 +438,139 @@ func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var req struct {
-		AccountID  int64  `json:"account_id"`
-		PaymentID  string `json:"payment_id"`
-	}
+	var req paymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AccountID == 0 || req.PaymentID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if err := s.mgr.CompletePayment(r.Context(), req.AccountID, req.PaymentID); err != nil {
-		log.Printf("complete payment error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error"})
+	if !s.authorizeAccount(w, r, req.AccountID) {
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	if !s.mgr.MayConfirmPaid(req.AccountID, req.UserID) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Status: "error", Error: "user may not confirm payments of this account"})
+		return
+	}
+	ref, err := s.mgr.CompletePayment(r.Context(), req.AccountID, req.PaymentID)
+	if err != nil {
+		log.Printf("complete payment %s error: %v", ref, err)
+		writeJSON(w, http.StatusInternalServerError, paymentResponse{Status: "error", Payment: ref, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, paymentResponse{Status: "ok", Payment: ref})
+}
+
+// handleSearchPayments finds payments of the caller's accounts by amount,
+// fiat, status, brand and take time, e.g.
+// ?amount=14500&fiat=RUB&from=2026-10-16T18:00&to=2026-10-17.
+func (s *Server) handleSearchPayments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := engine.PaymentQuery{
+		Tenant: tenantFrom(r.Context()),
+		Fiat:   q.Get("fiat"),
+		Status: engine.PaymentState(q.Get("status")),
+		Brand:  q.Get("brand"),
+		Limit:  100,
+	}
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		query.Limit = v
+	}
+	if v := q.Get("amount"); v != "" {
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(strings.ReplaceAll(v, " ", ""), ",", "."), 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "amount: number like 14500 or 14500.50"})
+			return
+		}
+		query.Amount = amount
+	}
+	var err error
+	if query.From, err = parseSearchTime(q.Get("from"), false); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "from: " + err.Error()})
+		return
+	}
+	if query.To, err = parseSearchTime(q.Get("to"), true); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "to: " + err.Error()})
+		return
+	}
+	payments := s.mgr.SearchPayments(query)
+	writeJSON(w, http.StatusOK, searchResponse{Count: len(payments), Payments: payments})
+}
+
+// parseSearchTime accepts RFC 3339, "2006-01-02T15:04" or a date in local
+// time; a date as the upper bound covers the whole day.
+func parseSearchTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", v, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("time like 2026-10-16, 2026-10-16T18:00 or RFC 3339")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// maxBatchItems caps one bulk complete or cancel request.
+const maxBatchItems = 500
+
+// handleBatch runs a bulk complete or cancel. Items of accounts the caller may
+// not touch or that another instance leases fail on their own; the rest run
+// paced per account, and the report lists every item in request order.
+func (s *Server) handleBatch(run func(*engine.Manager, context.Context, []engine.BatchItem) []engine.BatchResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Items) == 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: "items are required"})
+			return
+		}
+		if len(req.Items) > maxBatchItems {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Status: "error", Error: fmt.Sprintf("at most %d items per request", maxBatchItems)})
+			return
+		}
+		tenant := tenantFrom(r.Context())
+		results := make([]engine.BatchResult, len(req.Items))
+		var allowed []engine.BatchItem
+		var pos []int
+		for i, it := range req.Items {
+			results[i] = engine.BatchResult{AccountID: it.AccountID, PaymentID: it.PaymentID}
+			switch owner, leased := s.mgr.AccountOwner(it.AccountID); {
+			case it.AccountID == 0 || it.PaymentID == "":
+				results[i].Error = "account_id and payment_id are required"
+			case s.mgr.AccountTenant(it.AccountID) != tenant:
+				results[i].Error = "account not found"
+			case leased:
+				results[i].Error = "account is served by " + owner
+			default:
+				allowed = append(allowed, it)
+				pos = append(pos, i)
+			}
+		}
+		// пачка по сотне заявок идёт дольше WriteTimeout сервера
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		for n, res := range run(s.mgr, r.Context(), allowed) {
+			results[pos[n]] = res
+		}
+		resp := batchResponse{Status: "ok", Results: results}
+		for _, res := range results {
+			if res.OK {
+				resp.Done++
+			} else {
+				resp.Failed++
+				log.Printf("batch account=%d payment %s error: %s", res.AccountID, res.PaymentID, res.Error)
+			}
+		}
+		if resp.Failed > 0 {
+			resp.Status = "partial"
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
 }
 
 // handleCancel cancels payment.
@@ -132,20 +579,31 @@ func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var req struct {
-		AccountID int64  `json:"account_id"`
-		PaymentID string `json:"payment_id"`
-	}
+	var req paymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AccountID == 0 || req.PaymentID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if err := s.mgr.CancelPayment(r.Context(), req.AccountID, req.PaymentID); err != nil {
-		log.Printf("cancel payment error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error"})
+	if !s.authorizeAccount(w, r, req.AccountID) {
+		return
+	}
+	ref, err := s.mgr.CancelPayment(r.Context(), req.AccountID, req.PaymentID)
+	if err != nil {
+		log.Printf("cancel payment %s error: %v", ref, err)
+		writeJSON(w, http.StatusInternalServerError, paymentResponse{Status: "error", Payment: ref, Error: err.Error()})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, paymentResponse{Status: "ok", Payment: ref})
+}
+
+// pathAccountID parses the {id} path segment, answering 400 when it is invalid.
+func pathAccountID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
 }
 
 func writeJSON(w http.ResponseWriter, status int, body any) {
@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type tenantKey struct{}
+
+// Scopes granted to API tokens.
+const (
+	ScopeRead    = "read"    // status, events, penalties, traces (GET only)
+	ScopeControl = "control" // everything, including reload and order actions
+)
+
+// tenantToken binds an API token to a tenant and a scope.
+type tenantToken struct {
+	tenant string
+	scope  string
+	token  []byte
+}
+
+// SetTenantTokens enables multi-tenant mode: every control request must carry
+// "Authorization: Bearer <token>" and may act only on its tenant's accounts.
+// tokens maps tenant id to its API token; tenant tokens have control scope.
+func (s *Server) SetTenantTokens(tokens map[string]string) {
+	s.setTenantTokens(tokens, ScopeControl)
+}
+
+// SetTenantReadTokens adds read-only tokens of tenants, e.g. for a tenant's
+// dashboard: they see the tenant's status, stats and events but cannot act.
+func (s *Server) SetTenantReadTokens(tokens map[string]string) {
+	s.setTenantTokens(tokens, ScopeRead)
+}
+
+func (s *Server) setTenantTokens(tokens map[string]string, scope string) {
+	s.tenants = dropTenantTokens(s.tenants, func(t tenantToken) bool { return t.tenant != "" && t.scope == scope })
+	for tenant, token := range tokens {
+		if tenant == "" || token == "" {
+			continue
+		}
+		s.tenants = append(s.tenants, tenantToken{tenant: tenant, scope: scope, token: []byte(token)})
+	}
+}
+
+// SetAPITokens adds tokens of the default tenant. tokens maps token to its
+// scope (ScopeRead or ScopeControl); unknown scopes fall back to read.
+func (s *Server) SetAPITokens(tokens map[string]string) {
+	s.tenants = dropTenantTokens(s.tenants, func(t tenantToken) bool { return t.tenant == "" })
+	for token, scope := range tokens {
+		if token == "" {
+			continue
+		}
+		if scope != ScopeControl {
+			scope = ScopeRead
+		}
+		s.tenants = append(s.tenants, tenantToken{scope: scope, token: []byte(token)})
+	}
+}
+
+func dropTenantTokens(tokens []tenantToken, drop func(tenantToken) bool) []tenantToken {
+	kept := tokens[:0]
+	for _, t := range tokens {
+		if !drop(t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// withTenant resolves the caller tenant and scope from the bearer token.
+// Without configured tokens the API is open and the tenant is "".
+func (s *Server) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.tenants) == 0 || r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/openapi.json" || strings.HasPrefix(r.URL.Path, "/p/") || isDashboardAsset(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, ok := s.lookupToken(bearerToken(r))
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "error", "error": "unauthorized"})
+			return
+		}
+		if !scopeAllows(t.scope, r.Method) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"status": "error", "error": "token scope does not allow this request"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t.tenant)))
+	})
+}
+
+// scopeAllows reports whether a token scope permits the request method.
+func scopeAllows(scope, method string) bool {
+	if scope == ScopeControl {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// lookupToken compares the token against every configured one in constant time.
+func (s *Server) lookupToken(token string) (tenantToken, bool) {
+	if token == "" {
+		return tenantToken{}, false
+	}
+	var found tenantToken
+	ok := false
+	for _, t := range s.tenants {
+		if subtle.ConstantTimeCompare([]byte(token), t.token) == 1 {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func tenantFrom(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// authorizeAccount rejects requests touching an account of another tenant
+// and forwards requests for accounts leased by another instance.
+func (s *Server) authorizeAccount(w http.ResponseWriter, r *http.Request, accountID int64) bool {
+	if s.mgr.AccountTenant(accountID) != tenantFrom(r.Context()) {
+		// не раскрываем, что аккаунт существует у другого тенанта
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "error": "account not found"})
+		return false
+	}
+	return !s.proxyToOwner(w, r, accountID)
+}
@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"p2c-engine/internal/engine"
+)
+
+type tuneResponse struct {
+	AccountID int64                `json:"account_id"`
+	Proposal  *engine.TuneProposal `json:"proposal"` // null — предложений нет
+}
+
+type tuneAppliedResponse struct {
+	Status    string   `json:"status"`
+	OK        bool     `json:"ok"`
+	AccountID int64    `json:"account_id"`
+	MinAmount *float64 `json:"min_amount"`
+	MaxAmount *float64 `json:"max_amount"`
+}
+
+// handleTune returns the pending amount filter proposal of the account.
+func (s *Server) handleTune(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := pathAccountID(w, r)
+	if !ok || !s.authorizeAccount(w, r, accountID) {
+		return
+	}
+	p, err := s.mgr.TuneProposal(accountID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, tuneResponse{AccountID: accountID, Proposal: p})
+}
+
+// handleTuneAnswer applies or rejects a proposal: the operator's answer to
+// the prompt in the account chat.
+func (s *Server) handleTuneAnswer(apply bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, ok := pathAccountID(w, r)
+		if !ok || !s.authorizeAccount(w, r, accountID) {
+			return
+		}
+		id := r.PathValue("proposal")
+		var (
+			p   *engine.TuneProposal
+			err error
+		)
+		if apply {
+			p, err = s.mgr.ApplyTune(accountID, id)
+		} else {
+			err = s.mgr.RejectTune(accountID, id)
+		}
+		switch {
+		case errors.Is(err, engine.ErrNoProposal):
+			writeJSON(w, http.StatusConflict, errorResponse{Status: "error", Error: err.Error()})
+		case errors.Is(err, engine.ErrNoWorker):
+			writeJSON(w, http.StatusNotFound, errorResponse{Status: "error", Error: err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Status: "error", Error: err.Error()})
+		case apply:
+			writeJSON(w, http.StatusOK, tuneAppliedResponse{Status: "applied", OK: true, AccountID: accountID, MinAmount: p.NewMin, MaxAmount: p.NewMax})
+		default:
+			writeJSON(w, http.StatusOK, okResponse{Status: "rejected", OK: true, AccountID: accountID})
+		}
+	}
+}
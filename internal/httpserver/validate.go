@@ -0,0 +1,135 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/i18n"
+)
+
+// fieldError is one invalid field of a request body; Field is its JSON name.
+type fieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+type validationResponse struct {
+	Status string       `json:"status"`
+	Error  string       `json:"error"`
+	Fields []fieldError `json:"fields"`
+}
+
+// validator collects every invalid field instead of stopping at the first,
+// so the frontend can mark them all at once.
+type validator []fieldError
+
+func (v *validator) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		*v = append(*v, fieldError{Field: field, Error: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (v *validator) nonNegative(field string, n float64) {
+	v.check(n >= 0, field, "must not be negative")
+}
+
+func (v *validator) bounds(minField string, lo *float64, maxField string, hi *float64) {
+	if lo != nil {
+		v.nonNegative(minField, *lo)
+	}
+	if hi != nil {
+		v.nonNegative(maxField, *hi)
+	}
+	if lo != nil && hi != nil {
+		v.check(*lo <= *hi, minField, "must not exceed %s", maxField)
+	}
+}
+
+// write answers 422 with the collected errors; false if there are none.
+func (v validator) write(w http.ResponseWriter) bool {
+	if len(v) == 0 {
+		return false
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, validationResponse{Status: "error", Error: "invalid config", Fields: v})
+	return true
+}
+
+// validateReload checks a reload request before it becomes a worker config,
+// which would otherwise take negative or crossed bounds at face value.
+func validateReload(req reloadRequest) validator {
+	var v validator
+	v.bounds("min_amount", req.MinAmount, "max_amount", req.MaxAmount)
+	v.bounds("min_out_amount", req.MinOutAmount, "max_out_amount", req.MaxOutAmount)
+	v.check(engine.HasStrategy(req.Strategy.Name), "strategy.name", "unknown strategy %q", req.Strategy.Name)
+	v.check(i18n.Supported(req.Locale), "locale", "unsupported locale %q", req.Locale)
+	v.check(engine.ValidEnvironment(req.Environment), "environment", "must be prod or sandbox")
+	v.check(engine.ValidFrameLogMode(req.FrameLog.Mode), "frame_log.mode", "must be off, errors, sample or full")
+
+	// уведомления, кнопки и темы без чата просто теряются
+	needsChat := ""
+	switch {
+	case len(req.Payers) > 0:
+		needsChat = "payers"
+	case len(req.PaidUsers) > 0:
+		needsChat = "paid_users"
+	case req.PaidOneTap:
+		needsChat = "paid_one_tap"
+	case req.OrderCard:
+		needsChat = "order_card"
+	case req.AutoTopic:
+		needsChat = "auto_topic"
+	case req.MessageThreadID != 0:
+		needsChat = "message_thread_id"
+	case req.SkipSummary:
+		needsChat = "skip_summary"
+	case req.AutoTune:
+		needsChat = "auto_tune"
+	}
+	v.check(req.ChatID != 0 || needsChat == "", "chat_id", "is required with %s", needsChat)
+
+	for _, f := range []struct {
+		name string
+		n    int
+	}{
+		{"warm_conns", req.WarmConns},
+		{"weight", req.Weight},
+		{"penalty_cooldown_sec", req.PenaltyCooldownSec},
+		{"ws_trace_size", req.WSTraceSize},
+		{"poll_fallback_after_sec", req.PollFallbackAfterSec},
+		{"poll_interval_ms", req.PollIntervalMs},
+		{"take_delay_ms", req.TakeDelayMs},
+		{"payer_ack_sec", req.PayerAckSec},
+		{"deaf_after_sec", req.DeafAfterSec},
+		{"breaker.max_failures", req.Breaker.MaxFailures},
+		{"breaker.window_sec", req.Breaker.WindowSec},
+		{"breaker.min_attempts", req.Breaker.MinAttempts},
+		{"breaker.cooldown_sec", req.Breaker.CooldownSec},
+		{"brand_guard.max_disputes", req.BrandGuard.MaxDisputes},
+		{"brand_guard.max_cancels", req.BrandGuard.MaxCancels},
+		{"brand_guard.days", req.BrandGuard.Days},
+		{"frame_log.every", req.FrameLog.Every},
+	} {
+		v.nonNegative(f.name, float64(f.n))
+	}
+	v.nonNegative("daily_cap", req.DailyCap)
+	v.nonNegative("owner_group_cap", req.OwnerGroupCap)
+	v.check(req.OwnerGroupCap == 0 || req.OwnerGroup != "", "owner_group", "is required with owner_group_cap")
+	v.check(req.Breaker.MinSuccessRate >= 0 && req.Breaker.MinSuccessRate <= 1, "breaker.min_success_rate", "must be between 0 and 1")
+
+	for _, list := range []struct {
+		field    string
+		patterns []string
+	}{{"amounts_allow", req.AmountsAllow}, {"amounts_deny", req.AmountsDeny}} {
+		for i, p := range list.patterns {
+			v.check(engine.ValidAmountPattern(p), fmt.Sprintf("%s[%d]", list.field, i), "invalid pattern %q", p)
+		}
+	}
+	if req.WebhookURL != "" {
+		u, err := url.Parse(req.WebhookURL)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "webhook_url", "must be an http(s) URL")
+	}
+	v.check(req.WebhookSecret == "" || req.WebhookURL != "", "webhook_secret", "is set without webhook_url")
+	return v
+}
@@ -0,0 +1,163 @@
+package httpserver
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"p2c-engine/internal/engine"
+	"p2c-engine/internal/i18n"
+)
+
+var paymentPage = template.Must(template.New("payment").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="5">
+<title>{{call .T "web.payment"}} {{.Rec.Ref}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:560px;margin:24px auto;padding:0 12px}
+table{border-collapse:collapse;width:100%}
+td{padding:4px 8px;border-bottom:1px solid #ddd}
+td:first-child{color:#666;width:40%}
+button{font-size:16px;padding:8px 16px;margin-right:8px}
+.msg{padding:8px;background:#fee}
+</style>
+</head>
+<body>
+<h2>{{call .T "web.payment"}} {{.Rec.Ref}}</h2>
+{{if .Msg}}<p class="msg">{{.Msg}}</p>{{end}}
+<table>
+<tr><td>{{call .T "web.status"}}</td><td><b>{{.Rec.Status}}</b></td></tr>
+<tr><td>{{call .T "web.brand"}}</td><td>{{.Rec.BrandName}}</td></tr>
+<tr><td>{{call .T "web.amount"}}</td><td>{{.Rec.InAmount}} {{.Rec.InAsset}}</td></tr>
+<tr><td>{{call .T "web.rate"}}</td><td>{{.Rec.ExchangeRate}}</td></tr>
+<tr><td>{{call .T "web.expires"}}</td><td>{{.Rec.ExpiresAt}}</td></tr>
+<tr><td>{{call .T "web.taken"}}</td><td>{{.Rec.TakenAt.Format "15:04:05.000"}}</td></tr>
+<tr><td>{{call .T "web.take_timing"}}</td><td>{{.Rec.ToTakeMs}} {{call .T "web.ms"}} / {{.Rec.TakeMs}} {{call .T "web.ms"}}</td></tr>
+<tr><td>CF-RAY</td><td>{{.Rec.CFRay}}</td></tr>
+{{if .Rec.URL}}<tr><td>{{call .T "web.pay"}}</td><td><a href="{{.Rec.URL}}">{{call .T "web.pay_link"}}</a></td></tr>{{end}}
+{{if .Banks}}<tr><td>{{call .T "web.bank_apps"}}</td><td>{{range $i, $b := .Banks}}{{if $i}} · {{end}}<a href="{{$b.URL}}">{{$b.Bank}}</a>{{end}}</td></tr>{{end}}
+</table>
+{{if .Rec.Status.Open}}
+<p>
+<form method="post" action="{{.Base}}/complete?exp={{.Exp}}&amp;sig={{.Sig}}" style="display:inline"><button>{{call .T "button.paid"}}</button></form>
+<form method="post" action="{{.Base}}/cancel?exp={{.Exp}}&amp;sig={{.Sig}}" style="display:inline"><button>{{call .T "button.cancel"}}</button></form>
+</p>
+{{end}}
+</body>
+</html>
+`))
+
+// handlePaymentPage renders the single-payment web view linked from the Telegram card.
+func (s *Server) handlePaymentPage(w http.ResponseWriter, r *http.Request) {
+	accountID, paymentID, link, ok := s.authorizePaymentLink(w, r)
+	if !ok {
+		return
+	}
+	locale := s.mgr.AccountLocale(accountID)
+	rec, found := s.mgr.Payment(accountID, paymentID)
+	if !found {
+		http.Error(w, i18n.T(locale, "web.not_found"), http.StatusNotFound)
+		return
+	}
+	// схемы bank<id>:// html/template считает небезопасными; ссылки строит движок
+	type bankLink struct {
+		Bank string
+		URL  template.URL
+	}
+	var banks []bankLink
+	if rec.Status.Open() {
+		for _, b := range rec.PayInstructions().BankLinks() {
+			banks = append(banks, bankLink{Bank: b.Bank, URL: template.URL(b.URL)})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := paymentPage.Execute(w, map[string]any{
+		"Rec":   rec,
+		"Banks": banks,
+		"Base":  r.URL.Path,
+		"Exp":   link.exp,
+		"Sig":   link.sig,
+		"Msg":   failedMessage(locale, r.URL.Query().Get("failed")),
+		"Lang":  locale,
+		"T":     func(key string) string { return i18n.T(locale, key) },
+	})
+	if err != nil {
+		log.Printf("render payment page error: %v", err)
+	}
+}
+
+// handlePaymentAction runs complete/cancel from the web view and redirects back.
+func (s *Server) handlePaymentAction(w http.ResponseWriter, r *http.Request) {
+	accountID, paymentID, link, ok := s.authorizePaymentLink(w, r)
+	if !ok {
+		return
+	}
+	back := "/p/" + strconv.FormatInt(accountID, 10) + "/" + paymentID + "?" + link.query()
+	var err error
+	switch r.PathValue("action") {
+	case "complete":
+		// ссылку открывает кто угодно из чата: при списке PaidUsers подтверждают только из Telegram
+		if !s.mgr.MayConfirmPaid(accountID, engine.LinkUser) {
+			http.Redirect(w, r, back+"&failed=denied", http.StatusSeeOther)
+			return
+		}
+		_, err = s.mgr.CompletePayment(r.Context(), accountID, paymentID)
+	case "cancel":
+		_, err = s.mgr.CancelPayment(r.Context(), accountID, paymentID)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		// в ссылку только код действия: параметры вне подписи, текст ошибки — в лог
+		log.Printf("web %s payment %s error: %v", r.PathValue("action"), paymentID, err)
+		back += "&failed=" + r.PathValue("action")
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// failedMessage returns the page notice for the ?failed= action code; unknown
+// codes render nothing.
+func failedMessage(locale, action string) string {
+	switch action {
+	case "complete", "cancel", "denied":
+		return i18n.T(locale, "web.failed_"+action)
+	}
+	return ""
+}
+
+// paymentLink is the signed part of a web view link.
+type paymentLink struct {
+	exp, sig string
+}
+
+func (l paymentLink) query() string {
+	return "exp=" + url.QueryEscape(l.exp) + "&sig=" + url.QueryEscape(l.sig)
+}
+
+// authorizePaymentLink checks the link signature and forwards requests for
+// accounts leased by another instance, like authorizeAccount does for the API.
+func (s *Server) authorizePaymentLink(w http.ResponseWriter, r *http.Request) (int64, string, paymentLink, bool) {
+	accountID, err := strconv.ParseInt(r.PathValue("account"), 10, 64)
+	paymentID := r.PathValue("payment")
+	q := r.URL.Query()
+	link := paymentLink{exp: q.Get("exp"), sig: q.Get("sig")}
+	if err != nil || paymentID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, "", paymentLink{}, false
+	}
+	if !s.mgr.WebLinks().Verify(accountID, paymentID, link.exp, link.sig) {
+		w.WriteHeader(http.StatusForbidden)
+		return 0, "", paymentLink{}, false
+	}
+	// журнал и заявки у реплики, держащей аккаунт
+	if s.proxyToOwner(w, r, accountID) {
+		return 0, "", paymentLink{}, false
+	}
+	return accountID, paymentID, link, true
+}
@@ -0,0 +1,113 @@
+package i18n
+
+var en = map[string]string{
+	"live.taken_auto":      "🤖 Order taken automatically ✅",
+	"live.pending_restart": "🔁 Taken before the engine restart, still awaiting payment",
+	"button.paid":          "✅ I paid",
+	"button.cancel":        "❌ Cancel",
+	"button.web":           "🌐 Open in browser",
+	"button.bank":          "🏦 Pay in bank app",
+	"button.ack":           "🙋 On it",
+	"button.tune_apply":    "✅ Apply",
+	"button.tune_reject":   "✖️ Keep as is",
+
+	"cmd.paused":             "⏸ Account %s: auto-take paused",
+	"cmd.resumed":            "▶️ Account %s: auto-take resumed",
+	"cmd.usage_amount":       "Usage: %s <amount> [account_id]",
+	"cmd.bad_amount":         "Invalid amount: %s",
+	"cmd.update_error":       "Account %s: error: %v",
+	"cmd.updated":            "✅ Account %s: %s = %.2f",
+	"cmd.takes_today":        "Account %s: taken today %d for %.2f",
+	"cmd.bad_account":        "Invalid account_id: %s",
+	"cmd.no_accounts":        "No accounts available for this chat",
+	"cmd.usage_export":       "Usage: /export [account_id] [today|yesterday|7d|YYYY-MM-DD..YYYY-MM-DD] [csv|xlsx]",
+	"cmd.exported":           "📄 Account %s: %d payments for %s..%s",
+	"cmd.usage_blackout":     "Usage: /blackout [account_id] — list; /blackout add <YYYY-MM-DD[THH:MM]> [YYYY-MM-DD[THH:MM]] [account_id|all] [reason]; /blackout del <id>",
+	"cmd.blackout_line":      "🗓 %s: %s — %s, account %s",
+	"cmd.blackout_all":       "all",
+	"cmd.blackout_added":     "✅ No auto-take: %s",
+	"cmd.blackout_removed":   "🗑 Blackout %s removed",
+	"cmd.blackout_not_found": "Blackout %s not found",
+	"cmd.blackout_none":      "No blackouts planned",
+	"cmd.blackout_bad":       "Invalid blackout: %v",
+	"status.line":            "Account %s: %s, min=%.2f max=%.2f, today %d/%.2f",
+	"status.mode_auto":       "auto",
+	"status.mode_paused":     "paused",
+	"status.active":          ", active %s",
+	"status.blocked_till":    ", blocked until %s",
+
+	"web.payment":         "Order",
+	"web.status":          "Status",
+	"web.brand":           "Brand",
+	"web.amount":          "Amount",
+	"web.rate":            "Rate",
+	"web.expires":         "Expires",
+	"web.taken":           "Taken",
+	"web.take_timing":     "To take / take",
+	"web.ms":              "ms",
+	"web.pay":             "Payment",
+	"web.pay_link":        "payment link",
+	"web.bank_apps":       "Open in app",
+	"web.failed_complete": "Could not mark the order as paid, see the engine log",
+	"web.failed_cancel":   "Could not cancel the order, see the engine log",
+	"web.failed_denied":   "Only the account's paid users may confirm payment, use the Telegram card",
+	"web.not_found":       "order not found",
+
+	"account.deleted": "🗑 Account %s removed from the engine, orders canceled: %d",
+	"breaker.open":    "⛔ Account %s: auto-take stopped (%s) until %s. Use /resume to continue earlier",
+	"brand.denied":    "🚫 Account %s: brand %s has %d disputes and %d cancels in %d days, its orders are no longer taken",
+	"take.unverified": "⚠️ Account %s: P2C confirmed taking %s but the order is not in the account's list, please check manually (%s)",
+	"alert.firing":    "🚨 %s: %s — %s",
+	"alert.resolved":  "✅ %s: %s — back to normal",
+	"maintenance.on":  "🛠 Maintenance: auto-take paused on all accounts (%s), until %s",
+	"maintenance.off": "▶️ Maintenance over after %s, auto-take resumed",
+	"p2c.failover":    "🔀 P2C unavailable (%s), switched from %s to %s",
+	"payer.assigned":  "👤 Payer: %s",
+	"payer.escalated": "⏰ Account %s: order %s for %s not acknowledged by %s, now assigned to %s",
+	"payer.unacked":   "⚠️ Account %s: nobody acknowledged order %s (last asked %s), please pay manually",
+	"payer.acked":     "👌 Account %s: order %s is paid by %s",
+	"card.expires_in": "⏳ Expires in: %s",
+	"card.completing": "⏳ Confirming payment…",
+	"card.completed":  "✅ Paid",
+	"card.cancelling": "⏳ Cancelling…",
+	"card.canceled":   "❌ Order canceled",
+	"card.expired":    "⌛ Expired unpaid",
+	"card.disputed":   "⚠️ Order disputed",
+
+	"handoff.any":       "any eligible account",
+	"handoff.released":  "🔀 Account %s: order %s for %s %s canceled for another account to re-take (%s)",
+	"handoff.routed":    "🔀 Account %s: order %s is listed again, account %s takes it",
+	"handoff.unclaimed": "⚠️ Account %s: order %s is listed again but no account can take it",
+	"handoff.expired":   "⌛ Account %s: order %s was not listed again, handoff dropped",
+
+	"tune.proposal": "🎯 Account %s: suggest changing the amount filter from %s..%s to %s..%s. Outcomes by band:",
+	"tune.band":     "%.0f–%.0f: ✅ %d ❌ %d ⌛ %d ⚠️ %d (%.0f%% failed)",
+	"tune.skips":    "Turned away for the bounds: %d below min, %d above max",
+	"tune.applied":  "✅ Account %s: amount filter is now %s..%s",
+	"tune.rejected": "Account %s: proposal rejected, next one in a day at the earliest",
+
+	"skip.summary":        "📊 Account %s: skipped %d in the last hour — %s",
+	"skip.below_min":      "below min",
+	"skip.above_max":      "above max",
+	"skip.provider":       "provider",
+	"skip.brand":          "disputed brand",
+	"skip.amount_pattern": "amount pattern",
+	"skip.boost":          "boost",
+	"skip.rate":           "rate",
+	"skip.market":         "worse than market",
+	"skip.round_robin":    "another account's turn",
+	"skip.daily_cap":      "daily cap",
+	"skip.paused":         "paused",
+	"skip.maintenance":    "maintenance",
+	"skip.blackout":       "blackout",
+	"skip.active":         "active order",
+	"skip.penalty":        "penalty",
+	"skip.breaker":        "circuit breaker",
+	"skip.arbitrated":     "given to another account",
+	"skip.handoff":        "handed off",
+	"skip.owner_group":    "group cap",
+	"skip.replica":        "another replica",
+	"skip.shutdown":       "shutting down",
+	"skip.gone":           "gone",
+	"skip.other":          "other",
+}
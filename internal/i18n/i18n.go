@@ -0,0 +1,52 @@
+// Package i18n holds the message catalogs for operator-facing texts.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Default is the locale used when an account has none or an unknown one.
+const Default = "ru"
+
+var catalogs = map[string]map[string]string{
+	"ru": ru,
+	"en": en,
+}
+
+// Normalize maps "en-US", "EN" etc. onto a supported locale, falling back
+// to Default.
+func Normalize(locale string) string {
+	if l := language(locale); catalogs[l] != nil {
+		return l
+	}
+	return Default
+}
+
+// Supported reports whether locale has a catalog ("" means Default).
+func Supported(locale string) bool {
+	return locale == "" || catalogs[language(locale)] != nil
+}
+
+func language(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
+
+// T returns the message for key in locale formatted with args. Missing keys
+// fall back to the Default catalog and then to the key itself.
+func T(locale, key string, args ...any) string {
+	msg, ok := catalogs[Normalize(locale)][key]
+	if !ok {
+		if msg, ok = catalogs[Default][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
@@ -0,0 +1,113 @@
+package i18n
+
+var ru = map[string]string{
+	"live.taken_auto":      "🤖 Заявка принята автоматически ✅",
+	"live.pending_restart": "🔁 Взята до перезапуска движка, всё ещё ждёт оплаты",
+	"button.paid":          "✅ Я оплатил",
+	"button.cancel":        "❌ Отменить",
+	"button.web":           "🌐 Открыть в браузере",
+	"button.bank":          "🏦 Оплатить в банке",
+	"button.ack":           "🙋 Беру",
+	"button.tune_apply":    "✅ Применить",
+	"button.tune_reject":   "✖️ Оставить как есть",
+
+	"cmd.paused":             "⏸ Аккаунт %s: авто-взятие на паузе",
+	"cmd.resumed":            "▶️ Аккаунт %s: авто-взятие возобновлено",
+	"cmd.usage_amount":       "Использование: %s <сумма> [account_id]",
+	"cmd.bad_amount":         "Некорректная сумма: %s",
+	"cmd.update_error":       "Аккаунт %s: ошибка: %v",
+	"cmd.updated":            "✅ Аккаунт %s: %s = %.2f",
+	"cmd.takes_today":        "Аккаунт %s: сегодня взято %d на %.2f",
+	"cmd.bad_account":        "Некорректный account_id: %s",
+	"cmd.no_accounts":        "Нет доступных аккаунтов для этого чата",
+	"cmd.usage_export":       "Использование: /export [account_id] [today|yesterday|7d|ГГГГ-ММ-ДД..ГГГГ-ММ-ДД] [csv|xlsx]",
+	"cmd.exported":           "📄 Аккаунт %s: %d заявок за %s..%s",
+	"cmd.usage_blackout":     "Использование: /blackout [account_id] — список; /blackout add <YYYY-MM-DD[THH:MM]> [YYYY-MM-DD[THH:MM]] [account_id|all] [причина]; /blackout del <id>",
+	"cmd.blackout_line":      "🗓 %s: %s — %s, аккаунт %s",
+	"cmd.blackout_all":       "все",
+	"cmd.blackout_added":     "✅ Без авто-взятия: %s",
+	"cmd.blackout_removed":   "🗑 Окно %s удалено",
+	"cmd.blackout_not_found": "Окно %s не найдено",
+	"cmd.blackout_none":      "Окон без авто-взятия не запланировано",
+	"cmd.blackout_bad":       "Неверное окно: %v",
+	"status.line":            "Аккаунт %s: %s, min=%.2f max=%.2f, сегодня %d/%.2f",
+	"status.mode_auto":       "авто",
+	"status.mode_paused":     "пауза",
+	"status.active":          ", активная %s",
+	"status.blocked_till":    ", блок до %s",
+
+	"web.payment":         "Заявка",
+	"web.status":          "Статус",
+	"web.brand":           "Бренд",
+	"web.amount":          "Сумма",
+	"web.rate":            "Курс",
+	"web.expires":         "Истекает",
+	"web.taken":           "Взята",
+	"web.take_timing":     "До take / take",
+	"web.ms":              "мс",
+	"web.pay":             "Оплата",
+	"web.pay_link":        "ссылка на оплату",
+	"web.bank_apps":       "Открыть в приложении",
+	"web.failed_complete": "Не удалось отметить оплату, подробности в логе движка",
+	"web.failed_cancel":   "Не удалось отменить заявку, подробности в логе движка",
+	"web.failed_denied":   "Оплату этого аккаунта подтверждают только назначенные пользователи, из карточки в Telegram",
+	"web.not_found":       "заявка не найдена",
+
+	"account.deleted": "🗑 Аккаунт %s удалён из движка, отменено заявок: %d",
+	"breaker.open":    "⛔ Аккаунт %s: авто-взятие остановлено (%s) до %s. Продолжить раньше — /resume",
+	"brand.denied":    "🚫 Аккаунт %s: у бренда %s %d споров и %d отмен за %d дн., его заявки больше не берём",
+	"take.unverified": "⚠️ Аккаунт %s: P2C подтвердил взятие %s, но заявки нет в списке аккаунта — проверьте вручную (%s)",
+	"alert.firing":    "🚨 %s: %s — %s",
+	"alert.resolved":  "✅ %s: %s — в норме",
+	"maintenance.on":  "🛠 Технические работы: авто-взятие на всех аккаунтах остановлено (%s), до %s",
+	"maintenance.off": "▶️ Технические работы закончились через %s, авто-взятие возобновлено",
+	"p2c.failover":    "🔀 P2C недоступен (%s), переключились с %s на %s",
+	"payer.assigned":  "👤 Платит: %s",
+	"payer.escalated": "⏰ Аккаунт %s: заявку %s на %s не подтвердил %s, теперь платит %s",
+	"payer.unacked":   "⚠️ Аккаунт %s: заявку %s никто не подтвердил (последний — %s), оплатите вручную",
+	"payer.acked":     "👌 Аккаунт %s: заявку %s оплачивает %s",
+	"card.expires_in": "⏳ До истечения: %s",
+	"card.completing": "⏳ Подтверждаем оплату…",
+	"card.completed":  "✅ Оплачено",
+	"card.cancelling": "⏳ Отменяем…",
+	"card.canceled":   "❌ Заявка отменена",
+	"card.expired":    "⌛ Истекла без оплаты",
+	"card.disputed":   "⚠️ Спор по заявке",
+
+	"handoff.any":       "любой подходящий аккаунт",
+	"handoff.released":  "🔀 Аккаунт %s: заявка %s на %s %s отменена для перехвата другим аккаунтом (%s)",
+	"handoff.routed":    "🔀 Аккаунт %s: заявка %s снова в ленте, её берёт аккаунт %s",
+	"handoff.unclaimed": "⚠️ Аккаунт %s: заявка %s снова в ленте, но взять её некому",
+	"handoff.expired":   "⌛ Аккаунт %s: заявка %s не вернулась в ленту, передача отменена",
+
+	"tune.proposal": "🎯 Аккаунт %s: предлагаю поменять фильтр суммы с %s..%s на %s..%s. Исходы по полосам:",
+	"tune.band":     "%.0f–%.0f: ✅ %d ❌ %d ⌛ %d ⚠️ %d (неудачных %.0f%%)",
+	"tune.skips":    "Не прошли по границам: %d ниже min, %d выше max",
+	"tune.applied":  "✅ Аккаунт %s: фильтр суммы теперь %s..%s",
+	"tune.rejected": "Аккаунт %s: предложение отклонено, следующее — не раньше чем через сутки",
+
+	"skip.summary":        "📊 Аккаунт %s: за час пропущено %d — %s",
+	"skip.below_min":      "ниже минимума",
+	"skip.above_max":      "выше максимума",
+	"skip.provider":       "провайдер",
+	"skip.brand":          "бренд со спорами",
+	"skip.amount_pattern": "шаблон суммы",
+	"skip.boost":          "буст",
+	"skip.rate":           "курс",
+	"skip.market":         "хуже рынка",
+	"skip.round_robin":    "очередь другого аккаунта",
+	"skip.daily_cap":      "дневной лимит",
+	"skip.paused":         "пауза",
+	"skip.maintenance":    "технические работы",
+	"skip.blackout":       "окно без авто-взятия",
+	"skip.active":         "активная заявка",
+	"skip.penalty":        "штраф",
+	"skip.breaker":        "предохранитель",
+	"skip.arbitrated":     "отдано другому аккаунту",
+	"skip.handoff":        "передана другому аккаунту",
+	"skip.owner_group":    "лимит группы",
+	"skip.replica":        "другая реплика",
+	"skip.shutdown":       "остановка",
+	"skip.gone":           "ушла из списка",
+	"skip.other":          "прочее",
+}
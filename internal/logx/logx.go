@@ -0,0 +1,149 @@
+// Package logx adds a process-wide log level on top of the standard logger,
+// switchable at runtime (SIGHUP, POST /log-level) so verbose logging can be
+// turned on during an incident without a restart. Lines are still written
+// with log.Printf; only the ones below the current level are dropped.
+package logx
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level orders the verbosity: Debug logs everything, Warn only problems.
+type Level int32
+
+const (
+	Debug Level = iota - 1
+	Info
+	Warn
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Warn:
+		return "warn"
+	}
+	return "info"
+}
+
+func (l Level) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+
+func (l *Level) UnmarshalText(b []byte) error {
+	v, err := ParseLevel(string(b))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// ParseLevel reads debug, info or warn ("warning" too); empty is info.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return Debug, nil
+	case "", "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q (debug, info, warn)", s)
+}
+
+// State is the current level; a temporary level falls back to Base at Until.
+type State struct {
+	Level Level     `json:"level"`
+	Base  Level     `json:"base"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+var (
+	level atomic.Int32 // копия state.Level: Enabled вызывается на каждый кадр websocket
+
+	mu    sync.Mutex
+	state = State{Level: Info, Base: Info}
+	timer *time.Timer
+	gen   int // номер последней смены уровня: старый таймер не откатывает новый уровень
+)
+
+// SetLevel switches the level. With d > 0 the change is temporary and the
+// level returns to the base one after d; otherwise l becomes the base.
+func SetLevel(l Level, d time.Duration) State {
+	mu.Lock()
+	defer mu.Unlock()
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	gen++
+	state.Level = l
+	level.Store(int32(l))
+	state.Until = time.Time{}
+	if d > 0 {
+		state.Until = time.Now().Add(d)
+		g := gen
+		timer = time.AfterFunc(d, func() { restore(g) })
+	} else {
+		state.Base = l
+	}
+	log.Printf("[log] level %s%s", l, untilText(state))
+	return state
+}
+
+func restore(g int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if g != gen {
+		return
+	}
+	gen++
+	timer = nil
+	state.Level = state.Base
+	state.Until = time.Time{}
+	level.Store(int32(state.Base))
+	log.Printf("[log] level back to %s", state.Level)
+}
+
+func untilText(st State) string {
+	if st.Until.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" until %s, then %s", st.Until.Format(time.TimeOnly), st.Base)
+}
+
+// Current returns the level in effect.
+func Current() State {
+	mu.Lock()
+	defer mu.Unlock()
+	return state
+}
+
+// Enabled reports whether lines of level l are written.
+func Enabled(l Level) bool {
+	return int32(l) >= level.Load()
+}
+
+// Debugf logs verbose diagnostics such as raw websocket frames.
+func Debugf(format string, args ...any) {
+	if Enabled(Debug) {
+		log.Printf(format, args...)
+	}
+}
+
+// Infof logs routine events that can be silenced with the warn level.
+func Infof(format string, args ...any) {
+	if Enabled(Info) {
+		log.Printf(format, args...)
+	}
+}
+
+// Warnf logs problems; they are written at every level.
+func Warnf(format string, args ...any) {
+	log.Printf(format, args...)
+}
@@ -0,0 +1,51 @@
+package p2c
+
+import (
+	"context"
+	"time"
+)
+
+// API is what the engine needs from P2C for one account. *Client is the
+// real one; p2ctest.API is an in-memory double for engine tests.
+type API interface {
+	Warmup(ctx context.Context)
+	TakeLivePayment(ctx context.Context, id, idemKey string) (*TakeResult, error)
+	TakePayment(ctx context.Context, id string) error
+	CompletePayment(ctx context.Context, id string, method string) error
+	CancelPayment(ctx context.Context, id string, reason string) error
+	ListPayments(ctx context.Context, params ListPaymentsParams) (*ListPaymentsResponse, error)
+	GetPayment(ctx context.Context, id string) (*Payment, error)
+	ListRequisites(ctx context.Context) ([]Requisite, error)
+	AddRequisite(ctx context.Context, r NewRequisite) (*Requisite, error)
+	SetRequisiteEnabled(ctx context.Context, id string, enabled bool) error
+	// OpenFeed returns the live payment list of the account.
+	OpenFeed(standby bool) Feed
+	// WarmPool returns the keep-warm pool of the take path.
+	WarmPool(conns int, interval time.Duration) Pool
+	// Clock returns the skew of the P2C clock; nil means none is known.
+	Clock() *Clock
+}
+
+// Feed delivers the live payment list, see Socket.
+type Feed interface {
+	Run(ctx context.Context, h SocketHandlers) error
+	Drop()
+}
+
+// Pool keeps connections to P2C warm, see Warmer.
+type Pool interface {
+	Run(ctx context.Context)
+	Stats() WarmStats
+}
+
+var _ API = (*Client)(nil)
+
+// OpenFeed returns NewSocket(standby) as a Feed.
+func (c *Client) OpenFeed(standby bool) Feed {
+	return c.NewSocket(standby)
+}
+
+// WarmPool returns NewWarmer(conns, interval) as a Pool.
+func (c *Client) WarmPool(conns int, interval time.Duration) Pool {
+	return c.NewWarmer(conns, interval)
+}
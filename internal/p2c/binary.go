@@ -0,0 +1,106 @@
+package p2c
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// frameBinaryEvent starts a socket.io BINARY_EVENT: `45<n>-["event",...]`
+// followed by n attachments sent as Engine.IO binary packets.
+var frameBinaryEvent = []byte("45")
+
+// binaryEvent is a BINARY_EVENT waiting for its attachments.
+type binaryEvent struct {
+	body  []byte
+	want  int
+	parts [][]byte
+}
+
+// parseBinaryHeader parses `45<n>-[...]` (namespace and ack id are ignored).
+func parseBinaryHeader(msg []byte) (*binaryEvent, error) {
+	rest := msg[len(frameBinaryEvent):]
+	dash := bytes.IndexByte(rest, '-')
+	if dash <= 0 {
+		return nil, fmt.Errorf("bad binary event header: %q", msg)
+	}
+	n, err := strconv.Atoi(string(rest[:dash]))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad attachment count: %q", msg)
+	}
+	body := rest[dash+1:]
+	// "/ns,<ack>[...]" — пропускаем всё до начала массива
+	if i := bytes.IndexByte(body, '['); i > 0 {
+		body = body[i:]
+	}
+	return &binaryEvent{body: body, want: n}, nil
+}
+
+func (e *binaryEvent) complete() bool { return len(e.parts) >= e.want }
+
+// assemble replaces {"_placeholder":true,"num":i} with the i-th attachment:
+// JSON attachments are inlined, anything else becomes a base64 string.
+func (e *binaryEvent) assemble() ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(e.body, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e.fill(v))
+}
+
+func (e *binaryEvent) fill(v any) any {
+	switch t := v.(type) {
+	case []any:
+		for i := range t {
+			t[i] = e.fill(t[i])
+		}
+	case map[string]any:
+		if ph, _ := t["_placeholder"].(bool); ph {
+			num, _ := t["num"].(float64)
+			if i := int(num); i >= 0 && i < len(e.parts) {
+				if json.Valid(e.parts[i]) {
+					return json.RawMessage(e.parts[i])
+				}
+				return e.parts[i]
+			}
+			return nil
+		}
+		for k := range t {
+			t[k] = e.fill(t[k])
+		}
+	}
+	return v
+}
+
+// handleBinary processes one Engine.IO binary packet: an attachment of the
+// pending BINARY_EVENT.
+func (s *session) handleBinary(data []byte) error {
+	s.recvAt = s.now()
+	if s.h.OnFrame != nil {
+		s.h.OnFrame(s.recvAt, data)
+	}
+	if s.pending == nil {
+		s.frameError(data, fmt.Errorf("binary frame without event, %d bytes ignored", len(data)))
+		return nil
+	}
+	s.pending.parts = append(s.pending.parts, data)
+	if !s.pending.complete() {
+		return nil
+	}
+	return s.flushBinary()
+}
+
+func (s *session) flushBinary() error {
+	ev := s.pending
+	s.pending = nil
+	body, err := ev.assemble()
+	if err != nil {
+		s.frameError(nil, fmt.Errorf("binary event: %w", err))
+		return nil
+	}
+	if err := s.handleEvent(body); err != nil {
+		s.frameError(body, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package p2c
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// RequestIDHeader carries the operation id on every request to P2C.
+const RequestIDHeader = "X-Request-Id"
+
+// Call is one engine operation (a take, a complete, a cancel) followed
+// through the logs, the P2C requests it makes and the chat. It keeps the
+// CF-RAY of the last P2C response, which is what P2C support asks for.
+type Call struct {
+	ID string
+
+	mu     sync.Mutex
+	cfRay  string
+	timing TraceTimings
+}
+
+type callKey struct{}
+
+// NewCall starts an operation; an empty id gets a random one.
+func NewCall(id string) *Call {
+	if id == "" {
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+	return &Call{ID: id}
+}
+
+// WithCall attaches the operation to ctx: requests made with it send the id
+// and record their CF-RAY.
+func WithCall(ctx context.Context, c *Call) context.Context {
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+// CallFrom returns the operation attached to ctx, or nil.
+func CallFrom(ctx context.Context) *Call {
+	c, _ := ctx.Value(callKey{}).(*Call)
+	return c
+}
+
+// CFRay returns the CF-RAY of the last response within the operation.
+func (c *Call) CFRay() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfRay
+}
+
+// Timing returns the timings of the last request within the operation.
+func (c *Call) Timing() TraceTimings {
+	if c == nil {
+		return TraceTimings{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timing
+}
+
+// record keeps the CF-RAY and the timings of a response.
+func (c *Call) record(ray string, t TraceTimings) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if ray != "" {
+		c.cfRay = ray
+	}
+	c.timing = t
+	c.mu.Unlock()
+}
+
+// String renders "req=<id> cf-ray=<ray>" for logs and error messages.
+func (c *Call) String() string {
+	if c == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("req=" + c.ID)
+	if ray := c.CFRay(); ray != "" {
+		b.WriteString(" cf-ray=" + ray)
+	}
+	return b.String()
+}
@@ -2,22 +2,26 @@ package p2c
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"net/http/httptrace"
+	"net/url"
 	"time"
-
-	"github.com/valyala/fasthttp"
 )
 
 type Client struct {
 	baseURL     string
 	accessToken string
-	httpClient  *fasthttp.Client
-	h2Client    *http.Client
+	rt          Transport       // все вызовы API; по умолчанию pooled net/http (HTTP/2)
+	h2          *http.Transport // транспорт rt по умолчанию, для настройки TLS; nil после UseTransport
+	dialer      *net.Dialer
+	prober      *Prober
+	mirrors     *Mirrors // nil — один baseURL без failover
+	transport   TransportConfig
+	sessions    *TLSSessions // общий кэш TLS-сессий, nil — полный handshake каждый раз
+	h3          *h3State     // take по HTTP/3, nil — только HTTP/2
+	resolver    *Resolver    // кэш DNS и статические IP, nil — системный резолвер на каждый dial
+	clock       *Clock       // расхождение с часами P2C по заголовку Date
 }
 
 // TraceTimings captures key timings for HTTP request.
@@ -31,161 +35,222 @@ type TraceTimings struct {
 
 // TakeResult carries take response details.
 type TakeResult struct {
+	Status int
 	Body   []byte
 	CFRay  string
+	Proto  string // HTTP/2.0 или HTTP/3.0
 	Timing TraceTimings
 }
 
+// IsAmbiguousTake reports a take whose outcome is unknown: no response at
+// all (timeout, reset) or a gateway error. P2C may have accepted it, so the
+// payment has to be checked before the take is treated as failed.
+func IsAmbiguousTake(res *TakeResult, err error) bool {
+	if err == nil {
+		return false
+	}
+	if res == nil {
+		return true
+	}
+	switch res.Status {
+	case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return !isChallenge(res.Status, "", res.Body)
+	}
+	return false
+}
+
 func NewClient(baseURL, accessToken string) *Client {
-	transport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: 2 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          512,
-		MaxIdleConnsPerHost:   256,
-		MaxConnsPerHost:       256,
-		IdleConnTimeout:       120 * time.Second,
-		TLSHandshakeTimeout:   2 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		DisableCompression:    true,
-	}
-	return &Client{
+	return NewClientWith(baseURL, accessToken, TransportConfig{})
+}
+
+// NewClientWith builds a client with tuned timeouts and pools.
+func NewClientWith(baseURL, accessToken string, tc TransportConfig) *Client {
+	c := &Client{
 		baseURL:     baseURL,
 		accessToken: accessToken,
-		httpClient: &fasthttp.Client{
-			NoDefaultUserAgentHeader: true,
-			MaxConnsPerHost:          1024,
-			ReadTimeout:              2 * time.Second,
-			WriteTimeout:             2 * time.Second,
-			MaxIdleConnDuration:      30 * time.Second,
-		},
-		h2Client: &http.Client{
-			Transport: transport,
-			Timeout:   3 * time.Second,
-		},
+		dialer:      &net.Dialer{Timeout: tc.dial(), KeepAlive: 30 * time.Second},
+		transport:   tc,
+		clock:       NewClock(),
 	}
+	c.rt, c.h2 = newHTTPTransport(tc, c.dialContext)
+	return c
+}
+
+// Transport returns the timeouts and pool settings of the client.
+func (c *Client) Transport() TransportConfig {
+	return c.transport
+}
+
+// Subscribe connects to p2c-socket of the current mirror with the client's
+// token and handshake timeout; see SubscribeSocket.
+func (c *Client) Subscribe(ctx context.Context, h SocketHandlers) error {
+	return subscribeSocket(ctx, c.base(), c.accessToken, c.transport.handshake(), h)
 }
 
 func (c *Client) BaseURL() string {
+	return c.base()
+}
+
+// base returns the mirror in use, or the fixed base URL.
+func (c *Client) base() string {
+	if c.mirrors != nil {
+		return c.mirrors.Current()
+	}
 	return c.baseURL
 }
 
-// Warmup opens a cheap request to prime TLS/keepalive.
-func (c *Client) Warmup(ctx context.Context) {
-	req, resp := c.newRequest(http.MethodGet, "/health", nil)
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-	_ = c.do(ctx, req, resp)
-	// пробуем также HTTP/2 клиент
-	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
-	if c.accessToken != "" {
-		hreq.Header.Set("Cookie", fmt.Sprintf("access_token=%s", c.accessToken))
+// UseMirrors makes the client follow the shared failover list of base URLs.
+func (c *Client) UseMirrors(m *Mirrors) {
+	c.mirrors = m
+}
+
+// Mirrors returns the failover list attached to the client, if any.
+func (c *Client) Mirrors() *Mirrors {
+	return c.mirrors
+}
+
+// observe feeds the outcome of a request to the failover list: transport
+// errors, 5xx and Cloudflare challenges count as failures of the mirror.
+func (c *Client) observe(host string, err error, status int, mitigated string, body []byte) {
+	if c.mirrors == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		c.mirrors.report(host, false, false, err.Error())
+	case isChallenge(status, mitigated, body):
+		c.mirrors.report(host, false, true, fmt.Sprintf("cloudflare challenge (http %d)", status))
+	case status >= http.StatusInternalServerError:
+		c.mirrors.report(host, false, false, fmt.Sprintf("http %d", status))
+	default:
+		c.mirrors.report(host, true, false, "")
+	}
+}
+
+// UseTransport sends every call through t instead of the network, e.g. a
+// p2ctest.Transport. Call it before the first request.
+func (c *Client) UseTransport(t Transport) {
+	c.rt, c.h2 = t, nil
+}
+
+// UseTLSSessions makes the transport resume TLS sessions from the shared
+// cache. Call it before the first request.
+func (c *Client) UseTLSSessions(s *TLSSessions) {
+	if s == nil {
+		return
+	}
+	c.sessions = s
+	if c.h2 != nil {
+		c.h2.TLSClientConfig = s.tlsConfig()
 	}
-	_, _ = c.h2Client.Do(hreq)
 }
 
-func (c *Client) newRequest(method, path string, body []byte) (*fasthttp.Request, *fasthttp.Response) {
-	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
+// TLSSessions returns the session cache attached to the client, if any.
+func (c *Client) TLSSessions() *TLSSessions {
+	return c.sessions
+}
 
-	req.SetRequestURI(c.baseURL + path)
-	req.Header.SetMethod(method)
-	req.Header.Set("Content-Type", "application/json")
-	if c.accessToken != "" {
-		req.Header.Set("Cookie", fmt.Sprintf("access_token=%s", c.accessToken))
+// UseClock makes the client sample the P2C clock into a shared c.
+func (c *Client) UseClock(clock *Clock) {
+	if clock != nil {
+		c.clock = clock
 	}
-	if body != nil {
-		req.SetBody(body)
+}
+
+// Clock returns the skew estimate of the P2C clock.
+func (c *Client) Clock() *Clock {
+	return c.clock
+}
+
+// UseResolver makes dials use the shared DNS cache and static pins.
+func (c *Client) UseResolver(r *Resolver) {
+	c.resolver = r
+}
+
+// Resolver returns the DNS cache attached to the client, if any.
+func (c *Client) Resolver() *Resolver {
+	return c.resolver
+}
+
+// UseProber pins dials to the P2C host to the prober's fastest edge.
+func (c *Client) UseProber(p *Prober) {
+	c.prober = p
+}
+
+// Prober returns the edge prober attached to the client, if any.
+func (c *Client) Prober() *Prober {
+	return c.prober
+}
+
+// dialContext подменяет адрес P2C-хоста на закреплённый пробером edge; SNI/Host остаются прежними.
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addr == hostPortOf(c.base()) {
+		if pinned := c.prober.Pinned(); pinned != "" {
+			return c.dialer.DialContext(ctx, network, pinned)
+		}
 	}
-	return req, resp
+	if c.resolver != nil {
+		return c.resolver.dial(ctx, c.dialer, network, addr)
+	}
+	return c.dialer.DialContext(ctx, network, addr)
 }
 
-func (c *Client) do(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
-	return c.httpClient.DoRedirects(req, resp, 3)
+func hostPortOf(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
 }
 
-func (c *Client) statusOK(resp *fasthttp.Response) bool {
-	return resp.StatusCode() >= http.StatusOK && resp.StatusCode() < http.StatusMultipleChoices
+// Warmup opens a cheap request to prime TLS/keepalive.
+func (c *Client) Warmup(ctx context.Context) {
+	_, _ = c.call(ctx, apiRequest{op: "warmup", method: http.MethodGet, path: "/health"})
 }
 
 // TakeLivePayment tries to accept a payment by its hex/id from websocket list:update.
-// Endpoint: POST /p2c/payments/take/{id}
-func (c *Client) TakeLivePayment(ctx context.Context, id string) (*TakeResult, error) {
+// idemKey, if set, is sent as Idempotency-Key so a repeated attempt is not
+// taken twice. Endpoint: POST /p2c/payments/take/{id}
+func (c *Client) TakeLivePayment(ctx context.Context, id, idemKey string) (*TakeResult, error) {
 	if id == "" {
 		return nil, fmt.Errorf("empty id")
 	}
-	url := fmt.Sprintf("%s/p2c/payments/take/%s", c.baseURL, id)
-	var t TraceTimings
-	var dnsStart, connStart, tlsStart, writeDone time.Time
-	trace := &httptrace.ClientTrace{
-		DNSStart: func(_ httptrace.DNSStartInfo) { dnsStart = time.Now() },
-		DNSDone:  func(_ httptrace.DNSDoneInfo) { t.DNSLookup = time.Since(dnsStart) },
-		ConnectStart: func(_, _ string) { connStart = time.Now() },
-		ConnectDone: func(_, _ string, _ error) { t.TCPConnection = time.Since(connStart) },
-		TLSHandshakeStart: func() { tlsStart = time.Now() },
-		TLSHandshakeDone:  func(_ tls.ConnectionState, _ error) { t.TLSHandshake = time.Since(tlsStart) },
-		WroteRequest:      func(_ httptrace.WroteRequestInfo) { writeDone = time.Now() },
-		GotFirstResponseByte: func() {
-			if !writeDone.IsZero() {
-				t.ServerTime = time.Since(writeDone)
-			}
-		},
-		GotConn: func(info httptrace.GotConnInfo) {
-			t.ReusedConn = info.Reused
-		},
-	}
-	ctx = httptrace.WithClientTrace(ctx, trace)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
-	if c.accessToken != "" {
-		req.Header.Set("Cookie", fmt.Sprintf("access_token=%s", c.accessToken))
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.h2Client.Do(req)
+	resp, err := c.call(ctx, apiRequest{op: "take payment", method: http.MethodPost, path: "/p2c/payments/take/" + id, idemKey: idemKey, take: true})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 	result := &TakeResult{
-		Body:   body,
-		CFRay:  resp.Header.Get("CF-RAY"),
-		Timing: t,
-	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return result, fmt.Errorf("take payment status %d body=%s", resp.StatusCode, string(body))
+		Status: resp.Status,
+		Body:   resp.Body,
+		CFRay:  resp.CFRay,
+		Proto:  resp.Proto,
+		Timing: resp.Timing,
 	}
-	return result, nil
+	return result, resp.err("take payment")
 }
 
 // CompletePayment confirms payment.
 func (c *Client) CompletePayment(ctx context.Context, id string, method string) error {
 	body := []byte(fmt.Sprintf(`{"method":"%s"}`, method))
-	req, resp := c.newRequest(http.MethodPost, fmt.Sprintf("/p2c/payments/%s/complete", id), body)
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-
-	if err := c.do(ctx, req, resp); err != nil {
+	resp, err := c.call(ctx, apiRequest{op: "complete payment", method: http.MethodPost, path: fmt.Sprintf("/p2c/payments/%s/complete", id), body: body})
+	if err != nil {
 		return err
 	}
-	if !c.statusOK(resp) {
-		return fmt.Errorf("complete payment status %d body=%s", resp.StatusCode(), string(resp.Body()))
-	}
-	return nil
+	return resp.err("complete payment")
 }
 
 // CancelPayment cancels a payment.
 func (c *Client) CancelPayment(ctx context.Context, id string, reason string) error {
 	body := []byte(fmt.Sprintf(`{"reason":"%s"}`, reason))
-	req, resp := c.newRequest(http.MethodPost, fmt.Sprintf("/p2c/payments/%s/cancel", id), body)
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-
-	if err := c.do(ctx, req, resp); err != nil {
+	resp, err := c.call(ctx, apiRequest{op: "cancel payment", method: http.MethodPost, path: fmt.Sprintf("/p2c/payments/%s/cancel", id), body: body})
+	if err != nil {
 		return err
 	}
-	if !c.statusOK(resp) {
-		return fmt.Errorf("cancel payment status %d body=%s", resp.StatusCode(), string(resp.Body()))
-	}
-	return nil
+	return resp.err("cancel payment")
 }
@@ -0,0 +1,124 @@
+package p2c
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	clockSamples = 15              // по медиане стольких ответов
+	clockMinN    = 3               // до стольких замеров считаем, что часы совпадают
+	clockMaxRTT  = 2 * time.Second // ответ дольше — плохой замер: не знаем, когда сервер писал Date
+	clockMinSkew = 1 * time.Second // Date с точностью до секунды: меньшее расхождение — шум
+)
+
+// Clock estimates how far the local clock is from P2C's. Every response
+// carries a Date header; the offset is the median of the recent
+// Date - (send+receive)/2. expires_at and penalty_end_at are P2C times, so
+// the engine turns them into local ones with Local before timing anything
+// against time.Now. A nil Clock assumes the clocks agree.
+type Clock struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	offset  time.Duration
+	at      time.Time // последний замер
+	fixed   bool      // смещение задано, ответы не замеряются
+}
+
+// NewClock returns a clock with no samples yet.
+func NewClock() *Clock {
+	return &Clock{}
+}
+
+// FixedClock returns a clock that is offset ahead of the local one and
+// ignores the responses, e.g. to test a skewed P2C.
+func FixedClock(offset time.Duration) *Clock {
+	return &Clock{offset: offset, fixed: true}
+}
+
+// ClockSnapshot describes the measured skew for the status API.
+type ClockSnapshot struct {
+	OffsetMs int64     `json:"offset_ms"` // P2C впереди локальных часов на столько
+	Samples  int       `json:"samples"`
+	At       time.Time `json:"at,omitempty"`
+}
+
+// observe takes a sample from a response received at recv for a request
+// sent at sent.
+func (c *Clock) observe(h http.Header, sent, recv time.Time) {
+	if c == nil || c.fixed || recv.Sub(sent) > clockMaxRTT {
+		return
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		return
+	}
+	mid := sent.Add(recv.Sub(sent) / 2)
+	// Date обрезан до секунды: в среднем сервер на полсекунды впереди записанного
+	skew := date.Add(500 * time.Millisecond).Sub(mid)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, skew)
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[len(c.samples)-clockSamples:]
+	}
+	sorted := slices.Clone(c.samples)
+	slices.Sort(sorted)
+	was := c.offsetLocked() != 0
+	c.offset = sorted[len(sorted)/2]
+	c.at = recv
+	switch now := c.offsetLocked() != 0; {
+	case now && !was:
+		log.Printf("[clock] P2C clock is %s ahead of the local one (median of %d responses), correcting expiry times", c.offset.Round(time.Millisecond), len(c.samples))
+	case !now && was:
+		log.Printf("[clock] P2C clock back in sync (offset %s)", c.offset.Round(time.Millisecond))
+	}
+}
+
+func skewed(d time.Duration) bool {
+	return d <= -clockMinSkew || d >= clockMinSkew
+}
+
+// Offset returns how far P2C's clock is ahead of the local one; below a
+// second, or before a few responses, it is 0: the Date header cannot tell.
+func (c *Clock) Offset() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offsetLocked()
+}
+
+func (c *Clock) offsetLocked() time.Duration {
+	if !skewed(c.offset) || (!c.fixed && len(c.samples) < clockMinN) {
+		return 0
+	}
+	return c.offset
+}
+
+// Local turns a P2C time into the local clock; zero stays zero.
+func (c *Clock) Local(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(-c.Offset())
+}
+
+// Now returns the current P2C time.
+func (c *Clock) Now() time.Time {
+	return time.Now().Add(c.Offset())
+}
+
+// Snapshot returns the measured skew.
+func (c *Clock) Snapshot() ClockSnapshot {
+	if c == nil {
+		return ClockSnapshot{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClockSnapshot{OffsetMs: c.offset.Milliseconds(), Samples: len(c.samples), At: c.at}
+}
@@ -0,0 +1,353 @@
+package p2c
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// listUpdatePrefix starts the body of every list:update event.
+var listUpdatePrefix = []byte(`["list:update",`)
+
+var errFastJSON = errors.New("fastjson: unexpected input")
+
+// parseListUpdateEvent parses `["list:update",[{op,data,pos},...]]` without
+// reflection, appending to dst (reused between frames). ok is false when the
+// body is not a list:update or uses something the scanner does not support
+// (escapes in keys, a field of another type); callers then fall back to
+// encoding/json. Keys are matched exactly, as P2C sends them.
+func parseListUpdateEvent(body []byte, dst []listUpdate) (updates []listUpdate, ok bool) {
+	if !bytes.HasPrefix(body, listUpdatePrefix) {
+		return dst, false
+	}
+	s := scanner{b: body, i: len(listUpdatePrefix)}
+	updates, err := s.updates(dst[:0])
+	if err != nil {
+		return dst[:0], false
+	}
+	s.ws()
+	if !s.eat(']') {
+		return dst[:0], false
+	}
+	s.ws()
+	return updates, s.i == len(s.b)
+}
+
+// scanner is a minimal JSON reader for the websocket hot path.
+type scanner struct {
+	b []byte
+	i int
+}
+
+func (s *scanner) ws() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) eat(c byte) bool {
+	if s.i < len(s.b) && s.b[s.i] == c {
+		s.i++
+		return true
+	}
+	return false
+}
+
+func (s *scanner) peek() byte {
+	if s.i < len(s.b) {
+		return s.b[s.i]
+	}
+	return 0
+}
+
+func (s *scanner) updates(dst []listUpdate) ([]listUpdate, error) {
+	s.ws()
+	if !s.eat('[') {
+		return dst, errFastJSON
+	}
+	s.ws()
+	if s.eat(']') {
+		return dst, nil
+	}
+	for {
+		var u listUpdate
+		if err := s.update(&u); err != nil {
+			return dst, err
+		}
+		dst = append(dst, u)
+		s.ws()
+		if s.eat(']') {
+			return dst, nil
+		}
+		if !s.eat(',') {
+			return dst, errFastJSON
+		}
+	}
+}
+
+func (s *scanner) update(u *listUpdate) error {
+	return s.object(func(key []byte) error {
+		switch string(key) {
+		case "op":
+			v, err := s.str()
+			u.Op = v
+			return err
+		case "pos":
+			if s.null() {
+				u.Pos = nil
+				return nil
+			}
+			n, err := s.int()
+			u.Pos = &n
+			return err
+		case "data":
+			if s.null() {
+				u.Data = nil
+				return nil
+			}
+			p := new(LivePayment)
+			u.Data = p
+			return s.payment(p)
+		}
+		return s.skip()
+	})
+}
+
+func (s *scanner) payment(p *LivePayment) error {
+	return s.object(func(key []byte) error {
+		var dst *string
+		switch string(key) {
+		case "id":
+			dst = &p.ID
+		case "payload":
+			dst = &p.Payload
+		case "url":
+			dst = &p.URL
+		case "brand_name":
+			dst = &p.BrandName
+		case "in_asset":
+			dst = &p.InAsset
+		case "out_asset":
+			dst = &p.OutAsset
+		case "provider":
+			dst = &p.Provider
+		case "in_amount":
+			dst = &p.InAmount
+		case "out_amount":
+			dst = &p.OutAmount
+		case "exchange_rate":
+			dst = &p.ExchangeRate
+		case "fee_amount":
+			dst = &p.FeeAmount
+		case "expires_at":
+			dst = &p.ExpiresAt
+		case "boost":
+			if s.null() {
+				return nil
+			}
+			f, err := s.float()
+			p.Boost = f
+			return err
+		default:
+			return s.skip()
+		}
+		if s.null() {
+			return nil
+		}
+		v, err := s.str()
+		*dst = v
+		return err
+	})
+}
+
+// object calls field for every key; field must consume the value.
+func (s *scanner) object(field func(key []byte) error) error {
+	s.ws()
+	if !s.eat('{') {
+		return errFastJSON
+	}
+	s.ws()
+	if s.eat('}') {
+		return nil
+	}
+	for {
+		s.ws()
+		key, err := s.rawKey()
+		if err != nil {
+			return err
+		}
+		s.ws()
+		if !s.eat(':') {
+			return errFastJSON
+		}
+		s.ws()
+		if err := field(key); err != nil {
+			return err
+		}
+		s.ws()
+		if s.eat('}') {
+			return nil
+		}
+		if !s.eat(',') {
+			return errFastJSON
+		}
+	}
+}
+
+// rawKey returns a key without escapes as a slice of the input.
+func (s *scanner) rawKey() ([]byte, error) {
+	if !s.eat('"') {
+		return nil, errFastJSON
+	}
+	start := s.i
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case '"':
+			key := s.b[start:s.i]
+			s.i++
+			return key, nil
+		case '\\':
+			return nil, errFastJSON
+		}
+		s.i++
+	}
+	return nil, errFastJSON
+}
+
+func (s *scanner) str() (string, error) {
+	if !s.eat('"') {
+		return "", errFastJSON
+	}
+	start := s.i
+	for s.i < len(s.b) {
+		c := s.b[s.i]
+		switch {
+		case c == '"':
+			v := string(s.b[start:s.i])
+			s.i++
+			return v, nil
+		case c == '\\':
+			// редкий случай — отдаём разбор экранирования encoding/json
+			end := s.stringEnd(start)
+			if end < 0 {
+				return "", errFastJSON
+			}
+			var v string
+			if err := json.Unmarshal(s.b[start-1:end], &v); err != nil {
+				return "", err
+			}
+			s.i = end
+			return v, nil
+		case c < 0x20:
+			return "", errFastJSON
+		}
+		s.i++
+	}
+	return "", errFastJSON
+}
+
+// stringEnd returns the index after the closing quote of a string whose
+// content starts at start.
+func (s *scanner) stringEnd(start int) int {
+	for i := start; i < len(s.b); i++ {
+		switch s.b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func (s *scanner) null() bool {
+	if bytes.HasPrefix(s.b[s.i:], []byte("null")) {
+		s.i += 4
+		return true
+	}
+	return false
+}
+
+func (s *scanner) number() []byte {
+	start := s.i
+	for s.i < len(s.b) {
+		switch c := s.b[s.i]; {
+		case c >= '0' && c <= '9', c == '-', c == '+', c == '.', c == 'e', c == 'E':
+			s.i++
+		default:
+			return s.b[start:s.i]
+		}
+	}
+	return s.b[start:s.i]
+}
+
+func (s *scanner) int() (int, error) {
+	n, err := strconv.Atoi(string(s.number()))
+	if err != nil {
+		return 0, errFastJSON
+	}
+	return n, nil
+}
+
+func (s *scanner) float() (float64, error) {
+	f, err := strconv.ParseFloat(string(s.number()), 64)
+	if err != nil {
+		return 0, errFastJSON
+	}
+	return f, nil
+}
+
+// skip consumes any value.
+func (s *scanner) skip() error {
+	s.ws()
+	switch c := s.peek(); {
+	case c == '"':
+		end := s.stringEnd(s.i + 1)
+		if end < 0 {
+			return errFastJSON
+		}
+		s.i = end
+		return nil
+	case c == '{', c == '[':
+		depth := 0
+		for s.i < len(s.b) {
+			switch s.b[s.i] {
+			case '"':
+				end := s.stringEnd(s.i + 1)
+				if end < 0 {
+					return errFastJSON
+				}
+				s.i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					s.i++
+					return nil
+				}
+			}
+			s.i++
+		}
+		return errFastJSON
+	case c == 't' && bytes.HasPrefix(s.b[s.i:], []byte("true")):
+		s.i += 4
+	case c == 'f' && bytes.HasPrefix(s.b[s.i:], []byte("false")):
+		s.i += 5
+	case s.null():
+	case c == '-' || (c >= '0' && c <= '9'):
+		if len(s.number()) == 0 {
+			return errFastJSON
+		}
+	default:
+		return errFastJSON
+	}
+	return nil
+}
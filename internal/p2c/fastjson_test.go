@@ -0,0 +1,59 @@
+package p2c
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// benchFrame is a typical list:update body: one add and one remove.
+var benchFrame = []byte(`["list:update",[{"op":"add","pos":0,"data":{"id":"6f1c2a9e4b7d","payload":"","url":"https://pay.example/6f1c2a9e4b7d","brand_name":"Магнит","in_asset":"RUB","out_asset":"USDT","boost":1.5,"provider":"sbp","in_amount":"4500.00","out_amount":"48120000000000000000","exchange_rate":"93.51","fee_amount":"721800000000000000","expires_at":"2026-01-01T12:00:00Z"}},{"op":"remove","pos":7}]]`)
+
+// decodeListUpdate is the encoding/json path the scanner falls back to.
+func decodeListUpdate(body []byte) ([]listUpdate, error) {
+	var arr []json.RawMessage
+	if err := json.Unmarshal(body, &arr); err != nil {
+		return nil, err
+	}
+	if len(arr) < 2 {
+		return nil, errors.New("list:update without updates")
+	}
+	var updates []listUpdate
+	err := json.Unmarshal(arr[1], &updates)
+	return updates, err
+}
+
+func TestParseListUpdateEventMatchesEncodingJSON(t *testing.T) {
+	got, ok := parseListUpdateEvent(benchFrame, nil)
+	if !ok {
+		t.Fatal("fast parse failed")
+	}
+	want, err := decodeListUpdate(benchFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fast parse = %+v, encoding/json = %+v", got, want)
+	}
+}
+
+func BenchmarkListUpdateFast(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]listUpdate, 0, 16)
+	for i := 0; i < b.N; i++ {
+		var ok bool
+		if buf, ok = parseListUpdateEvent(benchFrame, buf); !ok {
+			b.Fatal("fast parse failed")
+		}
+	}
+}
+
+func BenchmarkListUpdateEncodingJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeListUpdate(benchFrame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package p2c
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// newHTTP3Transport builds the QUIC transport. It is set by http3_quic.go
+// in builds with -tags http3 and stays nil otherwise.
+var newHTTP3Transport func(tlsConf *tls.Config, tc TransportConfig) http.RoundTripper
+
+// ErrNoHTTP3 is returned by UseHTTP3 in builds without QUIC support.
+var ErrNoHTTP3 = errors.New("p2c: built without HTTP/3 support (build with -tags http3)")
+
+// h3Cooldown is how long takes stay on HTTP/2 after HTTP/3 failed, e.g.
+// when the network drops UDP.
+const h3Cooldown = time.Minute
+
+// h3State is the optional HTTP/3 transport of the take path.
+type h3State struct {
+	client    Transport
+	downUntil atomic.Int64 // unix nano, до этого момента take идёт по HTTP/2
+}
+
+// UseHTTP3 sends takes over HTTP/3 (QUIC): no TCP+TLS handshake after a
+// network change, and no head-of-line blocking on loss. When a take fails
+// at the transport level it is retried over HTTP/2 with the same
+// idempotency key, and HTTP/3 is skipped for a minute.
+func (c *Client) UseHTTP3() error {
+	if newHTTP3Transport == nil {
+		return ErrNoHTTP3
+	}
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS13}
+	if c.sessions != nil {
+		tlsConf = c.sessions.tlsConfig()
+	}
+	c.h3 = &h3State{client: &http.Client{
+		Transport: newHTTP3Transport(tlsConf, c.transport),
+		Timeout:   c.transport.request(),
+	}}
+	return nil
+}
+
+// HTTP3 reports whether takes try HTTP/3 first.
+func (c *Client) HTTP3() bool {
+	return c.h3 != nil
+}
+
+// takeTransport returns the transport for the next take: HTTP/3 unless it
+// is disabled or cooling down after a failure.
+func (c *Client) takeTransport() (rt Transport, h3 bool) {
+	if c.h3 == nil || time.Now().UnixNano() < c.h3.downUntil.Load() {
+		return c.rt, false
+	}
+	return c.h3.client, true
+}
+
+// h3Failed switches takes to HTTP/2 for h3Cooldown.
+func (c *Client) h3Failed(err error) {
+	if c.h3.downUntil.Swap(time.Now().Add(h3Cooldown).UnixNano()) < time.Now().UnixNano() {
+		log.Printf("http3 take failed (%v), falling back to HTTP/2 for %s", err, h3Cooldown)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build http3
+
+package p2c
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func init() {
+	newHTTP3Transport = func(tlsConf *tls.Config, tc TransportConfig) http.RoundTripper {
+		return &http3.Transport{
+			TLSClientConfig: tlsConf,
+			QUICConfig: &quic.Config{
+				// UDP может резаться сетью — долго не ждём, take уйдёт по HTTP/2
+				HandshakeIdleTimeout: tc.dial() + tc.tls(),
+				MaxIdleTimeout:       tc.idle(),
+				KeepAlivePeriod:      15 * time.Second,
+			},
+		}
+	}
+}
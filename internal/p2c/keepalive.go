@@ -0,0 +1,86 @@
+package p2c
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval = 20 * time.Second
+	defaultPingTimeout  = 20 * time.Second
+	maxClientPingEvery  = 5 * time.Second
+	maxPongWait         = 10 * time.Second
+)
+
+// heartbeat holds the Engine.IO timings from the handshake.
+type heartbeat struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// keepalive detects dead connections without waiting for TCP. Every frame
+// pushes the read deadline forward by pingInterval+pingTimeout — the server
+// must ping within that time. The client also pings on its own; once the
+// server has answered a client ping, a pong missing for pongWait is enough
+// to drop the connection.
+type keepalive struct {
+	conn *websocket.Conn
+	hb   heartbeat
+
+	pongSeen atomic.Bool
+	lastRead atomic.Int64 // unix nano
+}
+
+func newKeepalive(conn *websocket.Conn, hb heartbeat) *keepalive {
+	k := &keepalive{conn: conn, hb: hb}
+	k.frame(nil)
+	return k
+}
+
+func (k *keepalive) pingEvery() time.Duration {
+	return min(k.hb.interval/2, maxClientPingEvery)
+}
+
+func (k *keepalive) pongWait() time.Duration {
+	return min(k.hb.timeout, maxPongWait)
+}
+
+// frame is called for every received frame.
+func (k *keepalive) frame(msg []byte) {
+	now := time.Now()
+	k.lastRead.Store(now.UnixNano())
+	if bytes.Equal(msg, framePong) {
+		k.pongSeen.Store(true)
+	}
+	_ = k.conn.SetReadDeadline(now.Add(k.hb.interval + k.hb.timeout))
+}
+
+// run sends client pings until ctx is done; then it unblocks the reader.
+func (k *keepalive) run(ctx context.Context, send func([]byte) error) {
+	t := time.NewTicker(k.pingEvery())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = k.conn.SetReadDeadline(time.Now())
+			return
+		case <-t.C:
+		}
+		sentAt := time.Now()
+		if err := send(framePing); err != nil {
+			_ = k.conn.SetReadDeadline(time.Now())
+			return
+		}
+		if k.pongSeen.Load() {
+			// сервер отвечает на наши пинги — ждём ответ не дольше pongWait
+			deadline := sentAt.Add(k.pongWait())
+			if last := time.Unix(0, k.lastRead.Load()); last.Before(sentAt) {
+				_ = k.conn.SetReadDeadline(deadline)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package p2c
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const defaultMirrorMaxFailures = 5
+
+// MirrorSnapshot is the failover state exposed in the status API.
+type MirrorSnapshot struct {
+	Current    string    `json:"current"`
+	URLs       []string  `json:"urls"`
+	Failures   int       `json:"failures"`
+	SwitchedAt time.Time `json:"switched_at,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// Mirrors is the list of P2C base URLs (mirror domains) shared by all
+// clients. After maxFailures failed requests in a row, or at once on a
+// Cloudflare challenge, every client moves to the next URL; after the last
+// one it wraps around to the primary.
+type Mirrors struct {
+	urls        []string
+	maxFailures int
+
+	mu         sync.Mutex
+	current    int
+	failures   int
+	switchedAt time.Time
+	reason     string
+	onFailover func(from, to, reason string)
+}
+
+// NewMirrors builds the failover list; the first URL is the primary.
+func NewMirrors(urls []string, maxFailures int) *Mirrors {
+	if maxFailures <= 0 {
+		maxFailures = defaultMirrorMaxFailures
+	}
+	return &Mirrors{urls: urls, maxFailures: maxFailures}
+}
+
+// OnFailover registers a callback invoked after switching to another URL.
+func (m *Mirrors) OnFailover(fn func(from, to, reason string)) {
+	m.mu.Lock()
+	m.onFailover = fn
+	m.mu.Unlock()
+}
+
+// Current returns the base URL in use.
+func (m *Mirrors) Current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.urls[m.current]
+}
+
+// report records the outcome of a request sent to host. Outcomes for a
+// host that is no longer current (requests in flight during a switch) are
+// ignored.
+func (m *Mirrors) report(host string, ok bool, challenge bool, reason string) {
+	if len(m.urls) < 2 {
+		return
+	}
+	m.mu.Lock()
+	from := m.urls[m.current]
+	if hostOf(from) != host {
+		m.mu.Unlock()
+		return
+	}
+	if ok {
+		m.failures = 0
+		m.mu.Unlock()
+		return
+	}
+	m.failures++
+	if !challenge && m.failures < m.maxFailures {
+		m.mu.Unlock()
+		return
+	}
+	m.current = (m.current + 1) % len(m.urls)
+	m.failures = 0
+	m.switchedAt = time.Now()
+	m.reason = reason
+	to := m.urls[m.current]
+	fn := m.onFailover
+	m.mu.Unlock()
+
+	log.Printf("p2c failover %s -> %s: %s", from, to, reason)
+	if fn != nil {
+		fn(from, to, reason)
+	}
+}
+
+// Snapshot returns the current failover state.
+func (m *Mirrors) Snapshot() MirrorSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MirrorSnapshot{
+		Current:    m.urls[m.current],
+		URLs:       append([]string(nil), m.urls...),
+		Failures:   m.failures,
+		SwitchedAt: m.switchedAt,
+		Reason:     m.reason,
+	}
+}
+
+// isChallenge reports a Cloudflare challenge page instead of an API answer.
+func isChallenge(status int, mitigated string, body []byte) bool {
+	if mitigated == "challenge" {
+		return true
+	}
+	if status != http.StatusForbidden && status != http.StatusServiceUnavailable {
+		return false
+	}
+	return bytes.Contains(body, []byte("challenge-platform")) || bytes.Contains(body, []byte("Just a moment..."))
+}
+
+func hostOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,481 @@
+// Package p2cfake is a fake P2C for load tests of the take path: it lists
+// synthetic payments over the p2c-socket websocket and serves take, get,
+// list, complete and cancel with configurable latencies and competition
+// from other traders. The engine talks to it as to the real API:
+//
+//	srv := p2cfake.New(p2cfake.Config{Rate: 5})
+//	defer srv.Close()
+//	client := p2c.NewClient(srv.URL(), "token")
+//
+// The server uses TLS (the client dials https and wss only); its
+// certificate is in CertPEM and must be trusted by the engine, e.g. with
+// SSL_CERT_FILE. Any non-empty access_token cookie is an account.
+package p2cfake
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+const (
+	apiPrefix   = "/internal/v1" // где клиент ждёт API и сокет
+	forgetAfter = time.Minute    // сколько помним ушедшую из списка чужую заявку
+)
+
+// Config sets the synthetic traffic. Zero fields take the defaults.
+type Config struct {
+	Rate       float64       // новых заявок в секунду (пуассоновский поток), 0 — 1
+	Lifetime   time.Duration // сколько никем не взятая заявка висит в списке, 0 — 30с
+	MinAmount  float64       // границы суммы в фиате, 0 — 500 и 20000
+	MaxAmount  float64
+	Providers  []string      // пусто — sbp и card
+	Contention float64       // доля заявок, которые забирает сторонний трейдер
+	RivalDelay time.Duration // через сколько после появления их забирают, 0 — 150мс
+	// TakeLatency is the response time of a take, APILatency of the other
+	// requests; Jitter spreads both by ±that share.
+	TakeLatency time.Duration
+	APILatency  time.Duration
+	Jitter      float64
+	// TakeErrors is the share of successful takes answered with 502, so the
+	// engine has to check the outcome.
+	TakeErrors float64
+	PayTTL     time.Duration // срок оплаты взятой заявки, 0 — 15мин
+}
+
+func (c Config) withDefaults() Config {
+	if c.Rate <= 0 {
+		c.Rate = 1
+	}
+	if c.Lifetime <= 0 {
+		c.Lifetime = 30 * time.Second
+	}
+	if c.MinAmount <= 0 {
+		c.MinAmount = 500
+	}
+	if c.MaxAmount <= c.MinAmount {
+		c.MaxAmount = max(20000, c.MinAmount)
+	}
+	if len(c.Providers) == 0 {
+		c.Providers = []string{"sbp", "card"}
+	}
+	if c.RivalDelay <= 0 {
+		c.RivalDelay = 150 * time.Millisecond
+	}
+	if c.PayTTL <= 0 {
+		c.PayTTL = 15 * time.Minute
+	}
+	return c
+}
+
+// Payment states of the fake.
+const (
+	stateListed    = "listed"
+	stateRival     = "rival"   // забрал сторонний трейдер
+	stateExpired   = "expired" // никто не взял
+	stateTaken     = "processing"
+	stateCompleted = "completed"
+	stateCanceled  = "canceled"
+)
+
+type payment struct {
+	live      p2c.LivePayment
+	num       int64
+	state     string
+	owner     string // токен аккаунта, взявшего заявку
+	idemKey   string
+	listedAt  time.Time
+	takenAt   time.Time
+	expiresAt time.Time
+}
+
+// Server is a running fake P2C.
+type Server struct {
+	cfg  Config
+	ts   *httptest.Server
+	stop chan struct{}
+	done sync.WaitGroup
+
+	mu       sync.Mutex
+	payments map[string]*payment // по hex и по числовому id
+	list     []string            // hex заявок в порядке списка
+	nextNum  int64
+	conns    map[*conn]bool // подписанные на list:update
+	stats    Stats
+}
+
+// New starts a fake on a random local port.
+func New(cfg Config) *Server {
+	return NewOn(cfg, nil)
+}
+
+// NewOn starts a fake on l; nil means a random local port.
+func NewOn(cfg Config, l net.Listener) *Server {
+	s := &Server{
+		cfg:      cfg.withDefaults(),
+		stop:     make(chan struct{}),
+		payments: make(map[string]*payment),
+		nextNum:  1000,
+		conns:    make(map[*conn]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+apiPrefix+"/p2c-socket/", s.handleSocket)
+	mux.HandleFunc(apiPrefix+"/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET "+apiPrefix+"/p2c/payments", s.handleList)
+	mux.HandleFunc("GET "+apiPrefix+"/p2c/payments/{id}", s.handleGet)
+	// take/{id} и {id}/complete пересекаются в шаблонах ServeMux — разбираем сами
+	mux.HandleFunc("POST "+apiPrefix+"/p2c/payments/{a}/{b}", func(w http.ResponseWriter, r *http.Request) {
+		a, b := r.PathValue("a"), r.PathValue("b")
+		switch {
+		case a == "take":
+			s.handleTake(w, r, b)
+		case b == "complete":
+			s.handleFinish(w, r, a, stateCompleted)
+		case b == "cancel":
+			s.handleFinish(w, r, a, stateCanceled)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	s.ts = httptest.NewUnstartedServer(mux)
+	if l != nil {
+		s.ts.Listener.Close()
+		s.ts.Listener = l
+	}
+	s.ts.EnableHTTP2 = true
+	s.ts.StartTLS()
+	s.done.Add(1)
+	go s.generate()
+	return s
+}
+
+// URL is the API base URL to give the client (P2C_BASE_URL).
+func (s *Server) URL() string { return s.ts.URL + apiPrefix }
+
+// CertPEM is the server certificate the engine has to trust.
+func (s *Server) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ts.Certificate().Raw})
+}
+
+// Close stops the traffic and the server.
+func (s *Server) Close() {
+	close(s.stop)
+	s.done.Wait()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+	s.ts.CloseClientConnections()
+	s.ts.Close()
+}
+
+// generate lists new payments at cfg.Rate and takes some of them on
+// behalf of other traders.
+func (s *Server) generate() {
+	defer s.done.Done()
+	for {
+		wait := time.Duration(rand.ExpFloat64() / s.cfg.Rate * float64(time.Second))
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		p := s.add(time.Now())
+		time.AfterFunc(s.cfg.Lifetime, func() { s.unlist(p, stateExpired) })
+		if rand.Float64() < s.cfg.Contention {
+			time.AfterFunc(s.jitter(s.cfg.RivalDelay), func() { s.unlist(p, stateRival) })
+		}
+	}
+}
+
+func (s *Server) add(now time.Time) string {
+	amount := s.cfg.MinAmount + rand.Float64()*(s.cfg.MaxAmount-s.cfg.MinAmount)
+	rate := 90 + rand.Float64()*10
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextNum++
+	p := &payment{
+		num:      s.nextNum,
+		state:    stateListed,
+		listedAt: now,
+		live: p2c.LivePayment{
+			ID:           fmt.Sprintf("%08x%016x", uint32(now.Unix()), rand.Uint64()), // как ObjectID
+			InAsset:      "RUB",
+			OutAsset:     "USDT",
+			Provider:     s.cfg.Providers[rand.IntN(len(s.cfg.Providers))],
+			InAmount:     strconv.FormatFloat(amount, 'f', 0, 64),
+			OutAmount:    strconv.FormatFloat(amount/rate, 'f', 2, 64),
+			ExchangeRate: strconv.FormatFloat(rate, 'f', 2, 64),
+			Boost:        float64(rand.IntN(4)) / 2,
+			ExpiresAt:    now.Add(s.cfg.Lifetime).UTC().Format(time.RFC3339),
+		},
+	}
+	s.payments[p.live.ID] = p
+	s.payments[strconv.FormatInt(p.num, 10)] = p
+	s.list = slices.Insert(s.list, 0, p.live.ID)
+	s.stats.Listed++
+	s.broadcastLocked(map[string]any{"op": "add", "data": p.live})
+	return p.live.ID
+}
+
+// unlist removes a payment nobody of ours took.
+func (s *Server) unlist(id, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.payments[id]
+	if p == nil || p.state != stateListed {
+		return
+	}
+	p.state = state
+	if state == stateRival {
+		s.stats.Rival++
+	} else {
+		s.stats.Expired++
+	}
+	s.removeLocked(id)
+	// ещё минуту отвечаем опоздавшим take, потом забываем
+	time.AfterFunc(forgetAfter, func() {
+		s.mu.Lock()
+		delete(s.payments, p.live.ID)
+		delete(s.payments, strconv.FormatInt(p.num, 10))
+		s.mu.Unlock()
+	})
+}
+
+func (s *Server) removeLocked(id string) {
+	if i := slices.Index(s.list, id); i >= 0 {
+		s.list = slices.Delete(s.list, i, i+1)
+		s.broadcastLocked(map[string]any{"op": "remove", "pos": i})
+	}
+}
+
+// jitter spreads d by ±cfg.Jitter.
+func (s *Server) jitter(d time.Duration) time.Duration {
+	if d <= 0 || s.cfg.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + s.cfg.Jitter*(2*rand.Float64()-1)))
+}
+
+func (s *Server) sleep(d time.Duration) {
+	if d = s.jitter(d); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func token(r *http.Request) string {
+	c, err := r.Cookie("access_token")
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func (s *Server) handleTake(w http.ResponseWriter, r *http.Request, id string) {
+	arrived := time.Now()
+	tok := token(r)
+	if tok == "" {
+		apiError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	s.sleep(s.cfg.TakeLatency)
+	key := r.Header.Get("Idempotency-Key")
+	s.mu.Lock()
+	p := s.payments[id]
+	switch {
+	case p == nil:
+		s.mu.Unlock()
+		apiError(w, http.StatusNotFound, "PaymentNotFound")
+		return
+	case p.owner == tok && key != "" && p.idemKey == key:
+		// повтор того же take: отвечаем как в первый раз
+		s.stats.Repeats++
+	case p.state != stateListed:
+		s.stats.Lost++
+		s.mu.Unlock()
+		apiError(w, http.StatusBadRequest, "PaymentNotAvailable")
+		return
+	case s.activeLocked(tok):
+		s.stats.ActiveExists++
+		s.mu.Unlock()
+		apiError(w, http.StatusBadRequest, "ActiveOrderExists")
+		return
+	default:
+		p.state, p.owner, p.idemKey = stateTaken, tok, key
+		p.takenAt = time.Now()
+		p.expiresAt = p.takenAt.Add(s.cfg.PayTTL)
+		s.stats.Taken++
+		s.stats.reaction = append(s.stats.reaction, arrived.Sub(p.listedAt))
+		s.removeLocked(p.live.ID)
+	}
+	body := map[string]any{"data": map[string]any{"id": p.num, "expires_at": p.expiresAt.UTC().Format(time.RFC3339), "url": "https://pay.example/" + p.live.ID}}
+	s.mu.Unlock()
+	if rand.Float64() < s.cfg.TakeErrors {
+		s.mu.Lock()
+		s.stats.BadGateway++
+		s.mu.Unlock()
+		apiError(w, http.StatusBadGateway, "BadGateway")
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// activeLocked reports whether the account holds an unpaid payment.
+func (s *Server) activeLocked(tok string) bool {
+	for id, p := range s.payments {
+		if p.owner == tok && p.state == stateTaken && id == p.live.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) apiPayment(p *payment) p2c.Payment {
+	return p2c.Payment{
+		ID:           json.Number(strconv.FormatInt(p.num, 10)),
+		Asset:        p.live.OutAsset,
+		Amount:       p.live.OutAmount,
+		AmountFiat:   p.live.InAmount,
+		Fiat:         p.live.InAsset,
+		ExchangeRate: p.live.ExchangeRate,
+		URL:          "https://pay.example/" + p.live.ID,
+		Status:       p2c.PaymentStatus(p.state),
+		Processing:   p.takenAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	tok := token(r)
+	s.sleep(s.cfg.APILatency)
+	s.mu.Lock()
+	p := s.payments[r.PathValue("id")]
+	if p == nil || tok == "" || p.owner != tok {
+		s.mu.Unlock()
+		apiError(w, http.StatusNotFound, "PaymentNotFound")
+		return
+	}
+	out := s.apiPayment(p)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"data": out})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	tok := token(r)
+	if tok == "" {
+		apiError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	s.sleep(s.cfg.APILatency)
+	status := r.URL.Query().Get("status")
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if size <= 0 {
+		size = 20
+	}
+	s.mu.Lock()
+	var own []*payment
+	for id, p := range s.payments {
+		if p.owner == tok && id == p.live.ID && (status == "" || p.state == status) {
+			own = append(own, p)
+		}
+	}
+	slices.SortFunc(own, func(a, b *payment) int { return b.takenAt.Compare(a.takenAt) })
+	out := make([]p2c.Payment, 0, min(size, len(own)))
+	for _, p := range own[:min(size, len(own))] {
+		out = append(out, s.apiPayment(p))
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, p2c.ListPaymentsResponse{Data: out})
+}
+
+// handleFinish completes or cancels a payment of the account.
+func (s *Server) handleFinish(w http.ResponseWriter, r *http.Request, id, state string) {
+	tok := token(r)
+	s.sleep(s.cfg.APILatency)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.payments[id]
+	switch {
+	case p == nil || tok == "" || p.owner != tok:
+		apiError(w, http.StatusNotFound, "PaymentNotFound")
+		return
+	case p.state != stateTaken:
+		apiError(w, http.StatusBadRequest, "InvalidPaymentStatus")
+		return
+	}
+	p.state = state
+	if state == stateCompleted {
+		s.stats.Completed++
+	} else {
+		s.stats.Canceled++
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": s.apiPayment(p)})
+}
+
+// Stats counts what happened to the listed payments.
+type Stats struct {
+	Listed       int // появилось в списке
+	Taken        int // взято нашими аккаунтами
+	Rival        int // забрали сторонние трейдеры
+	Expired      int // никто не взял
+	Lost         int // take опоздал: заявки уже нет в списке
+	ActiveExists int // take при неоплаченной заявке аккаунта — ошибка движка
+	Repeats      int // повтор take с тем же Idempotency-Key
+	BadGateway   int // успешный take, отвеченный 502
+	Completed    int
+	Canceled     int
+	// Reaction percentiles: from the listing to the take arriving.
+	ReactionP50, ReactionP90, ReactionP99 time.Duration
+
+	reaction []time.Duration
+}
+
+// Stats returns the counters so far.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	st := s.stats
+	sorted := slices.Clone(s.stats.reaction)
+	s.mu.Unlock()
+	st.reaction = nil
+	slices.Sort(sorted)
+	pct := func(q float64) time.Duration {
+		if len(sorted) == 0 {
+			return 0
+		}
+		return sorted[min(int(q*float64(len(sorted))), len(sorted)-1)]
+	}
+	st.ReactionP50, st.ReactionP90, st.ReactionP99 = pct(0.5), pct(0.9), pct(0.99)
+	return st
+}
+
+// String is a one-line summary for logs.
+func (st Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "listed=%d taken=%d rival=%d expired=%d lost=%d active_exists=%d repeats=%d bad_gateway=%d completed=%d canceled=%d",
+		st.Listed, st.Taken, st.Rival, st.Expired, st.Lost, st.ActiveExists, st.Repeats, st.BadGateway, st.Completed, st.Canceled)
+	if st.Taken > 0 {
+		fmt.Fprintf(&b, " reaction p50=%s p90=%s p99=%s", st.ReactionP50.Round(time.Microsecond), st.ReactionP90.Round(time.Microsecond), st.ReactionP99.Round(time.Microsecond))
+	}
+	return b.String()
+}
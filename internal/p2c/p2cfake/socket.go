@@ -0,0 +1,160 @@
+package p2cfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"p2c-engine/internal/p2c"
+)
+
+const (
+	pingInterval = 25 * time.Second
+	pingTimeout  = 20 * time.Second
+	connQueue    = 1024 // кадров в очереди медленного клиента, дальше — разрыв
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin:       func(*http.Request) bool { return true },
+	EnableCompression: true,
+}
+
+// conn is one subscribed socket; frames go out through a queue so a slow
+// client never blocks the list.
+type conn struct {
+	ws    *websocket.Conn
+	queue chan []byte
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// push queues a frame; a client that fell too far behind is dropped.
+func (c *conn) push(frame []byte) bool {
+	select {
+	case c.queue <- frame:
+		return true
+	default:
+		c.close()
+		return false
+	}
+}
+
+func (c *conn) writeLoop() {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+	for {
+		var frame []byte
+		select {
+		case <-c.done:
+			return
+		case frame = <-c.queue:
+		case <-t.C:
+			frame = []byte("2")
+		}
+		if err := c.ws.WriteMessage(websocket.TextMessage, frame); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// handleSocket serves the Engine.IO handshake (polling, without sid) and
+// the websocket upgrade that follows it. Long-polling itself is not
+// supported: the client under test must upgrade.
+func (s *Server) handleSocket(w http.ResponseWriter, r *http.Request) {
+	if token(r) == "" {
+		apiError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case q.Get("transport") == "polling" && q.Get("sid") == "":
+		open, _ := json.Marshal(map[string]any{
+			"sid":          fmt.Sprintf("%016x", rand.Uint64()),
+			"upgrades":     []string{"websocket"},
+			"pingInterval": pingInterval.Milliseconds(),
+			"pingTimeout":  pingTimeout.Milliseconds(),
+			"maxPayload":   1000000,
+		})
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		_, _ = w.Write(append([]byte("0"), open...))
+	case q.Get("transport") == "websocket":
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.serveConn(&conn{ws: ws, queue: make(chan []byte, connQueue), done: make(chan struct{})})
+	default:
+		http.Error(w, "long-polling is not supported by the fake", http.StatusBadRequest)
+	}
+}
+
+// serveConn runs the upgrade probe, the socket.io connect and the list
+// subscription of one client.
+func (s *Server) serveConn(c *conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.close()
+	}()
+	go c.writeLoop()
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch m := string(msg); {
+		case m == "2probe":
+			c.push([]byte("3probe"))
+		case m == "5", m == "3":
+		case m == "2":
+			c.push([]byte("3"))
+		case m == "40":
+			c.push([]byte(fmt.Sprintf(`40{"sid":"%016x"}`, rand.Uint64())))
+		case m == `42["list:initialize"]`:
+			// снимок и подписка под одной блокировкой: ни одно обновление
+			// не потеряется и не придёт раньше снимка
+			s.mu.Lock()
+			snapshot := make([]p2c.LivePayment, 0, len(s.list))
+			for _, id := range s.list {
+				snapshot = append(snapshot, s.payments[id].live)
+			}
+			frame, _ := json.Marshal([]any{"list:snapshot", snapshot})
+			if c.push(append([]byte("42"), frame...)) {
+				s.conns[c] = true
+			}
+			s.mu.Unlock()
+		default:
+			log.Printf("[p2cfake] unexpected frame %q", msg)
+		}
+	}
+}
+
+// broadcastLocked sends one list:update to every subscribed socket.
+func (s *Server) broadcastLocked(update map[string]any) {
+	if len(s.conns) == 0 {
+		return
+	}
+	body, _ := json.Marshal([]any{"list:update", []any{update}})
+	frame := append([]byte("42"), body...)
+	for c := range s.conns {
+		if !c.push(frame) {
+			log.Printf("[p2cfake] slow client dropped")
+			delete(s.conns, c)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+// Package p2ctest is an in-memory p2c.API for engine tests: payments are
+// listed on a fake market and pushed to the account's feed, takes, gets,
+// completes and cancels follow the rules of P2C (one unpaid order per
+// account, a repeated take with the same Idempotency-Key is answered as the
+// first one), and every call is recorded. No HTTP server is involved:
+//
+//	api := p2ctest.New()
+//	mgr.UseAPI(func(engine.WorkerConfig) (p2c.API, error) { return api, nil })
+//	api.List(p2c.LivePayment{ID: "ab12", InAmount: "1500", InAsset: "RUB"})
+//	// … assert on api.Calls(), api.Payment("ab12")
+//
+// Set Take to script the answer of a take, e.g. a 502 after P2C accepted it.
+package p2ctest
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2c-engine/internal/p2c"
+)
+
+// PayTTL is the time to pay a taken payment, as its expires_at.
+const PayTTL = 15 * time.Minute
+
+// Call is one request the engine made.
+type Call struct {
+	Op  string // take, get, list, complete, cancel, requisites…
+	ID  string // заявка или реквизит, пусто для списков
+	Key string // Idempotency-Key take
+	Arg string // способ complete, причина cancel
+}
+
+type payment struct {
+	live    p2c.LivePayment
+	num     int64
+	status  p2c.PaymentStatus // пусто — в списке рынка, никем не взята
+	idemKey string
+	takenAt time.Time
+}
+
+// API is a fake P2C of one account.
+type API struct {
+	// Take, if set, answers every take instead of the market rules. take
+	// runs them, so the script can let the take through and still fail it.
+	Take func(id, idemKey string, take func() (*p2c.TakeResult, error)) (*p2c.TakeResult, error)
+
+	mu         sync.Mutex
+	payments   map[string]*payment // по hex и по числовому id
+	requisites []p2c.Requisite
+	nextNum    int64
+	calls      []Call
+	feed       *Feed
+	clock      *p2c.Clock
+}
+
+// New returns an empty market.
+func New() *API {
+	a := &API{payments: make(map[string]*payment), nextNum: 1000}
+	a.feed = &Feed{api: a}
+	return a
+}
+
+var _ p2c.API = (*API)(nil)
+
+// List puts a payment on the market and sends it to a running feed.
+func (a *API) List(p p2c.LivePayment) {
+	a.mu.Lock()
+	a.nextNum++
+	e := &payment{live: p, num: a.nextNum}
+	a.payments[p.ID] = e
+	a.payments[strconv.FormatInt(e.num, 10)] = e
+	a.mu.Unlock()
+	a.feed.add(p)
+}
+
+// Unlist removes a payment nobody took from the market, as when another
+// trader takes it.
+func (a *API) Unlist(id string) {
+	a.mu.Lock()
+	e := a.payments[id]
+	if e == nil || e.status != "" {
+		a.mu.Unlock()
+		return
+	}
+	a.deleteLocked(e)
+	a.mu.Unlock()
+	a.feed.remove(id)
+}
+
+func (a *API) deleteLocked(e *payment) {
+	delete(a.payments, e.live.ID)
+	delete(a.payments, strconv.FormatInt(e.num, 10))
+}
+
+// Payment returns a payment by its hex or numeric id as GetPayment would
+// show it, taken or not.
+func (a *API) Payment(id string) (p2c.Payment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.payments[id]
+	if e == nil {
+		return p2c.Payment{}, false
+	}
+	return e.api(), true
+}
+
+// SetStatus changes a taken payment as P2C would on its own, e.g. a dispute
+// opened by the payer.
+func (a *API) SetStatus(id string, status p2c.PaymentStatus) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.payments[id]
+	if e == nil || e.status == "" {
+		return false
+	}
+	e.status = status
+	return true
+}
+
+// Calls returns the requests made so far, oldest first.
+func (a *API) Calls() []Call {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Call(nil), a.calls...)
+}
+
+// Count returns how many requests of the operation were made.
+func (a *API) Count(op string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, c := range a.calls {
+		if c.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// Feed returns the live feed of the account.
+func (a *API) Feed() *Feed {
+	return a.feed
+}
+
+func (a *API) record(c Call) {
+	a.mu.Lock()
+	a.calls = append(a.calls, c)
+	a.mu.Unlock()
+}
+
+func (e *payment) api() p2c.Payment {
+	p := p2c.Payment{
+		ID:         json.Number(strconv.FormatInt(e.num, 10)),
+		Asset:      e.live.OutAsset,
+		Amount:     e.live.OutAmount,
+		AmountFiat: e.live.InAmount,
+		Fiat:       e.live.InAsset,
+		URL:        e.live.URL,
+		BrandName:  e.live.BrandName,
+		Status:     e.status,
+	}
+	if !e.takenAt.IsZero() {
+		p.Processing = e.takenAt.UTC().Format(time.RFC3339)
+	}
+	return p
+}
+
+// apiError is the answer of P2C with an error code.
+func apiError(op string, status int, code string) error {
+	return &p2c.StatusError{Op: op, Status: status, Body: []byte(`{"error":"` + code + `"}`)}
+}
+
+func (a *API) Warmup(ctx context.Context) {}
+
+func (a *API) TakeLivePayment(ctx context.Context, id, idemKey string) (*p2c.TakeResult, error) {
+	a.record(Call{Op: "take", ID: id, Key: idemKey})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	take := func() (*p2c.TakeResult, error) { return a.take(id, idemKey) }
+	if a.Take != nil {
+		return a.Take(id, idemKey, take)
+	}
+	return take()
+}
+
+func (a *API) take(id, idemKey string) (*p2c.TakeResult, error) {
+	a.mu.Lock()
+	e := a.payments[id]
+	switch {
+	case e == nil:
+		a.mu.Unlock()
+		return fail("take payment", http.StatusNotFound, "PaymentNotFound")
+	case e.status != "" && idemKey != "" && e.idemKey == idemKey:
+		// повтор того же take: отвечаем как в первый раз
+	case e.status != "":
+		a.mu.Unlock()
+		return fail("take payment", http.StatusBadRequest, "PaymentNotAvailable")
+	case a.activeLocked():
+		a.mu.Unlock()
+		return fail("take payment", http.StatusBadRequest, "ActiveOrderExists")
+	default:
+		e.status, e.idemKey, e.takenAt = p2c.StatusProcessing, idemKey, time.Now()
+	}
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"id": e.num, "expires_at": e.takenAt.Add(PayTTL).UTC().Format(time.RFC3339), "url": e.live.URL,
+	}})
+	a.mu.Unlock()
+	a.feed.remove(e.live.ID)
+	return &p2c.TakeResult{Status: http.StatusOK, Body: body, Proto: "HTTP/2.0"}, nil
+}
+
+func fail(op string, status int, code string) (*p2c.TakeResult, error) {
+	err := apiError(op, status, code).(*p2c.StatusError)
+	return &p2c.TakeResult{Status: status, Body: err.Body, Proto: "HTTP/2.0"}, err
+}
+
+// activeLocked reports whether the account holds an unpaid payment.
+func (a *API) activeLocked() bool {
+	for _, e := range a.payments {
+		if e.status == p2c.StatusProcessing {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *API) TakePayment(ctx context.Context, id string) error {
+	_, err := a.TakeLivePayment(ctx, id, "")
+	return err
+}
+
+func (a *API) CompletePayment(ctx context.Context, id string, method string) error {
+	a.record(Call{Op: "complete", ID: id, Arg: method})
+	return a.finish("complete payment", id, p2c.StatusCompleted)
+}
+
+func (a *API) CancelPayment(ctx context.Context, id string, reason string) error {
+	a.record(Call{Op: "cancel", ID: id, Arg: reason})
+	return a.finish("cancel payment", id, p2c.StatusCanceled)
+}
+
+func (a *API) finish(op, id string, status p2c.PaymentStatus) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.payments[id]
+	switch {
+	case e == nil || e.status == "":
+		return apiError(op, http.StatusNotFound, "PaymentNotFound")
+	case e.status != p2c.StatusProcessing:
+		return apiError(op, http.StatusBadRequest, "InvalidPaymentStatus")
+	}
+	e.status = status
+	return nil
+}
+
+func (a *API) ListPayments(ctx context.Context, params p2c.ListPaymentsParams) (*p2c.ListPaymentsResponse, error) {
+	a.record(Call{Op: "list"})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := &p2c.ListPaymentsResponse{Data: []p2c.Payment{}}
+	for id, e := range a.payments {
+		if e.status == "" || id != e.live.ID || (params.Status != "" && e.status != params.Status) {
+			continue
+		}
+		out.Data = append(out.Data, e.api())
+	}
+	// новые сверху, как у P2C
+	slices.SortFunc(out.Data, func(x, y p2c.Payment) int { return cmp.Compare(y.NumericID(), x.NumericID()) })
+	if params.Size > 0 && len(out.Data) > params.Size {
+		out.Data = out.Data[:params.Size]
+	}
+	return out, nil
+}
+
+func (a *API) GetPayment(ctx context.Context, id string) (*p2c.Payment, error) {
+	a.record(Call{Op: "get", ID: id})
+	if id == "" {
+		return nil, fmt.Errorf("empty payment id")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.payments[id]
+	if e == nil || e.status == "" {
+		return nil, p2c.ErrPaymentNotFound
+	}
+	p := e.api()
+	return &p, nil
+}
+
+func (a *API) ListRequisites(ctx context.Context) ([]p2c.Requisite, error) {
+	a.record(Call{Op: "requisites"})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]p2c.Requisite{}, a.requisites...), nil
+}
+
+func (a *API) AddRequisite(ctx context.Context, r p2c.NewRequisite) (*p2c.Requisite, error) {
+	a.record(Call{Op: "add requisite", Arg: r.Value})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextNum++
+	req := p2c.Requisite{ID: json.Number(strconv.FormatInt(a.nextNum, 10)), Type: r.Type, Value: r.Value, Bank: r.Bank, Holder: r.Holder, Enabled: true}
+	a.requisites = append(a.requisites, req)
+	return &req, nil
+}
+
+func (a *API) SetRequisiteEnabled(ctx context.Context, id string, enabled bool) error {
+	a.record(Call{Op: "update requisite", ID: id, Arg: strconv.FormatBool(enabled)})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.requisites {
+		if a.requisites[i].ID.String() == id {
+			a.requisites[i].Enabled = enabled
+			return nil
+		}
+	}
+	return apiError("update requisite", http.StatusNotFound, "RequisiteNotFound")
+}
+
+func (a *API) OpenFeed(standby bool) p2c.Feed {
+	return a.feed
+}
+
+// UseClock makes the market run on a skewed clock, see p2c.FixedClock.
+func (a *API) UseClock(c *p2c.Clock) {
+	a.mu.Lock()
+	a.clock = c
+	a.mu.Unlock()
+}
+
+func (a *API) Clock() *p2c.Clock {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.clock
+}
+
+func (a *API) WarmPool(conns int, interval time.Duration) p2c.Pool {
+	return pool{conns: conns}
+}
+
+// ErrDropped is returned by Feed.Run after Drop.
+var ErrDropped = errors.New("p2ctest: feed dropped")
+
+// Feed is the live list of the fake market. Run delivers the listed
+// payments as the snapshot, then every List and Unlist, until Drop or ctx.
+type Feed struct {
+	api *API
+
+	mu    sync.Mutex
+	h     *p2c.SocketHandlers // обработчики запущенного Run, nil — не подключён
+	drop  chan struct{}
+	runs  int
+	drops int
+}
+
+// Run serves the feed until ctx is done or Drop.
+func (f *Feed) Run(ctx context.Context, h p2c.SocketHandlers) error {
+	drop := make(chan struct{})
+	f.mu.Lock()
+	f.h, f.drop = &h, drop
+	f.runs++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		if f.drop == drop {
+			f.h, f.drop = nil, nil
+		}
+		f.mu.Unlock()
+	}()
+	if h.OnConnect != nil {
+		h.OnConnect()
+	}
+	if h.OnSnapshot != nil {
+		h.OnSnapshot(f.api.listed())
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-drop:
+		return ErrDropped
+	}
+}
+
+// Drop ends the running session, as a dropped connection.
+func (f *Feed) Drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.drop != nil {
+		close(f.drop)
+		f.h, f.drop = nil, nil
+		f.drops++
+	}
+}
+
+// Connected reports whether Run is serving the feed.
+func (f *Feed) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.h != nil
+}
+
+// Runs returns how many sessions were started and how many were dropped.
+func (f *Feed) Runs() (runs, drops int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.runs, f.drops
+}
+
+func (f *Feed) handlers() *p2c.SocketHandlers {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.h
+}
+
+func (f *Feed) add(p p2c.LivePayment) {
+	if h := f.handlers(); h != nil && h.OnAdd != nil {
+		h.OnAdd(p)
+	}
+}
+
+func (f *Feed) remove(id string) {
+	if h := f.handlers(); h != nil && h.OnRemove != nil {
+		h.OnRemove(id)
+	}
+}
+
+// listed returns the payments on the market, oldest first.
+func (a *API) listed() []p2c.LivePayment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []p2c.LivePayment
+	for id, e := range a.payments {
+		if e.status == "" && id == e.live.ID {
+			out = append(out, e.live)
+		}
+	}
+	slices.SortFunc(out, func(x, y p2c.LivePayment) int { return cmp.Compare(a.payments[x.ID].num, a.payments[y.ID].num) })
+	return out
+}
+
+// pool is a keep-warm pool with nothing to keep warm.
+type pool struct {
+	conns int
+}
+
+func (p pool) Run(ctx context.Context) { <-ctx.Done() }
+
+func (p pool) Stats() p2c.WarmStats {
+	return p2c.WarmStats{Conns: p.conns, LastOK: p.conns, LiveConns: p.conns}
+}
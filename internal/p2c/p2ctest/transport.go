@@ -0,0 +1,44 @@
+package p2ctest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Transport is a p2c.Transport that answers requests with an http.Handler
+// in process and remembers them, so the client runs without a network:
+//
+//	client.UseTransport(p2ctest.NewTransport(mux))
+type Transport struct {
+	Handler http.Handler
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// NewTransport returns a transport served by h.
+func NewTransport(h http.Handler) *Transport {
+	return &Transport{Handler: h}
+}
+
+func (t *Transport) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.mu.Unlock()
+	rec := httptest.NewRecorder()
+	t.Handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Requests returns the requests sent so far, oldest first.
+func (t *Transport) Requests() []*http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*http.Request(nil), t.requests...)
+}
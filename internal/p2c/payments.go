@@ -3,9 +3,10 @@ package p2c
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-
-	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -53,6 +54,22 @@ func (p Payment) NumericID() int64 {
 	return v
 }
 
+// Live converts a polled payment to the feed's form so it goes through the
+// same take pipeline; the list carries no provider, payload or expiry.
+func (p Payment) Live() LivePayment {
+	return LivePayment{
+		ID:           p.IDString(),
+		URL:          p.URL,
+		BrandName:    p.BrandName,
+		InAsset:      p.Fiat,
+		OutAsset:     p.Asset,
+		InAmount:     p.AmountFiat,
+		OutAmount:    p.Amount,
+		ExchangeRate: p.ExchangeRate,
+		FeeAmount:    p.RewardAmount,
+	}
+}
+
 type ListPaymentsParams struct {
 	Size   int
 	Status PaymentStatus
@@ -67,15 +84,17 @@ type ListPaymentsResponse struct {
 // TakeResponse mirrors data from /take to extract numeric id.
 type TakeResponse struct {
 	Data *struct {
-		ID json.Number `json:"id"`
+		ID         json.Number `json:"id"`
+		ExpiresAt  string      `json:"expires_at,omitempty"`
+		URL        string      `json:"url,omitempty"`
+		Requisites []Requisite `json:"requisites,omitempty"` // реквизиты, назначенные на заявку
 	} `json:"data,omitempty"`
 }
 
 func (c *Client) ListPayments(ctx context.Context, params ListPaymentsParams) (*ListPaymentsResponse, error) {
-	req, resp := c.newRequest("GET", "/p2c/payments", nil)
-	query := req.URI().QueryArgs()
+	query := url.Values{}
 	if params.Size > 0 {
-		query.SetUint("size", params.Size)
+		query.Set("size", strconv.Itoa(params.Size))
 	}
 	if params.Status != "" {
 		query.Set("status", string(params.Status))
@@ -84,37 +103,64 @@ func (c *Client) ListPayments(ctx context.Context, params ListPaymentsParams) (*
 		query.Set("cursor", params.Cursor)
 	}
 
-	if err := c.do(ctx, req, resp); err != nil {
+	resp, err := c.call(ctx, apiRequest{op: "list payments", method: http.MethodGet, path: "/p2c/payments", query: query})
+	if err != nil {
 		return nil, err
 	}
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-
-	if !c.statusOK(resp) {
-		return nil, fmt.Errorf("list payments status %d", resp.StatusCode())
+	if resp.Status == http.StatusUnauthorized || resp.Status == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: %w", resp.err("list payments"), ErrUnauthorized)
+	}
+	if err := resp.err("list payments"); err != nil {
+		return nil, err
 	}
 
 	var out ListPaymentsResponse
-	if err := json.Unmarshal(resp.Body(), &out); err != nil {
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
 		return nil, err
 	}
 	return &out, nil
 }
 
+// ErrUnauthorized is returned when P2C rejects the access token.
+var ErrUnauthorized = errors.New("access token rejected")
+
+// ErrPaymentNotFound is returned by GetPayment for an unknown payment or one
+// that belongs to someone else.
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// GetPayment fetches one payment of the account.
+// Endpoint: GET /p2c/payments/{id}
+func (c *Client) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty payment id")
+	}
+	resp, err := c.call(ctx, apiRequest{op: "get payment", method: http.MethodGet, path: "/p2c/payments/" + id})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status {
+	case http.StatusNotFound, http.StatusForbidden:
+		return nil, ErrPaymentNotFound
+	}
+	if err := resp.err("get payment"); err != nil {
+		return nil, err
+	}
+	var out struct {
+		Data Payment `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
 func (c *Client) TakePayment(ctx context.Context, id string) error {
 	if id == "" {
 		return fmt.Errorf("empty payment id")
 	}
-	path := fmt.Sprintf("/p2c/payments/take/%s", id)
-	req, resp := c.newRequest("POST", path, nil)
-	if err := c.do(ctx, req, resp); err != nil {
+	resp, err := c.call(ctx, apiRequest{op: "take payment", method: http.MethodPost, path: "/p2c/payments/take/" + id})
+	if err != nil {
 		return err
 	}
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-
-	if !c.statusOK(resp) {
-		return fmt.Errorf("take payment status %d", resp.StatusCode())
-	}
-	return nil
+	return resp.err("take payment")
 }
@@ -0,0 +1,168 @@
+package p2c
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// recordSeparator splits Engine.IO v4 packets in a polling payload.
+const recordSeparator = '\x1e'
+
+// eioPacket is one Engine.IO packet; binary packets come base64 encoded
+// with a "b" prefix over polling and are decoded here.
+type eioPacket struct {
+	data   []byte
+	binary bool
+}
+
+// pollTransport is the Engine.IO long-polling transport, used when the
+// network or a proxy blocks the websocket upgrade.
+type pollTransport struct {
+	url         string
+	accessToken string
+	client      *http.Client
+}
+
+func newPollTransport(pollURL, accessToken string, hb heartbeat) *pollTransport {
+	return &pollTransport{
+		url:         pollURL,
+		accessToken: accessToken,
+		// сервер держит GET не дольше pingInterval, дальше — мёртвое соединение
+		client: &http.Client{Timeout: hb.interval + hb.timeout},
+	}
+}
+
+func (t *pollTransport) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	u, err := url.Parse(t.url)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("t", fmt.Sprintf("%d", time.Now().UnixNano())) // против кеширования прокси
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if t.accessToken != "" {
+		req.Header.Set("Cookie", fmt.Sprintf("access_token=%s", t.accessToken))
+	}
+	req.Header.Set("Origin", fmt.Sprintf("%s://%s", "https", u.Host))
+	req.Header.Set("Pragma", "no-cache")
+	req.Header.Set("Cache-Control", "no-cache")
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	}
+	return req, nil
+}
+
+// get long-polls for the next payload.
+func (t *pollTransport) get(ctx context.Context) ([]eioPacket, error) {
+	req, err := t.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("polling GET: http %d body=%s", resp.StatusCode, body)
+	}
+	return decodePayload(body)
+}
+
+// post sends text packets in one payload.
+func (t *pollTransport) post(ctx context.Context, packets ...[]byte) error {
+	req, err := t.request(ctx, http.MethodPost, bytes.Join(packets, []byte{recordSeparator}))
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("polling POST: http %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func decodePayload(body []byte) ([]eioPacket, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	parts := bytes.Split(body, []byte{recordSeparator})
+	out := make([]eioPacket, 0, len(parts))
+	for _, p := range parts {
+		if len(p) > 0 && p[0] == 'b' {
+			data, err := base64.StdEncoding.DecodeString(string(p[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("decode binary packet: %w", err)
+			}
+			out = append(out, eioPacket{data: data, binary: true})
+			continue
+		}
+		out = append(out, eioPacket{data: p})
+	}
+	return out, nil
+}
+
+// pollingURL turns the websocket URL from the handshake back into the
+// polling URL of the same session.
+func pollingURL(wsURL string) string {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return wsURL
+	}
+	u.Scheme = "https"
+	q := u.Query()
+	q.Set("transport", "polling")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// subscribePolling runs the session over long-polling until ctx is done or
+// the server drops the session.
+func subscribePolling(ctx context.Context, pollURL, accessToken string, hb heartbeat, h SocketHandlers) error {
+	t := newPollTransport(pollURL, accessToken, hb)
+	if err := t.post(ctx, frameConnect); err != nil {
+		return fmt.Errorf("polling connect: %w", err)
+	}
+	log.Printf("ws polling connected: %s", pollURL)
+	sess := newSession(h, func(msg []byte) error { return t.post(ctx, msg) })
+	for {
+		packets, err := t.get(ctx)
+		if ctx.Err() != nil {
+			_ = t.post(context.Background(), frameClose)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, p := range packets {
+			if p.binary {
+				err = sess.handleBinary(p.data)
+			} else {
+				err = sess.handleFrame(p.data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package p2c
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProbeResult is a single RTT measurement against an edge target.
+type ProbeResult struct {
+	Target string  `json:"target"`
+	Addr   string  `json:"addr,omitempty"`
+	RTTMs  float64 `json:"rtt_ms"`
+	Error  string  `json:"error,omitempty"`
+
+	rtt time.Duration
+}
+
+// ProbeSnapshot is the prober state exposed in the status API.
+type ProbeSnapshot struct {
+	Pinned    string        `json:"pinned"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Results   []ProbeResult `json:"results"`
+}
+
+// Prober periodically measures TCP connect RTT to a list of P2C edge
+// hostnames/IPs (Cloudflare POPs) and pins client dials to the fastest one.
+type Prober struct {
+	targets  []string
+	interval time.Duration
+	samples  int
+
+	mu        sync.RWMutex
+	pinned    string
+	checkedAt time.Time
+	results   []ProbeResult
+}
+
+func NewProber(targets []string, interval time.Duration) *Prober {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Prober{
+		targets:  targets,
+		interval: interval,
+		samples:  3,
+	}
+}
+
+// Run probes immediately and then every interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	if p == nil || len(p.targets) == 0 {
+		return
+	}
+	p.probeAll(ctx)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probeAll(ctx)
+		}
+	}
+}
+
+// Pinned returns the ip:port dials to the P2C host should go to, or "" if none.
+func (p *Prober) Pinned() string {
+	if p == nil {
+		return ""
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pinned
+}
+
+// Snapshot returns the current choice and last measurements.
+func (p *Prober) Snapshot() ProbeSnapshot {
+	if p == nil {
+		return ProbeSnapshot{}
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make([]ProbeResult, len(p.results))
+	copy(res, p.results)
+	return ProbeSnapshot{Pinned: p.pinned, CheckedAt: p.checkedAt, Results: res}
+}
+
+func (p *Prober) probeAll(ctx context.Context) {
+	results := make([]ProbeResult, len(p.targets))
+	var wg sync.WaitGroup
+	for i, target := range p.targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			results[i] = p.probe(ctx, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	ok := make([]ProbeResult, 0, len(results))
+	for _, r := range results {
+		if r.Error == "" {
+			ok = append(ok, r)
+		}
+	}
+	sort.Slice(ok, func(i, j int) bool { return ok[i].rtt < ok[j].rtt })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = results
+	p.checkedAt = time.Now()
+	if len(ok) == 0 {
+		// все цели недоступны — отпускаем пин, пусть работает обычный DNS
+		if p.pinned != "" {
+			log.Printf("[prober] all edges failed, unpin %s", p.pinned)
+		}
+		p.pinned = ""
+		return
+	}
+	best := ok[0]
+	if p.pinned == best.Addr {
+		return
+	}
+	// гистерезис: переключаемся, только если текущий пин пропал или новый быстрее на 10%+
+	for _, r := range ok {
+		if r.Addr == p.pinned && float64(best.rtt) > 0.9*float64(r.rtt) {
+			return
+		}
+	}
+	log.Printf("[prober] pin %s (%s) rtt=%.1fms, was %q", best.Addr, best.Target, best.RTTMs, p.pinned)
+	p.pinned = best.Addr
+}
+
+func (p *Prober) probe(ctx context.Context, target string) ProbeResult {
+	res := ProbeResult{Target: target}
+	addr := target
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		addr = net.JoinHostPort(target, "443")
+	}
+	dialer := net.Dialer{Timeout: 2 * time.Second}
+	var best time.Duration
+	for i := 0; i < p.samples; i++ {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		rtt := time.Since(start)
+		res.Addr = conn.RemoteAddr().String()
+		conn.Close()
+		if best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	res.rtt = best
+	res.RTTMs = float64(best.Microseconds()) / 1000
+	return res
+}
@@ -0,0 +1,118 @@
+package p2c
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RecordedFrame is one raw socket frame as written by a Recorder. Text
+// frames are kept as they are, binary attachments in Raw.
+type RecordedFrame struct {
+	At   time.Time `json:"at"`
+	Data string    `json:"data,omitempty"`
+	Raw  []byte    `json:"raw,omitempty"`
+}
+
+// Recorder writes the raw frames of a socket session, one JSON line per
+// frame, so the stream can be fed again with Replay. Write is meant to be
+// set as SocketHandlers.OnFrame.
+type Recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	c   io.Closer
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a recorder writing to wc; Close closes wc.
+func NewRecorder(wc io.WriteCloser) *Recorder {
+	bw := bufio.NewWriter(wc)
+	return &Recorder{w: bw, c: wc, enc: json.NewEncoder(bw)}
+}
+
+// Write records one frame. After the first write error the recorder stops.
+func (r *Recorder) Write(at time.Time, frame []byte) {
+	f := RecordedFrame{At: at}
+	if utf8.Valid(frame) {
+		f.Data = string(frame)
+	} else {
+		f.Raw = frame
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(f); r.err == nil {
+		// кадров немного, а запись не должна теряться при падении процесса
+		r.err = r.w.Flush()
+	}
+	if r.err != nil {
+		log.Printf("ws record: %v, recording stopped", r.err)
+	}
+}
+
+// Close flushes and closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if cerr := r.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Replay feeds a recording into h as if the frames came from the socket:
+// the list is mirrored and OnAdd, OnRemove and OnSnapshot fire with the
+// recorded receive times. speed scales the original pauses between frames
+// (1 — real time, 10 — ten times faster); 0 feeds everything at once.
+// Nothing is sent anywhere.
+func Replay(ctx context.Context, r io.Reader, h SocketHandlers, speed float64) error {
+	var at time.Time
+	s := newSession(h, func([]byte) error { return nil })
+	s.now = func() time.Time { return at }
+	dec := json.NewDecoder(r)
+	var prev time.Time
+	for n := 1; ; n++ {
+		var f RecordedFrame
+		if err := dec.Decode(&f); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("frame %d: %w", n, err)
+		}
+		if speed > 0 && !prev.IsZero() && f.At.After(prev) {
+			t := time.NewTimer(time.Duration(float64(f.At.Sub(prev)) / speed))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		prev, at = f.At, f.At
+		frame := []byte(f.Data)
+		if f.Raw != nil {
+			frame = f.Raw
+		}
+		// вложения приходят сразу за заголовком BINARY_EVENT
+		var err error
+		if s.pending != nil {
+			err = s.handleBinary(frame)
+		} else {
+			err = s.handleFrame(frame)
+		}
+		if err != nil {
+			log.Printf("replay frame %d: %v", n, err)
+		}
+	}
+}
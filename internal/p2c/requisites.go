@@ -0,0 +1,83 @@
+package p2c
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Requisite is a payout destination of the merchant: a card, a phone number
+// for SBP or a bank account. Endpoints mirror the ones used by the P2C web UI.
+type Requisite struct {
+	ID      json.Number `json:"id"`
+	Type    string      `json:"type"` // card, phone, account
+	Value   string      `json:"value"`
+	Bank    string      `json:"bank,omitempty"`
+	Holder  string      `json:"holder,omitempty"`
+	Enabled bool        `json:"enabled"`
+}
+
+// NewRequisite is the body of AddRequisite.
+type NewRequisite struct {
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Bank   string `json:"bank,omitempty"`
+	Holder string `json:"holder,omitempty"`
+}
+
+// ListRequisites returns all requisites of the account.
+// Endpoint: GET /p2c/requisites
+func (c *Client) ListRequisites(ctx context.Context) ([]Requisite, error) {
+	resp, err := c.call(ctx, apiRequest{op: "list requisites", method: http.MethodGet, path: "/p2c/requisites"})
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.err("list requisites"); err != nil {
+		return nil, err
+	}
+	var out struct {
+		Data []Requisite `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+// AddRequisite creates a requisite and returns it as stored by P2C.
+// Endpoint: POST /p2c/requisites
+func (c *Client) AddRequisite(ctx context.Context, r NewRequisite) (*Requisite, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.call(ctx, apiRequest{op: "add requisite", method: http.MethodPost, path: "/p2c/requisites", body: body})
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.err("add requisite"); err != nil {
+		return nil, err
+	}
+	var out struct {
+		Data *Requisite `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
+		return nil, err
+	}
+	if out.Data == nil {
+		return nil, fmt.Errorf("add requisite: empty response")
+	}
+	return out.Data, nil
+}
+
+// SetRequisiteEnabled turns a requisite on or off.
+// Endpoint: PATCH /p2c/requisites/{id}
+func (c *Client) SetRequisiteEnabled(ctx context.Context, id string, enabled bool) error {
+	body := []byte(fmt.Sprintf(`{"enabled":%t}`, enabled))
+	resp, err := c.call(ctx, apiRequest{op: "update requisite", method: http.MethodPatch, path: "/p2c/requisites/" + id, body: body})
+	if err != nil {
+		return err
+	}
+	return resp.err("update requisite")
+}
@@ -0,0 +1,180 @@
+package p2c
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDNSTTL = 5 * time.Minute
+
+// ResolverSnapshot is the DNS cache state exposed in the status API.
+type ResolverSnapshot struct {
+	Hits    int64               `json:"hits"`
+	Misses  int64               `json:"misses"`
+	Static  map[string]string   `json:"static,omitempty"`
+	Entries map[string][]string `json:"entries"`
+}
+
+// Resolver caches DNS answers of the P2C hosts and pins hosts to static
+// IPs, so dials on the take path never wait for a lookup. A stale entry is
+// still served while it is refreshed in the background; Run keeps the
+// hosts warm so entries do not go stale at all.
+type Resolver struct {
+	ttl    time.Duration
+	static map[string]string
+	lookup func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type dnsEntry struct {
+	addrs      []string
+	expires    time.Time
+	refreshing bool
+}
+
+// NewResolver returns a cache keeping answers for ttl (0 = 5m); static maps
+// host to the IP every dial to it goes to.
+func NewResolver(ttl time.Duration, static map[string]string) *Resolver {
+	if ttl <= 0 {
+		ttl = defaultDNSTTL
+	}
+	return &Resolver{
+		ttl:     ttl,
+		static:  static,
+		lookup:  net.DefaultResolver.LookupHost,
+		entries: make(map[string]*dnsEntry),
+	}
+}
+
+// Resolve returns the addresses of host: the static pin, the cached answer
+// (even a stale one) or, on a miss only, a fresh lookup.
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]string, error) {
+	if ip, ok := r.static[host]; ok {
+		r.hits.Add(1)
+		return []string{ip}, nil
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	e, ok := r.entries[host]
+	if ok {
+		if now.After(e.expires) && !e.refreshing {
+			e.refreshing = true
+			go r.refresh(host)
+		}
+		addrs := e.addrs
+		r.mu.Unlock()
+		r.hits.Add(1)
+		return addrs, nil
+	}
+	r.mu.Unlock()
+	r.misses.Add(1)
+	return r.fetch(ctx, host)
+}
+
+func (r *Resolver) fetch(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// IPv4 первыми: без IPv6-связности каждый v6-адрес стоил бы таймаута dial
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return net.ParseIP(addrs[i]).To4() != nil && net.ParseIP(addrs[j]).To4() == nil
+	})
+	r.mu.Lock()
+	r.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// refresh updates a stale entry; on failure the old answer is kept.
+func (r *Resolver) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.fetch(ctx, host); err != nil {
+		log.Printf("dns refresh %s: %v (keeping cached answer)", host, err)
+		r.mu.Lock()
+		if e, ok := r.entries[host]; ok {
+			e.refreshing = false
+			e.expires = time.Now().Add(r.ttl / 4)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Run resolves hosts at once and then refreshes them every ttl/2 until
+// ctx is done.
+func (r *Resolver) Run(ctx context.Context, hosts []string) {
+	warm := func() {
+		for _, h := range hosts {
+			if _, ok := r.static[h]; ok || net.ParseIP(h) != nil {
+				continue
+			}
+			lctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if _, err := r.fetch(lctx, h); err != nil {
+				log.Printf("dns prefetch %s: %v", h, err)
+			}
+			cancel()
+		}
+	}
+	warm()
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			warm()
+		}
+	}
+}
+
+// Snapshot returns counters and cached answers.
+func (r *Resolver) Snapshot() ResolverSnapshot {
+	snap := ResolverSnapshot{Hits: r.hits.Load(), Misses: r.misses.Load(), Static: r.static, Entries: make(map[string][]string)}
+	r.mu.Lock()
+	for host, e := range r.entries {
+		addrs := append([]string(nil), e.addrs...)
+		sort.Strings(addrs)
+		snap.Entries[host] = addrs
+	}
+	r.mu.Unlock()
+	return snap
+}
+
+// dial connects to the first reachable resolved address of addr.
+func (r *Resolver) dial(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	ips, err := r.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,211 @@
+package p2c
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+)
+
+// Transport sends one HTTP request to P2C. Every endpoint of the client goes
+// through it, so they share timeouts, redirects, tracing and retries; tests
+// swap it for a p2ctest.Transport.
+type Transport interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+const (
+	maxRedirects = 3
+	maxRetries   = 2                      // повторы чтения после сетевой ошибки или 502/503/504
+	retryBackoff = 100 * time.Millisecond // 100ms, 200ms
+)
+
+// newHTTPTransport builds the pooled net/http client used for every call:
+// HTTP/2 unless disabled, dials through dial, at most maxRedirects redirects.
+func newHTTPTransport(tc TransportConfig, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*http.Client, *http.Transport) {
+	conns := intOr(tc.MaxConnsPerHost, 256)
+	rt := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     !tc.DisableHTTP2,
+		MaxIdleConns:          intOr(tc.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   conns,
+		MaxConnsPerHost:       conns,
+		IdleConnTimeout:       tc.idle(),
+		TLSHandshakeTimeout:   tc.tls(),
+		ResponseHeaderTimeout: tc.response(),
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableCompression:    true,
+	}
+	if tc.DisableHTTP2 {
+		// непустая карта выключает HTTP/2 в net/http
+		rt.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	client := &http.Client{
+		Transport: rt,
+		Timeout:   tc.request(),
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+	return client, rt
+}
+
+// StatusError is a P2C answer outside 2xx. Its text keeps the body, where
+// P2C puts the error code (ActiveOrderExists, MerchantPenalized).
+type StatusError struct {
+	Op     string
+	Status int
+	CFRay  string
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s status %d cf-ray=%s body=%s", e.Op, e.Status, e.CFRay, e.Body)
+}
+
+// apiRequest is one call to the P2C API.
+type apiRequest struct {
+	op      string // имя вызова в ошибках: "take payment", "list payments"
+	method  string
+	path    string
+	query   url.Values
+	body    []byte
+	idemKey string
+	take    bool // путь take: сначала HTTP/3, если включён
+}
+
+// apiResponse is a P2C answer read to the end.
+type apiResponse struct {
+	Status int
+	Body   []byte
+	CFRay  string
+	Proto  string
+	Timing TraceTimings
+}
+
+func (r *apiResponse) ok() bool {
+	return r.Status >= http.StatusOK && r.Status < http.StatusMultipleChoices
+}
+
+// err returns the StatusError of a non-2xx answer.
+func (r *apiResponse) err(op string) error {
+	if r.ok() {
+		return nil
+	}
+	return &StatusError{Op: op, Status: r.Status, CFRay: r.CFRay, Body: r.Body}
+}
+
+// call sends the request and reads the answer. Reads are retried after a
+// network error or a gateway error, writes never: P2C may have applied
+// them. Every attempt is timed, reported to the mirrors and stamped with
+// the operation id from ctx.
+func (c *Client) call(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	retry := r.method == http.MethodGet || r.method == http.MethodHead
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, r)
+		gateway := resp != nil && (resp.Status == http.StatusBadGateway || resp.Status == http.StatusServiceUnavailable || resp.Status == http.StatusGatewayTimeout) &&
+			!isChallenge(resp.Status, "", resp.Body)
+		if !retry || attempt == maxRetries || (err == nil && !gateway) || ctx.Err() != nil {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(retryBackoff << attempt):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	var t TraceTimings
+	ctx = httptrace.WithClientTrace(ctx, traceInto(&t))
+	newReq := func() (*http.Request, error) {
+		u := c.base() + r.path
+		if len(r.query) > 0 {
+			u += "?" + r.query.Encode()
+		}
+		var body io.Reader
+		if r.body != nil {
+			body = bytes.NewReader(r.body)
+		}
+		req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.accessToken != "" {
+			req.Header.Set("Cookie", "access_token="+c.accessToken)
+		}
+		if r.idemKey != "" {
+			req.Header.Set("Idempotency-Key", r.idemKey)
+		}
+		if call := CallFrom(ctx); call != nil {
+			req.Header.Set(RequestIDHeader, call.ID)
+		}
+		return req, nil
+	}
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	rt, h3 := c.rt, false
+	if r.take {
+		rt, h3 = c.takeTransport()
+	}
+	sent := time.Now()
+	resp, err := rt.Do(req)
+	if err != nil && h3 && ctx.Err() == nil {
+		// повтор с тем же Idempotency-Key не возьмёт заявку второй раз
+		c.h3Failed(err)
+		if req, err = newReq(); err != nil {
+			return nil, err
+		}
+		sent = time.Now()
+		resp, err = c.rt.Do(req)
+	}
+	if err != nil {
+		c.observe(req.URL.Host, err, 0, "", nil)
+		return nil, err
+	}
+	c.clock.observe(resp.Header, sent, time.Now())
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.observe(req.URL.Host, err, 0, "", nil)
+		return nil, fmt.Errorf("%s: read body: %w", r.op, err)
+	}
+	c.observe(req.URL.Host, nil, resp.StatusCode, resp.Header.Get("Cf-Mitigated"), body)
+	out := &apiResponse{Status: resp.StatusCode, Body: body, CFRay: resp.Header.Get("Cf-Ray"), Proto: resp.Proto, Timing: t}
+	CallFrom(ctx).record(out.CFRay, t)
+	return out, nil
+}
+
+// traceInto fills t from the connection events of one request.
+func traceInto(t *TraceTimings) *httptrace.ClientTrace {
+	var dnsStart, connStart, tlsStart, writeDone time.Time
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.DNSLookup = time.Since(dnsStart) },
+		ConnectStart:      func(_, _ string) { connStart = time.Now() },
+		ConnectDone:       func(_, _ string, _ error) { t.TCPConnection = time.Since(connStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.TLSHandshake = time.Since(tlsStart) },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { writeDone = time.Now() },
+		GotFirstResponseByte: func() {
+			if !writeDone.IsZero() {
+				t.ServerTime = time.Since(writeDone)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) { t.ReusedConn = info.Reused },
+	}
+}
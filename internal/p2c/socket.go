@@ -1,17 +1,24 @@
 package p2c
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"p2c-engine/internal/logx"
 )
 
 // LivePayment carries data from list:update op=add.
@@ -29,6 +36,17 @@ type LivePayment struct {
 	ExchangeRate string `json:"exchange_rate"`
 	FeeAmount   string  `json:"fee_amount"`
 	ExpiresAt   string  `json:"expires_at"`
+
+	// Position is the list index from list:update; BatchSize and
+	// BatchMaxBoost describe the adds delivered in the same update batch.
+	Position      int     `json:"-"`
+	BatchSize     int     `json:"-"`
+	BatchMaxBoost float64 `json:"-"`
+
+	// ReceivedAt is when the frame was read from the socket, ParsedAt when
+	// the update was decoded; zero for payments that did not come from it.
+	ReceivedAt time.Time `json:"-"`
+	ParsedAt   time.Time `json:"-"`
 }
 
 type listUpdate struct {
@@ -37,142 +55,349 @@ type listUpdate struct {
 	Pos  *int         `json:"pos,omitempty"`
 }
 
+// SocketHandlers receives socket events; every field is optional.
+type SocketHandlers struct {
+	OnAdd    func(LivePayment)
+	OnRemove func(id string)
+	// OnSnapshot gets the full list sent on every (re)connect, in list order.
+	OnSnapshot func([]LivePayment)
+	// OnConnect is called when the server acknowledges the socket.io connect.
+	OnConnect func()
+	// OnFrame sees every raw frame with its receive time; the slice is not reused.
+	OnFrame func(at time.Time, frame []byte)
+	// OnFrameError gets a frame that could not be parsed, or nil with the
+	// error when there is no single frame to blame; without it the error is
+	// logged.
+	OnFrameError func(frame []byte, err error)
+}
+
+// IsServiceRestart reports whether the feed ended with close code 1012: the
+// server is restarting and expects its clients back right away.
+func IsServiceRestart(err error) bool {
+	var ce *websocket.CloseError
+	return errors.As(err, &ce) && ce.Code == websocket.CloseServiceRestart
+}
+
 // SubscribeSocket connects to p2c-socket and feeds incoming updates via handlers.
-func SubscribeSocket(ctx context.Context, baseURL, accessToken string, onAdd func(LivePayment), onRemove func(string)) error {
-	wsURL, pingInterval, err := eioHandshake(baseURL, accessToken)
+// When the websocket upgrade fails (blocked by the network or a proxy) the
+// session continues over Engine.IO long-polling.
+func SubscribeSocket(ctx context.Context, baseURL, accessToken string, h SocketHandlers) error {
+	return subscribeSocket(ctx, baseURL, accessToken, defaultHandshakeTimeout, h)
+}
+
+func subscribeSocket(ctx context.Context, baseURL, accessToken string, handshake time.Duration, h SocketHandlers) error {
+	sc, pollURL, hb, err := dialSocket(ctx, baseURL, accessToken, handshake)
 	if err != nil {
-		return fmt.Errorf("handshake: %w", err)
+		if pollURL == "" || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("ws upgrade failed (%v), falling back to polling", err)
+		return subscribePolling(ctx, pollURL, accessToken, hb, h)
 	}
+	sc.handlers.set(&h)
+	return sc.serve(ctx)
+}
+
+// socketConn is an upgraded websocket with its keepalive. Its handlers can
+// be swapped while it runs, so a standby connection can be promoted.
+type socketConn struct {
+	conn     *websocket.Conn
+	hb       heartbeat
+	handlers switchHandlers
+	wmu      sync.Mutex // gorilla допускает только одного писателя
+}
 
-	conn, err := eioWebsocket(ctx, wsURL, accessToken)
+// dialSocket runs the Engine.IO handshake and the websocket upgrade. When
+// only the upgrade failed, pollURL and hb are set for a polling fallback.
+func dialSocket(ctx context.Context, baseURL, accessToken string, handshake time.Duration) (sc *socketConn, pollURL string, hb heartbeat, err error) {
+	wsURL, hb, err := eioHandshake(baseURL, accessToken, handshake)
+	if err != nil {
+		return nil, "", hb, fmt.Errorf("handshake: %w", err)
+	}
+	conn, err := eioWebsocket(ctx, wsURL, accessToken, handshake)
 	if err != nil {
-		return fmt.Errorf("dial ws: %w", err)
+		return nil, pollingURL(wsURL), hb, err
 	}
-	defer conn.Close()
-	log.Printf("ws connected: %s (pingInterval=%s)", wsURL, pingInterval)
+	log.Printf("ws connected: %s (pingInterval=%s pingTimeout=%s)", wsURL, hb.interval, hb.timeout)
+	return &socketConn{conn: conn, hb: hb}, "", hb, nil
+}
+
+func (sc *socketConn) send(msg []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	_ = sc.conn.SetWriteDeadline(time.Now().Add(sc.hb.timeout))
+	return sc.conn.WriteMessage(websocket.TextMessage, msg)
+}
 
-	msgCount := 0
-	addTimes := make(map[string]time.Time)
-	listIDs := make([]string, 0, 32)
+// serve reads frames until the connection fails or ctx is done; it closes
+// the connection on return.
+func (sc *socketConn) serve(ctx context.Context) error {
+	defer sc.conn.Close()
+	sess := newSession(sc.handlers.handlers(), sc.send)
+	ka := newKeepalive(sc.conn, sc.hb)
+	pingCtx, stopPing := context.WithCancel(ctx)
+	defer stopPing()
+	// пинги идут из отдельной горутины
+	go ka.run(pingCtx, sc.send)
 
 	for {
-		select {
-		case <-ctx.Done():
-			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+		kind, msg, err := sc.conn.ReadMessage()
+		if ctx.Err() != nil {
+			sc.wmu.Lock()
+			_ = sc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+			sc.wmu.Unlock()
 			return nil
-		default:
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				return err
-			}
-			s := string(msg)
-			msgCount++
-			if msgCount <= 20 {
-				log.Printf("ws raw: %q", s)
-			}
-			// server ping -> answer pong
-			if s == "2" {
-				_ = conn.WriteMessage(websocket.TextMessage, []byte("3"))
-				continue
-			}
-			// connect ack from server -> отправляем list:initialize
-			if strings.HasPrefix(s, "40") {
-				// новый коннект — сбрасываем локальное состояние списка
-				addTimes = make(map[string]time.Time)
-				listIDs = listIDs[:0]
-				if err := conn.WriteMessage(websocket.TextMessage, []byte(`42["list:initialize"]`)); err != nil {
-					return err
-				}
-				log.Printf("ws send init on 40")
-				continue
-			}
-			// Engine.IO messages start with numeric prefix. We care about "42" -> socket.io event
-			if len(s) < 2 || s[0:2] != "42" {
-				log.Printf("ws ctrl: %s", s)
-				continue
-			}
-			payload := []byte(s[2:])
-			var arr []json.RawMessage
-			if err := json.Unmarshal(payload, &arr); err != nil || len(arr) < 2 {
-				continue
-			}
-			var event string
-			if err := json.Unmarshal(arr[0], &event); err != nil {
-				continue
-			}
-			if event == "list:snapshot" {
-				var snapshot []LivePayment
-				if err := json.Unmarshal(arr[1], &snapshot); err == nil {
-					addTimes = make(map[string]time.Time)
-					listIDs = listIDs[:0]
-					now := time.Now()
-					for _, p := range snapshot {
-						listIDs = append(listIDs, p.ID)
-						addTimes[p.ID] = now
-					}
-					log.Printf("ws snapshot loaded %d items", len(listIDs))
-				}
-				continue
-			}
-			if event != "list:update" {
-				continue
-			}
-			var updates []listUpdate
-			if err := json.Unmarshal(arr[1], &updates); err != nil {
-				continue
-			}
-			for _, u := range updates {
-				log.Printf("ws list:update op=%s id=%s", u.Op, idFrom(u.Data))
-				if u.Op == "add" && u.Data != nil {
-					// фиксируем время появления в стриме
-					if _, ok := addTimes[u.Data.ID]; !ok {
-						addTimes[u.Data.ID] = time.Now()
-					}
-					// убираем дубликат, если внезапно пришёл повтор
-					for i, id := range listIDs {
-						if id == u.Data.ID {
-							listIDs = append(listIDs[:i], listIDs[i+1:]...)
-							break
-						}
-					}
-					pos := 0
-					if u.Pos != nil && *u.Pos >= 0 && *u.Pos <= len(listIDs) {
-						pos = *u.Pos
-					}
-					if pos < 0 {
-						pos = 0
-					}
-					if pos > len(listIDs) {
-						pos = len(listIDs)
-					}
-					listIDs = append(listIDs[:pos], append([]string{u.Data.ID}, listIDs[pos:]...)...)
-					if onAdd != nil {
-						onAdd(*u.Data)
-					}
-				}
-				if u.Op == "remove" {
-					// если пришел pos, пытаемся вытащить id и посчитать ttl
-					if u.Pos == nil || *u.Pos < 0 || *u.Pos >= len(listIDs) {
-						log.Printf("ws list:remove desync pos=%v len=%d", u.Pos, len(listIDs))
-						continue
-					}
-					id := listIDs[*u.Pos]
-					tAdd, ok := addTimes[id]
-					ttl := int64(-1)
-					if ok {
-						ttl = time.Since(tAdd).Milliseconds()
-					}
-					log.Printf("ws list:remove id=%s pos=%d ttl=%dms hasAdd=%v", id, *u.Pos, ttl, ok)
-					if onRemove != nil {
-						onRemove(id)
-					}
-					// убираем из списка
-					listIDs = append(listIDs[:*u.Pos], listIDs[*u.Pos+1:]...)
-					delete(addTimes, id)
-				}
+		}
+		if err != nil {
+			return err
+		}
+		ka.frame(msg)
+		// в Engine.IO v4 бинарный пакет приходит binary-кадром без префикса
+		if kind == websocket.BinaryMessage {
+			err = sess.handleBinary(msg)
+		} else {
+			err = sess.handleFrame(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// session holds per-connection socket.io state: the mirrored order list and
+// the handlers. It is independent of the network so frames can be fed directly.
+type session struct {
+	h    SocketHandlers
+	send func([]byte) error
+
+	addTimes map[string]time.Time
+	listIDs  []string
+	pending  *binaryEvent     // BINARY_EVENT, ждущий вложений
+	updates  []listUpdate     // буфер разбора list:update, переиспользуется
+	recvAt   time.Time        // время чтения текущего кадра
+	now      func() time.Time // часы; при воспроизведении — время записи кадра
+}
+
+func newSession(h SocketHandlers, send func([]byte) error) *session {
+	return &session{
+		h:        h,
+		send:     send,
+		addTimes: make(map[string]time.Time),
+		listIDs:  make([]string, 0, 32),
+		updates:  make([]listUpdate, 0, 16),
+		now:      time.Now,
+	}
+}
+
+var (
+	frameClose   = []byte("1")
+	framePing    = []byte("2")
+	framePong    = []byte("3")
+	frameNoop    = []byte("6")
+	frameConnect = []byte("40")
+	frameEvent   = []byte("42")
+	frameInit    = []byte(`42["list:initialize"]`)
+)
+
+// handleFrame processes one Engine.IO frame.
+func (s *session) handleFrame(msg []byte) error {
+	s.recvAt = s.now()
+	if s.h.OnFrame != nil {
+		s.h.OnFrame(s.recvAt, msg)
+	}
+	// server ping -> answer pong
+	if bytes.Equal(msg, framePing) {
+		return s.send(framePong)
+	}
+	// ответ на наш пинг, дедлайн уже продлён keepalive
+	if bytes.Equal(msg, framePong) || bytes.Equal(msg, frameNoop) {
+		return nil
+	}
+	if bytes.Equal(msg, frameClose) {
+		return fmt.Errorf("server closed the session")
+	}
+	// connect ack from server -> отправляем list:initialize
+	if bytes.HasPrefix(msg, frameConnect) {
+		// новый коннект — сбрасываем локальное состояние списка
+		s.resetList()
+		if s.h.OnConnect != nil {
+			s.h.OnConnect()
+		}
+		if err := s.send(frameInit); err != nil {
+			return err
+		}
+		logx.Infof("ws send init on 40")
+		return nil
+	}
+	if bytes.HasPrefix(msg, frameBinaryEvent) {
+		ev, err := parseBinaryHeader(msg)
+		if err != nil {
+			s.frameError(msg, err)
+			return nil
+		}
+		s.pending = ev
+		if ev.complete() {
+			return s.flushBinary()
+		}
+		return nil
+	}
+	// Engine.IO messages start with numeric prefix. We care about "42" -> socket.io event
+	if !bytes.HasPrefix(msg, frameEvent) {
+		logx.Infof("ws ctrl: %s", msg)
+		return nil
+	}
+	if err := s.handleEvent(msg[2:]); err != nil {
+		s.frameError(msg, err)
+	}
+	return nil
+}
+
+// frameError reports a frame the session could not make sense of.
+func (s *session) frameError(frame []byte, err error) {
+	if s.h.OnFrameError != nil {
+		s.h.OnFrameError(frame, err)
+		return
+	}
+	log.Printf("ws %v", err)
+}
+
+// handleEvent dispatches a socket.io event body `["event", data]`.
+func (s *session) handleEvent(body []byte) error {
+	// горячий путь: list:update разбираем без reflection
+	if updates, ok := parseListUpdateEvent(body, s.updates); ok {
+		s.updates = updates
+		s.applyBatch(updates)
+		return nil
+	}
+	var arr []json.RawMessage
+	if err := json.Unmarshal(body, &arr); err != nil {
+		return fmt.Errorf("event: %w", err)
+	}
+	if len(arr) < 2 {
+		return fmt.Errorf("event without data")
+	}
+	var event string
+	if err := json.Unmarshal(arr[0], &event); err != nil {
+		return fmt.Errorf("event name: %w", err)
+	}
+	switch event {
+	case "list:snapshot":
+		var snapshot []LivePayment
+		if err := json.Unmarshal(arr[1], &snapshot); err != nil {
+			return fmt.Errorf("list:snapshot: %w", err)
+		}
+		s.loadSnapshot(snapshot)
+	case "list:update":
+		var updates []listUpdate
+		if err := json.Unmarshal(arr[1], &updates); err != nil {
+			return fmt.Errorf("list:update: %w", err)
+		}
+		s.applyBatch(updates)
+	}
+	return nil
+}
+
+func (s *session) resetList() {
+	s.addTimes = make(map[string]time.Time)
+	s.listIDs = s.listIDs[:0]
+}
+
+func (s *session) loadSnapshot(snapshot []LivePayment) {
+	s.resetList()
+	now := s.now()
+	for i, p := range snapshot {
+		s.listIDs = append(s.listIDs, p.ID)
+		s.addTimes[p.ID] = now
+		snapshot[i].Position = i
+	}
+	log.Printf("ws snapshot loaded %d items", len(s.listIDs))
+	if s.h.OnSnapshot != nil {
+		s.h.OnSnapshot(snapshot)
+	}
+}
+
+// applyBatch mirrors all updates in order, then hands the batch's adds to
+// OnAdd highest boost first, so the best-paying order is attempted first.
+// OnRemove fires in batch order; an add that a later update of the batch
+// removes is dropped, so no id is removed before it is added.
+func (s *session) applyBatch(updates []listUpdate) {
+	var adds []LivePayment
+	for _, u := range updates {
+		p, removed, added := s.applyUpdate(u)
+		if removed != "" {
+			// заявка ушла в том же батче — брать её уже поздно
+			adds = slices.DeleteFunc(adds, func(a LivePayment) bool { return a.ID == removed })
+		}
+		if added {
+			adds = append(adds, p)
+		}
+	}
+	maxBoost := 0.0
+	for _, p := range adds {
+		maxBoost = max(maxBoost, p.Boost)
+	}
+	if len(adds) > 1 {
+		sort.SliceStable(adds, func(i, j int) bool { return adds[i].Boost > adds[j].Boost })
+	}
+	parsedAt := s.now()
+	for _, p := range adds {
+		p.BatchSize = len(adds)
+		p.BatchMaxBoost = maxBoost
+		p.ReceivedAt = s.recvAt
+		p.ParsedAt = parsedAt
+		if s.h.OnAdd != nil {
+			s.h.OnAdd(p)
+		}
+	}
+}
+
+// applyUpdate mirrors one update and returns the added payment or the
+// removed id, if any.
+func (s *session) applyUpdate(u listUpdate) (p LivePayment, removed string, added bool) {
+	log.Printf("ws list:update op=%s id=%s", u.Op, idFrom(u.Data))
+	if u.Op == "add" && u.Data != nil {
+		// фиксируем время появления в стриме
+		if _, ok := s.addTimes[u.Data.ID]; !ok {
+			s.addTimes[u.Data.ID] = s.now()
+		}
+		// убираем дубликат, если внезапно пришёл повтор
+		for i, id := range s.listIDs {
+			if id == u.Data.ID {
+				s.listIDs = append(s.listIDs[:i], s.listIDs[i+1:]...)
+				break
 			}
 		}
+		pos := 0
+		if u.Pos != nil && *u.Pos >= 0 && *u.Pos <= len(s.listIDs) {
+			pos = *u.Pos
+		}
+		s.listIDs = append(s.listIDs[:pos], append([]string{u.Data.ID}, s.listIDs[pos:]...)...)
+		p = *u.Data
+		p.Position = pos
+		return p, "", true
 	}
+	if u.Op == "remove" {
+		// если пришел pos, пытаемся вытащить id и посчитать ttl
+		if u.Pos == nil || *u.Pos < 0 || *u.Pos >= len(s.listIDs) {
+			log.Printf("ws list:remove desync pos=%v len=%d", u.Pos, len(s.listIDs))
+			return LivePayment{}, "", false
+		}
+		id := s.listIDs[*u.Pos]
+		tAdd, ok := s.addTimes[id]
+		ttl := int64(-1)
+		if ok {
+			ttl = s.now().Sub(tAdd).Milliseconds()
+		}
+		log.Printf("ws list:remove id=%s pos=%d ttl=%dms hasAdd=%v", id, *u.Pos, ttl, ok)
+		if s.h.OnRemove != nil {
+			s.h.OnRemove(id)
+		}
+		// убираем из списка
+		s.listIDs = append(s.listIDs[:*u.Pos], s.listIDs[*u.Pos+1:]...)
+		delete(s.addTimes, id)
+		return LivePayment{}, id, false
+	}
+	return LivePayment{}, "", false
 }
 
 func idFrom(p *LivePayment) string {
@@ -182,10 +407,10 @@ func idFrom(p *LivePayment) string {
 	return p.ID
 }
 
-func eioHandshake(baseURL, accessToken string) (wsURL string, pingInterval time.Duration, err error) {
+func eioHandshake(baseURL, accessToken string, timeout time.Duration) (wsURL string, hb heartbeat, err error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
-		return "", 0, err
+		return "", hb, err
 	}
 	u.Scheme = "https"
 	u.Path = "/internal/v1/p2c-socket/"
@@ -202,15 +427,19 @@ func eioHandshake(baseURL, accessToken string) (wsURL string, pingInterval time.
 	req.Header.Set("Pragma", "no-cache")
 	req.Header.Set("Cache-Control", "no-cache")
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", 0, err
+		return "", hb, err
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
+	// ответ может содержать несколько пакетов, open — первый
+	if i := bytes.IndexByte(body, recordSeparator); i >= 0 {
+		body = body[:i]
+	}
 	if len(body) == 0 || body[0] != '0' {
-		return "", 0, fmt.Errorf("unexpected handshake body: %s", string(body))
+		return "", hb, fmt.Errorf("unexpected handshake body: %s", string(body))
 	}
 
 	var open struct {
@@ -219,10 +448,10 @@ func eioHandshake(baseURL, accessToken string) (wsURL string, pingInterval time.
 		PingTimeout  int64  `json:"pingTimeout"`
 	}
 	if err := json.Unmarshal(body[1:], &open); err != nil {
-		return "", 0, fmt.Errorf("parse open: %w", err)
+		return "", hb, fmt.Errorf("parse open: %w", err)
 	}
 	if open.SID == "" {
-		return "", 0, fmt.Errorf("empty sid")
+		return "", hb, fmt.Errorf("empty sid")
 	}
 
 	// prepare websocket URL with sid
@@ -231,17 +460,23 @@ func eioHandshake(baseURL, accessToken string) (wsURL string, pingInterval time.
 	q.Set("sid", open.SID)
 	u.RawQuery = q.Encode()
 
-	pi := time.Duration(open.PingInterval) * time.Millisecond
-	if pi <= 0 {
-		pi = 20 * time.Second
+	hb = heartbeat{
+		interval: time.Duration(open.PingInterval) * time.Millisecond,
+		timeout:  time.Duration(open.PingTimeout) * time.Millisecond,
+	}
+	if hb.interval <= 0 {
+		hb.interval = defaultPingInterval
 	}
-	return u.String(), pi, nil
+	if hb.timeout <= 0 {
+		hb.timeout = defaultPingTimeout
+	}
+	return u.String(), hb, nil
 }
 
-func eioWebsocket(ctx context.Context, wsURL, accessToken string) (*websocket.Conn, error) {
+func eioWebsocket(ctx context.Context, wsURL, accessToken string, timeout time.Duration) (*websocket.Conn, error) {
 	dialer := websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: 5 * time.Second,
+		HandshakeTimeout: timeout,
 		EnableCompression: true,
 	}
 	header := http.Header{}
@@ -261,6 +496,11 @@ func eioWebsocket(ctx context.Context, wsURL, accessToken string) (*websocket.Co
 		}
 		return nil, err
 	}
+	// permessage-deflate распаковывает gorilla; пишем тоже сжатым, если сервер согласился
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); strings.Contains(ext, "permessage-deflate") {
+		conn.EnableWriteCompression(true)
+		log.Printf("ws compression negotiated: %s", ext)
+	}
 
 	// Engine.IO v4: send probe, expect "3probe", then upgrade "5"
 	if err := conn.WriteMessage(websocket.TextMessage, []byte("2probe")); err != nil {
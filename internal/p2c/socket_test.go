@@ -0,0 +1,73 @@
+package p2c
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+// Бюджеты аллокаций горячего пути: кадр list:update от чтения до OnAdd.
+// Поднимая бюджет, напишите в коммите, за что платим.
+const (
+	frameAllocBudget = 24 // add-кадр и remove-кадр, сейчас 21: строки разбора и log.Printf
+	batchAllocBudget = 6  // add и remove уже разобранного батча, сейчас 5
+)
+
+var (
+	addFrame    = []byte(`42["list:update",[{"op":"add","pos":0,"data":{"id":"6f1c2a9e4b7d","payload":"","url":"https://pay.example/6f1c2a9e4b7d","brand_name":"Магнит","in_asset":"RUB","out_asset":"USDT","boost":1.5,"provider":"sbp","in_amount":"4500.00","out_amount":"48120000000000000000","exchange_rate":"93.51","fee_amount":"721800000000000000","expires_at":"2026-01-01T12:00:00Z"}}]]`)
+	removeFrame = []byte(`42["list:update",[{"op":"remove","pos":0}]]`)
+)
+
+// testSession returns a session that counts adds; the per-update logs are
+// discarded but still counted in the budgets.
+func testSession(t *testing.T) (*session, *int) {
+	t.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+	adds := new(int)
+	s := newSession(SocketHandlers{
+		OnAdd:    func(LivePayment) { *adds++ },
+		OnRemove: func(string) {},
+	}, func([]byte) error { return nil })
+	return s, adds
+}
+
+func TestHandleFrameAllocs(t *testing.T) {
+	s, adds := testSession(t)
+	run := func() {
+		if err := s.handleFrame(addFrame); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.handleFrame(removeFrame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allocs := testing.AllocsPerRun(200, run) // AllocsPerRun прогревает одним лишним вызовом
+	if *adds != 201 || len(s.listIDs) != 0 {
+		t.Fatalf("adds = %d, list = %v; want 201 adds and an empty list", *adds, s.listIDs)
+	}
+	if allocs > frameAllocBudget {
+		t.Fatalf("handleFrame: %.0f allocs per add+remove, budget %d", allocs, frameAllocBudget)
+	}
+}
+
+func TestApplyBatchAllocs(t *testing.T) {
+	s, adds := testSession(t)
+	add, ok := parseListUpdateEvent(addFrame[2:], nil)
+	if !ok {
+		t.Fatal("fast parse failed")
+	}
+	pos := 0
+	remove := []listUpdate{{Op: "remove", Pos: &pos}}
+	allocs := testing.AllocsPerRun(200, func() {
+		s.applyBatch(add)
+		s.applyBatch(remove)
+	})
+	if *adds != 201 || len(s.listIDs) != 0 {
+		t.Fatalf("adds = %d, list = %v; want 201 adds and an empty list", *adds, s.listIDs)
+	}
+	if allocs > batchAllocBudget {
+		t.Fatalf("applyBatch: %.0f allocs per add+remove, budget %d", allocs, batchAllocBudget)
+	}
+}
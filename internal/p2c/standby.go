@@ -0,0 +1,199 @@
+package p2c
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// standbyRetry is the pause before preparing a new standby after a failure.
+const standbyRetry = 5 * time.Second
+
+// switchHandlers forwards session callbacks to handlers that can be set
+// later: a standby connection runs with none and gets the worker's
+// handlers when promoted.
+type switchHandlers struct {
+	p atomic.Pointer[SocketHandlers]
+}
+
+func (sw *switchHandlers) set(h *SocketHandlers) { sw.p.Store(h) }
+
+// handlers returns callbacks reading the current handlers on every call.
+func (sw *switchHandlers) handlers() SocketHandlers {
+	return SocketHandlers{
+		OnAdd: func(p LivePayment) {
+			if h := sw.p.Load(); h != nil && h.OnAdd != nil {
+				h.OnAdd(p)
+			}
+		},
+		OnRemove: func(id string) {
+			if h := sw.p.Load(); h != nil && h.OnRemove != nil {
+				h.OnRemove(id)
+			}
+		},
+		OnSnapshot: func(list []LivePayment) {
+			if h := sw.p.Load(); h != nil && h.OnSnapshot != nil {
+				h.OnSnapshot(list)
+			}
+		},
+		OnConnect: func() {
+			if h := sw.p.Load(); h != nil && h.OnConnect != nil {
+				h.OnConnect()
+			}
+		},
+		OnFrame: func(at time.Time, frame []byte) {
+			if h := sw.p.Load(); h != nil && h.OnFrame != nil {
+				h.OnFrame(at, frame)
+			}
+		},
+		OnFrameError: func(frame []byte, err error) {
+			if h := sw.p.Load(); h != nil && h.OnFrameError != nil {
+				h.OnFrameError(frame, err)
+				return
+			}
+			log.Printf("ws %v", err)
+		},
+	}
+}
+
+// Socket is the live feed of one worker with an optional warm standby: a
+// second session, already handshaken, upgraded and answering pings, that
+// replaces the active one the moment it drops. Promotion re-sends
+// list:initialize, so the worker gets a fresh snapshot and backfills.
+type Socket struct {
+	client  *Client
+	standby bool
+	ready   chan *standbyConn
+	started atomic.Bool
+	drop    atomic.Pointer[func()] // закрыть текущий сеанс
+}
+
+// Drop closes the current session: Run returns or, with a standby, switches
+// to it. For a connection that answers pings but delivers no events.
+func (s *Socket) Drop() {
+	if f := s.drop.Load(); f != nil {
+		(*f)()
+	}
+}
+
+func (s *Socket) setDrop(f func()) { s.drop.Store(&f) }
+
+type standbyConn struct {
+	sc   *socketConn
+	done chan struct{}
+	err  error
+}
+
+// NewSocket returns the feed of the client's account; standby keeps a
+// second connection open.
+func (c *Client) NewSocket(standby bool) *Socket {
+	return &Socket{client: c, standby: standby, ready: make(chan *standbyConn)}
+}
+
+// Run serves the feed until the connection fails without a standby to take
+// over, or ctx is done.
+func (s *Socket) Run(ctx context.Context, h SocketHandlers) error {
+	if !s.standby {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		s.setDrop(cancel)
+		return s.client.Subscribe(ctx, h)
+	}
+	if s.started.CompareAndSwap(false, true) {
+		go s.maintain(ctx)
+	}
+	var cur *standbyConn
+	promote := true
+	select {
+	case cur = <-s.ready:
+	default:
+		sc, pollURL, hb, err := dialSocket(ctx, s.client.base(), s.client.accessToken, s.client.transport.handshake())
+		if err != nil {
+			if pollURL == "" || ctx.Err() != nil {
+				return err
+			}
+			log.Printf("ws upgrade failed (%v), falling back to polling", err)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			s.setDrop(cancel)
+			return subscribePolling(ctx, pollURL, s.client.accessToken, hb, h)
+		}
+		sc.handlers.set(&h)
+		cur = s.start(ctx, sc)
+		// свежий сеанс сам пройдёт 40 → list:initialize
+		promote = false
+	}
+	for {
+		sc := cur.sc
+		s.setDrop(func() { _ = sc.conn.Close() })
+		if promote {
+			if err := cur.promote(h); err != nil {
+				log.Printf("ws standby promote: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			<-cur.done
+			return nil
+		case <-cur.done:
+		}
+		select {
+		case next := <-s.ready:
+			log.Printf("ws session dropped (%v), switching to standby", cur.err)
+			cur, promote = next, true
+		default:
+			return cur.err
+		}
+	}
+}
+
+func (s *Socket) start(ctx context.Context, sc *socketConn) *standbyConn {
+	c := &standbyConn{sc: sc, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		c.err = sc.serve(ctx)
+	}()
+	return c
+}
+
+// promote hands the standby to the worker and asks for a fresh snapshot.
+func (c *standbyConn) promote(h SocketHandlers) error {
+	c.sc.handlers.set(&h)
+	if h.OnConnect != nil {
+		h.OnConnect()
+	}
+	return c.sc.send(frameInit)
+}
+
+// maintain keeps one standby ready until ctx is done.
+func (s *Socket) maintain(ctx context.Context) {
+	for ctx.Err() == nil {
+		sc, _, _, err := dialSocket(ctx, s.client.base(), s.client.accessToken, s.client.transport.handshake())
+		if err != nil {
+			log.Printf("ws standby: %v", err)
+			sleepCtx(ctx, standbyRetry)
+			continue
+		}
+		c := s.start(ctx, sc)
+		select {
+		case s.ready <- c:
+			// забрали в работу — сразу готовим следующий
+		case <-c.done:
+			log.Printf("ws standby dropped: %v", c.err)
+			sleepCtx(ctx, standbyRetry)
+		case <-ctx.Done():
+			<-c.done
+			return
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
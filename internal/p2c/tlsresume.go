@@ -0,0 +1,159 @@
+package p2c
+
+import (
+	"container/list"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+)
+
+// TLSStats is the TLS resumption hit rate exposed in the status API.
+type TLSStats struct {
+	Handshakes int64   `json:"handshakes"`
+	Resumed    int64   `json:"resumed"`
+	HitRate    float64 `json:"hit_rate"`
+	Sessions   int     `json:"sessions"`
+}
+
+// SavedSession is a TLS session exported to survive an engine restart.
+type SavedSession struct {
+	Key    string
+	Ticket []byte
+	State  []byte
+}
+
+// TLSSessions is the TLS session cache shared by all clients of the
+// engine, so a reconnect after idle (or another account's first request)
+// resumes the session instead of a full handshake: one round trip less on
+// TLS 1.2 and no certificate exchange on TLS 1.3. Sessions are keyed by
+// server name, i.e. per P2C host.
+//
+// 0-RTT is not used: crypto/tls does not send early data, and a take is a
+// non-idempotent POST that must not be replayable anyway.
+type TLSSessions struct {
+	capacity int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List
+
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+type sessionEntry struct {
+	key   string
+	state *tls.ClientSessionState
+}
+
+// NewTLSSessions returns a cache keeping up to capacity sessions.
+func NewTLSSessions(capacity int) *TLSSessions {
+	if capacity <= 0 {
+		capacity = 256
+	}
+	return &TLSSessions{capacity: capacity, items: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Get implements tls.ClientSessionCache.
+func (s *TLSSessions) Get(key string) (*tls.ClientSessionState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*sessionEntry).state, true
+}
+
+// Put implements tls.ClientSessionCache; a nil state removes the key.
+func (s *TLSSessions) Put(key string, cs *tls.ClientSessionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		if cs == nil {
+			s.lru.Remove(el)
+			delete(s.items, key)
+			return
+		}
+		el.Value.(*sessionEntry).state = cs
+		s.lru.MoveToFront(el)
+		return
+	}
+	if cs == nil {
+		return
+	}
+	s.items[key] = s.lru.PushFront(&sessionEntry{key: key, state: cs})
+	if s.lru.Len() > s.capacity {
+		old := s.lru.Back()
+		s.lru.Remove(old)
+		delete(s.items, old.Value.(*sessionEntry).key)
+	}
+}
+
+// tlsConfig returns a client config using the cache; every handshake is
+// counted for the hit rate.
+func (s *TLSSessions) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ClientSessionCache: s,
+		MinVersion:         tls.VersionTLS12,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			s.handshakes.Add(1)
+			if cs.DidResume {
+				s.resumed.Add(1)
+			}
+			return nil
+		},
+	}
+}
+
+// Stats returns handshake counters since start.
+func (s *TLSSessions) Stats() TLSStats {
+	st := TLSStats{Handshakes: s.handshakes.Load(), Resumed: s.resumed.Load()}
+	if st.Handshakes > 0 {
+		st.HitRate = float64(st.Resumed) / float64(st.Handshakes)
+	}
+	s.mu.Lock()
+	st.Sessions = s.lru.Len()
+	s.mu.Unlock()
+	return st
+}
+
+// Export serializes the cached sessions, most recent first.
+func (s *TLSSessions) Export() []SavedSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]SavedSession, 0, s.lru.Len())
+	for el := s.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*sessionEntry)
+		ticket, state, err := e.state.ResumptionState()
+		if err != nil || state == nil {
+			continue
+		}
+		b, err := state.Bytes()
+		if err != nil {
+			continue
+		}
+		out = append(out, SavedSession{Key: e.key, Ticket: ticket, State: b})
+	}
+	return out
+}
+
+// Import restores sessions saved by Export; broken entries are skipped and
+// expired tickets are simply refused by the server.
+func (s *TLSSessions) Import(saved []SavedSession) int {
+	n := 0
+	for i := len(saved) - 1; i >= 0; i-- {
+		state, err := tls.ParseSessionState(saved[i].State)
+		if err != nil {
+			continue
+		}
+		cs, err := tls.NewResumptionState(saved[i].Ticket, state)
+		if err != nil {
+			continue
+		}
+		s.Put(saved[i].Key, cs)
+		n++
+	}
+	return n
+}
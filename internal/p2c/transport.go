@@ -0,0 +1,88 @@
+package p2c
+
+import "time"
+
+// TransportConfig tunes the client timeouts and connection pools. Zero
+// fields keep the defaults, so an engine-wide config can be overridden per
+// account field by field (see Merge).
+type TransportConfig struct {
+	DialMs          int  `json:"dial_ms,omitempty"`            // TCP connect
+	TLSMs           int  `json:"tls_ms,omitempty"`             // TLS handshake
+	ResponseMs      int  `json:"response_ms,omitempty"`        // wait for the response headers of an API call
+	RequestMs       int  `json:"request_ms,omitempty"`         // whole API call, body included
+	HandshakeMs     int  `json:"handshake_ms,omitempty"`       // Engine.IO handshake and websocket upgrade
+	MaxConnsPerHost int  `json:"max_conns_per_host,omitempty"` // 0 — 256
+	MaxIdleConns    int  `json:"max_idle_conns,omitempty"`
+	IdleTimeoutMs   int  `json:"idle_timeout_ms,omitempty"`
+	DisableHTTP2    bool `json:"disable_http2,omitempty"`
+}
+
+// Defaults used for zero fields of TransportConfig.
+const (
+	defaultDialTimeout      = 2 * time.Second
+	defaultTLSTimeout       = 2 * time.Second
+	defaultResponseTimeout  = 2 * time.Second
+	defaultRequestTimeout   = 3 * time.Second
+	defaultHandshakeTimeout = 5 * time.Second
+	defaultIdleConnTimeout  = 120 * time.Second
+	defaultMaxIdleConns     = 512
+)
+
+// Merge returns c with the non-zero fields of over applied on top.
+func (c TransportConfig) Merge(over TransportConfig) TransportConfig {
+	pick := func(base, v int) int {
+		if v > 0 {
+			return v
+		}
+		return base
+	}
+	return TransportConfig{
+		DialMs:          pick(c.DialMs, over.DialMs),
+		TLSMs:           pick(c.TLSMs, over.TLSMs),
+		ResponseMs:      pick(c.ResponseMs, over.ResponseMs),
+		RequestMs:       pick(c.RequestMs, over.RequestMs),
+		HandshakeMs:     pick(c.HandshakeMs, over.HandshakeMs),
+		MaxConnsPerHost: pick(c.MaxConnsPerHost, over.MaxConnsPerHost),
+		MaxIdleConns:    pick(c.MaxIdleConns, over.MaxIdleConns),
+		IdleTimeoutMs:   pick(c.IdleTimeoutMs, over.IdleTimeoutMs),
+		DisableHTTP2:    c.DisableHTTP2 || over.DisableHTTP2,
+	}
+}
+
+func msOr(ms int, def time.Duration) time.Duration {
+	if ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return def
+}
+
+func intOr(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func (c TransportConfig) dial() time.Duration {
+	return msOr(c.DialMs, defaultDialTimeout)
+}
+
+func (c TransportConfig) tls() time.Duration {
+	return msOr(c.TLSMs, defaultTLSTimeout)
+}
+
+func (c TransportConfig) response() time.Duration {
+	return msOr(c.ResponseMs, defaultResponseTimeout)
+}
+
+func (c TransportConfig) request() time.Duration {
+	return msOr(c.RequestMs, defaultRequestTimeout)
+}
+
+func (c TransportConfig) handshake() time.Duration {
+	return msOr(c.HandshakeMs, defaultHandshakeTimeout)
+}
+
+func (c TransportConfig) idle() time.Duration {
+	return msOr(c.IdleTimeoutMs, defaultIdleConnTimeout)
+}
@@ -0,0 +1,133 @@
+package p2c
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WarmStats reports the state of the keep-warm pool. LastOK counts the
+// pings that succeeded, LiveConns the distinct connections that carried
+// them: over HTTP/2 the pings share one connection, so it is 1 however many
+// conns are configured.
+type WarmStats struct {
+	Conns       int       `json:"conns"`
+	LastOK      int       `json:"last_ok"`
+	LiveConns   int       `json:"live_conns"`
+	LastRefresh time.Time `json:"last_refresh"`
+	Failures    int64     `json:"failures"`
+}
+
+// Warmer keeps N established TLS connections to the P2C host on the take-path
+// transport, refreshing them well before the idle timeout. Over HTTP/2 the
+// pings multiplex onto the live connection and keep it hot; over HTTP/1.1
+// each ping holds its own pooled connection. Stats tells the two apart.
+type Warmer struct {
+	client   *Client
+	conns    int
+	interval time.Duration
+
+	mu          sync.Mutex
+	lastOK      int
+	liveConns   int
+	lastRefresh time.Time
+	failures    atomic.Int64
+}
+
+// NewWarmer creates a keep-warm pool of conns connections refreshed every interval.
+func (c *Client) NewWarmer(conns int, interval time.Duration) *Warmer {
+	if conns <= 0 {
+		conns = 1
+	}
+	if interval <= 0 {
+		interval = 8 * time.Second
+	}
+	return &Warmer{client: c, conns: conns, interval: interval}
+}
+
+// Run refreshes the pool until ctx is done.
+func (w *Warmer) Run(ctx context.Context) {
+	w.refresh(ctx)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.refresh(ctx)
+		}
+	}
+}
+
+// Stats returns pool counters.
+func (w *Warmer) Stats() WarmStats {
+	if w == nil {
+		return WarmStats{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WarmStats{
+		Conns:       w.conns,
+		LastOK:      w.lastOK,
+		LiveConns:   w.liveConns,
+		LastRefresh: w.lastRefresh,
+		Failures:    w.failures.Load(),
+	}
+}
+
+// refresh fires conns concurrent cheap requests so the transport keeps
+// (or re-establishes) that many live connections, and counts the
+// connections that actually answered.
+func (w *Warmer) refresh(ctx context.Context) {
+	var ok atomic.Int64
+	var wg sync.WaitGroup
+	var connsMu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		connsMu.Lock()
+		conns[info.Conn] = struct{}{}
+		connsMu.Unlock()
+	}}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	for i := 0; i < w.conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w.ping(ctx) {
+				ok.Add(1)
+			} else {
+				w.failures.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	w.mu.Lock()
+	w.lastOK = int(ok.Load())
+	w.liveConns = len(conns)
+	w.lastRefresh = time.Now()
+	w.mu.Unlock()
+}
+
+func (w *Warmer) ping(ctx context.Context) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, w.client.base()+"/health", nil)
+	if err != nil {
+		return false
+	}
+	if w.client.accessToken != "" {
+		req.Header.Set("Cookie", "access_token="+w.client.accessToken)
+	}
+	resp, err := w.client.rt.Do(req)
+	if err != nil {
+		return false
+	}
+	// дочитываем тело, чтобы соединение вернулось в пул
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return true
+}
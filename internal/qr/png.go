@@ -0,0 +1,48 @@
+package qr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// quietZone is the light border required around the symbol, in modules.
+const quietZone = 4
+
+// Image renders the code with the given module size in pixels.
+func (c *Code) Image(scale int) image.Image {
+	scale = max(scale, 1)
+	side := (c.Size + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			px, py := (x+quietZone)*scale, (y+quietZone)*scale
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[(py+dy)*img.Stride+px:]
+				for dx := 0; dx < scale; dx++ {
+					row[dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes text with level M and renders it at least size pixels wide.
+func PNG(text string, size int) ([]byte, error) {
+	code, err := Encode(text, M)
+	if err != nil {
+		return nil, err
+	}
+	modules := code.Size + 2*quietZone
+	scale := (size + modules - 1) / modules
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStorage keeps every document as a JSON file under dir; replaces are
+// atomic through a temp file and rename.
+type fileStorage struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (f *fileStorage) Get(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := os.ReadFile(f.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f *fileStorage) Put(name string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := f.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *fileStorage) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := os.Remove(f.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *fileStorage) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, filepath.FromSlash(dir)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() {
+			names = append(names, dir+"/"+name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Lock uses a lock file created with O_EXCL, so it holds across processes
+// sharing the directory. The file keeps the holder's token: unlocking and
+// breaking a stale lock delete it only if it still has the token they
+// expect, see removeLock.
+func (f *fileStorage) Lock(name string, stale time.Duration) (unlock func(), err error) {
+	path := f.path(name) + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	token := newLockToken()
+	deadline := time.Now().Add(lockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, werr := file.WriteString(token)
+			if err := errors.Join(werr, file.Close()); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { removeLock(path, token, token) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > stale {
+			if held, err := os.ReadFile(path); err == nil {
+				removeLock(path, string(held), token)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// removeLock deletes the lock file at path if it holds the token want. The
+// file is first renamed aside (to a name of the caller's token mine), which
+// only one process can do; a lock found there with another token was taken
+// in the meantime and is linked back instead of deleted.
+func removeLock(path, want, mine string) {
+	aside := path + "." + mine
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+	if held, err := os.ReadFile(aside); err == nil && string(held) == want {
+		return
+	}
+	// Link, в отличие от Rename, не затрёт замок, взятый за это время третьим
+	_ = os.Link(aside, path)
+}
+
+func (f *fileStorage) path(name string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(name)+".json")
+}
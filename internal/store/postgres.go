@@ -0,0 +1,13 @@
+//go:build postgres
+
+package store
+
+import (
+	"strconv"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // драйвер "pgx"
+)
+
+func init() {
+	sqlDialects["postgres"] = sqlDialect{driver: "pgx", bind: func(n int) string { return "$" + strconv.Itoa(n) }}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Secret is a credential such as a P2C access token. It prints as a redacted
+// placeholder and is encrypted with the key from SetSecretKey when marshaled,
+// so it never reaches logs or disk in plain text.
+//
+// Secrets are sealed with AES-256-GCM from the standard library rather than
+// age or NaCl secretbox, which would be the first crypto dependency of the
+// engine. A random 96-bit nonce per value is safe for the number of secrets
+// one key ever seals here; the key itself must be random, not a passphrase.
+type Secret string
+
+const (
+	redacted     = "[redacted]"
+	sealedPrefix = "enc:v2:" // ключ — 32 случайных байта
+)
+
+var (
+	// ErrNoSecretKey is returned when a secret is persisted without a key.
+	ErrNoSecretKey = errors.New("store: secret key is not configured")
+
+	secretMu   sync.RWMutex
+	secretAEAD cipher.AEAD
+)
+
+// SetSecretKey sets the key used to encrypt secrets at rest: 32 random bytes
+// in hex or base64, e.g. from openssl rand -hex 32.
+func SetSecretKey(key string) error {
+	raw, err := parseSecretKey(key)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(raw)
+	if err != nil {
+		return err
+	}
+	secretMu.Lock()
+	secretAEAD = aead
+	secretMu.Unlock()
+	return nil
+}
+
+func parseSecretKey(key string) ([]byte, error) {
+	key = strings.TrimSpace(key)
+	decoders := []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	for _, decode := range decoders {
+		if raw, err := decode(key); err == nil && len(raw) == 32 {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("store: secret key must be 32 random bytes in hex or base64 (openssl rand -hex 32)")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Reveal returns the plain value; use it only where the credential is sent.
+func (s Secret) Reveal() string { return string(s) }
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string { return `store.Secret("` + s.String() + `")` }
+
+// MarshalJSON encrypts the secret. Without a key it fails instead of
+// writing the plain value.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return []byte(`""`), nil
+	}
+	aead := currentAEAD()
+	if aead == nil {
+		return nil, ErrNoSecretKey
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(s), nil)
+	return json.Marshal(sealedPrefix + base64.StdEncoding.EncodeToString(sealed))
+}
+
+// UnmarshalJSON decrypts a sealed secret. Plain values are accepted as is,
+// so API requests and documents written before encryption still load.
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(v, sealedPrefix) {
+		*s = Secret(v)
+		return nil
+	}
+	aead := currentAEAD()
+	if aead == nil {
+		return ErrNoSecretKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, sealedPrefix))
+	if err != nil {
+		return err
+	}
+	if len(raw) < aead.NonceSize() {
+		return errors.New("store: sealed secret is too short")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return errors.New("store: cannot decrypt secret (wrong key?)")
+	}
+	*s = Secret(plain)
+	return nil
+}
+
+func currentAEAD() cipher.AEAD {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secretAEAD
+}
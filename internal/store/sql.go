@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sqlDialect differs between the SQL backends only in driver name and
+// placeholders.
+type sqlDialect struct {
+	driver string
+	// bind returns the n-th (1-based) placeholder.
+	bind func(n int) string
+	// maxConns limits the pool; SQLite allows one writer at a time.
+	maxConns int
+}
+
+// sqlDialects are filled by the build-tagged files registering the drivers.
+var sqlDialects = map[string]sqlDialect{}
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS p2c_documents (
+	name       VARCHAR(255) PRIMARY KEY,
+	data       TEXT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS p2c_locks (
+	name     VARCHAR(255) PRIMARY KEY,
+	token    VARCHAR(64) NOT NULL,
+	taken_at BIGINT NOT NULL
+)`
+
+// sqlStorage keeps documents as rows of one table, so several engine
+// instances can share state through an existing database.
+type sqlStorage struct {
+	db *sql.DB
+	d  sqlDialect
+}
+
+func openSQL(kind, dsn string) (*Store, error) {
+	d, ok := sqlDialects[kind]
+	if !ok {
+		return nil, fmt.Errorf("store: %s backend is not compiled in, rebuild with -tags %s", kind, kind)
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.maxConns > 0 {
+		db.SetMaxOpenConns(d.maxConns)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, stmt := range strings.Split(sqlSchema, ";") {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("store: create schema: %w", err)
+		}
+	}
+	return New(&sqlStorage{db: db, d: d}), nil
+}
+
+func (s *sqlStorage) q(query string) string {
+	// запросы пишем с ?, диалект подставляет свои плейсхолдеры
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(s.d.bind(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStorage) Get(name string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.q(`SELECT data FROM p2c_documents WHERE name = ?`), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (s *sqlStorage) Put(name string, data []byte) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO p2c_documents (name, data, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`),
+		name, string(data), time.Now().UnixMilli())
+	return err
+}
+
+func (s *sqlStorage) Delete(name string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM p2c_documents WHERE name = ?`), name)
+	return err
+}
+
+func (s *sqlStorage) List(dir string) ([]string, error) {
+	prefix := dir + "/"
+	rows, err := s.db.Query(s.q(`SELECT name FROM p2c_documents WHERE name LIKE ? ESCAPE '\'`), escapeLike(prefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		// только прямые потомки, как у файлов в каталоге
+		if !strings.Contains(strings.TrimPrefix(name, prefix), "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, rows.Err()
+}
+
+// Lock inserts a row of the lock table; the primary key makes the insert
+// fail while another process holds it. The row keeps the holder's token, so
+// unlock deletes only its own lock, not one taken after it was broken.
+func (s *sqlStorage) Lock(name string, stale time.Duration) (unlock func(), err error) {
+	token := newLockToken()
+	deadline := time.Now().Add(lockWait)
+	for {
+		now := time.Now()
+		res, err := s.db.Exec(s.q(`INSERT INTO p2c_locks (name, token, taken_at) VALUES (?, ?, ?) ON CONFLICT (name) DO NOTHING`), name, token, now.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return func() { _, _ = s.db.Exec(s.q(`DELETE FROM p2c_locks WHERE name = ? AND token = ?`), name, token) }, nil
+		}
+		// замок упавшего процесса снимаем, если он старше stale
+		if _, err := s.db.Exec(s.q(`DELETE FROM p2c_locks WHERE name = ? AND taken_at < ?`), name, now.Add(-stale).UnixMilli()); err != nil {
+			return nil, err
+		}
+		if now.After(deadline) {
+			return nil, ErrLocked
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,11 @@
+//go:build sqlite
+
+package store
+
+import (
+	_ "modernc.org/sqlite" // драйвер "sqlite" без cgo
+)
+
+func init() {
+	sqlDialects["sqlite"] = sqlDialect{driver: "sqlite", bind: func(int) string { return "?" }, maxConns: 1}
+}
@@ -0,0 +1,66 @@
+//go:build otel
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	setup = func(ctx context.Context) (func(*Span), func(context.Context) error, error) {
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		// OTEL_SERVICE_NAME и OTEL_RESOURCE_ATTRIBUTES перекрывают имя по умолчанию
+		res, err := resource.New(ctx,
+			resource.WithAttributes(attribute.String("service.name", "p2c-engine")),
+			resource.WithFromEnv(),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+		tracer := tp.Tracer("p2c-engine")
+		return func(s *Span) { emit(context.Background(), tracer, s) }, tp.Shutdown, nil
+	}
+}
+
+// emit replays a recorded span tree into the tracer with its own timestamps.
+func emit(ctx context.Context, tracer trace.Tracer, s *Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make([]attribute.KeyValue, 0, len(s.Attrs))
+	for k, v := range s.Attrs {
+		switch v := v.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(k, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(k, v))
+		case int:
+			attrs = append(attrs, attribute.Int(k, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(k, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(k, v))
+		default:
+			attrs = append(attrs, attribute.String(k, fmt.Sprint(v)))
+		}
+	}
+	ctx, span := tracer.Start(ctx, s.Name, trace.WithTimestamp(s.Start), trace.WithAttributes(attrs...))
+	for _, c := range s.Children {
+		emit(ctx, tracer, c)
+	}
+	if s.Err != "" {
+		span.SetStatus(codes.Error, s.Err)
+	}
+	span.End(trace.WithTimestamp(s.End))
+}
@@ -0,0 +1,113 @@
+// Package tracing records the take pipeline as spans and exports them over
+// OTLP in builds with -tags otel. Spans are assembled from timestamps the
+// worker already takes and exported after the take settles, so the hot path
+// never waits on the exporter; without an exporter every call is a no-op.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoOTel is returned by Setup in builds without the OTLP exporter.
+var ErrNoOTel = errors.New("tracing: built without OpenTelemetry support (build with -tags otel)")
+
+// setup builds the exporter. It is set by otel.go in builds with -tags otel.
+var setup func(ctx context.Context) (export func(*Span), shutdown func(context.Context) error, err error)
+
+var (
+	mu     sync.RWMutex
+	export func(*Span)
+)
+
+// Setup starts the OTLP exporter configured by the standard OTEL_* variables
+// (OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_SERVICE_NAME, OTEL_TRACES_SAMPLER...).
+// shutdown flushes the spans that are still queued.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	if setup == nil {
+		return nil, ErrNoOTel
+	}
+	fn, shutdown, err := setup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	export = fn
+	mu.Unlock()
+	return shutdown, nil
+}
+
+func enabled() func(*Span) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return export
+}
+
+// Span is one timed step. A nil *Span is valid and records nothing.
+type Span struct {
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]any
+	Err      string
+	Children []*Span
+
+	mu sync.Mutex
+}
+
+// Start begins a root span, or returns nil when nothing exports traces.
+func Start(name string, at time.Time) *Span {
+	if enabled() == nil {
+		return nil
+	}
+	return &Span{Name: name, Start: at, Attrs: make(map[string]any)}
+}
+
+// Step adds a finished child span.
+func (s *Span) Step(name string, start, end time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{Name: name, Start: start, End: end, Attrs: make(map[string]any)}
+	s.mu.Lock()
+	s.Children = append(s.Children, c)
+	s.mu.Unlock()
+	return c
+}
+
+// Set adds an attribute: string, bool, int, int64 or float64; anything else
+// is exported as its fmt form.
+func (s *Span) Set(key string, v any) *Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.Attrs[key] = v
+	s.mu.Unlock()
+	return s
+}
+
+// Fail marks the span as failed.
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends a root span at at and exports it with its children.
+func (s *Span) Finish(at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.End = at
+	s.mu.Unlock()
+	if fn := enabled(); fn != nil {
+		fn(s)
+	}
+}